@@ -0,0 +1,51 @@
+// Command ekipackage generates the controller package, the KRL programs and EthernetKRL configuration, for a kuka arm
+// from its Viam config so that both sides of the connection use the same values.
+//
+// Usage:
+//
+//	ekipackage -config <config.json> [-name <arm name>] [-out <dir>]
+//
+// The config is either the arm's attributes or a machine config, in which case the kuka arm is found by name.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	kuka "github.com/viam-soleng/viam-kuka/src"
+	"github.com/viam-soleng/viam-kuka/src/ekimanager"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path to the arm's attributes or a machine config (required)")
+	name := flag.String("name", "", "name of the arm in a machine config, required if it has more than one kuka arm")
+	outputDir := flag.String("out", "kuka_controller", "directory the controller package is written to")
+	flag.Parse()
+
+	if *configPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*configPath, *name, *outputDir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(configPath, name, outputDir string) error {
+	conf, err := kuka.ReadConfigFile(configPath, name)
+	if err != nil {
+		return err
+	}
+
+	paths, err := ekimanager.WritePackage(outputDir, conf.ControllerSettings())
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		fmt.Println(path)
+	}
+	return nil
+}
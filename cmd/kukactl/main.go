@@ -0,0 +1,247 @@
+// Command kukactl checks the connection to a kuka device and the EKI program running on it without a viam-server.
+// The EKI program accepts a single connection, so the arm must not be running in a viam-server at the same time.
+//
+// Usage:
+//
+//	kukactl (-config <config.json> [-name <arm name>] | -ip <address> [-port <port>]) <info|check|shell>
+//
+// info prints the device info, program state, joints, pose, limits and modes of the kuka device. check measures the
+// latency of the connection and exits with a non-zero code if requests go unanswered or the program is not running.
+// shell sends commands typed one per line, such as "getcurrentjoints" or "setjointspeed,10", printing every response.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/spatialmath"
+
+	kuka "github.com/viam-soleng/viam-kuka/src"
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+)
+
+type options struct {
+	configPath  string
+	name        string
+	ipAddress   string
+	port        int
+	timeout     time.Duration
+	count       int
+	allowMotion bool
+	debug       bool
+	traceFile   string
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.configPath, "config", "", "path to the arm's attributes or a machine config")
+	flag.StringVar(&opts.name, "name", "", "name of the arm in a machine config, required if it has more than one kuka arm")
+	flag.StringVar(&opts.ipAddress, "ip", "", "IP address of the kuka device, used instead of a config")
+	flag.IntVar(&opts.port, "port", 0, "port of the kuka device when -ip is given, the default is the arm's default port")
+	flag.DurationVar(&opts.timeout, "timeout", 2*time.Second, "time given to the kuka device to answer a request")
+	flag.IntVar(&opts.count, "count", 10, "number of requests sent by check")
+	flag.BoolVar(&opts.allowMotion, "allow-motion", false, "allow the shell to send commands that move the robot")
+	flag.BoolVar(&opts.debug, "debug", false, "log every command sent and response received")
+	flag.StringVar(&opts.traceFile, "trace", "", "file the data sent and received is recorded to, as JSON lines")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [flags] <info|check|shell>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || (opts.configPath == "") == (opts.ipAddress == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := run(ctx, flag.Arg(0), opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		cancel()
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, action string, opts options) error {
+	var conf *kuka.Config
+	if opts.configPath != "" {
+		var err error
+		if conf, err = kuka.ReadConfigFile(opts.configPath, opts.name); err != nil {
+			return err
+		}
+	} else {
+		conf = &kuka.Config{IPAddress: opts.ipAddress, Port: opts.port}
+	}
+	if opts.traceFile != "" {
+		conf.TraceFile = opts.traceFile
+	}
+
+	logger := logging.NewLogger("kukactl")
+	if opts.debug {
+		logger.SetLevel(logging.DEBUG)
+	} else {
+		logger.SetLevel(logging.WARN)
+	}
+
+	switch action {
+	case "info", "check", "shell":
+	default:
+		return errors.Errorf("unknown action %q, expected info, check or shell", action)
+	}
+
+	d, err := kuka.NewDiagnostics(ctx, conf, logger)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			logger.Warnf("error closing connection: %v", err)
+		}
+	}()
+	d.AllowMotion = opts.allowMotion
+
+	switch action {
+	case "info":
+		return info(ctx, d, opts.timeout)
+	case "check":
+		return check(ctx, d, opts.count, opts.timeout)
+	default:
+		return shell(ctx, d, os.Stdin, os.Stdout, opts.timeout)
+	}
+}
+
+func info(ctx context.Context, d *kuka.Diagnostics, timeout time.Duration) error {
+	report, err := d.Report(ctx, timeout)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "address\t%v\n", d.Address())
+	fmt.Fprintf(w, "name\t%v\n", report.Name)
+	fmt.Fprintf(w, "serial number\t%v\n", report.SerialNum)
+	fmt.Fprintf(w, "robot type\t%v\n", report.RobotType)
+	fmt.Fprintf(w, "software version\t%v\n", report.SoftwareVersion)
+	fmt.Fprintf(w, "operating mode\t%v\n", report.OperatingMode)
+	fmt.Fprintf(w, "protocol version\t%v (module %v)\n", report.ProtocolVersion, ekiCommand.ProtocolVersion)
+	if len(report.MissingCommands) > 0 {
+		fmt.Fprintf(w, "missing commands\t%v\n", strings.Join(report.MissingCommands, ", "))
+	}
+	fmt.Fprintf(w, "program\t%v (%v)\n", report.ProgramName, report.ProgramState)
+	fmt.Fprintf(w, "run mode\t%v\n", report.RunMode)
+	fmt.Fprintf(w, "stop message\t%v\n", report.StopMessage)
+	fmt.Fprintf(w, "joints (deg)\t%v\n", formatFloats(report.Joints))
+	for i, limit := range report.JointLimits {
+		fmt.Fprintf(w, "a%v limits (deg)\t[%.2f, %.2f]\n", i+1, limit.Min, limit.Max)
+	}
+	if report.Pose != nil {
+		pt := report.Pose.Point()
+		fmt.Fprintf(w, "position (mm)\t%.2f, %.2f, %.2f\n", pt.X, pt.Y, pt.Z)
+		fmt.Fprintf(w, "orientation\t%v\n", formatOrientation(report.Pose.Orientation()))
+	}
+	fmt.Fprintf(w, "tool (x,y,z,a,b,c)\t%v\n", formatFloats(report.ToolFrame))
+	fmt.Fprintf(w, "base (x,y,z,a,b,c)\t%v\n", formatFloats(report.BaseFrame))
+	return w.Flush()
+}
+
+func check(ctx context.Context, d *kuka.Diagnostics, count int, timeout time.Duration) error {
+	if count < 1 {
+		return errors.Errorf("count (%v) must be at least 1", count)
+	}
+
+	result, err := d.Check(ctx, count, timeout)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%v: %v/%v requests answered", result.Address, result.Received, result.Sent)
+	if result.Received > 0 {
+		fmt.Printf(", round trip min/avg/max %v/%v/%v", result.Min(), result.Avg(), result.Max())
+	}
+	fmt.Println()
+	fmt.Printf("program %v is %v\n", result.ProgramName, result.ProgramState)
+	return result.Err()
+}
+
+func shell(ctx context.Context, d *kuka.Diagnostics, in io.Reader, out io.Writer, timeout time.Duration) error {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case resp := <-d.Responses():
+				fmt.Fprintf(out, "< %v\n", resp)
+			}
+		}
+	}()
+
+	fmt.Fprintf(out, "connected to %v, type help for the list of commands or quit to exit\n", d.Address())
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	for {
+		var line string
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return nil
+		case line, ok = <-lines:
+			if !ok {
+				// Give the responses to the last commands time to arrive when the input ends
+				select {
+				case <-ctx.Done():
+				case <-time.After(timeout):
+				}
+				return nil
+			}
+		}
+
+		switch strings.TrimSpace(line) {
+		case "":
+			continue
+		case "quit", "exit":
+			return nil
+		case "help":
+			for _, command := range ekiCommand.Commands {
+				if command.Kind != ekiCommand.Pushed {
+					fmt.Fprintln(out, command.Usage())
+				}
+			}
+			continue
+		}
+		if err := d.Send(line); err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+		}
+	}
+}
+
+func formatFloats(values []float64) string {
+	formatted := make([]string, len(values))
+	for i, v := range values {
+		formatted[i] = fmt.Sprintf("%.2f", v)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func formatOrientation(o spatialmath.Orientation) string {
+	ov := o.OrientationVectorDegrees()
+	return fmt.Sprintf("ox %.3f, oy %.3f, oz %.3f, theta %.2f", ov.OX, ov.OY, ov.OZ, ov.Theta)
+}
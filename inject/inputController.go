@@ -0,0 +1,51 @@
+package inject
+
+import (
+	"context"
+
+	"go.viam.com/rdk/components/input"
+	"go.viam.com/rdk/resource"
+)
+
+// InputController represents a fake instance of an input controller.
+type InputController struct {
+	input.Controller
+	name                        resource.Name
+	RegisterControlCallbackFunc func(
+		ctx context.Context,
+		control input.Control,
+		triggers []input.EventType,
+		ctrlFunc input.ControlFunction,
+		extra map[string]interface{},
+	) error
+	EventsFunc func(ctx context.Context, extra map[string]interface{}) (map[input.Control]input.Event, error)
+}
+
+// NewInputController returns a new injected input controller.
+func NewInputController(name string) *InputController {
+	return &InputController{name: input.Named(name)}
+}
+
+func (controller *InputController) Name() resource.Name {
+	return controller.name
+}
+
+func (controller *InputController) RegisterControlCallback(
+	ctx context.Context,
+	control input.Control,
+	triggers []input.EventType,
+	ctrlFunc input.ControlFunction,
+	extra map[string]interface{},
+) error {
+	if controller.RegisterControlCallbackFunc == nil {
+		return controller.Controller.RegisterControlCallback(ctx, control, triggers, ctrlFunc, extra)
+	}
+	return controller.RegisterControlCallbackFunc(ctx, control, triggers, ctrlFunc, extra)
+}
+
+func (controller *InputController) Events(ctx context.Context, extra map[string]interface{}) (map[input.Control]input.Event, error) {
+	if controller.EventsFunc == nil {
+		return controller.Controller.Events(ctx, extra)
+	}
+	return controller.EventsFunc(ctx, extra)
+}
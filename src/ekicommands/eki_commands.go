@@ -14,8 +14,14 @@ import "github.com/pkg/errors"
 -   status/turn: information regarding robot's position when returning end position as multiple robot poses can lead to
 				 same end position
 -   program_state: returns the current state of the program either: "Free", "Running", "Reset", "Ended" or "Stopped"
+-   run_mode: GO, MotionStep, IncrementalStep, BackwardStep, ProgramStep or ContinuousStep
+-   program commands are refused with "notAllowed" when the controller cannot perform them, e.g. starting the program
+	outside of EXT
+-   set commands are refused with "invalidValue" when out of range and with "robotBusy" while the robot interpreter is
+	executing a motion
 */
 
+// Names of the commands of the EKI program, whose arguments and replies are described by Commands
 var (
 	// Info Commands
 	GetRobotName            string = "getrobotname"       // Response: <robot_name>
@@ -26,14 +32,48 @@ var (
 	GetEKIProgramState      string = "getprograminfo"     // Response: <program_name,program_state>
 	GetJointPosLimit        string = "getposjntlim"       // Response: <a1,a2,a3,a4,a5,a6,e1,e2,e3,e4,e5,e6>
 	GetJointNegLimit        string = "getnegjntlim"       // Response: <a1,a2,a3,a4,a5,a6,e1,e2,e3,e4,e5,e6>
+	GetMaxJointSpeed        string = "getmaxjointspeed"   // Response: <a1,a2,a3,a4,a5,a6,e1,e2,e3,e4,e5,e6> in rad/s
 	GetEndPosition          string = "getcurrentpos"      // Response: <x,y,z,a,b,c,status,turn,e1,e2,e3,e4,e5,e6>
 	GetJointPosition        string = "getcurrentjoints"   // Response: <a1,a2,a3,a4,a5,a6,e1,e2,e3,e4,e5,e6>
+	GetToolData             string = "gettooldata"        // Response: <x,y,z,a,b,c>
+	GetBaseData             string = "getbasedata"        // Response: <x,y,z,a,b,c>
+	GetStopMessage          string = "getstopmessage"     // Response: <true|false>
+	GetRunMode              string = "getrunmode"         // Response: <run_mode>
+	GetProtocolInfo         string = "getprotocolinfo"    // Response: <version,command,command,...>
 
-	SetJointSpeed string = "setjointspeed" // Response: success
+	SetJointSpeed string = "setjointspeed" // Request: <speed>, Response: success
+	SetOverride   string = "setoverride"   // Request: <override>, Response: success
+	SetToolData   string = "settooldata"   // Request: <x,y,z,a,b,c>, Response: success
+	SetBaseData   string = "setbasedata"   // Request: <x,y,z,a,b,c>, Response: success
+	SetCartSpeed  string = "setcartspeed"  // Request: <speed>, Response: success
 
 	// Motion Commands
-	SetJointPosition string = "ptptojointpos" // Request: <a1,a2,a3,a4,a5,a6,e1,e2,e3,e4,e5,e6>, Response: <status>
-	SetStop          string = "setstop"       // Response: success
+	SetJointPosition     string = "ptptojointpos" // Request: <a1,a2,a3,a4,a5,a6,e1,e2,e3,e4,e5,e6>, Response: <status>
+	SetCartesianPosition string = "ptptocartpos"  // Request: <x,y,z,a,b,c,status,turn,e1,e2,e3,e4,e5,e6>, Response: <status>
+	SetLinearPosition    string = "lintocartpos"  // Request: <x,y,z,a,b,c,e1,e2,e3,e4,e5,e6>, Response: <status>
+	SetStop              string = "setstop"       // Response: success
+
+	// Program Commands
+	SelectProgram string = "selectprogram" // Response: success or notAllowed
+	StartProgram  string = "startprogram"  // Response: success or notAllowed
+	StopProgram   string = "stopprogram"   // Response: success or notAllowed
+	ResetProgram  string = "resetprogram"  // Response: success or notAllowed
+
+	// Pushed by the kuka device when ekiStateStream is running, without being requested
+	StateStream string = "statestream" // <a1,...,a6,e1,...,e6,x,y,z,a,b,c,status,turn,e1,...,e6,override,mode>
+)
+
+// ProtocolVersion is the version of the commands and replies of the EKI program shipped with this module, reported by
+// GetProtocolInfo. EKI programs predating it refuse GetProtocolInfo as an invalid command.
+const ProtocolVersion = 1
+
+// Replies of the kuka device to commands that do not return values
+const (
+	ResponseSuccess        = "success"
+	ResponseInvalidValue   = "invalidValue"   // the value given is out of range
+	ResponseInvalidCommand = "invalidCommand" // the command is not known to the EKI program
+	ResponseBusy           = "robotBusy"      // the robot interpreter is still executing the previous command
+	ResponseNotAllowed     = "notAllowed"     // the controller cannot perform a program command
 )
 
 type ProgramStatus int64
@@ -47,6 +87,14 @@ const (
 	StatusUnknown
 )
 
+func (status ProgramStatus) String() string {
+	str, err := ProgramStatusToString(status)
+	if err != nil {
+		return "Unknown"
+	}
+	return str
+}
+
 func ProgramStatusToString(status ProgramStatus) (string, error) {
 	switch status {
 	case StatusFree:
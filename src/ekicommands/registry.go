@@ -0,0 +1,309 @@
+package eki_command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Kind is how the EKI program runs a command.
+type Kind int
+
+const (
+	// Submit commands are answered at once by ekiCommHandler on the submit interpreter.
+	Submit Kind = iota
+	// Sync commands are passed to ekiMain on the robot interpreter, which answers once they are done, motions once the
+	// robot has stopped. Another is refused with ResponseBusy until then.
+	Sync
+	// Pushed messages are sent by the kuka device without being requested.
+	Pushed
+)
+
+// Type is the type of an argument of a command or of a value replied.
+type Type int
+
+const (
+	String Type = iota
+	Float
+	Int
+	Bool
+)
+
+func (t Type) String() string {
+	switch t {
+	case Float:
+		return "float"
+	case Int:
+		return "int"
+	case Bool:
+		return "bool"
+	default:
+		return "string"
+	}
+}
+
+// Field is an argument of a command or a value replied.
+type Field struct {
+	Name string
+	Type Type
+}
+
+// Command describes a command of the EKI program, its arguments and its reply, as sent and read by the arm, kukactl and
+// the fake EKI server of the tests.
+type Command struct {
+	Name string
+	Kind Kind
+	// Motion is whether the command moves the robot
+	Motion bool
+	Args   []Field
+	// Reply is the values replied, none for a command answered with ResponseSuccess
+	Reply []Field
+	// Repeated is whether the last value replied is repeated, any number of times
+	Repeated bool
+}
+
+// fields returns fields of the given type and names.
+func fields(t Type, names ...string) []Field {
+	list := make([]Field, len(names))
+	for i, name := range names {
+		list[i] = Field{Name: name, Type: t}
+	}
+	return list
+}
+
+// concat returns the given lists of fields one after the other.
+func concat(lists ...[]Field) []Field {
+	var all []Field
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	return all
+}
+
+var (
+	robotAxes    = fields(Float, "a1", "a2", "a3", "a4", "a5", "a6")
+	externalAxes = fields(Float, "e1", "e2", "e3", "e4", "e5", "e6")
+	axes         = concat(robotAxes, externalAxes)
+	frame        = fields(Float, "x", "y", "z", "a", "b", "c")
+	endPosition  = concat(frame, fields(Int, "status", "turn"), externalAxes)
+)
+
+// Commands are the commands of the EKI program shipped with this module, and the messages it pushes.
+var Commands = []*Command{
+	{Name: GetRobotName, Reply: fields(String, "robot_name")},
+	{Name: GetRobotSoftwareVersion, Reply: fields(String, "sw_version")},
+	{Name: GetRobotSerialNum, Reply: fields(String, "robot_serial_number")},
+	{Name: GetRobotType, Reply: fields(String, "robot_type")},
+	{Name: GetRobotOperatingMode, Reply: fields(String, "mode")},
+	{Name: GetEKIProgramState, Reply: fields(String, "program_name", "program_state")},
+	{Name: GetJointPosLimit, Reply: axes},
+	{Name: GetJointNegLimit, Reply: axes},
+	{Name: GetMaxJointSpeed, Reply: axes},
+	{Name: GetEndPosition, Reply: endPosition},
+	{Name: GetJointPosition, Reply: axes},
+	{Name: GetToolData, Reply: frame},
+	{Name: GetBaseData, Reply: frame},
+	{Name: GetStopMessage, Reply: fields(Bool, "active")},
+	{Name: GetRunMode, Reply: fields(String, "run_mode")},
+	{Name: GetProtocolInfo, Reply: concat(fields(Int, "version"), fields(String, "command")), Repeated: true},
+
+	{Name: SetJointSpeed, Kind: Sync, Args: fields(Float, "speed")},
+	{Name: SetOverride, Args: fields(Int, "override")},
+	{Name: SetToolData, Kind: Sync, Args: frame},
+	{Name: SetBaseData, Kind: Sync, Args: frame},
+	{Name: SetCartSpeed, Kind: Sync, Args: fields(Float, "speed")},
+
+	{Name: SetJointPosition, Kind: Sync, Motion: true, Args: axes},
+	{Name: SetCartesianPosition, Kind: Sync, Motion: true, Args: endPosition},
+	{Name: SetLinearPosition, Kind: Sync, Motion: true, Args: concat(frame, externalAxes)},
+	{Name: SetStop, Kind: Sync},
+
+	{Name: SelectProgram},
+	// Starting the program first moves the robot to its home position
+	{Name: StartProgram, Motion: true},
+	{Name: StopProgram},
+	{Name: ResetProgram},
+
+	{Name: StateStream, Kind: Pushed, Reply: concat(axes, endPosition, fields(Int, "override"), fields(String, "mode"))},
+}
+
+var commandsByName = func() map[string]*Command {
+	byName := map[string]*Command{}
+	for _, command := range Commands {
+		byName[command.Name] = command
+	}
+	return byName
+}()
+
+// Lookup returns the command of the given name, which is matched regardless of case as by the EKI program.
+func Lookup(name string) (*Command, bool) {
+	command, ok := commandsByName[strings.ToLower(name)]
+	return command, ok
+}
+
+// Usage returns the command followed by the names of its arguments, such as "setjointspeed,<speed>".
+func (c *Command) Usage() string {
+	parts := []string{c.Name}
+	for _, arg := range c.Args {
+		parts = append(parts, "<"+arg.Name+">")
+	}
+	return strings.Join(parts, ",")
+}
+
+// Encode returns the arguments of the command, comma separated, checking they are of the types it takes. Floats are
+// given as float64 or int, ints as int, strings as string and bools as bool.
+func (c *Command) Encode(values ...interface{}) (string, error) {
+	if len(values) != len(c.Args) {
+		return "", c.countError(len(values))
+	}
+	encoded := make([]string, len(values))
+	for i, value := range values {
+		arg := c.Args[i]
+		switch v := value.(type) {
+		case float64:
+			if arg.Type == Float {
+				encoded[i] = strconv.FormatFloat(v, 'f', -1, 64)
+				continue
+			}
+		case int:
+			if arg.Type == Float || arg.Type == Int {
+				encoded[i] = strconv.Itoa(v)
+				continue
+			}
+		case string:
+			if arg.Type == String {
+				encoded[i] = v
+				continue
+			}
+		case bool:
+			if arg.Type == Bool {
+				encoded[i] = strconv.FormatBool(v)
+				continue
+			}
+		}
+		return "", errors.Errorf("%v of %v must be of type %v, %T given", arg.Name, c.Name, arg.Type, value)
+	}
+	return strings.Join(encoded, ","), nil
+}
+
+// Check checks arguments given as text, such as typed in kukactl, are as many and of the types the command takes.
+func (c *Command) Check(args []string) error {
+	if len(args) != len(c.Args) {
+		return c.countError(len(args))
+	}
+	for i, arg := range args {
+		if _, err := parseValue(c.Args[i].Type, arg); err != nil {
+			return errors.Errorf("%v of %v must be of type %v, %q given", c.Args[i].Name, c.Name, c.Args[i].Type, arg)
+		}
+	}
+	return nil
+}
+
+func (c *Command) countError(given int) error {
+	return errors.Errorf("%v takes %v arguments, %v given", c.Name, len(c.Args), given)
+}
+
+// Decode parses the values replied to the command, or pushed by the kuka device, into the types of its reply.
+func (c *Command) Decode(values []string) (Reply, error) {
+	if len(c.Reply) == 0 {
+		if len(values) != 1 || values[0] != ResponseSuccess {
+			return Reply{}, errors.Errorf("incorrect reply to %v: %v (should be %v)", c.Name, values, ResponseSuccess)
+		}
+		return Reply{Command: c}, nil
+	}
+
+	expected := len(c.Reply)
+	if c.Repeated {
+		expected--
+	}
+	if len(values) < expected || (!c.Repeated && len(values) > expected) {
+		return Reply{}, errors.Errorf("incorrect amount of data returned for %v: %v (should be %v)", c.Name, values,
+			c.expectedCount())
+	}
+
+	decoded := make([]interface{}, len(values))
+	for i, value := range values {
+		field := c.Reply[len(c.Reply)-1]
+		if i < len(c.Reply) {
+			field = c.Reply[i]
+		}
+		parsed, err := parseValue(field.Type, value)
+		if err != nil {
+			return Reply{}, errors.Errorf("failed to parse %v of %v: %v", field.Name, c.Name, values)
+		}
+		decoded[i] = parsed
+	}
+	return Reply{Command: c, values: decoded}, nil
+}
+
+func (c *Command) expectedCount() string {
+	if c.Repeated {
+		return fmt.Sprintf("at least %v", len(c.Reply)-1)
+	}
+	return strconv.Itoa(len(c.Reply))
+}
+
+func parseValue(t Type, value string) (interface{}, error) {
+	switch t {
+	case Float:
+		return strconv.ParseFloat(value, 64)
+	case Int:
+		return strconv.Atoi(value)
+	case Bool:
+		return strconv.ParseBool(value)
+	default:
+		return value, nil
+	}
+}
+
+// Reply is the values replied to a command, or pushed by the kuka device, decoded by Command.Decode. Reading a value
+// as a type other than that of its field panics.
+type Reply struct {
+	Command *Command
+	values  []interface{}
+}
+
+// Len returns the number of values replied.
+func (r Reply) Len() int {
+	return len(r.values)
+}
+
+// Text returns the string value at index i.
+func (r Reply) Text(i int) string {
+	return r.values[i].(string)
+}
+
+// Texts returns the string values from index i on.
+func (r Reply) Texts(i int) []string {
+	texts := make([]string, 0, len(r.values)-i)
+	for _, value := range r.values[i:] {
+		texts = append(texts, value.(string))
+	}
+	return texts
+}
+
+// Float returns the float value at index i.
+func (r Reply) Float(i int) float64 {
+	return r.values[i].(float64)
+}
+
+// Floats returns the float values from index from up to index to.
+func (r Reply) Floats(from, to int) []float64 {
+	floats := make([]float64, 0, to-from)
+	for _, value := range r.values[from:to] {
+		floats = append(floats, value.(float64))
+	}
+	return floats
+}
+
+// Int returns the int value at index i.
+func (r Reply) Int(i int) int {
+	return r.values[i].(int)
+}
+
+// Bool returns the bool value at index i.
+func (r Reply) Bool(i int) bool {
+	return r.values[i].(bool)
+}
@@ -0,0 +1,90 @@
+package eki_command
+
+import (
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestLookup(t *testing.T) {
+	command, ok := Lookup("getCurrentJoints")
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, command.Name, test.ShouldEqual, GetJointPosition)
+	test.That(t, len(command.Reply), test.ShouldEqual, 12)
+
+	_, ok = Lookup("getMadaDh")
+	test.That(t, ok, test.ShouldBeFalse)
+
+	command, _ = Lookup(SetCartesianPosition)
+	test.That(t, command.Kind, test.ShouldEqual, Sync)
+	test.That(t, command.Motion, test.ShouldBeTrue)
+	test.That(t, command.Usage(), test.ShouldStartWith, "ptptocartpos,<x>,<y>,<z>,<a>,<b>,<c>,<status>,<turn>,<e1>")
+}
+
+func TestEncode(t *testing.T) {
+	command, _ := Lookup(SetJointSpeed)
+	args, err := command.Encode(12.5)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, args, test.ShouldEqual, "12.5")
+	args, err = command.Encode(10)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, args, test.ShouldEqual, "10")
+	_, err = command.Encode()
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "takes 1 arguments, 0 given")
+
+	// Floats are never given in exponent notation, which the EKI program cannot read
+	args, err = command.Encode(0.00001)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, args, test.ShouldEqual, "0.00001")
+
+	command, _ = Lookup(SetOverride)
+	_, err = command.Encode(50.5)
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "override of setoverride must be of type int")
+
+	command, _ = Lookup(SetToolData)
+	test.That(t, command.Check([]string{"0", "0", "100", "0", "0", "0"}), test.ShouldBeNil)
+	test.That(t, command.Check([]string{"0", "0", "high", "0", "0", "0"}), test.ShouldNotBeNil)
+	test.That(t, command.Check([]string{"0", "0", "100"}), test.ShouldNotBeNil)
+}
+
+func TestDecode(t *testing.T) {
+	command, _ := Lookup(GetEndPosition)
+	reply, err := command.Decode([]string{"500", "0", "600", "0", "90", "0", "2", "35", "0", "0", "0", "0", "0", "0"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, reply.Floats(0, 6), test.ShouldResemble, []float64{500, 0, 600, 0, 90, 0})
+	test.That(t, reply.Int(6), test.ShouldEqual, 2)
+	test.That(t, reply.Int(7), test.ShouldEqual, 35)
+
+	_, err = command.Decode([]string{"500", "0", "600"})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "(should be 14)")
+	_, err = command.Decode([]string{"500", "0", "600", "0", "90", "0", "2.5", "35", "0", "0", "0", "0", "0", "0"})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "failed to parse status")
+
+	command, _ = Lookup(GetProtocolInfo)
+	reply, err = command.Decode([]string{"1", "getRobotName", "setStop"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, reply.Int(0), test.ShouldEqual, 1)
+	test.That(t, reply.Texts(1), test.ShouldResemble, []string{"getRobotName", "setStop"})
+	reply, err = command.Decode([]string{"1"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, reply.Texts(1), test.ShouldBeEmpty)
+	_, err = command.Decode(nil)
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "(should be at least 1)")
+
+	command, _ = Lookup(GetStopMessage)
+	reply, err = command.Decode([]string{"TRUE"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, reply.Bool(0), test.ShouldBeTrue)
+
+	command, _ = Lookup(SetOverride)
+	reply, err = command.Decode([]string{ResponseSuccess})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, reply.Len(), test.ShouldEqual, 0)
+	_, err = command.Decode([]string{"50"})
+	test.That(t, err, test.ShouldNotBeNil)
+}
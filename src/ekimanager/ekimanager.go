@@ -0,0 +1,323 @@
+// Package ekimanager holds the KRL programs run by the kuka controller and generates the controller package, the KRL
+// files along with the EthernetKRL configuration, for a given connection.
+package ekimanager
+
+import (
+	"embed"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Defaults matching the KRL files as shipped
+const (
+	DefaultConfigName    = "ekiManagerConfig"
+	DefaultAliveFlag     = 1
+	DefaultReceiveFlag   = 10
+	DefaultStopAliveFlag = 2
+	DefaultStopFlag      = 11
+
+	// MessageFormatText frames each message as comma separated values terminated by ';', MessageFormatXML as the
+	// elements read by package ekixml.
+	MessageFormatText = "text"
+	MessageFormatXML  = "xml"
+
+	globalsFile = "ekiGlobals.dat"
+
+	maxFlag   = 1024
+	maxOutput = 4096
+
+	// minStreamPeriodMs is the cycle time of the submit interpreter, below which the state cannot be pushed any faster
+	minStreamPeriodMs = 12
+	maxStreamPeriodMs = 10000
+)
+
+//go:embed *.src *.sub *.dat
+var krlFiles embed.FS
+
+var configNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,23}$`)
+
+// Settings are the values shared by the arm config and the EKI program on the controller.
+type Settings struct {
+	// IPAddress and Port are where the controller listens for the arm to connect.
+	IPAddress string
+	Port      int
+	// ConfigName is the name of the EthernetKRL configuration file, without its extension.
+	ConfigName string
+	// AliveFlag is set by EthernetKRL while a client is connected, ReceiveFlag when a command has been received.
+	AliveFlag   int
+	ReceiveFlag int
+	// ExtStartOutput is the output wired to $EXT_START, used to start the program remotely. Zero disables it.
+	ExtStartOutput int
+	// StreamPeriodMs is how often ekiStateStream pushes the state of the robot, in milliseconds. Zero disables it.
+	StreamPeriodMs int
+	// MessageFormat is how the commands and replies are framed, MessageFormatText if empty.
+	MessageFormat string
+	// StopPort is where the controller listens for the stop channel, a second connection read by ekiStopHandler on
+	// which setStop is sent. Zero disables it. StopAliveFlag is set while it is connected, StopFlag when a stop has
+	// been received.
+	StopPort      int
+	StopAliveFlag int
+	StopFlag      int
+}
+
+// Validate ensures the settings can be used on the controller. The IP address is only checked when generating the
+// package, as the arm may also be configured with a host name.
+func (s Settings) Validate() error {
+	if s.Port <= 0 || s.Port > 65535 {
+		return errors.Errorf("port (%v) must be in the range [1, 65535]", s.Port)
+	}
+	if !configNameRegex.MatchString(s.ConfigName) {
+		return errors.Errorf("config name (%v) must be a valid KRL name of at most 24 characters", s.ConfigName)
+	}
+	if s.AliveFlag < 1 || s.AliveFlag > maxFlag {
+		return errors.Errorf("alive flag (%v) must be in the range [1, %v]", s.AliveFlag, maxFlag)
+	}
+	if s.ReceiveFlag < 1 || s.ReceiveFlag > maxFlag {
+		return errors.Errorf("receive flag (%v) must be in the range [1, %v]", s.ReceiveFlag, maxFlag)
+	}
+	if s.AliveFlag == s.ReceiveFlag {
+		return errors.Errorf("alive flag and receive flag must differ, both are %v", s.AliveFlag)
+	}
+	if s.ExtStartOutput < 0 || s.ExtStartOutput > maxOutput {
+		return errors.Errorf("ext start output (%v) must be in the range [0, %v]", s.ExtStartOutput, maxOutput)
+	}
+	if s.StreamPeriodMs != 0 && (s.StreamPeriodMs < minStreamPeriodMs || s.StreamPeriodMs > maxStreamPeriodMs) {
+		return errors.Errorf("stream period (%v) must be 0 or in the range [%v, %v]",
+			s.StreamPeriodMs, minStreamPeriodMs, maxStreamPeriodMs)
+	}
+	if s.MessageFormat != "" && s.MessageFormat != MessageFormatText && s.MessageFormat != MessageFormatXML {
+		return errors.Errorf("message format (%v) must be %v or %v", s.MessageFormat, MessageFormatText, MessageFormatXML)
+	}
+	if s.StopPort != 0 {
+		return s.validateStopChannel()
+	}
+	return nil
+}
+
+// validateStopChannel ensures the stop channel does not share its port or flags with the main connection.
+func (s Settings) validateStopChannel() error {
+	if s.StopPort < 0 || s.StopPort > 65535 {
+		return errors.Errorf("stop port (%v) must be 0 or in the range [1, 65535]", s.StopPort)
+	}
+	if s.StopPort == s.Port {
+		return errors.Errorf("stop port and port must differ, both are %v", s.Port)
+	}
+	flags := map[int]string{s.AliveFlag: "alive flag", s.ReceiveFlag: "receive flag"}
+	for _, flag := range []struct {
+		name  string
+		value int
+	}{{"stop alive flag", s.StopAliveFlag}, {"stop flag", s.StopFlag}} {
+		if flag.value < 1 || flag.value > maxFlag {
+			return errors.Errorf("%v (%v) must be in the range [1, %v]", flag.name, flag.value, maxFlag)
+		}
+		if other, ok := flags[flag.value]; ok {
+			return errors.Errorf("%v and %v must differ, both are %v", other, flag.name, flag.value)
+		}
+		flags[flag.value] = flag.name
+	}
+	return nil
+}
+
+// stopConfigName returns the name of the EthernetKRL configuration file of the stop channel, without its extension.
+func (s Settings) stopConfigName() string {
+	return s.ConfigName + "Stop"
+}
+
+// xmlMessages returns whether the commands and replies are framed as XML.
+func (s Settings) xmlMessages() bool {
+	return s.MessageFormat == MessageFormatXML
+}
+
+// globalsSubstitution replaces the value of a declaration in ekiGlobals.dat.
+type globalsSubstitution struct {
+	pattern *regexp.Regexp
+	value   func(Settings) string
+}
+
+var globalsSubstitutions = []globalsSubstitution{
+	{regexp.MustCompile(`(?m)^(ekiConfigFile\[\]=)"[^"]*"`), func(s Settings) string { return fmt.Sprintf("%q", s.ConfigName) }},
+	{regexp.MustCompile(`(?m)^(GLOBAL INT ekiReveiveFlagNum=)\d+`), func(s Settings) string { return fmt.Sprint(s.ReceiveFlag) }},
+	{regexp.MustCompile(`(?m)^(GLOBAL INT ekiAliveFlagNum=)\d+`), func(s Settings) string { return fmt.Sprint(s.AliveFlag) }},
+	{regexp.MustCompile(`(?m)^(GLOBAL INT ekiExtStartOutNum=)\d+`), func(s Settings) string { return fmt.Sprint(s.ExtStartOutput) }},
+	{regexp.MustCompile(`(?m)^(GLOBAL INT ekiStreamPeriodMs=)\d+`), func(s Settings) string { return fmt.Sprint(s.StreamPeriodMs) }},
+	{regexp.MustCompile(`(?m)^(GLOBAL BOOL ekiXmlMessages=)(TRUE|FALSE)`), func(s Settings) string {
+		return strings.ToUpper(fmt.Sprint(s.xmlMessages()))
+	}},
+	{regexp.MustCompile(`(?m)^(ekiCommandTag\[\]=)"[^"]*"`), func(s Settings) string { return fmt.Sprintf("%q", commandTag(s)) }},
+	{regexp.MustCompile(`(?m)^(GLOBAL BOOL ekiStopChannel=)(TRUE|FALSE)`), func(s Settings) string {
+		return strings.ToUpper(fmt.Sprint(s.StopPort != 0))
+	}},
+	{regexp.MustCompile(`(?m)^(ekiStopConfigFile\[\]=)"[^"]*"`), func(s Settings) string { return fmt.Sprintf("%q", s.stopConfigName()) }},
+	{regexp.MustCompile(`(?m)^(GLOBAL INT ekiStopAliveFlagNum=)\d+`), func(s Settings) string { return fmt.Sprint(s.StopAliveFlag) }},
+	{regexp.MustCompile(`(?m)^(GLOBAL INT ekiStopFlagNum=)\d+`), func(s Settings) string { return fmt.Sprint(s.StopFlag) }},
+}
+
+// configureGlobals sets the connection values declared in ekiGlobals.dat.
+func configureGlobals(globals []byte, s Settings) ([]byte, error) {
+	for _, sub := range globalsSubstitutions {
+		matches := sub.pattern.FindAllIndex(globals, -1)
+		if len(matches) != 1 {
+			return nil, errors.Errorf("expected one match of %v in %v, found %v", sub.pattern, globalsFile, len(matches))
+		}
+		globals = sub.pattern.ReplaceAll(globals, []byte("${1}"+sub.value(s)))
+	}
+	return globals, nil
+}
+
+// commandTag returns the element of the EthernetKRL configuration the commands are received into.
+func commandTag(s Settings) string {
+	if s.xmlMessages() {
+		return "Cmd"
+	}
+	return "Buffer"
+}
+
+// ConfigXML returns the EthernetKRL configuration. The controller listens as a server for the arm to connect and
+// receives commands terminated by ';' into the "Buffer" stream read by the KRL, or with the XML message format, the
+// name, id and arguments of each <Cmd> element. The channel belongs to the submit interpreter, which opens it, so
+// that it stays open when the robot program is reset.
+func ConfigXML(s Settings) []byte {
+	return []byte(fmt.Sprintf(`<ETHERNETKRL>
+   <CONFIGURATION>
+      <EXTERNAL>
+         <TYPE>Client</TYPE>
+      </EXTERNAL>
+      <INTERNAL>
+         <ENVIRONMENT>Submit</ENVIRONMENT>
+         <BUFFERING Limit="512"/>
+         <BUFFSIZE Limit="65534"/>
+         <ALIVE Set_Flag="%v"/>
+         <IP>%v</IP>
+         <PORT>%v</PORT>
+         <PROTOCOL>TCP</PROTOCOL>
+      </INTERNAL>
+   </CONFIGURATION>
+%v</ETHERNETKRL>
+`, s.AliveFlag, s.IPAddress, s.Port, messagesXML(s)))
+}
+
+// messagesXML returns the RECEIVE and SEND sections of the EthernetKRL configuration. Replies are sent as complete
+// strings by the KRL, so only the XML message format declares the element sent.
+func messagesXML(s Settings) string {
+	if s.xmlMessages() {
+		return fmt.Sprintf(`   <RECEIVE>
+      <XML>
+         <ELEMENT Tag="Cmd/@Name" Type="STRING"/>
+         <ELEMENT Tag="Cmd/@Id" Type="INT"/>
+         <ELEMENT Tag="Cmd/@Count" Type="INT"/>
+         <ELEMENT Tag="Cmd/Arg" Type="STRING"/>
+         <ELEMENT Tag="Cmd" Set_Flag="%v"/>
+      </XML>
+   </RECEIVE>
+   <SEND>
+      <XML>
+         <ELEMENT Tag="Reply"/>
+      </XML>
+   </SEND>
+`, s.ReceiveFlag)
+	}
+	return fmt.Sprintf(`   <RECEIVE>
+      <RAW>
+         <ELEMENT Tag="Buffer" Type="STREAM" Set_Flag="%v" Size="2000" EOS="59"/>
+      </RAW>
+   </RECEIVE>
+   <SEND/>
+`, s.ReceiveFlag)
+}
+
+// StopConfigXML returns the EthernetKRL configuration of the stop channel. As the main configuration, the controller
+// listens as a server, here on the stop port, and receives messages terminated by ';' into the "Buffer" stream. Every
+// message is taken as a stop, so the format is always text.
+func StopConfigXML(s Settings) []byte {
+	return []byte(fmt.Sprintf(`<ETHERNETKRL>
+   <CONFIGURATION>
+      <EXTERNAL>
+         <TYPE>Client</TYPE>
+      </EXTERNAL>
+      <INTERNAL>
+         <ENVIRONMENT>Submit</ENVIRONMENT>
+         <BUFFERING Limit="16"/>
+         <ALIVE Set_Flag="%v"/>
+         <IP>%v</IP>
+         <PORT>%v</PORT>
+         <PROTOCOL>TCP</PROTOCOL>
+      </INTERNAL>
+   </CONFIGURATION>
+   <RECEIVE>
+      <RAW>
+         <ELEMENT Tag="Buffer" Type="STREAM" Set_Flag="%v" Size="256" EOS="59"/>
+      </RAW>
+   </RECEIVE>
+   <SEND/>
+</ETHERNETKRL>
+`, s.StopAliveFlag, s.IPAddress, s.StopPort, s.StopFlag))
+}
+
+// Generate returns the files of the controller package by name: the KRL programs, with ekiGlobals.dat configured from
+// the settings, and the EthernetKRL configuration, along with that of the stop channel if it is enabled.
+func Generate(s Settings) (map[string][]byte, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if net.ParseIP(s.IPAddress) == nil {
+		return nil, errors.Errorf("ip address (%v) must be the IP address of the kuka device", s.IPAddress)
+	}
+
+	entries, err := krlFiles.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte, len(entries)+1)
+	for _, entry := range entries {
+		data, err := krlFiles.ReadFile(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if entry.Name() == globalsFile {
+			if data, err = configureGlobals(data, s); err != nil {
+				return nil, err
+			}
+		}
+		files[entry.Name()] = data
+	}
+	files[s.ConfigName+".xml"] = ConfigXML(s)
+	if s.StopPort != 0 {
+		files[s.stopConfigName()+".xml"] = StopConfigXML(s)
+	}
+	return files, nil
+}
+
+// WritePackage generates the controller package into dir, returning the paths of the files written.
+func WritePackage(dir string, s Settings) ([]string, error) {
+	files, err := Generate(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, files[name], 0o644); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
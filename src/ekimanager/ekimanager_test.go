@@ -0,0 +1,170 @@
+package ekimanager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func defaultSettings() Settings {
+	return Settings{
+		IPAddress:     "172.31.1.147",
+		Port:          54610,
+		ConfigName:    DefaultConfigName,
+		AliveFlag:     DefaultAliveFlag,
+		ReceiveFlag:   DefaultReceiveFlag,
+		StopAliveFlag: DefaultStopAliveFlag,
+		StopFlag:      DefaultStopFlag,
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	t.Run("defaults match the shipped files", func(t *testing.T) {
+		files, err := Generate(defaultSettings())
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 8)
+
+		shipped, err := os.ReadFile(globalsFile)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, string(files[globalsFile]), test.ShouldEqual, string(shipped))
+		test.That(t, files["ekiMain.src"], test.ShouldNotBeEmpty)
+		test.That(t, string(files["ekiManagerConfig.xml"]), test.ShouldContainSubstring, "<PORT>54610</PORT>")
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		settings := Settings{
+			IPAddress:      "10.0.0.2",
+			Port:           6000,
+			ConfigName:     "viamEki",
+			AliveFlag:      20,
+			ReceiveFlag:    21,
+			ExtStartOutput: 100,
+			StreamPeriodMs: 20,
+		}
+		files, err := Generate(settings)
+		test.That(t, err, test.ShouldBeNil)
+
+		globals := string(files[globalsFile])
+		test.That(t, globals, test.ShouldContainSubstring, "ekiConfigFile[]=\"viamEki\"\r\n")
+		test.That(t, globals, test.ShouldContainSubstring, "GLOBAL INT ekiAliveFlagNum=20\r\n")
+		test.That(t, globals, test.ShouldContainSubstring, "GLOBAL INT ekiReveiveFlagNum=21\r\n")
+		test.That(t, globals, test.ShouldContainSubstring, "GLOBAL INT ekiExtStartOutNum=100\r\n")
+		test.That(t, globals, test.ShouldContainSubstring, "GLOBAL INT ekiStreamPeriodMs=20\r\n")
+
+		xml := string(files["viamEki.xml"])
+		test.That(t, xml, test.ShouldContainSubstring, "<IP>10.0.0.2</IP>")
+		test.That(t, xml, test.ShouldContainSubstring, "<PORT>6000</PORT>")
+		test.That(t, xml, test.ShouldContainSubstring, `<ALIVE Set_Flag="20"/>`)
+		test.That(t, xml, test.ShouldContainSubstring, `Set_Flag="21"`)
+		_, ok := files["ekiManagerConfig.xml"]
+		test.That(t, ok, test.ShouldBeFalse)
+	})
+
+	t.Run("xml messages", func(t *testing.T) {
+		settings := defaultSettings()
+		text, err := Generate(settings)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, string(text[globalsFile]), test.ShouldContainSubstring, "GLOBAL BOOL ekiXmlMessages=FALSE\r\n")
+		test.That(t, string(text["ekiManagerConfig.xml"]), test.ShouldContainSubstring, `<RAW>`)
+
+		settings.MessageFormat = MessageFormatXML
+		files, err := Generate(settings)
+		test.That(t, err, test.ShouldBeNil)
+
+		globals := string(files[globalsFile])
+		test.That(t, globals, test.ShouldContainSubstring, "GLOBAL BOOL ekiXmlMessages=TRUE\r\n")
+		test.That(t, globals, test.ShouldContainSubstring, "ekiCommandTag[]=\"Cmd\"\r\n")
+
+		xml := string(files["ekiManagerConfig.xml"])
+		test.That(t, xml, test.ShouldContainSubstring, `<ELEMENT Tag="Cmd/Arg" Type="STRING"/>`)
+		test.That(t, xml, test.ShouldContainSubstring, `<ELEMENT Tag="Cmd" Set_Flag="10"/>`)
+		test.That(t, xml, test.ShouldContainSubstring, `<ELEMENT Tag="Reply"/>`)
+		test.That(t, xml, test.ShouldNotContainSubstring, "Buffer")
+	})
+
+	t.Run("stop channel", func(t *testing.T) {
+		settings := defaultSettings()
+		settings.StopPort = 54611
+		settings.StopAliveFlag = 3
+		settings.StopFlag = 12
+		files, err := Generate(settings)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(files), test.ShouldEqual, 9)
+		test.That(t, files["ekiStopHandler.sub"], test.ShouldNotBeEmpty)
+
+		globals := string(files[globalsFile])
+		test.That(t, globals, test.ShouldContainSubstring, "GLOBAL BOOL ekiStopChannel=TRUE\r\n")
+		test.That(t, globals, test.ShouldContainSubstring, "ekiStopConfigFile[]=\"ekiManagerConfigStop\"\r\n")
+		test.That(t, globals, test.ShouldContainSubstring, "GLOBAL INT ekiStopAliveFlagNum=3\r\n")
+		test.That(t, globals, test.ShouldContainSubstring, "GLOBAL INT ekiStopFlagNum=12\r\n")
+
+		xml := string(files["ekiManagerConfigStop.xml"])
+		test.That(t, xml, test.ShouldContainSubstring, "<PORT>54611</PORT>")
+		test.That(t, xml, test.ShouldContainSubstring, `<ALIVE Set_Flag="3"/>`)
+		test.That(t, xml, test.ShouldContainSubstring, `Set_Flag="12"`)
+
+		// The stop channel is read as text whatever the message format
+		settings.MessageFormat = MessageFormatXML
+		files, err = Generate(settings)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, string(files["ekiManagerConfigStop.xml"]), test.ShouldContainSubstring, `<RAW>`)
+	})
+
+	t.Run("missing declaration", func(t *testing.T) {
+		_, err := configureGlobals([]byte("DEFDAT EKIGLOBALS PUBLIC\r\nENDDAT\r\n"), defaultSettings())
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "expected one match")
+	})
+
+	errorTests := []struct {
+		description string
+		modify      func(*Settings)
+		errContains string
+	}{
+		{description: "host name", modify: func(s *Settings) { s.IPAddress = "kuka.local" }, errContains: "must be the IP address"},
+		{description: "port", modify: func(s *Settings) { s.Port = 70000 }, errContains: "port (70000)"},
+		{description: "config name", modify: func(s *Settings) { s.ConfigName = "eki config" }, errContains: "config name"},
+		{description: "alive flag", modify: func(s *Settings) { s.AliveFlag = 0 }, errContains: "alive flag (0)"},
+		{description: "same flags", modify: func(s *Settings) { s.ReceiveFlag = s.AliveFlag }, errContains: "must differ"},
+		{description: "output", modify: func(s *Settings) { s.ExtStartOutput = -1 }, errContains: "ext start output (-1)"},
+		{description: "stream period", modify: func(s *Settings) { s.StreamPeriodMs = 5 }, errContains: "stream period (5)"},
+		{description: "message format", modify: func(s *Settings) { s.MessageFormat = "json" }, errContains: "message format (json)"},
+		{description: "stop port", modify: func(s *Settings) { s.StopPort = s.Port }, errContains: "stop port and port must differ"},
+		{description: "stop flag", modify: func(s *Settings) {
+			s.StopPort = 54611
+			s.StopFlag = s.ReceiveFlag
+		}, errContains: "receive flag and stop flag must differ"},
+		{description: "stop alive flag", modify: func(s *Settings) {
+			s.StopPort = 54611
+			s.StopAliveFlag = 0
+		}, errContains: "stop alive flag (0)"},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.description, func(t *testing.T) {
+			settings := defaultSettings()
+			tt.modify(&settings)
+			_, err := Generate(settings)
+			test.That(t, err, test.ShouldNotBeNil)
+			test.That(t, err.Error(), test.ShouldContainSubstring, tt.errContains)
+		})
+	}
+}
+
+func TestWritePackage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "package")
+	paths, err := WritePackage(dir, defaultSettings())
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(paths), test.ShouldEqual, 8)
+	test.That(t, paths[0], test.ShouldEqual, filepath.Join(dir, "ekiCommHandler.sub"))
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, data, test.ShouldNotBeEmpty)
+	}
+	test.That(t, strings.HasSuffix(paths[len(paths)-1], "ekiUtils.src"), test.ShouldBeTrue)
+}
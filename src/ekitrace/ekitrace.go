@@ -0,0 +1,155 @@
+// Package ekitrace records the data sent to and received from the kuka device to a trace file of JSON lines, and plays
+// a recorded trace back to the arm to reproduce a session:
+//
+//	{"time":"2026-10-19T09:30:01.123456789Z","dir":"sent","data":"getcurrentjoints;"}
+//	{"time":"2026-10-19T09:30:01.131212454Z","dir":"received","data":"getcurrentjoints,0.0000,-90.0000,..."}
+//
+// Data is recorded as read from and written to the connection, so a message split across reads, or several messages
+// received at once, are played back the same.
+package ekitrace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Direction is whether an entry of a trace was sent to or received from the kuka device.
+type Direction string
+
+// Directions of the entries of a trace
+const (
+	Sent     Direction = "sent"
+	Received Direction = "received"
+)
+
+// Entry is data sent to or received from the kuka device at a given time.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"dir"`
+	Data      string    `json:"data"`
+}
+
+// Recorder appends the entries of a trace to a file.
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	err     error
+}
+
+// NewRecorder opens the trace file at path, creating it if needed, and appends the entries recorded to it.
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Record appends an entry of the given data, timestamped now. The first error writing the trace is kept and returned
+// by Close, after which nothing more is recorded.
+func (r *Recorder) Record(direction Direction, data []byte) {
+	entry := Entry{Time: time.Now(), Direction: direction, Data: string(data)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.err = r.encoder.Encode(entry)
+}
+
+// Close closes the trace file, returning the first error recording to it, if any.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.file.Close()
+	if r.err != nil {
+		return errors.Wrapf(r.err, "failed to record trace to %v", r.file.Name())
+	}
+	return err
+}
+
+// Wrap returns conn recording the data read from and written to it. Closing it closes both conn and the recorder.
+func (r *Recorder) Wrap(conn net.Conn) net.Conn {
+	return &recordingConn{Conn: conn, recorder: r}
+}
+
+type recordingConn struct {
+	net.Conn
+	recorder *Recorder
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.recorder.Record(Received, b[:n])
+	}
+	return n, err
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.recorder.Record(Sent, b[:n])
+	}
+	return n, err
+}
+
+func (c *recordingConn) Close() error {
+	err := c.Conn.Close()
+	if recErr := c.recorder.Close(); err == nil {
+		err = recErr
+	}
+	return err
+}
+
+// Read reads the entries of a trace.
+func Read(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, errors.Wrapf(err, "invalid trace entry on line %v", line)
+		}
+		if entry.Direction != Sent && entry.Direction != Received {
+			return nil, errors.Errorf("invalid direction (%q) on line %v, must be %v or %v", entry.Direction, line,
+				Sent, Received)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// ReadFile reads the entries of the trace file at path.
+func ReadFile(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Read(bytes.NewReader(data))
+}
+
+// Data returns the data of the entries in the given direction, in order.
+func Data(entries []Entry, direction Direction) []string {
+	var data []string
+	for _, entry := range entries {
+		if entry.Direction == direction {
+			data = append(data, entry.Data)
+		}
+	}
+	return data
+}
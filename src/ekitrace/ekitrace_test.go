@@ -0,0 +1,118 @@
+package ekitrace
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	recorder, err := NewRecorder(path)
+	test.That(t, err, test.ShouldBeNil)
+
+	client, device := net.Pipe()
+	conn := recorder.Wrap(client)
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := device.Read(buf)
+		device.Write([]byte(string(buf[:n-1]) + ",KR10;"))
+	}()
+
+	start := time.Now()
+	_, err = conn.Write([]byte("getrobotname;"))
+	test.That(t, err, test.ShouldBeNil)
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, string(buf[:n]), test.ShouldEqual, "getrobotname,KR10;")
+	test.That(t, conn.Close(), test.ShouldBeNil)
+	test.That(t, device.Close(), test.ShouldBeNil)
+
+	entries, err := ReadFile(path)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(entries), test.ShouldEqual, 2)
+	test.That(t, entries[0].Direction, test.ShouldEqual, Sent)
+	test.That(t, entries[0].Data, test.ShouldEqual, "getrobotname;")
+	test.That(t, entries[0].Time.Before(start), test.ShouldBeFalse)
+	test.That(t, Data(entries, Received), test.ShouldResemble, []string{"getrobotname,KR10;"})
+
+	// A trace is appended to
+	recorder, err = NewRecorder(path)
+	test.That(t, err, test.ShouldBeNil)
+	recorder.Record(Sent, []byte("getrunmode;"))
+	test.That(t, recorder.Close(), test.ShouldBeNil)
+	entries, err = ReadFile(path)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(entries), test.ShouldEqual, 3)
+
+	_, err = Read(strings.NewReader(`{"time":"2026-10-19T09:30:01Z","dir":"sent","data":"x;"}` + "\n\n" +
+		`{"time":"2026-10-19T09:30:01Z","dir":"up","data":"x;"}`))
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "line 3")
+	_, err = Read(strings.NewReader("not json"))
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestReplay(t *testing.T) {
+	replay := NewReplay([]Entry{
+		{Direction: Received, Data: "statestream,1;"},
+		{Direction: Sent, Data: "getrobotname;"},
+		{Direction: Received, Data: "getrobotname,KR10;"},
+	})
+
+	buf := make([]byte, 8)
+	n, err := replay.Conn.Read(buf)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, string(buf[:n]), test.ShouldEqual, "statestr")
+	n, err = replay.Conn.Read(buf)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, string(buf[:n]), test.ShouldEqual, "eam,1;")
+
+	// The reply is only read once the command has been written
+	read := make(chan string, 1)
+	go func() {
+		n, _ := replay.Conn.Read(buf)
+		read <- string(buf[:n])
+	}()
+	select {
+	case <-read:
+		t.Fatal("reply read before the command was written")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err = replay.Conn.Write([]byte("getrobotname;"))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, <-read, test.ShouldEqual, "getrobot")
+
+	n, err = replay.Conn.Read(buf)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, string(buf[:n]), test.ShouldEqual, "name,KR1")
+	n, err = replay.Conn.Read(buf)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, string(buf[:n]), test.ShouldEqual, "0;")
+	test.That(t, replay.Written(), test.ShouldResemble, []string{"getrobotname;"})
+
+	// Once played back, reads wait for the connection to be closed
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		replay.Conn.Close()
+	}()
+	all, err := io.ReadAll(replay.Conn)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, all, test.ShouldBeEmpty)
+	<-replay.Done()
+
+	// Closing ends a read waiting on a write
+	replay = NewReplay([]Entry{{Direction: Sent, Data: "getrobotname;"}, {Direction: Received, Data: "x;"}})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		replay.Conn.Close()
+	}()
+	_, err = replay.Conn.Read(buf)
+	test.That(t, err, test.ShouldEqual, io.EOF)
+}
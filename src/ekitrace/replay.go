@@ -0,0 +1,111 @@
+package ekitrace
+
+import (
+	"io"
+	"sync"
+
+	"github.com/viam-soleng/viam-kuka/inject"
+)
+
+// Replay plays back the data received in a trace through an injected connection, standing in for the kuka device.
+// The data received after data sent in the trace is only read once as many writes have been made to the connection,
+// so replies follow the commands they answer. Once everything received has been played back, reads wait for the
+// connection to be closed, as the kuka device keeps it open, then end with io.EOF.
+type Replay struct {
+	Conn *inject.TCPConn
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	received []string
+	// sentBefore is the number of entries sent before each entry received
+	sentBefore []int
+	pending    []byte
+	written    []string
+	closed     bool
+	done       chan struct{}
+}
+
+// NewReplay returns a replay of the given entries. Data is only read from and written to its Conn, which is not
+// connected to anything.
+func NewReplay(entries []Entry) *Replay {
+	r := &Replay{Conn: inject.NewTCPConn(), done: make(chan struct{})}
+	r.cond = sync.NewCond(&r.mu)
+
+	sent := 0
+	for _, entry := range entries {
+		switch entry.Direction {
+		case Sent:
+			sent++
+		case Received:
+			r.received = append(r.received, entry.Data)
+			r.sentBefore = append(r.sentBefore, sent)
+		}
+	}
+
+	r.Conn.ReadFunc = r.read
+	r.Conn.WriteFunc = r.write
+	r.Conn.CloseFunc = r.close
+	return r
+}
+
+// Done returns a channel closed once everything received in the trace has been read.
+func (r *Replay) Done() <-chan struct{} {
+	return r.done
+}
+
+// Written returns the data written to the connection, to be compared with the data sent in the trace.
+func (r *Replay) Written() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.written...)
+}
+
+func (r *Replay) read(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.pending) == 0 {
+		if len(r.received) == 0 {
+			select {
+			case <-r.done:
+			default:
+				close(r.done)
+			}
+			for !r.closed {
+				r.cond.Wait()
+			}
+			return 0, io.EOF
+		}
+		for !r.closed && len(r.written) < r.sentBefore[0] {
+			r.cond.Wait()
+		}
+		if r.closed {
+			return 0, io.EOF
+		}
+		r.pending = []byte(r.received[0])
+		r.received, r.sentBefore = r.received[1:], r.sentBefore[1:]
+	}
+
+	n := copy(b, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *Replay) write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+	r.written = append(r.written, string(b))
+	r.cond.Broadcast()
+	return len(b), nil
+}
+
+func (r *Replay) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	r.cond.Broadcast()
+	return nil
+}
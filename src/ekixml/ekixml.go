@@ -0,0 +1,117 @@
+// Package ekixml encodes the commands sent to the EKI program and decodes its replies in the XML message format, read
+// and written by EthernetKRL as elements and attributes rather than as a comma separated stream:
+//
+//	<Cmd Name="setjointspeed" Id="0" Count="1"><Arg>10</Arg></Cmd>
+//	<Reply Name="setjointspeed" Id="0" Count="1"><V>success</V></Reply>
+//
+// The command set is the one of the text format. Count is the number of Arg or V elements, which EthernetKRL buffers
+// and the EKI program reads one at a time, and Id is the command id used when useCommandId is set in ekiGlobals.dat.
+package ekixml
+
+import (
+	"encoding/xml"
+	"io"
+	"regexp"
+
+	"github.com/pkg/errors"
+
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+)
+
+// Limits of the EKI program, which reads the name, id and arguments of a command into 20 strings of 99 characters.
+const (
+	MaxArgs      = 18
+	MaxArgLength = 99
+)
+
+// Command is a command sent to the EKI program.
+type Command struct {
+	XMLName xml.Name `xml:"Cmd"`
+	Name    string   `xml:"Name,attr"`
+	ID      int      `xml:"Id,attr"`
+	Count   int      `xml:"Count,attr"`
+	Args    []string `xml:"Arg"`
+}
+
+// Reply is a reply of the EKI program to a command, or a message it pushes without being requested.
+type Reply struct {
+	XMLName xml.Name `xml:"Reply"`
+	Name    string   `xml:"Name,attr"`
+	ID      int      `xml:"Id,attr"`
+	Count   int      `xml:"Count,attr"`
+	Values  []string `xml:"V"`
+}
+
+// ErrInvalidReply is the cause of the errors returned by Decoder.Next for a well formed element that is not a valid
+// reply, after which the stream can still be read.
+var ErrInvalidReply = errors.New("invalid reply")
+
+var nameRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// Encode returns the message of a command and its arguments, checked against the command of the registry of the same
+// name and the limits of the EKI program. Commands missing from the registry are sent as given, for the EKI program to
+// refuse those it does not know.
+func Encode(name string, args []string) ([]byte, error) {
+	if !nameRegex.MatchString(name) {
+		return nil, errors.Errorf("command name (%q) must be a KRL name", name)
+	}
+	if command, ok := ekiCommand.Lookup(name); ok {
+		if err := command.Check(args); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) > MaxArgs {
+		return nil, errors.Errorf("%v arguments given to %v, at most %v can be read by the EKI program", len(args), name,
+			MaxArgs)
+	}
+	for i, arg := range args {
+		if len(arg) > MaxArgLength {
+			return nil, errors.Errorf("argument %v of %v is longer than %v characters", i, name, MaxArgLength)
+		}
+	}
+	return xml.Marshal(Command{Name: name, Count: len(args), Args: args})
+}
+
+// Decoder reads the replies of the EKI program from a stream.
+type Decoder struct {
+	decoder *xml.Decoder
+}
+
+// NewDecoder returns a decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{decoder: xml.NewDecoder(r)}
+}
+
+// Next returns the next reply, skipping what is found between replies such as whitespace or an XML declaration. A
+// reply without a name or whose count does not match its values is an ErrInvalidReply, any other error ends the stream.
+func (d *Decoder) Next() (*Reply, error) {
+	for {
+		token, err := d.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local != "Reply" {
+			if err := d.decoder.Skip(); err != nil {
+				return nil, err
+			}
+			return nil, errors.Wrapf(ErrInvalidReply, "unexpected element <%v>", start.Name.Local)
+		}
+
+		var reply Reply
+		if err := d.decoder.DecodeElement(&reply, &start); err != nil {
+			return nil, err
+		}
+		if reply.Name == "" {
+			return nil, errors.Wrap(ErrInvalidReply, "no name given")
+		}
+		if reply.Count != len(reply.Values) {
+			return nil, errors.Wrapf(ErrInvalidReply, "%v has %v values, %v expected", reply.Name, len(reply.Values),
+				reply.Count)
+		}
+		return &reply, nil
+	}
+}
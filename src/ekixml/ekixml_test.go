@@ -0,0 +1,77 @@
+package ekixml
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.viam.com/test"
+
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+)
+
+func TestEncode(t *testing.T) {
+	data, err := Encode(ekiCommand.SetJointSpeed, []string{"10"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, string(data), test.ShouldEqual, `<Cmd Name="setjointspeed" Id="0" Count="1"><Arg>10</Arg></Cmd>`)
+
+	data, err = Encode(ekiCommand.GetJointPosition, nil)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, string(data), test.ShouldEqual, `<Cmd Name="getcurrentjoints" Id="0" Count="0"></Cmd>`)
+
+	// Commands unknown to the schema are sent as given
+	_, err = Encode("getMadaDh", nil)
+	test.That(t, err, test.ShouldBeNil)
+
+	_, err = Encode(ekiCommand.SetToolData, []string{"0", "0", "100"})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "takes 6 arguments")
+	_, err = Encode(`set"stop`, nil)
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = Encode("custom", make([]string, MaxArgs+1))
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = Encode(ekiCommand.SetJointSpeed, []string{"fast"})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "speed of setjointspeed must be of type float")
+	_, err = Encode("custom", []string{strings.Repeat("1", MaxArgLength+1)})
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestDecoder(t *testing.T) {
+	stream := `<?xml version="1.0"?>
+<Reply Name="getcurrentjoints" Id="0" Count="3"><V>0.0</V><V>-90.0</V><V>90.0</V></Reply>` +
+		`<Reply Name="setstop" Id="4" Count="1"><V>success</V></Reply>
+<Reply Name="setoverride" Id="0" Count="2"><V>success</V></Reply>
+<Other/>
+<Reply Count="0"></Reply>
+<Reply Name="getrobotname" Id="0" Count="1"><V>KR10 &amp; co</V></Reply>`
+	decoder := NewDecoder(strings.NewReader(stream))
+
+	reply, err := decoder.Next()
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, reply.Name, test.ShouldEqual, ekiCommand.GetJointPosition)
+	test.That(t, reply.Values, test.ShouldResemble, []string{"0.0", "-90.0", "90.0"})
+
+	reply, err = decoder.Next()
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, reply.ID, test.ShouldEqual, 4)
+	test.That(t, reply.Values, test.ShouldResemble, []string{ekiCommand.ResponseSuccess})
+
+	// Invalid replies are skipped over
+	for i := 0; i < 3; i++ {
+		_, err = decoder.Next()
+		test.That(t, errors.Cause(err), test.ShouldEqual, ErrInvalidReply)
+	}
+
+	reply, err = decoder.Next()
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, reply.Values, test.ShouldResemble, []string{"KR10 & co"})
+
+	_, err = decoder.Next()
+	test.That(t, err, test.ShouldEqual, io.EOF)
+
+	_, err = NewDecoder(strings.NewReader(`<Reply Name="x" Count="0"></Other>`)).Next()
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, errors.Cause(err), test.ShouldNotEqual, ErrInvalidReply)
+}
@@ -0,0 +1,124 @@
+package krl
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// namePattern matches valid KRL identifiers, which are limited to 24 characters.
+var namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,23}$`)
+
+// Format writes a program as a KRL .src/.dat pair that can be run stand-alone on the controller. Motion targets are
+// declared in the .dat, named after their Target or, if none is set, numbered in order (XP1, XP2, ...).
+func Format(prog *Program) (string, string, error) {
+	if !namePattern.MatchString(prog.Name) {
+		return "", "", errors.Errorf("invalid program name %q, names must start with a letter and be at most 24 characters", prog.Name)
+	}
+
+	var src, dat strings.Builder
+	fmt.Fprintf(&src, "&ACCESS RVP\n&REL 1\nDEF %v( )\n", prog.Name)
+	src.WriteString("BAS(#INITMOV, 0)\n")
+	fmt.Fprintf(&dat, "&ACCESS RVP\n&REL 1\nDEFDAT %v PUBLIC\n", prog.Name)
+
+	declared := map[string]string{}
+	pointNum := 0
+	for i, instruction := range prog.Instructions {
+		switch instruction.Kind {
+		case KindPTP, KindLIN:
+			var declType, value string
+			switch {
+			case instruction.Axis != nil && instruction.Kind == KindPTP:
+				declType, value = "E6AXIS", formatAxis(instruction.Axis)
+			case instruction.Pos != nil:
+				declType, value = "E6POS", formatPos(instruction.Pos)
+			default:
+				return "", "", errors.Errorf("instruction %v: %v requires a valid target", i+1, instruction.Kind)
+			}
+
+			name := instruction.Target
+			if name == "" {
+				pointNum++
+				name = fmt.Sprintf("XP%v", pointNum)
+				for declared[strings.ToUpper(name)] != "" {
+					pointNum++
+					name = fmt.Sprintf("XP%v", pointNum)
+				}
+			}
+			if !namePattern.MatchString(name) {
+				return "", "", errors.Errorf("instruction %v: invalid point name %q", i+1, name)
+			}
+
+			decl := fmt.Sprintf("%v %v=%v", declType, name, value)
+			if prev, ok := declared[strings.ToUpper(name)]; !ok {
+				declared[strings.ToUpper(name)] = decl
+				fmt.Fprintf(&dat, "DECL %v\n", decl)
+			} else if prev != decl {
+				return "", "", errors.Errorf("instruction %v: point %v is declared with different values", i+1, name)
+			}
+			fmt.Fprintf(&src, "%v %v\n", instruction.Kind, name)
+		case KindWait:
+			fmt.Fprintf(&src, "WAIT SEC %v\n", formatReal(instruction.Value))
+		case KindJointSpeed:
+			fmt.Fprintf(&src, "BAS(#VEL_PTP, %v)\n", formatReal(instruction.Value))
+		case KindCartSpeed:
+			fmt.Fprintf(&src, "$VEL.CP = %v\n", formatReal(instruction.Value))
+		case KindOverride:
+			fmt.Fprintf(&src, "$OV_PRO = %v\n", formatReal(instruction.Value))
+		case KindTool, KindBase:
+			if instruction.Frame == nil {
+				return "", "", errors.Errorf("instruction %v: %v requires a frame", i+1, instruction.Kind)
+			}
+			fmt.Fprintf(&src, "$%v = %v\n", instruction.Kind, formatFrame(instruction.Frame))
+		default:
+			return "", "", errors.Errorf("instruction %v: unsupported instruction %v", i+1, instruction.Kind)
+		}
+	}
+
+	src.WriteString("END\n")
+	dat.WriteString("ENDDAT\n")
+	return src.String(), dat.String(), nil
+}
+
+func formatReal(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func formatFields(names []string, values []float64) string {
+	fields := make([]string, len(names))
+	for i, name := range names {
+		fields[i] = name + " " + formatReal(values[i])
+	}
+	return strings.Join(fields, ",")
+}
+
+var externalNames = []string{"E1", "E2", "E3", "E4", "E5", "E6"}
+
+// FormatAxis formats an axis as a typed E6AXIS aggregate, as assigned to a variable on the controller.
+func FormatAxis(axis *Axis) string {
+	return "{E6AXIS: " + strings.TrimPrefix(formatAxis(axis), "{")
+}
+
+func formatAxis(axis *Axis) string {
+	return "{" + formatFields([]string{"A1", "A2", "A3", "A4", "A5", "A6"}, axis.Joints[:]) + "," +
+		formatFields(externalNames, axis.External[:]) + "}"
+}
+
+func formatFrame(frame *Frame) string {
+	return "{" + formatFrameFields(frame) + "}"
+}
+
+func formatFrameFields(frame *Frame) string {
+	return formatFields([]string{"X", "Y", "Z", "A", "B", "C"}, []float64{frame.X, frame.Y, frame.Z, frame.A, frame.B, frame.C})
+}
+
+func formatPos(pos *Pos) string {
+	fields := formatFrameFields(&pos.Frame)
+	if pos.HasST {
+		fields += fmt.Sprintf(",S %v,T %v", pos.S, pos.T)
+	}
+	return "{" + fields + "," + formatFields(externalNames, pos.External[:]) + "}"
+}
@@ -0,0 +1,93 @@
+package krl
+
+import (
+	"testing"
+
+	"go.viam.com/test"
+)
+
+// withoutPositions clears the source line and point names, which are not preserved when formatting.
+func withoutPositions(instructions []Instruction) []Instruction {
+	cleared := make([]Instruction, len(instructions))
+	for i, instruction := range instructions {
+		instruction.Line = 0
+		instruction.Target = ""
+		cleared[i] = instruction
+	}
+	return cleared
+}
+
+func TestFormat(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		prog := &Program{
+			Name: "viam_plan",
+			Instructions: []Instruction{
+				{Kind: KindTool, Frame: &Frame{Z: 120.25}},
+				{Kind: KindBase, Frame: &Frame{X: 1000, Y: -250, A: 90}},
+				{Kind: KindJointSpeed, Value: 30},
+				{Kind: KindCartSpeed, Value: 0.25},
+				{Kind: KindOverride, Value: 75},
+				{Kind: KindPTP, Axis: &Axis{Joints: [6]float64{0, -90, 90, 0, 45.5, 0}, External: [6]float64{10}}},
+				{Kind: KindPTP, Pos: &Pos{Frame: Frame{X: 500, Y: 0, Z: 600, B: 90}, S: 2, T: 10, HasST: true}},
+				{Kind: KindLIN, Pos: &Pos{Frame: Frame{X: 500, Y: 200, Z: 600.125, A: -179.5, B: 1e-7, C: 3}}},
+				{Kind: KindWait, Value: 0.5},
+			},
+		}
+
+		src, dat, err := Format(prog)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, src, test.ShouldContainSubstring, "DEF viam_plan( )\n")
+		test.That(t, src, test.ShouldContainSubstring, "LIN XP3\n")
+		test.That(t, dat, test.ShouldContainSubstring, "DECL E6POS XP2={X 500,Y 0,Z 600,A 0,B 90,C 0,S 2,T 10,")
+
+		parsed, err := Parse(src, dat)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, parsed.Name, test.ShouldEqual, prog.Name)
+		test.That(t, parsed.Warnings, test.ShouldBeEmpty)
+		test.That(t, withoutPositions(parsed.Instructions), test.ShouldResemble, prog.Instructions)
+	})
+
+	t.Run("round trip parsed program", func(t *testing.T) {
+		prog, err := Parse(testSrc, testDat, testConfigDat)
+		test.That(t, err, test.ShouldBeNil)
+
+		src, dat, err := Format(prog)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, src, test.ShouldContainSubstring, "PTP XHOME\n")
+		test.That(t, src, test.ShouldContainSubstring, "PTP XP3\n")
+
+		reparsed, err := Parse(src, dat)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, withoutPositions(reparsed.Instructions), test.ShouldResemble, withoutPositions(prog.Instructions))
+	})
+
+	errorTests := []struct {
+		description string
+		prog        *Program
+		errContains string
+	}{
+		{description: "invalid name", prog: &Program{Name: "1plan"}, errContains: "invalid program name"},
+		{description: "long name", prog: &Program{Name: "a_program_name_that_is_too_long"}, errContains: "invalid program name"},
+		{
+			description: "missing target",
+			prog:        &Program{Name: "p", Instructions: []Instruction{{Kind: KindLIN, Axis: &Axis{}}}},
+			errContains: "requires a valid target",
+		},
+		{
+			description: "conflicting point",
+			prog: &Program{Name: "p", Instructions: []Instruction{
+				{Kind: KindPTP, Target: "XA", Axis: &Axis{}},
+				{Kind: KindPTP, Target: "XA", Axis: &Axis{Joints: [6]float64{1}}},
+			}},
+			errContains: "declared with different values",
+		},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.description, func(t *testing.T) {
+			_, _, err := Format(tt.prog)
+			test.That(t, err, test.ShouldNotBeNil)
+			test.That(t, err.Error(), test.ShouldContainSubstring, tt.errContains)
+		})
+	}
+}
@@ -0,0 +1,469 @@
+// Package krl reads and writes the subset of the KUKA Robot Language (KRL) used by point to point motion programs:
+// PTP and LIN motions to E6AXIS/E6POS points declared in a program's .dat file, along with speed, override, tool,
+// base and wait statements.
+package krl
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Kind is the type of an instruction in a motion program.
+type Kind string
+
+const (
+	KindPTP        Kind = "PTP"         // point to point motion to an Axis or Pos
+	KindLIN        Kind = "LIN"         // linear motion to a Pos
+	KindWait       Kind = "WAIT"        // wait for Value seconds
+	KindJointSpeed Kind = "JOINT_SPEED" // set joint speed to Value percent
+	KindCartSpeed  Kind = "CART_SPEED"  // set cartesian speed to Value m/s
+	KindOverride   Kind = "OVERRIDE"    // set program override to Value percent
+	KindTool       Kind = "TOOL"        // set the tool to Frame
+	KindBase       Kind = "BASE"        // set the base to Frame
+)
+
+// Axis is a KRL E6AXIS: robot joint values a1-a6 and external axes e1-e6, in degrees.
+type Axis struct {
+	Joints   [6]float64
+	External [6]float64
+}
+
+// Frame is a KRL FRAME: x,y,z in mm and a,b,c in degrees.
+type Frame struct {
+	X, Y, Z, A, B, C float64
+}
+
+// Pos is a KRL E6POS: a frame along with the status and turn selecting the robot configuration, and external axes.
+type Pos struct {
+	Frame
+	S, T     int
+	HasST    bool
+	External [6]float64
+}
+
+// Instruction is a single executable statement of a motion program. Motions reference either an Axis or a Pos.
+type Instruction struct {
+	Kind   Kind
+	Line   int
+	Target string
+	Axis   *Axis
+	Pos    *Pos
+	Frame  *Frame
+	Value  float64
+}
+
+// Program is a parsed motion program.
+type Program struct {
+	Name         string
+	Instructions []Instruction
+	Warnings     []string
+}
+
+var (
+	numberPattern = `[-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?`
+
+	declPattern      = regexp.MustCompile(`(?i)^(?:(?:DECL|GLOBAL)\s+)*(E6AXIS|AXIS|E6POS|POS|FRAME|PDAT|LDAT|FDAT)\s+(\w+)\s*=\s*(\{.*\})$`)
+	defPattern       = regexp.MustCompile(`(?i)^(?:GLOBAL\s+)?DEF\s+(\w+)\s*\(`)
+	motionPattern    = regexp.MustCompile(`(?i)^(S?PTP|S?LIN)\s+(\{.*\}|[\w$.]+)(?:\s+C_(?:PTP|DIS|VEL|ORI|SPL))?$`)
+	waitPattern      = regexp.MustCompile(`(?i)^WAIT\s+SEC\s+(` + numberPattern + `)$`)
+	overridePattern  = regexp.MustCompile(`(?i)^\$OV_PRO\s*=\s*(` + numberPattern + `)$`)
+	cartVelPattern   = regexp.MustCompile(`(?i)^\$VEL\.CP\s*=\s*(` + numberPattern + `)$`)
+	basPattern       = regexp.MustCompile(`(?i)^BAS\s*\(\s*#(\w+)\s*,\s*(` + numberPattern + `)\s*\)$`)
+	activeDatPattern = regexp.MustCompile(`(?i)^(PDAT_ACT|LDAT_ACT)\s*=\s*(\w+)$`)
+	framePattern     = regexp.MustCompile(`(?i)^\$(TOOL|BASE)\s*=\s*(.+)$`)
+	callPattern      = regexp.MustCompile(`(?i)^(\w+)\s*\(.*\)$`)
+
+	unsupportedMotionPattern = regexp.MustCompile(`(?i)^(CIRC|SCIRC|SPL|SLIN_REL|SPTP_REL|PTP_REL|LIN_REL|CIRC_REL)\b`)
+	controlFlowPattern       = regexp.MustCompile(`(?i)^(IF|ELSE|ENDIF|LOOP|ENDLOOP|FOR|ENDFOR|WHILE|ENDWHILE|REPEAT|UNTIL|SWITCH|CASE|DEFAULT|ENDSWITCH|GOTO|EXIT|HALT)\b`)
+	ignoredPattern           = regexp.MustCompile(`(?i)^(END|ENDDAT|DEFDAT\b.*|INI|DECL\b.*|(?:GLOBAL\s+)?INTERRUPT\b.*|WAIT\s+FOR\b.*|GLOBAL\s+DEFDAT\b.*)$`)
+	// inlineFormPattern matches the assignments inline forms make that have no effect on the motion.
+	inlineFormPattern = regexp.MustCompile(`(?i)^(FDAT_ACT|\$BWDSTART|\$H_POS)\s*=`)
+)
+
+// declarations holds the named values declared in .dat files or in the program itself.
+type declarations struct {
+	axes   map[string]Axis
+	poses  map[string]Pos
+	frames map[string]Frame
+	params map[string]map[string]string
+}
+
+// Parse parses a KRL program (.src) into a list of executable instructions. Points referenced by name are resolved
+// from the declarations in the given .dat files (typically the program's .dat and optionally $config.dat).
+func Parse(src string, dats ...string) (*Program, error) {
+	decls := &declarations{
+		axes:   map[string]Axis{},
+		poses:  map[string]Pos{},
+		frames: map[string]Frame{},
+		params: map[string]map[string]string{},
+	}
+	for _, dat := range dats {
+		for i, line := range strings.Split(dat, "\n") {
+			line = stripComment(line)
+			if line == "" || strings.HasPrefix(line, "&") {
+				continue
+			}
+			if err := decls.parse(line); err != nil {
+				return nil, errors.Wrapf(err, "dat line %v", i+1)
+			}
+		}
+	}
+
+	prog := &Program{}
+	var pdatVel float64 = 100
+	for i, line := range strings.Split(src, "\n") {
+		lineNum := i + 1
+		line = stripComment(line)
+		if line == "" || strings.HasPrefix(line, "&") {
+			continue
+		}
+
+		instruction, err := prog.parseLine(line, decls, &pdatVel)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %v", lineNum)
+		}
+		if instruction != nil {
+			instruction.Line = lineNum
+			prog.Instructions = append(prog.Instructions, *instruction)
+		}
+	}
+
+	if prog.Name == "" {
+		return nil, errors.New("no DEF found in program")
+	}
+	return prog, nil
+}
+
+// ParseAxis parses an E6AXIS or AXIS aggregate, such as the value of $AXIS_ACT read from the controller.
+func ParseAxis(value string) (*Axis, error) {
+	fields, err := parseAggregate(value)
+	if err != nil {
+		return nil, err
+	}
+	return axisFromFields(fields)
+}
+
+// ParsePos parses an E6POS or POS aggregate, such as the value of $POS_ACT read from the controller.
+func ParsePos(value string) (*Pos, error) {
+	fields, err := parseAggregate(value)
+	if err != nil {
+		return nil, err
+	}
+	return posFromFields(fields)
+}
+
+// parseLine parses a single line of a program, returning nil if the line has no executable effect.
+func (prog *Program) parseLine(line string, decls *declarations, pdatVel *float64) (*Instruction, error) {
+	if match := defPattern.FindStringSubmatch(line); match != nil {
+		if prog.Name == "" {
+			prog.Name = match[1]
+			return nil, nil
+		}
+		return nil, errors.Errorf("additional DEF %v is not supported", match[1])
+	}
+	if match := declPattern.FindStringSubmatch(line); match != nil {
+		return nil, decls.parse(line)
+	}
+	if match := motionPattern.FindStringSubmatch(line); match != nil {
+		return decls.parseMotion(strings.TrimPrefix(strings.ToUpper(match[1]), "S"), match[2])
+	}
+	if match := waitPattern.FindStringSubmatch(line); match != nil {
+		value, err := parseNumber(match[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Instruction{Kind: KindWait, Value: value}, nil
+	}
+	if match := overridePattern.FindStringSubmatch(line); match != nil {
+		value, err := parseNumber(match[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Instruction{Kind: KindOverride, Value: value}, nil
+	}
+	if match := cartVelPattern.FindStringSubmatch(line); match != nil {
+		value, err := parseNumber(match[1])
+		if err != nil {
+			return nil, err
+		}
+		return &Instruction{Kind: KindCartSpeed, Value: value}, nil
+	}
+	if match := activeDatPattern.FindStringSubmatch(line); match != nil {
+		params, ok := decls.params[strings.ToUpper(match[2])]
+		if !ok {
+			return nil, errors.Errorf("unresolved motion parameters %v", match[2])
+		}
+		if strings.EqualFold(match[1], "PDAT_ACT") {
+			vel, err := strconv.ParseFloat(params["VEL"], 64)
+			if err != nil {
+				return nil, errors.Errorf("motion parameters %v have no valid VEL", match[2])
+			}
+			*pdatVel = vel
+		}
+		return nil, nil
+	}
+	if match := basPattern.FindStringSubmatch(line); match != nil {
+		value, err := parseNumber(match[2])
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToUpper(match[1]) {
+		case "PTP_PARAMS":
+			return &Instruction{Kind: KindJointSpeed, Value: *pdatVel * value / 100}, nil
+		case "VEL_PTP":
+			return &Instruction{Kind: KindJointSpeed, Value: value}, nil
+		case "CP_PARAMS", "VEL_CP":
+			return &Instruction{Kind: KindCartSpeed, Value: value}, nil
+		default:
+			return nil, nil
+		}
+	}
+	if match := framePattern.FindStringSubmatch(line); match != nil {
+		kind := KindTool
+		if strings.EqualFold(match[1], "BASE") {
+			kind = KindBase
+		}
+		frame, err := decls.resolveFrame(match[2])
+		if err != nil {
+			prog.Warnings = append(prog.Warnings, fmt.Sprintf("ignored $%v assignment: %v", strings.ToUpper(match[1]), err))
+			return nil, nil
+		}
+		return &Instruction{Kind: kind, Frame: frame}, nil
+	}
+	if match := unsupportedMotionPattern.FindStringSubmatch(line); match != nil {
+		return nil, errors.Errorf("%v motions are not supported", strings.ToUpper(match[1]))
+	}
+	if match := controlFlowPattern.FindStringSubmatch(line); match != nil {
+		return nil, errors.Errorf("control flow (%v) is not supported in point to point programs", strings.ToUpper(match[1]))
+	}
+	if ignoredPattern.MatchString(line) || inlineFormPattern.MatchString(line) {
+		return nil, nil
+	}
+	if strings.Contains(line, "=") {
+		prog.Warnings = append(prog.Warnings, fmt.Sprintf("ignored assignment %q", line))
+		return nil, nil
+	}
+	if match := callPattern.FindStringSubmatch(line); match != nil {
+		prog.Warnings = append(prog.Warnings, fmt.Sprintf("ignored call to %v", match[1]))
+		return nil, nil
+	}
+
+	prog.Warnings = append(prog.Warnings, fmt.Sprintf("ignored statement %q", line))
+	return nil, nil
+}
+
+// parse adds the declaration on the given line, ignoring lines that are not point or motion parameter declarations.
+func (decls *declarations) parse(line string) error {
+	match := declPattern.FindStringSubmatch(line)
+	if match == nil {
+		return nil
+	}
+
+	name := strings.ToUpper(match[2])
+	fields, err := parseAggregate(match[3])
+	if err != nil {
+		return errors.Wrapf(err, "declaration of %v", match[2])
+	}
+
+	switch strings.ToUpper(match[1]) {
+	case "E6AXIS", "AXIS":
+		axis, err := axisFromFields(fields)
+		if err != nil {
+			return errors.Wrapf(err, "declaration of %v", match[2])
+		}
+		decls.axes[name] = *axis
+	case "E6POS", "POS":
+		pos, err := posFromFields(fields)
+		if err != nil {
+			return errors.Wrapf(err, "declaration of %v", match[2])
+		}
+		decls.poses[name] = *pos
+	case "FRAME":
+		frame, err := frameFromFields(fields)
+		if err != nil {
+			return errors.Wrapf(err, "declaration of %v", match[2])
+		}
+		decls.frames[name] = *frame
+	default:
+		decls.params[name] = fields
+	}
+	return nil
+}
+
+// parseMotion resolves the target of a PTP or LIN motion.
+func (decls *declarations) parseMotion(motion, target string) (*Instruction, error) {
+	instruction := &Instruction{Kind: Kind(motion)}
+
+	if strings.HasPrefix(target, "{") {
+		fields, err := parseAggregate(target)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := fields["A1"]; ok {
+			if instruction.Axis, err = axisFromFields(fields); err != nil {
+				return nil, err
+			}
+		} else if instruction.Pos, err = posFromFields(fields); err != nil {
+			return nil, err
+		}
+	} else {
+		name := strings.ToUpper(target)
+		instruction.Target = target
+		if axis, ok := decls.axes[name]; ok {
+			instruction.Axis = &axis
+		} else if pos, ok := decls.poses[name]; ok {
+			instruction.Pos = &pos
+		} else if frame, ok := decls.frames[name]; ok {
+			instruction.Pos = &Pos{Frame: frame}
+		} else {
+			return nil, errors.Errorf("unresolved point %v, is it declared in a .dat file?", target)
+		}
+	}
+
+	if instruction.Kind == KindLIN && instruction.Axis != nil {
+		return nil, errors.New("LIN motions to joint positions are not supported")
+	}
+	return instruction, nil
+}
+
+// resolveFrame resolves a frame given as an aggregate or a declared name.
+func (decls *declarations) resolveFrame(value string) (*Frame, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "{") {
+		fields, err := parseAggregate(value)
+		if err != nil {
+			return nil, err
+		}
+		return frameFromFields(fields)
+	}
+	if frame, ok := decls.frames[strings.ToUpper(value)]; ok {
+		return &frame, nil
+	}
+	return nil, errors.Errorf("unable to resolve %v", value)
+}
+
+// parseAggregate parses a KRL aggregate such as {X 1.0,Y 2.0,Z 3.0} into a map of upper case field names to values.
+func parseAggregate(aggregate string) (map[string]string, error) {
+	aggregate = strings.TrimSpace(aggregate)
+	if !strings.HasPrefix(aggregate, "{") || !strings.HasSuffix(aggregate, "}") {
+		return nil, errors.Errorf("invalid aggregate %v", aggregate)
+	}
+
+	fields := map[string]string{}
+	body := strings.TrimSpace(aggregate[1 : len(aggregate)-1])
+	// Skip an optional type prefix, e.g. {E6POS: X 1,...}
+	if idx := strings.Index(body, ":"); idx >= 0 {
+		body = body[idx+1:]
+	}
+	for _, field := range strings.Split(body, ",") {
+		parts := strings.Fields(field)
+		if len(parts) == 0 {
+			continue
+		}
+		if len(parts) < 2 {
+			return nil, errors.Errorf("invalid aggregate field %q", strings.TrimSpace(field))
+		}
+		fields[strings.ToUpper(parts[0])] = strings.Join(parts[1:], " ")
+	}
+	return fields, nil
+}
+
+func axisFromFields(fields map[string]string) (*Axis, error) {
+	axis := &Axis{}
+	for i := range axis.Joints {
+		val, err := requiredFloat(fields, fmt.Sprintf("A%v", i+1))
+		if err != nil {
+			return nil, err
+		}
+		axis.Joints[i] = val
+	}
+	for i := range axis.External {
+		val, err := optionalFloat(fields, fmt.Sprintf("E%v", i+1))
+		if err != nil {
+			return nil, err
+		}
+		axis.External[i] = val
+	}
+	return axis, nil
+}
+
+func frameFromFields(fields map[string]string) (*Frame, error) {
+	values := make([]float64, 6)
+	for i, name := range []string{"X", "Y", "Z", "A", "B", "C"} {
+		val, err := requiredFloat(fields, name)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = val
+	}
+	return &Frame{X: values[0], Y: values[1], Z: values[2], A: values[3], B: values[4], C: values[5]}, nil
+}
+
+func posFromFields(fields map[string]string) (*Pos, error) {
+	frame, err := frameFromFields(fields)
+	if err != nil {
+		return nil, err
+	}
+	pos := &Pos{Frame: *frame}
+
+	s, hasS := fields["S"]
+	t, hasT := fields["T"]
+	if hasS && hasT {
+		if pos.S, err = strconv.Atoi(s); err != nil {
+			return nil, errors.Errorf("invalid status %v", s)
+		}
+		if pos.T, err = strconv.Atoi(t); err != nil {
+			return nil, errors.Errorf("invalid turn %v", t)
+		}
+		pos.HasST = true
+	}
+
+	for i := range pos.External {
+		val, err := optionalFloat(fields, fmt.Sprintf("E%v", i+1))
+		if err != nil {
+			return nil, err
+		}
+		pos.External[i] = val
+	}
+	return pos, nil
+}
+
+func requiredFloat(fields map[string]string, name string) (float64, error) {
+	value, ok := fields[name]
+	if !ok {
+		return 0, errors.Errorf("missing %v (partial aggregates are not supported)", name)
+	}
+	val, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid value %v for %v", value, name)
+	}
+	return val, nil
+}
+
+func optionalFloat(fields map[string]string, name string) (float64, error) {
+	if _, ok := fields[name]; !ok {
+		return 0, nil
+	}
+	return requiredFloat(fields, name)
+}
+
+// parseNumber parses a value matched by numberPattern, which may still be out of range.
+func parseNumber(value string) (float64, error) {
+	val, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid number %v", value)
+	}
+	return val, nil
+}
+
+// stripComment removes any comment from a line of KRL and trims surrounding whitespace.
+func stripComment(line string) string {
+	if idx := strings.Index(line, ";"); idx >= 0 {
+		line = line[:idx]
+	}
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,156 @@
+package krl
+
+import (
+	"testing"
+
+	"go.viam.com/test"
+)
+
+const testSrc = `&ACCESS RVP
+&REL 1
+DEF pick_part( )
+;FOLD INI;%{PE}
+  ;FOLD BASISTECH INI
+    GLOBAL INTERRUPT DECL 3 WHEN $STOPMESS==TRUE DO IR_STOPM ( )
+    INTERRUPT ON 3
+    BAS (#INITMOV,0 )
+  ;ENDFOLD (BASISTECH INI)
+;ENDFOLD (INI)
+
+;FOLD PTP HOME Vel= 100 % DEFAULT;%{PE}
+$BWDSTART = FALSE
+PDAT_ACT=PDEFAULT
+FDAT_ACT=FHOME
+BAS (#PTP_PARAMS,100 )
+$H_POS=XHOME
+PTP XHOME
+;ENDFOLD
+
+;FOLD PTP P1 Vel=50 % PDAT1 Tool[1] Base[0];%{PE}
+PDAT_ACT=PPDAT1
+BAS(#PTP_PARAMS,50)
+PTP XP1 C_PTP
+;ENDFOLD
+$OV_PRO = 80
+;FOLD LIN P2 Vel=0.5 m/s CPDAT1;%{PE}
+LDAT_ACT=LCPDAT1
+BAS(#CP_PARAMS,0.5)
+LIN XP2
+;ENDFOLD
+WAIT SEC 1.5
+$TOOL = {X 0,Y 0,Z 120.5,A 0,B 0,C 0}
+PTP {A1 10,A2 -80,A3 90,A4 0,A5 45,A6 0}
+gripper_close()
+END
+`
+
+const testDat = `&ACCESS RVP
+DEFDAT pick_part
+DECL E6POS XP1={X 500.0,Y -20.0,Z 600.0,A 0.0,B 90.0,C 0.0,S 6,T 27,E1 0.0,E2 0.0,E3 0.0,E4 0.0,E5 0.0,E6 0.0}
+DECL FDAT FP1={TOOL_NO 1,BASE_NO 0,IPO_FRAME #BASE,POINT2[] " "}
+DECL PDAT PPDAT1={VEL 100.000,ACC 100.000,APO_DIST 100.000,APO_MODE #CDIS}
+DECL E6POS XP2={X 500.0,Y 200.0,Z 600.0,A 0.0,B 90.0,C 0.0}
+DECL LDAT LCPDAT1={VEL 2.0,ACC 100.000,APO_DIST 100.000,APO_FAC 50.0,AXIS_VEL 100.000}
+ENDDAT
+`
+
+const testConfigDat = `DEFDAT $CONFIG
+DECL GLOBAL E6AXIS XHOME={A1 0.0,A2 -90.0,A3 90.0,A4 0.0,A5 0.0,A6 0.0,E1 0.0}
+DECL PDAT PDEFAULT={VEL 100.000,ACC 100.000,APO_DIST 100.000}
+ENDDAT
+`
+
+func TestParse(t *testing.T) {
+	t.Run("program", func(t *testing.T) {
+		prog, err := Parse(testSrc, testDat, testConfigDat)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, prog.Name, test.ShouldEqual, "pick_part")
+
+		kinds := make([]Kind, 0, len(prog.Instructions))
+		for _, instruction := range prog.Instructions {
+			kinds = append(kinds, instruction.Kind)
+		}
+		test.That(t, kinds, test.ShouldResemble, []Kind{
+			KindJointSpeed, KindPTP, KindJointSpeed, KindPTP, KindOverride, KindCartSpeed, KindLIN, KindWait, KindTool, KindPTP,
+		})
+
+		home := prog.Instructions[1]
+		test.That(t, home.Target, test.ShouldEqual, "XHOME")
+		test.That(t, home.Axis.Joints, test.ShouldResemble, [6]float64{0, -90, 90, 0, 0, 0})
+		test.That(t, home.Line, test.ShouldEqual, 18)
+
+		test.That(t, prog.Instructions[2].Value, test.ShouldEqual, 50)
+
+		p1 := prog.Instructions[3]
+		test.That(t, p1.Pos.Frame, test.ShouldResemble, Frame{X: 500, Y: -20, Z: 600, B: 90})
+		test.That(t, p1.Pos.HasST, test.ShouldBeTrue)
+		test.That(t, p1.Pos.S, test.ShouldEqual, 6)
+		test.That(t, p1.Pos.T, test.ShouldEqual, 27)
+
+		test.That(t, prog.Instructions[4].Value, test.ShouldEqual, 80)
+		test.That(t, prog.Instructions[5].Value, test.ShouldEqual, 0.5)
+		test.That(t, prog.Instructions[6].Pos.HasST, test.ShouldBeFalse)
+		test.That(t, prog.Instructions[7].Value, test.ShouldEqual, 1.5)
+		test.That(t, *prog.Instructions[8].Frame, test.ShouldResemble, Frame{Z: 120.5})
+		test.That(t, prog.Instructions[9].Axis.Joints, test.ShouldResemble, [6]float64{10, -80, 90, 0, 45, 0})
+
+		test.That(t, prog.Warnings, test.ShouldResemble, []string{"ignored call to gripper_close"})
+	})
+
+	errorTests := []struct {
+		description string
+		src         string
+		errContains string
+	}{
+		{description: "no def", src: "PTP {A1 0,A2 0,A3 0,A4 0,A5 0,A6 0}", errContains: "no DEF"},
+		{description: "unresolved point", src: "DEF p()\nPTP XP9\nEND", errContains: "unresolved point XP9"},
+		{description: "partial aggregate", src: "DEF p()\nPTP {A1 10}\nEND", errContains: "partial aggregates"},
+		{description: "circular motion", src: "DEF p()\nCIRC XP1, XP2\nEND", errContains: "CIRC motions are not supported"},
+		{description: "control flow", src: "DEF p()\nLOOP\nPTP XP1\nENDLOOP\nEND", errContains: "line 2: control flow"},
+		{description: "linear to joints", src: "DEF p()\nLIN {A1 0,A2 0,A3 0,A4 0,A5 0,A6 0}\nEND", errContains: "LIN motions to joint"},
+		{description: "number out of range", src: "DEF p()\nWAIT SEC 1e400\nEND", errContains: "line 2: invalid number 1e400"},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.description, func(t *testing.T) {
+			_, err := Parse(tt.src, testDat)
+			test.That(t, err, test.ShouldNotBeNil)
+			test.That(t, err.Error(), test.ShouldContainSubstring, tt.errContains)
+		})
+	}
+
+	t.Run("unrecognised assignment", func(t *testing.T) {
+		prog, err := Parse("DEF p()\n$VEL_AXIS[1]=50\nPTP XP1\nEND", testDat)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(prog.Instructions), test.ShouldEqual, 1)
+		test.That(t, prog.Warnings, test.ShouldResemble, []string{`ignored assignment "$VEL_AXIS[1]=50"`})
+	})
+
+	t.Run("dat line numbers", func(t *testing.T) {
+		dat := "DEFDAT p\n; points\n\nDECL E6POS XP1={X 0.0,Y 0.0,Z 0.0,A 0.0,B 0.0,C 0.0}\nDECL E6POS XP2={X 1e400}\nENDDAT"
+		_, err := Parse("DEF p()\nPTP XP1\nEND", dat)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "dat line 5")
+	})
+}
+
+func TestParseValues(t *testing.T) {
+	axis, err := ParseAxis("{E6AXIS: A1 1.5, A2 -90.0, A3 90.0, A4 0.0, A5 45.0, A6 -0.25, E1 0.0, E2 0.0, E3 0.0, " +
+		"E4 0.0, E5 0.0, E6 0.0}")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, axis.Joints, test.ShouldResemble, [6]float64{1.5, -90, 90, 0, 45, -0.25})
+
+	parsed, err := ParseAxis(FormatAxis(axis))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, parsed, test.ShouldResemble, axis)
+
+	pos, err := ParsePos("{E6POS: X 500.0, Y 0.0, Z 600.0, A 0.0, B 90.0, C 0.0, S 2, T 35, E1 0.0, E2 0.0, " +
+		"E3 0.0, E4 0.0, E5 0.0, E6 0.0}")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, pos.Frame, test.ShouldResemble, Frame{X: 500, Z: 600, B: 90})
+	test.That(t, pos.S, test.ShouldEqual, 2)
+	test.That(t, pos.T, test.ShouldEqual, 35)
+
+	_, err = ParseAxis("0.0")
+	test.That(t, err, test.ShouldNotBeNil)
+}
@@ -3,22 +3,22 @@ package kuka
 import (
 	"context"
 	"fmt"
-	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
 	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	"github.com/viam-soleng/viam-kuka/src/ekimanager"
 
 	pb "go.viam.com/api/component/arm/v1"
 	"go.viam.com/rdk/components/arm"
+	"go.viam.com/rdk/components/input"
 	"go.viam.com/rdk/logging"
 	"go.viam.com/rdk/referenceframe"
 	"go.viam.com/rdk/resource"
 	"go.viam.com/rdk/services/motion"
 	"go.viam.com/rdk/spatialmath"
-
-	gutils "go.viam.com/utils"
 )
 
 const (
@@ -27,7 +27,8 @@ const (
 
 	defaultTCPPort int = 54610
 
-	defaultJointSpeed float64 = 6.28
+	// defaultJointSpeed is the joint speed, as a percentage of the maximum, used when joint_speed is not set
+	defaultJointSpeed float64 = 10
 )
 
 var (
@@ -42,25 +43,93 @@ const (
 
 var supportedKukaKRModels = []string{kr10r900}
 
+// the protocols the arm can use to talk to the kuka device
+const (
+	protocolEKI   = "eki"
+	protocolRSI   = "rsi"
+	protocolKVP   = "kvp"
+	protocolOPCUA = "opcua"
+)
+
+var protocols = []string{protocolEKI, protocolRSI, protocolKVP, protocolOPCUA}
+
 type Config struct {
 	IPAddress  string  `json:"ip_address"`
 	Port       int     `json:"port,omitempty"`
 	Model      string  `json:"model,omitempty"`
 	SafeMode   bool    `json:"safe_mode,omitempty"`
 	JointSpeed float64 `json:"joint_speed,omitempty"`
+	Protocol   string  `json:"protocol,omitempty"`
+
+	AutoStartProgram bool   `json:"auto_start_program,omitempty"`
+	AllowRawCommands bool   `json:"allow_raw_commands,omitempty"`
+	CommandTimeoutMs int    `json:"command_timeout_ms,omitempty"`
+	TraceFile        string `json:"trace_file,omitempty"`
+
+	EKIConfigName     string `json:"eki_config_name,omitempty"`
+	EKIAliveFlag      int    `json:"eki_alive_flag,omitempty"`
+	EKIReceiveFlag    int    `json:"eki_receive_flag,omitempty"`
+	EKIExtStartOutput int    `json:"eki_ext_start_output,omitempty"`
+	StateStreamMs     int    `json:"state_stream_ms,omitempty"`
+	EKIMessageFormat  string `json:"eki_message_format,omitempty"`
+	StopPort          int    `json:"stop_port,omitempty"`
+	EKIStopAliveFlag  int    `json:"eki_stop_alive_flag,omitempty"`
+	EKIStopFlag       int    `json:"eki_stop_flag,omitempty"`
+
+	StatePollMs   int `json:"state_poll_ms,omitempty"`
+	MaxStateAgeMs int `json:"max_state_age_ms,omitempty"`
+
+	RSIPort            int     `json:"rsi_port,omitempty"`
+	RSICorrection      string  `json:"rsi_correction,omitempty"`
+	RSISensorType      string  `json:"rsi_sensor_type,omitempty"`
+	RSICycleMs         int     `json:"rsi_cycle_ms,omitempty"`
+	RSIMaxMissedCycles int     `json:"rsi_max_missed_cycles,omitempty"`
+	RSIMaxStep         float64 `json:"rsi_max_step,omitempty"`
+
+	InputController     string  `json:"input_controller,omitempty"`
+	TeleopEnableButton  string  `json:"teleop_enable_button,omitempty"`
+	TeleopMaxJointSpeed float64 `json:"teleop_max_joint_speed,omitempty"`
+	TeleopTimeoutMs     int     `json:"teleop_timeout_ms,omitempty"`
+
+	WaypointFile string `json:"waypoint_file,omitempty"`
+
+	KeepOutZones []KeepOutZone                `json:"keep_out_zones,omitempty"`
+	Obstacles    []spatialmath.GeometryConfig `json:"obstacles,omitempty"`
+
+	AllowedOperatingModes []string `json:"allowed_operating_modes,omitempty"`
+	AllowManualMode       bool     `json:"allow_manual_mode,omitempty"`
+	T1MaxJointSpeed       float64  `json:"t1_max_joint_speed,omitempty"`
 }
 
 type state struct {
 	endEffectorPose spatialmath.Pose
+	endStatus       int
+	endTurn         int
 	joints          []float64
 	jointLimits     []referenceframe.Limit
-
-	isMoving bool
-
-	jointSpeed float64
-
-	programState ekiCommand.ProgramStatus
-	programName  string
+	toolFrame       []float64
+	baseFrame       []float64
+	override        int
+
+	// The times each part of the state was last reported by the kuka device, and streamUpdated when the state was last
+	// pushed by ekiStateStream
+	jointsUpdated        time.Time
+	poseUpdated          time.Time
+	operatingModeUpdated time.Time
+	programStateUpdated  time.Time
+	streamUpdated        time.Time
+
+	isMoving    bool
+	moveCommand string
+	stopMessage bool
+
+	jointSpeed        float64
+	appliedJointSpeed float64
+
+	programState      ekiCommand.ProgramStatus
+	programName       string
+	runMode           string
+	programCommandErr error
 }
 
 type deviceInfo struct {
@@ -72,10 +141,14 @@ type deviceInfo struct {
 }
 
 type tcpConn struct {
-	ipAddress string
-	port      int
-	conn      net.Conn
-	mu        sync.Mutex
+	ipAddress     string
+	port          int
+	messageFormat string
+	traceFile     string
+	stopPort      int
+	active        *connection
+	stop          *connection
+	mu            sync.Mutex
 }
 
 type kukaArm struct {
@@ -86,14 +159,37 @@ type kukaArm struct {
 	currentState state
 	stateMutex   sync.Mutex
 	model        referenceframe.Model
-
-	closed                  bool
+	keepOutZones []spatialmath.Geometry
+	obstacles    []spatialmath.Geometry
+	opModePolicy operatingModePolicy
+	stopHistory  []stopEvent
+	// protocol is what the EKI program reported of its protocol when connected, nil until checked
+	protocol atomic.Pointer[protocolInfo]
+
+	closed                  atomic.Bool
 	safeMode                bool
+	allowRawCommands        bool
+	stateStreamPeriod       time.Duration
+	maxStateAge             time.Duration
 	activeBackgroundWorkers sync.WaitGroup
 
 	tcpConn tcpConn
 
-	responseCh chan bool
+	stopCh chan stopEvent
+	// moveDone receives the result of the motion in progress once the kuka device ends it
+	moveDone chan error
+	// responseHook, if set, is given every response before it is handled
+	responseHook func(command string, args []string)
+
+	replyMutex          sync.Mutex
+	replyWaiters        map[string][]*replyWaiter
+	commandReplyTimeout time.Duration
+
+	teleop   *teleop
+	sequence *sequenceRunner
+	poller   *statePoller
+
+	waypoints *waypointStore
 }
 
 func init() {
@@ -110,11 +206,128 @@ func (cfg *Config) Validate(path string) ([]string, error) {
 		return nil, resource.NewConfigValidationFieldRequiredError(path, "ip_address")
 	}
 
-	return nil, nil
+	switch cfg.Protocol {
+	case "", protocolEKI:
+	case protocolRSI:
+		if err := cfg.validateRSI(path); err != nil {
+			return nil, err
+		}
+	case protocolKVP, protocolOPCUA:
+		if err := cfg.validateEKIOnly(path); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("%v: protocol (%v) must be one of %v", path, cfg.Protocol, protocols)
+	}
+
+	if cfg.JointSpeed < 0 || cfg.JointSpeed > 100 {
+		return nil, errors.Errorf("joint_speed (%v) must be in the range (0, 100]", cfg.JointSpeed)
+	}
+
+	if cfg.CommandTimeoutMs < 0 {
+		return nil, errors.Errorf("command_timeout_ms (%v) must be positive", cfg.CommandTimeoutMs)
+	}
+
+	if cfg.StatePollMs < 0 {
+		return nil, errors.Errorf("state_poll_ms (%v) must be positive", cfg.StatePollMs)
+	}
+	if cfg.MaxStateAgeMs < 0 {
+		return nil, errors.Errorf("max_state_age_ms (%v) must be positive", cfg.MaxStateAgeMs)
+	}
+
+	if cfg.TeleopMaxJointSpeed < 0 {
+		return nil, errors.Errorf("teleop_max_joint_speed (%v) must be positive", cfg.TeleopMaxJointSpeed)
+	}
+	if cfg.TeleopTimeoutMs < 0 {
+		return nil, errors.Errorf("teleop_timeout_ms (%v) must be positive", cfg.TeleopTimeoutMs)
+	}
+
+	for i, zone := range cfg.KeepOutZones {
+		if err := zone.Validate(fmt.Sprintf("%v.keep_out_zones.%v", path, i)); err != nil {
+			return nil, err
+		}
+	}
+	for i, obstacle := range cfg.Obstacles {
+		if _, err := obstacle.ParseConfig(); err != nil {
+			return nil, errors.Wrapf(err, "%v.obstacles.%v", path, i)
+		}
+	}
+
+	if err := validateOperatingModes(path, cfg.AllowedOperatingModes, cfg.AllowManualMode, cfg.T1MaxJointSpeed); err != nil {
+		return nil, err
+	}
+	if err := cfg.ControllerSettings().Validate(); err != nil {
+		return nil, errors.Wrapf(err, "%v: invalid controller settings", path)
+	}
+
+	var deps []string
+	if cfg.InputController != "" {
+		deps = append(deps, cfg.InputController)
+	}
+
+	return deps, nil
+}
+
+// validateEKIOnly ensures a config using another protocol does not rely on what only the EKI program provides.
+func (cfg *Config) validateEKIOnly(path string) error {
+	if len(cfg.KeepOutZones) > 0 || len(cfg.Obstacles) > 0 || cfg.InputController != "" {
+		return errors.Errorf("%v: keep_out_zones, obstacles and input_controller are not supported with protocol %v",
+			path, cfg.Protocol)
+	}
+	return nil
+}
+
+// ControllerSettings returns the settings of the EKI program on the kuka device that match the config, used to
+// generate the controller package.
+func (cfg *Config) ControllerSettings() ekimanager.Settings {
+	settings := ekimanager.Settings{
+		IPAddress:      cfg.IPAddress,
+		Port:           cfg.Port,
+		ConfigName:     cfg.EKIConfigName,
+		AliveFlag:      cfg.EKIAliveFlag,
+		ReceiveFlag:    cfg.EKIReceiveFlag,
+		ExtStartOutput: cfg.EKIExtStartOutput,
+		StreamPeriodMs: cfg.StateStreamMs,
+		MessageFormat:  cfg.EKIMessageFormat,
+		StopPort:       cfg.StopPort,
+		StopAliveFlag:  cfg.EKIStopAliveFlag,
+		StopFlag:       cfg.EKIStopFlag,
+	}
+	if settings.Port == 0 {
+		settings.Port = defaultTCPPort
+	}
+	if settings.ConfigName == "" {
+		settings.ConfigName = ekimanager.DefaultConfigName
+	}
+	if settings.AliveFlag == 0 {
+		settings.AliveFlag = ekimanager.DefaultAliveFlag
+	}
+	if settings.ReceiveFlag == 0 {
+		settings.ReceiveFlag = ekimanager.DefaultReceiveFlag
+	}
+	if settings.StopAliveFlag == 0 {
+		settings.StopAliveFlag = ekimanager.DefaultStopAliveFlag
+	}
+	if settings.StopFlag == 0 {
+		settings.StopFlag = ekimanager.DefaultStopFlag
+	}
+	return settings
 }
 
 // newKukaArm creates a new Kuka arm.
 func newKukaArm(ctx context.Context, deps resource.Dependencies, conf resource.Config, logger logging.Logger) (arm.Arm, error) {
+	newConf, err := resource.NativeConfig[*Config](conf)
+	if err != nil {
+		return nil, err
+	}
+	switch newConf.Protocol {
+	case protocolRSI:
+		return newRSIArm(conf, newConf, logger)
+	case protocolKVP:
+		return newKVPArm(ctx, conf, newConf, logger)
+	case protocolOPCUA:
+		return newOPCUAArm(ctx, conf, newConf, logger)
+	}
 
 	kuka := kukaArm{
 		Named:  conf.ResourceName().AsNamed(),
@@ -126,7 +339,7 @@ func newKukaArm(ctx context.Context, deps resource.Dependencies, conf resource.C
 
 		activeBackgroundWorkers: sync.WaitGroup{},
 		stateMutex:              sync.Mutex{},
-		responseCh:              make(chan bool, 1),
+		stopCh:                  make(chan stopEvent, 1),
 	}
 
 	if err := kuka.Reconfigure(ctx, deps, conf); err != nil {
@@ -142,6 +355,14 @@ func (kuka *kukaArm) Reconfigure(ctx context.Context, deps resource.Dependencies
 	if err != nil {
 		return err
 	}
+	if newConf.Protocol != "" && newConf.Protocol != protocolEKI {
+		return resource.NewMustRebuildError(conf.ResourceName())
+	}
+
+	// Stop any teleoperation, sequence or polling tied to the previous configuration
+	kuka.stopTeleop(ctx)
+	kuka.stopSequence(ctx)
+	kuka.stopStatePoller()
 
 	// Reset robot
 	kuka.resetCurrentStateAndDeviceInfo()
@@ -151,13 +372,35 @@ func (kuka *kukaArm) Reconfigure(ctx context.Context, deps resource.Dependencies
 		return err
 	}
 
-	// Attempt to connect to hardware
+	// Place keep-out zones and obstacles relative to the base of the arm
+	keepOutZones, err := newKeepOutZones(newConf.KeepOutZones, conf.Frame)
+	if err != nil {
+		return err
+	}
+	obstacles, err := newObstacles(newConf.Obstacles, conf.Frame)
+	if err != nil {
+		return err
+	}
+	kuka.stateMutex.Lock()
+	kuka.keepOutZones = keepOutZones
+	kuka.obstacles = obstacles
+	kuka.opModePolicy = newOperatingModePolicy(newConf)
+	kuka.stateMutex.Unlock()
+
+	// Load stored waypoints
+	waypoints, err := newWaypointStore(kuka.waypointFilePath(newConf))
+	if err != nil {
+		return err
+	}
+	kuka.waypoints = waypoints
+
+	// Attempt to connect to hardware, handling the responses of the kuka device in the background
 	if err := kuka.Connect(ctx); err != nil {
 		return err
 	}
 
-	// Start background monitor of logs from kuka device
-	if err := kuka.startResponseMonitor(); err != nil {
+	// Check which commands the EKI program supports
+	if err := kuka.checkProtocol(ctx); err != nil {
 		return err
 	}
 
@@ -173,13 +416,25 @@ func (kuka *kukaArm) Reconfigure(ctx context.Context, deps resource.Dependencies
 		return err
 	}
 
-	// Check program state
-	programState, err := kuka.checkEKIProgramState(ctx)
-	if err != nil {
+	// Check program state, starting the program if configured to
+	if err := kuka.ensureProgramRunning(ctx, newConf.AutoStartProgram); err != nil {
 		return err
 	}
-	if programState != ekiCommand.StatusRunning {
-		return errors.Errorf("associated program on your kuka device is %v, please get the program running before continuing", programState)
+
+	// Refresh the state in the background if a poll interval was given
+	if newConf.StatePollMs > 0 {
+		kuka.startStatePoller(time.Duration(newConf.StatePollMs) * time.Millisecond)
+	}
+
+	// Start teleoperation if an input controller was given
+	if newConf.InputController != "" {
+		controller, err := input.FromDependencies(deps, newConf.InputController)
+		if err != nil {
+			return err
+		}
+		if err := kuka.startTeleop(ctx, controller, newConf); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -187,30 +442,26 @@ func (kuka *kukaArm) Reconfigure(ctx context.Context, deps resource.Dependencies
 
 // The close method is executed when the component is shut down.
 func (kuka *kukaArm) Close(ctx context.Context) error {
-	kuka.closed = true
+	kuka.closed.Store(true)
 
-	// Wait for mutexes
-	kuka.stateMutex.Lock()
-	defer kuka.stateMutex.Unlock()
+	// Stop teleoperation, any running sequence and polling before waiting on background workers
+	kuka.stopTeleop(ctx)
+	kuka.stopSequence(ctx)
+	kuka.stopStatePoller()
 
-	kuka.tcpConn.mu.Lock()
-	defer kuka.tcpConn.mu.Unlock()
+	// Disconnect tcp connection first, so background workers waiting on the kuka device end
+	err := kuka.Disconnect()
 
 	// Wait for background process to end
 	kuka.activeBackgroundWorkers.Wait()
-
-	// Disconnect tcp connection
-	if err := kuka.Disconnect(); err != nil {
-		return err
-	}
-	return nil
+	return err
 }
 
 // CurrentInputs returns the current joint positions in the form of Inputs.
 func (kuka *kukaArm) CurrentInputs(ctx context.Context) ([]referenceframe.Input, error) {
 	kuka.stateMutex.Lock()
 	defer kuka.stateMutex.Unlock()
-	return kuka.model.InputFromProtobuf(&pb.JointPositions{Values: kuka.getCurrentStateSafe().joints}), nil
+	return kuka.model.InputFromProtobuf(&pb.JointPositions{Values: kuka.currentState.joints}), nil
 }
 
 // GoToInputs moves through the given inputSteps using sequential calls to MoveJointPosition.
@@ -232,12 +483,26 @@ func (kuka *kukaArm) GoToInputs(ctx context.Context, inputSteps ...[]referencefr
 
 // EndPosition returns the current position of the arm.
 func (kuka *kukaArm) EndPosition(ctx context.Context, extra map[string]interface{}) (spatialmath.Pose, error) {
-	return kuka.getCurrentStateSafe().endEffectorPose, nil
+	kuka.stateMutex.Lock()
+	pose, updated, maxAge := kuka.currentState.endEffectorPose, kuka.currentState.poseUpdated, kuka.maxStateAge
+	kuka.stateMutex.Unlock()
+
+	if err := checkStateAge("end position", updated, maxAge, extra); err != nil {
+		return nil, err
+	}
+	return pose, nil
 }
 
 // JointPositions returns the current joint positions of the arm.
 func (kuka *kukaArm) JointPositions(ctx context.Context, extra map[string]interface{}) (*pb.JointPositions, error) {
-	return &pb.JointPositions{Values: kuka.getCurrentStateSafe().joints}, nil
+	kuka.stateMutex.Lock()
+	joints, updated, maxAge := kuka.currentState.joints, kuka.currentState.jointsUpdated, kuka.maxStateAge
+	kuka.stateMutex.Unlock()
+
+	if err := checkStateAge("joint positions", updated, maxAge, extra); err != nil {
+		return nil, err
+	}
+	return &pb.JointPositions{Values: joints}, nil
 }
 
 // MoveToPosition moves the arm to the given absolute position. This will block until done or a new operation cancels this one.
@@ -248,57 +513,24 @@ func (kuka *kukaArm) MoveToPosition(ctx context.Context, pose spatialmath.Pose,
 
 // MoveToJointPositions moves the arm's joints to the given positions. This will block until done or a new operation cancels this one.
 func (kuka *kukaArm) MoveToJointPositions(ctx context.Context, positionDegs *pb.JointPositions, extra map[string]interface{}) error {
+	return kuka.moveToJointPositions(ctx, positionDegs.Values, 0)
+}
 
-	desiredJointPositions := positionDegs.Values
-
+// moveToJointPositions moves the arm's joints to the given positions, at no more than the given joint speed, as a
+// percentage of the maximum, if one is given.
+func (kuka *kukaArm) moveToJointPositions(ctx context.Context, desiredJointPositions []float64, maxJointSpeed float64) error {
 	// Check validity of action based on joint limit
 	if err := kuka.checkDesiredJointPositions(desiredJointPositions); err != nil {
 		return err
 	}
 
-	if isMoving, _ := kuka.IsMoving(ctx); isMoving {
-		return errors.New("robot is still moving, please try again after previous movement is complete")
-	}
-
-	stringifyJoints := fmt.Sprintf("%v,%v,%v,%v,%v,%v",
-		desiredJointPositions[0],
-		desiredJointPositions[1],
-		desiredJointPositions[2],
-		desiredJointPositions[3],
-		desiredJointPositions[4],
-		desiredJointPositions[5],
-	)
-
-	// Check EKI program state before issuing move command
-	if kuka.safeMode {
-		programState, err := kuka.checkEKIProgramState(ctx)
-		if err != nil {
-			return err
-		}
-		if programState != ekiCommand.StatusRunning {
-			return errors.Errorf("associated program on your kuka device is %v, please get the program running before continuing", programState)
-		}
-	}
-
-	// Send command
-	kuka.stateMutex.Lock()
-	kuka.currentState.isMoving = true
-	kuka.stateMutex.Unlock()
-	if err := kuka.sendCommand(ekiCommand.SetJointPosition, fmt.Sprintf("%v,0,0,0,0,0,0", stringifyJoints)); err != nil {
+	// Check the move is free of collisions and does not enter a keep-out zone
+	if err := kuka.checkJointMove(desiredJointPositions); err != nil {
 		return err
 	}
 
-	// Loop until operation ends
-	cancelCtx, cancelFunc := context.WithCancel(ctx)
-	defer cancelFunc()
-	kuka.activeBackgroundWorkers.Add(1)
-	gutils.PanicCapturingGo(func() {
-		kuka.updateStateLoop(cancelCtx)
-	})
-
-	<-kuka.responseCh
-
-	return nil
+	args := floatArgs(append(desiredJointPositions[:numJoints:numJoints], make([]float64, numExternalJoints)...)...)
+	return kuka.executeMove(ctx, maxJointSpeed, ekiCommand.SetJointPosition, args...)
 }
 
 // IsMoving returns if the arm is in motion.
@@ -308,26 +540,64 @@ func (kuka *kukaArm) IsMoving(context.Context) (bool, error) {
 
 // Stop stops and ongoing actions
 func (kuka *kukaArm) Stop(ctx context.Context, extra map[string]interface{}) error {
-	if err := kuka.sendCommand(ekiCommand.SetStop, ""); err != nil {
+	if err := kuka.requestStop(ctx); err != nil {
 		return err
 	}
 
 	// Get joint and eng effector position after stop action has occurred
-	kuka.updateState()
 	if err := kuka.updateState(); err != nil {
 		return err
 	}
 	return nil
 }
 
-// DoCommand can be implemented to extend functionality but returns unimplemented currently.
+// DoCommand handles the named commands given by the "command" key, otherwise the value of "cmd" is written directly
+// to the kuka device if allow_raw_commands is set.
 func (kuka *kukaArm) DoCommand(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
-	command, ok := cmd["cmd"].(string)
-	if !ok {
-		return nil, errors.Errorf("error, request value (%v) was not a string", cmd["cmd"])
+	if name, ok := cmd["command"]; ok {
+		switch name {
+		case saveWaypointCommand:
+			return kuka.saveWaypoint(ctx, cmd)
+		case listWaypointsCommand:
+			return kuka.listWaypoints()
+		case deleteWaypointCommand:
+			return kuka.deleteWaypoint(cmd)
+		case moveToWaypointCommand:
+			return kuka.moveToWaypoint(ctx, cmd)
+		case runSequenceCommand:
+			return kuka.runSequence(cmd)
+		case sequenceStatusCommand:
+			return kuka.sequenceStatus()
+		case pauseSequenceCommand:
+			return kuka.pauseSequence()
+		case resumeSequenceCommand:
+			return kuka.resumeSequence()
+		case abortSequenceCommand:
+			return kuka.abortSequence(ctx)
+		case importKRLCommand:
+			return kuka.importKRL(cmd)
+		case exportKRLCommand:
+			return kuka.exportKRL(cmd)
+		case getOperatingModeCommand:
+			return kuka.getOperatingMode()
+		case getStopHistoryCommand:
+			return kuka.getStopHistory()
+		case clearStopHistoryCommand:
+			return kuka.clearStopHistory()
+		case programStatusCommand:
+			return kuka.programStatus(ctx)
+		case selectProgramCommand, startProgramCommand, stopProgramCommand, resetProgramCommand:
+			return kuka.runProgramCommand(ctx, name.(string))
+		case getDeviceInfoCommand, getJointPositionsCommand, getEndPositionCommand, getJointLimitsCommand,
+			getToolDataCommand, getBaseDataCommand, setJointSpeedCommand, setCartSpeedCommand, setOverrideCommand,
+			setToolDataCommand, setBaseDataCommand:
+			return kuka.runValueCommand(ctx, name.(string), cmd)
+		default:
+			return nil, errors.Errorf("unknown command (%v) given", name)
+		}
 	}
 
-	if err := kuka.Write([]byte(command)); err != nil {
+	if err := kuka.sendRawCommand(cmd); err != nil {
 		return nil, err
 	}
 
@@ -343,9 +613,7 @@ func (kuka *kukaArm) ModelFrame() referenceframe.Model {
 
 // Geometries returns a list of geometries associated with the specified kuka arm.
 func (kuka *kukaArm) Geometries(ctx context.Context, extra map[string]interface{}) ([]spatialmath.Geometry, error) {
-	kuka.stateMutex.Lock()
-	defer kuka.stateMutex.Unlock()
-	model := kuka.model
+	model := kuka.ModelFrame()
 
 	inputs, err := kuka.CurrentInputs(ctx)
 	if err != nil {
@@ -0,0 +1,174 @@
+package kuka
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+	pb "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/rdk/spatialmath"
+)
+
+// collisionJointStep is the largest change, in degrees, of any joint between configurations checked along a joint move.
+const collisionJointStep = 2.0
+
+// newObstacles converts the configured static obstacles, given in the world frame, into geometries in the base frame
+// of the arm.
+func newObstacles(configs []spatialmath.GeometryConfig, armFrame *referenceframe.LinkConfig) ([]spatialmath.Geometry, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+
+	armPose, err := armPoseInWorld(armFrame)
+	if err != nil {
+		return nil, errors.Wrap(err, "obstacles are in the world frame")
+	}
+
+	obstacles := make([]spatialmath.Geometry, 0, len(configs))
+	for i, config := range configs {
+		geometry, err := config.ParseConfig()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid obstacle %v", i)
+		}
+		obstacle := geometry.Transform(spatialmath.PoseInverse(armPose))
+		if obstacle.Label() == "" {
+			obstacle.SetLabel(fmt.Sprintf("obstacle %v", i))
+		}
+		obstacles = append(obstacles, obstacle)
+	}
+	return obstacles, nil
+}
+
+// interpolateJoints returns the configurations along a joint space move from start to end, excluding start, such that
+// no joint changes by more than step degrees between configurations.
+func interpolateJoints(start, end []float64, step float64) [][]float64 {
+	maxDelta := 0.0
+	for i := range end {
+		maxDelta = math.Max(maxDelta, math.Abs(end[i]-start[i]))
+	}
+	numSteps := int(math.Max(1, math.Ceil(maxDelta/step)))
+
+	configurations := make([][]float64, 0, numSteps)
+	for n := 1; n <= numSteps; n++ {
+		joints := make([]float64, len(end))
+		for i := range end {
+			joints[i] = start[i] + (end[i]-start[i])*float64(n)/float64(numSteps)
+		}
+		configurations = append(configurations, joints)
+	}
+	return configurations
+}
+
+// geometryPair is a pair of link geometries, ordered by label.
+type geometryPair [2]string
+
+func newGeometryPair(a, b spatialmath.Geometry) geometryPair {
+	if a.Label() > b.Label() {
+		return geometryPair{b.Label(), a.Label()}
+	}
+	return geometryPair{a.Label(), b.Label()}
+}
+
+// collidingLinks returns the pairs of link geometries in contact with each other.
+func collidingLinks(geometries []spatialmath.Geometry) (map[geometryPair]bool, error) {
+	pairs := map[geometryPair]bool{}
+	for i := range geometries {
+		for j := i + 1; j < len(geometries); j++ {
+			collides, err := geometries[i].CollidesWith(geometries[j], 0)
+			if err != nil {
+				return nil, err
+			}
+			if collides {
+				pairs[newGeometryPair(geometries[i], geometries[j])] = true
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// checkJointMove checks the joint space path from the current joint positions to the target for self-collisions,
+// collisions with static obstacles and entry into keep-out zones. Links already in contact at the start of the move,
+// such as those joined to each other, are not considered to be colliding.
+func (kuka *kukaArm) checkJointMove(target []float64) error {
+	kuka.stateMutex.Lock()
+	model := kuka.model
+	zones := kuka.keepOutZones
+	obstacles := kuka.obstacles
+	current := kuka.currentState.joints
+	kuka.stateMutex.Unlock()
+
+	if model == nil {
+		if len(zones) != 0 || len(obstacles) != 0 {
+			return errors.New("no kinematic model loaded, unable to check for collisions")
+		}
+		return nil
+	}
+
+	start := make([]float64, len(target))
+	configurations := [][]float64{target}
+	if len(current) == len(target) {
+		start = current
+		configurations = interpolateJoints(current, target, collisionJointStep)
+	}
+
+	startGeometries, err := model.Geometries(model.InputFromProtobuf(&pb.JointPositions{Values: start}))
+	if err != nil {
+		return err
+	}
+	allowed, err := collidingLinks(startGeometries.Geometries())
+	if err != nil {
+		return err
+	}
+
+	for _, joints := range configurations {
+		geometriesInFrame, err := model.Geometries(model.InputFromProtobuf(&pb.JointPositions{Values: joints}))
+		if err != nil {
+			return err
+		}
+		geometries := geometriesInFrame.Geometries()
+
+		colliding, err := collidingLinks(geometries)
+		if err != nil {
+			return err
+		}
+		for pair := range colliding {
+			if !allowed[pair] {
+				return errors.Errorf("move rejected, self-collision between %v and %v at joint positions %v",
+					pair[0], pair[1], formatJoints(joints))
+			}
+		}
+
+		for _, geometry := range geometries {
+			for _, obstacle := range obstacles {
+				collides, err := geometry.CollidesWith(obstacle, 0)
+				if err != nil {
+					return err
+				}
+				if collides {
+					return errors.Errorf("move rejected, %v would collide with %q at joint positions %v",
+						geometry.Label(), obstacle.Label(), formatJoints(joints))
+				}
+			}
+			for _, zone := range zones {
+				collides, err := geometry.CollidesWith(zone, 0)
+				if err != nil {
+					return err
+				}
+				if collides {
+					return errors.Errorf("move rejected, %v would enter keep-out zone %q at joint positions %v",
+						geometry.Label(), zone.Label(), formatJoints(joints))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func formatJoints(joints []float64) string {
+	formatted := make([]string, len(joints))
+	for i, joint := range joints {
+		formatted[i] = fmt.Sprintf("%.1f", joint)
+	}
+	return fmt.Sprint(formatted)
+}
@@ -0,0 +1,84 @@
+package kuka
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/golang/geo/r3"
+	pb "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/rdk/referenceframe/urdf"
+	"go.viam.com/rdk/spatialmath"
+	"go.viam.com/test"
+)
+
+func TestNewObstacles(t *testing.T) {
+	configs := []spatialmath.GeometryConfig{
+		{Type: spatialmath.BoxType, X: 100, Y: 100, Z: 100, TranslationOffset: r3.Vector{X: 1000}, Label: "table"},
+		{Type: spatialmath.SphereType, R: 50, TranslationOffset: r3.Vector{Y: 500}},
+	}
+
+	_, err := newObstacles(configs, nil)
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "obstacles are in the world frame")
+
+	obstacles, err := newObstacles(configs, &referenceframe.LinkConfig{Parent: referenceframe.World, Translation: r3.Vector{X: 200}})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, obstacles[0].Label(), test.ShouldEqual, "table")
+	test.That(t, obstacles[1].Label(), test.ShouldEqual, "obstacle 1")
+	test.That(t, obstacles[0].Pose().Point(), test.ShouldResemble, r3.Vector{X: 800})
+
+	obstacles, err = newObstacles(nil, nil)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, obstacles, test.ShouldBeEmpty)
+}
+
+func TestCheckJointMove(t *testing.T) {
+	urdfModel, err := urdf.ParseModelXMLFile(resolveFile(fmt.Sprintf("src/models/%v_model.urdf", kr10r900)), "test")
+	test.That(t, err, test.ShouldBeNil)
+
+	kuka := &kukaArm{
+		model:        urdfModel,
+		currentState: state{joints: []float64{0, -90, 90, 0, 0, 0}},
+	}
+
+	t.Run("clear", func(t *testing.T) {
+		test.That(t, kuka.checkJointMove([]float64{30, -80, 100, 10, 20, 0}), test.ShouldBeNil)
+	})
+
+	t.Run("self-collision", func(t *testing.T) {
+		err := kuka.checkJointMove([]float64{0, -90, 160, 0, 0, 0})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "self-collision between test:link_2 and test:link_4")
+	})
+
+	t.Run("unknown start", func(t *testing.T) {
+		unknown := &kukaArm{model: urdfModel}
+		test.That(t, unknown.checkJointMove([]float64{0, -90, 90, 0, 0, 0}), test.ShouldBeNil)
+		test.That(t, unknown.checkJointMove([]float64{0, -90, 160, 0, 0, 0}), test.ShouldNotBeNil)
+	})
+
+	t.Run("obstacle", func(t *testing.T) {
+		target := []float64{45, -90, 90, 0, 0, 0}
+		pose, err := urdfModel.Transform(urdfModel.InputFromProtobuf(&pb.JointPositions{Values: target}))
+		test.That(t, err, test.ShouldBeNil)
+		obstacle, err := spatialmath.NewBox(spatialmath.NewPoseFromPoint(pose.Point()), r3.Vector{X: 100, Y: 100, Z: 100}, "fixture")
+		test.That(t, err, test.ShouldBeNil)
+
+		kuka.obstacles = []spatialmath.Geometry{obstacle}
+		defer func() { kuka.obstacles = nil }()
+
+		err = kuka.checkJointMove(target)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, `would collide with "fixture"`)
+
+		test.That(t, kuka.checkJointMove([]float64{-45, -90, 90, 0, 0, 0}), test.ShouldBeNil)
+	})
+
+	t.Run("no model", func(t *testing.T) {
+		noModel := &kukaArm{keepOutZones: []spatialmath.Geometry{spatialmath.NewPoint(r3.Vector{}, "")}}
+		err := noModel.checkJointMove([]float64{0, 0, 0, 0, 0, 0})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "no kinematic model")
+	})
+}
@@ -0,0 +1,245 @@
+package kuka
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+)
+
+// DoCommand names for reading and setting values on the kuka device, each of which waits for the reply of the device
+const (
+	getDeviceInfoCommand     = "get_device_info"
+	getJointPositionsCommand = "get_joint_positions"
+	getEndPositionCommand    = "get_end_position"
+	getJointLimitsCommand    = "get_joint_limits"
+	getToolDataCommand       = "get_tool_data"
+	getBaseDataCommand       = "get_base_data"
+
+	setJointSpeedCommand = "set_joint_speed"
+	setCartSpeedCommand  = "set_cart_speed"
+	setOverrideCommand   = "set_override"
+	setToolDataCommand   = "set_tool_data"
+	setBaseDataCommand   = "set_base_data"
+)
+
+type deviceInfoResponse struct {
+	Name            string `json:"name"`
+	SerialNumber    string `json:"serial_number"`
+	RobotType       string `json:"robot_type"`
+	SoftwareVersion string `json:"software_version"`
+	OperatingMode   string `json:"operating_mode"`
+	// ProtocolVersion is the protocol version of the EKI program, omitted if it predates reporting it
+	ProtocolVersion int      `json:"protocol_version,omitempty"`
+	MissingCommands []string `json:"missing_commands,omitempty"`
+}
+
+type jointPositionsResponse struct {
+	Joints       []float64 `json:"joints"`
+	ExternalAxes []float64 `json:"external_axes"`
+}
+
+type endPositionResponse struct {
+	Frame        []float64 `json:"frame"`
+	Status       int       `json:"status"`
+	Turn         int       `json:"turn"`
+	ExternalAxes []float64 `json:"external_axes"`
+}
+
+type jointLimitsResponse struct {
+	Min []float64 `json:"min"`
+	Max []float64 `json:"max"`
+}
+
+type frameResponse struct {
+	Frame []float64 `json:"frame"`
+}
+
+type valueResponse struct {
+	Value float64 `json:"value"`
+}
+
+// runValueCommand performs the named get or set command for DoCommand.
+func (kuka *kukaArm) runValueCommand(ctx context.Context, name string, cmd map[string]interface{}) (map[string]interface{}, error) {
+	var resp interface{}
+	var err error
+	switch name {
+	case getDeviceInfoCommand:
+		resp, err = kuka.requestDeviceInfo(ctx)
+	case getJointPositionsCommand:
+		resp, err = kuka.requestJointPositions(ctx)
+	case getEndPositionCommand:
+		resp, err = kuka.requestEndPosition(ctx)
+	case getJointLimitsCommand:
+		resp, err = kuka.requestJointLimits(ctx)
+	case getToolDataCommand:
+		resp, err = kuka.requestFrame(ctx, ekiCommand.GetToolData)
+	case getBaseDataCommand:
+		resp, err = kuka.requestFrame(ctx, ekiCommand.GetBaseData)
+	case setJointSpeedCommand, setCartSpeedCommand, setOverrideCommand:
+		resp, err = kuka.setValue(ctx, name, cmd)
+	case setToolDataCommand, setBaseDataCommand:
+		resp, err = kuka.setFrame(ctx, name, cmd)
+	default:
+		return nil, errors.Errorf("unknown command (%v) given", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toResponseMap(resp)
+}
+
+// requestString requests a single value from the kuka device.
+func (kuka *kukaArm) requestString(ctx context.Context, EKICommand string) (string, error) {
+	reply, err := kuka.requestReply(ctx, EKICommand)
+	if err != nil {
+		return "", err
+	}
+	return reply.Text(0), nil
+}
+
+func (kuka *kukaArm) requestDeviceInfo(ctx context.Context) (*deviceInfoResponse, error) {
+	var info deviceInfoResponse
+	var operatingMode string
+	for _, field := range []struct {
+		command string
+		value   *string
+	}{
+		{ekiCommand.GetRobotName, &info.Name},
+		{ekiCommand.GetRobotSerialNum, &info.SerialNumber},
+		{ekiCommand.GetRobotType, &info.RobotType},
+		{ekiCommand.GetRobotSoftwareVersion, &info.SoftwareVersion},
+		{ekiCommand.GetRobotOperatingMode, &operatingMode},
+	} {
+		val, err := kuka.requestString(ctx, field.command)
+		if err != nil {
+			return nil, err
+		}
+		*field.value = val
+	}
+	info.OperatingMode = parseOperatingMode(operatingMode)
+	if protocol := kuka.protocol.Load(); protocol != nil {
+		info.ProtocolVersion = protocol.version
+		info.MissingCommands = protocol.missingCommands()
+	}
+	return &info, nil
+}
+
+func (kuka *kukaArm) requestJointPositions(ctx context.Context) (*jointPositionsResponse, error) {
+	reply, err := kuka.requestReply(ctx, ekiCommand.GetJointPosition)
+	if err != nil {
+		return nil, err
+	}
+	return &jointPositionsResponse{
+		Joints:       reply.Floats(0, numJoints),
+		ExternalAxes: reply.Floats(numJoints, numJoints+numExternalJoints),
+	}, nil
+}
+
+func (kuka *kukaArm) requestEndPosition(ctx context.Context) (*endPositionResponse, error) {
+	reply, err := kuka.requestReply(ctx, ekiCommand.GetEndPosition)
+	if err != nil {
+		return nil, err
+	}
+	return &endPositionResponse{
+		Frame:        reply.Floats(0, 6),
+		Status:       reply.Int(6),
+		Turn:         reply.Int(7),
+		ExternalAxes: reply.Floats(8, 8+numExternalJoints),
+	}, nil
+}
+
+func (kuka *kukaArm) requestJointLimits(ctx context.Context) (*jointLimitsResponse, error) {
+	var limits jointLimitsResponse
+	for _, field := range []struct {
+		command string
+		values  *[]float64
+	}{
+		{ekiCommand.GetJointNegLimit, &limits.Min},
+		{ekiCommand.GetJointPosLimit, &limits.Max},
+	} {
+		reply, err := kuka.requestReply(ctx, field.command)
+		if err != nil {
+			return nil, err
+		}
+		*field.values = reply.Floats(0, numJoints)
+	}
+	return &limits, nil
+}
+
+func (kuka *kukaArm) requestFrame(ctx context.Context, EKICommand string) (*frameResponse, error) {
+	reply, err := kuka.requestReply(ctx, EKICommand)
+	if err != nil {
+		return nil, err
+	}
+	return &frameResponse{Frame: reply.Floats(0, 6)}, nil
+}
+
+// setValue sets the joint speed, cartesian speed or override given by "value".
+func (kuka *kukaArm) setValue(ctx context.Context, name string, cmd map[string]interface{}) (*valueResponse, error) {
+	value, err := getFloatArg(cmd, "value")
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case setJointSpeedCommand:
+		err = kuka.setJointSpeed(ctx, value)
+	case setCartSpeedCommand:
+		err = kuka.setCartSpeed(ctx, value)
+	case setOverrideCommand:
+		if value != math.Trunc(value) {
+			return nil, errors.Errorf("override (%v) must be a whole number", value)
+		}
+		err = kuka.setOverride(ctx, int(value))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &valueResponse{Value: value}, nil
+}
+
+// setFrame sets the tool or base frame given by "frame".
+func (kuka *kukaArm) setFrame(ctx context.Context, name string, cmd map[string]interface{}) (*frameResponse, error) {
+	frame, err := getFloatListArg(cmd, "frame")
+	if err != nil {
+		return nil, err
+	}
+
+	if name == setToolDataCommand {
+		err = kuka.setToolData(ctx, frame)
+	} else {
+		err = kuka.setBaseData(ctx, frame)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &frameResponse{Frame: frame}, nil
+}
+
+// sendRawCommand writes a command as given to the kuka device, framed in the message format of the connection, if
+// allowed by the config. Only a single command is accepted so the stream of commands read by the device cannot be
+// broken by the input.
+func (kuka *kukaArm) sendRawCommand(cmd map[string]interface{}) error {
+	kuka.stateMutex.Lock()
+	allowRaw := kuka.allowRawCommands
+	kuka.stateMutex.Unlock()
+	if !allowRaw {
+		return errors.New("raw commands are disabled, use a named command or set allow_raw_commands to send them")
+	}
+
+	command, err := getStringArg(cmd, "cmd")
+	if err != nil {
+		return err
+	}
+	command = strings.TrimSuffix(strings.TrimSpace(command), ";")
+	if command == "" || strings.ContainsAny(command, ";\r\n") {
+		return errors.Errorf("raw command (%q) must be a single command", cmd["cmd"])
+	}
+
+	name, args, _ := strings.Cut(command, ",")
+	return kuka.writeCommand(name, args)
+}
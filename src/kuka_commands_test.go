@@ -0,0 +1,251 @@
+package kuka
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/viam-soleng/viam-kuka/inject"
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/test"
+)
+
+// fakeReplyController replies to commands the way the EKI program does, recording every command written.
+type fakeReplyController struct {
+	mu       sync.Mutex
+	replies  map[string]string
+	commands []string
+}
+
+func (c *fakeReplyController) respond(kuka *kukaArm, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	written := strings.TrimSuffix(string(b), ";")
+	c.commands = append(c.commands, written)
+
+	command, _, _ := strings.Cut(written, ",")
+	if reply, ok := c.replies[command]; ok {
+		kuka.handleRobotResponses(command, strings.Split(reply, ","))
+	}
+}
+
+func (c *fakeReplyController) lastCommand() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.commands[len(c.commands)-1]
+}
+
+func TestValueCommands(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	timeout := replyTimeout
+	replyTimeout = 50 * time.Millisecond
+	defer func() { replyTimeout = timeout }()
+
+	kuka := &kukaArm{
+		logger:       logger,
+		stateMutex:   sync.Mutex{},
+		currentState: state{jointLimits: make([]referenceframe.Limit, numJoints)},
+	}
+
+	controller := &fakeReplyController{replies: map[string]string{
+		ekiCommand.GetRobotName:            "KR10",
+		ekiCommand.GetRobotSerialNum:       "12345",
+		ekiCommand.GetRobotType:            "KR10 R900-2",
+		ekiCommand.GetRobotSoftwareVersion: "8.6",
+		ekiCommand.GetRobotOperatingMode:   "Extern",
+		ekiCommand.GetJointPosition:        "0,-90,90,0,45,0,1,0,0,0,0,0",
+		ekiCommand.GetEndPosition:          "500,0,600,0,90,0,2,35,1,0,0,0,0,0",
+		ekiCommand.GetJointNegLimit:        "-170,-190,-120,-185,-120,-350,0,0,0,0,0,0",
+		ekiCommand.GetJointPosLimit:        "170,45,156,185,120,350,0,0,0,0,0,0",
+		ekiCommand.GetToolData:             "0,0,100,0,0,0",
+		ekiCommand.SetOverride:             ekiCommand.ResponseSuccess,
+		ekiCommand.SetJointSpeed:           ekiCommand.ResponseInvalidValue,
+		ekiCommand.SetToolData:             ekiCommand.ResponseSuccess,
+		ekiCommand.SetBaseData:             ekiCommand.ResponseBusy,
+	}}
+	conn := inject.NewTCPConn()
+	conn.WriteFunc = func(b []byte) (n int, err error) {
+		controller.respond(kuka, b)
+		return len(b), nil
+	}
+	useInjectedConn(t, kuka, conn, ekiCommand.GetBaseData)
+
+	t.Run("get", func(t *testing.T) {
+		resp, err := kuka.DoCommand(ctx, map[string]interface{}{"command": getDeviceInfoCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{
+			"name":             "KR10",
+			"serial_number":    "12345",
+			"robot_type":       "KR10 R900-2",
+			"software_version": "8.6",
+			"operating_mode":   opModeEXT,
+		})
+
+		resp, err = kuka.DoCommand(ctx, map[string]interface{}{"command": getJointPositionsCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["joints"], test.ShouldResemble, []interface{}{0.0, -90.0, 90.0, 0.0, 45.0, 0.0})
+		test.That(t, resp["external_axes"], test.ShouldResemble, []interface{}{1.0, 0.0, 0.0, 0.0, 0.0, 0.0})
+
+		resp, err = kuka.DoCommand(ctx, map[string]interface{}{"command": getEndPositionCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["frame"], test.ShouldResemble, []interface{}{500.0, 0.0, 600.0, 0.0, 90.0, 0.0})
+		test.That(t, resp["status"], test.ShouldEqual, 2)
+		test.That(t, resp["turn"], test.ShouldEqual, 35)
+
+		resp, err = kuka.DoCommand(ctx, map[string]interface{}{"command": getJointLimitsCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["min"], test.ShouldResemble, []interface{}{-170.0, -190.0, -120.0, -185.0, -120.0, -350.0})
+		test.That(t, resp["max"], test.ShouldResemble, []interface{}{170.0, 45.0, 156.0, 185.0, 120.0, 350.0})
+
+		resp, err = kuka.DoCommand(ctx, map[string]interface{}{"command": getToolDataCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{"frame": []interface{}{0.0, 0.0, 100.0, 0.0, 0.0, 0.0}})
+	})
+
+	t.Run("set", func(t *testing.T) {
+		resp, err := kuka.DoCommand(ctx, map[string]interface{}{"command": setOverrideCommand, "value": 50.0})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{"value": 50.0})
+		test.That(t, controller.lastCommand(), test.ShouldEqual, "setoverride,50")
+
+		frame := []interface{}{1.0, 2.0, 3.0, 0.0, 0.0, 90.0}
+		resp, err = kuka.DoCommand(ctx, map[string]interface{}{"command": setToolDataCommand, "frame": frame})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{"frame": frame})
+		test.That(t, kuka.getCurrentStateSafe().toolFrame, test.ShouldResemble, []float64{1, 2, 3, 0, 0, 90})
+	})
+
+	errorTests := []struct {
+		description string
+		cmd         map[string]interface{}
+		errContains string
+	}{
+		{
+			description: "refused value",
+			cmd:         map[string]interface{}{"command": setJointSpeedCommand, "value": 50.0},
+			errContains: "kuka device refused setjointspeed: invalidValue",
+		},
+		{
+			description: "busy",
+			cmd:         map[string]interface{}{"command": setBaseDataCommand, "frame": []interface{}{0.0, 0.0, 0.0, 0.0, 0.0, 0.0}},
+			errContains: "kuka device refused setbasedata: robotBusy",
+		},
+		{
+			description: "no reply",
+			cmd:         map[string]interface{}{"command": getBaseDataCommand},
+			errContains: "did not reply to getbasedata",
+		},
+		{
+			description: "missing value",
+			cmd:         map[string]interface{}{"command": setOverrideCommand},
+			errContains: `request value for "value" (<nil>) was not a number`,
+		},
+		{
+			description: "fractional override",
+			cmd:         map[string]interface{}{"command": setOverrideCommand, "value": 50.5},
+			errContains: "must be a whole number",
+		},
+		{
+			description: "out of range",
+			cmd:         map[string]interface{}{"command": setJointSpeedCommand, "value": 150.0},
+			errContains: "joint speed (150) must be in the range (0, 100]",
+		},
+		{
+			description: "short frame",
+			cmd:         map[string]interface{}{"command": setToolDataCommand, "frame": []interface{}{0.0, 0.0}},
+			errContains: "tool frame must have 6 values",
+		},
+		{
+			description: "frame of strings",
+			cmd:         map[string]interface{}{"command": setToolDataCommand, "frame": []interface{}{"a"}},
+			errContains: "was not a list of numbers",
+		},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.description, func(t *testing.T) {
+			_, err := kuka.DoCommand(ctx, tt.cmd)
+			test.That(t, err, test.ShouldNotBeNil)
+			test.That(t, err.Error(), test.ShouldContainSubstring, tt.errContains)
+		})
+	}
+
+	t.Run("no waiters left", func(t *testing.T) {
+		kuka.replyMutex.Lock()
+		defer kuka.replyMutex.Unlock()
+		for command, waiters := range kuka.replyWaiters {
+			// Only the request left without a reply still waits, to drop its reply should it arrive late
+			if command == ekiCommand.GetBaseData {
+				test.That(t, len(waiters), test.ShouldEqual, 1)
+				test.That(t, waiters[0].abandoned, test.ShouldBeTrue)
+				continue
+			}
+			test.That(t, waiters, test.ShouldBeEmpty)
+		}
+	})
+
+	t.Run("late reply", func(t *testing.T) {
+		// The late reply to the request that was not answered arrives ahead of the reply to the next one
+		controller.mu.Lock()
+		controller.replies[ekiCommand.GetBaseData] = "1,1,1,0,0,0"
+		controller.mu.Unlock()
+		conn.WriteFunc = func(b []byte) (n int, err error) {
+			if strings.HasPrefix(string(b), ekiCommand.GetBaseData) {
+				kuka.handleRobotResponses(ekiCommand.GetBaseData, strings.Split("9,9,9,0,0,0", ","))
+			}
+			controller.respond(kuka, b)
+			return len(b), nil
+		}
+
+		resp, err := kuka.DoCommand(ctx, map[string]interface{}{"command": getBaseDataCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{"frame": []interface{}{1.0, 1.0, 1.0, 0.0, 0.0, 0.0}})
+
+		kuka.replyMutex.Lock()
+		defer kuka.replyMutex.Unlock()
+		test.That(t, kuka.replyWaiters[ekiCommand.GetBaseData], test.ShouldBeEmpty)
+	})
+}
+
+func TestRawCommands(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	kuka := &kukaArm{
+		logger:     logger,
+		stateMutex: sync.Mutex{},
+	}
+
+	var written []string
+	conn := inject.NewTCPConn()
+	conn.WriteFunc = func(b []byte) (n int, err error) {
+		written = append(written, string(b))
+		return len(b), nil
+	}
+	useInjectedConn(t, kuka, conn)
+
+	_, err := kuka.DoCommand(ctx, map[string]interface{}{"cmd": "getrobotname;"})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "raw commands are disabled")
+
+	kuka.allowRawCommands = true
+
+	_, err = kuka.DoCommand(ctx, map[string]interface{}{"cmd": "setoverride,50"})
+	test.That(t, err, test.ShouldBeNil)
+	_, err = kuka.DoCommand(ctx, map[string]interface{}{"cmd": "getrobotname;"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, written, test.ShouldResemble, []string{"setoverride,50;", "getrobotname;"})
+
+	for _, raw := range []interface{}{"getrobotname;setstop;", "getrobotname\nsetstop", ";", 5} {
+		_, err = kuka.DoCommand(ctx, map[string]interface{}{"cmd": raw})
+		test.That(t, err, test.ShouldNotBeNil)
+	}
+	test.That(t, len(written), test.ShouldEqual, 2)
+}
@@ -0,0 +1,68 @@
+package kuka
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// configComponent is the part of a machine config's component needed to find a kuka arm.
+type configComponent struct {
+	Name       string          `json:"name"`
+	Model      string          `json:"model"`
+	Attributes json.RawMessage `json:"attributes"`
+}
+
+// ReadConfigFile reads and validates the config of a kuka arm for use outside of a viam-server. The file holds either
+// the arm's attributes or a machine config, in which case the arm is found by its model or, if given, its name.
+func ReadConfigFile(path, name string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err := armAttributes(data, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var conf Config
+	if err := json.Unmarshal(attributes, &conf); err != nil {
+		return nil, errors.Wrap(err, "invalid arm attributes")
+	}
+	if _, err := conf.Validate("attributes"); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+// armAttributes returns the attributes of the kuka arm from a machine config, or the data itself if it is not a
+// machine config.
+func armAttributes(data []byte, name string) (json.RawMessage, error) {
+	var machine struct {
+		Components []configComponent `json:"components"`
+	}
+	if err := json.Unmarshal(data, &machine); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+	if machine.Components == nil {
+		return data, nil
+	}
+
+	var arms []configComponent
+	for _, c := range machine.Components {
+		if (name == "" && c.Model == Model.String()) || (name != "" && c.Name == name) {
+			arms = append(arms, c)
+		}
+	}
+	switch {
+	case len(arms) == 0 && name != "":
+		return nil, errors.Errorf("no component named %q in the config", name)
+	case len(arms) == 0:
+		return nil, errors.Errorf("no %v component in the config", Model)
+	case len(arms) > 1:
+		return nil, errors.Errorf("config has %v %v components, select one by name", len(arms), Model)
+	}
+	return arms[0].Attributes, nil
+}
@@ -0,0 +1,64 @@
+package kuka
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.viam.com/test"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	write := func(t *testing.T, data string) string {
+		path := filepath.Join(t.TempDir(), "config.json")
+		test.That(t, os.WriteFile(path, []byte(data), 0o644), test.ShouldBeNil)
+		return path
+	}
+
+	t.Run("attributes", func(t *testing.T) {
+		conf, err := ReadConfigFile(write(t, `{"ip_address": "10.0.0.2", "port": 6000}`), "")
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, conf.IPAddress, test.ShouldEqual, "10.0.0.2")
+		test.That(t, conf.Port, test.ShouldEqual, 6000)
+	})
+
+	machine := `{"components": [
+		{"name": "gripper", "model": "rdk:builtin:fake", "attributes": {}},
+		{"name": "left", "model": "sol-eng:arm:kuka", "attributes": {"ip_address": "10.0.0.2"}},
+		{"name": "right", "model": "sol-eng:arm:kuka", "attributes": {"ip_address": "10.0.0.3"}}
+	]}`
+
+	t.Run("machine config by name", func(t *testing.T) {
+		conf, err := ReadConfigFile(write(t, machine), "right")
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, conf.IPAddress, test.ShouldEqual, "10.0.0.3")
+	})
+
+	t.Run("machine config by model", func(t *testing.T) {
+		conf, err := ReadConfigFile(write(t, `{"components": [{"name": "arm", "model": "sol-eng:arm:kuka",
+			"attributes": {"ip_address": "10.0.0.4"}}]}`), "")
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, conf.IPAddress, test.ShouldEqual, "10.0.0.4")
+	})
+
+	errorTests := []struct {
+		description string
+		data        string
+		name        string
+		errContains string
+	}{
+		{description: "several arms", data: machine, errContains: "config has 2 sol-eng:arm:kuka components"},
+		{description: "unknown name", data: machine, name: "middle", errContains: `no component named "middle"`},
+		{description: "no arm", data: `{"components": []}`, errContains: "no sol-eng:arm:kuka component"},
+		{description: "invalid json", data: `{"ip_address":`, errContains: "invalid config"},
+		{description: "invalid attributes", data: `{"port": 6000}`, errContains: "ip_address"},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.description, func(t *testing.T) {
+			_, err := ReadConfigFile(write(t, tt.data), tt.name)
+			test.That(t, err, test.ShouldNotBeNil)
+			test.That(t, err.Error(), test.ShouldContainSubstring, tt.errContains)
+		})
+	}
+}
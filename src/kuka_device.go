@@ -1,28 +1,69 @@
 package kuka
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"net"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/utils"
+
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	"github.com/viam-soleng/viam-kuka/src/ekimanager"
+	"github.com/viam-soleng/viam-kuka/src/ekitrace"
+	"github.com/viam-soleng/viam-kuka/src/ekixml"
 )
 
 const (
 	defaultReadBufSize int = 8192
+
+	// writeQueueSize, stopQueueSize and messageQueueSize bound the commands waiting to be written, the stops waiting
+	// to be written ahead of them and the messages waiting to be handled
+	writeQueueSize   int = 32
+	stopQueueSize    int = 4
+	messageQueueSize int = 64
 )
 
 var (
 	connectionTimeout time.Duration = 5 * time.Second
+
+	errNotConnected     = errors.New("not connected to kuka device")
+	errConnectionClosed = errors.New("connection to kuka device closed")
 )
 
+// connection is an open TCP connection to the kuka device. A single reader frames the messages received and passes
+// them, in order, to a dispatcher that handles them, while a single writer sends the commands queued by Write, so a
+// write never waits on a read. Stops are written ahead of the other commands queued. Commands and replies are framed
+// in the text or XML message format the EKI program was generated with.
+type connection struct {
+	conn        net.Conn
+	xmlMessages bool
+	writes      chan writeRequest
+	stops       chan writeRequest
+	messages    chan string
+
+	closing   chan struct{}
+	closeOnce sync.Once
+	workers   sync.WaitGroup
+}
+
+// writeRequest is a command queued to be written, done receiving the result of the write.
+type writeRequest struct {
+	data []byte
+	done chan error
+}
+
 // Connect to the Kuka Arm via TCP dialer
 func (kuka *kukaArm) Connect(ctx context.Context) error {
 
 	// Close any prior connections
-	if kuka.tcpConn.conn != nil {
-		if err := kuka.Disconnect(); err != nil {
-			return err
-		}
+	if err := kuka.Disconnect(); err != nil {
+		return err
 	}
 
 	// Attempt to dial the TCP server
@@ -37,50 +78,312 @@ func (kuka *kukaArm) Connect(ctx context.Context) error {
 	}
 
 	kuka.logger.Infof("Connected to device at %v", address)
-	kuka.tcpConn.conn = conn
+
+	// Record what is sent and received, for the session to be replayed
+	if kuka.tcpConn.traceFile != "" {
+		recorder, err := ekitrace.NewRecorder(kuka.tcpConn.traceFile)
+		if err != nil {
+			utils.UncheckedError(conn.Close())
+			return errors.Wrap(err, "failed to open trace_file")
+		}
+		conn = recorder.Wrap(conn)
+	}
+	kuka.startConnection(conn)
+
+	if kuka.tcpConn.stopPort == 0 {
+		return nil
+	}
+	stopAddress := fmt.Sprintf("%v:%v", kuka.tcpConn.ipAddress, kuka.tcpConn.stopPort)
+	stopConn, err := d.DialContext(ctx, "tcp", stopAddress)
+	if err != nil {
+		utils.UncheckedError(kuka.Disconnect())
+		return errors.Wrapf(err, "failed to connect to the stop channel at %v, the controller package must be "+
+			"generated with stop_port", stopAddress)
+	}
+	kuka.logger.Infof("Connected to stop channel at %v", stopAddress)
+	kuka.startStopConnection(stopConn)
 
 	return nil
 }
 
-// Disconnect TCP dialer
+// startConnection starts the reader, dispatcher and writer of the given connection to the kuka device. Requests
+// abandoned on a previous connection are dropped, as their replies will not arrive on this one.
+func (kuka *kukaArm) startConnection(conn net.Conn) {
+	kuka.tcpConn.mu.Lock()
+	xmlMessages := kuka.tcpConn.messageFormat == ekimanager.MessageFormatXML
+	kuka.tcpConn.mu.Unlock()
+
+	kuka.dropAbandonedReplyWaiters()
+	c := kuka.runConnection(conn, xmlMessages)
+
+	kuka.tcpConn.mu.Lock()
+	defer kuka.tcpConn.mu.Unlock()
+	kuka.tcpConn.active = c
+}
+
+// startStopConnection starts the reader, dispatcher and writer of the stop channel, a second connection to the kuka
+// device reserved for setStop. It is read by its own submit interpreter, so a stop is neither queued behind the
+// commands written to the main connection nor behind those the EKI program is handling. Its messages are always
+// framed as text.
+func (kuka *kukaArm) startStopConnection(conn net.Conn) {
+	c := kuka.runConnection(conn, false)
+
+	kuka.tcpConn.mu.Lock()
+	defer kuka.tcpConn.mu.Unlock()
+	kuka.tcpConn.stop = c
+}
+
+// runConnection starts the reader, dispatcher and writer of a connection to the kuka device.
+func (kuka *kukaArm) runConnection(conn net.Conn, xmlMessages bool) *connection {
+	c := &connection{
+		conn:        conn,
+		xmlMessages: xmlMessages,
+		writes:      make(chan writeRequest, writeQueueSize),
+		stops:       make(chan writeRequest, stopQueueSize),
+		messages:    make(chan string, messageQueueSize),
+		closing:     make(chan struct{}),
+	}
+
+	c.workers.Add(3)
+	utils.PanicCapturingGo(func() {
+		defer c.workers.Done()
+		kuka.readLoop(c)
+	})
+	utils.PanicCapturingGo(func() {
+		defer c.workers.Done()
+		kuka.dispatchLoop(c)
+	})
+	utils.PanicCapturingGo(func() {
+		defer c.workers.Done()
+		kuka.writeLoop(c)
+	})
+	return c
+}
+
+// Disconnect TCP dialer, and the stop channel if open, waiting for their readers, dispatchers and writers to end.
 func (kuka *kukaArm) Disconnect() error {
-	if err := kuka.tcpConn.conn.Close(); err != nil {
-		return err
+	kuka.tcpConn.mu.Lock()
+	c, stop := kuka.tcpConn.active, kuka.tcpConn.stop
+	kuka.tcpConn.active, kuka.tcpConn.stop = nil, nil
+	kuka.tcpConn.mu.Unlock()
+
+	var err error
+	for _, conn := range []*connection{c, stop} {
+		if conn == nil {
+			continue
+		}
+		if closeErr := conn.close(); err == nil {
+			err = closeErr
+		}
+		conn.workers.Wait()
 	}
+	return err
+}
 
-	return nil
+// close closes the connection, ending any read or write in progress.
+func (c *connection) close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closing)
+		err = c.conn.Close()
+	})
+	return err
 }
 
-// Write to TCP dialer
-func (kuka *kukaArm) Write(command []byte) error {
+// activeConnection returns the open connection to the kuka device, if any.
+func (kuka *kukaArm) activeConnection() *connection {
 	kuka.tcpConn.mu.Lock()
 	defer kuka.tcpConn.mu.Unlock()
+	return kuka.tcpConn.active
+}
 
-	kuka.logger.Debugf("Sending command: %v", string(command))
-	if _, err := kuka.tcpConn.conn.Write(command); err != nil {
-		return err
+// connectionClosed returns a channel closed once the open connection to the kuka device is closed, for waits on a
+// reply to end when it can no longer arrive. The channel is already closed if there is no connection.
+func (kuka *kukaArm) connectionClosed() <-chan struct{} {
+	if c := kuka.activeConnection(); c != nil {
+		return c.closing
 	}
-
-	return nil
+	closed := make(chan struct{})
+	close(closed)
+	return closed
 }
 
-// Read from TCP dialer
-func (kuka *kukaArm) Read() ([]byte, error) {
+// stopConnection returns the open stop channel to the kuka device, if any.
+func (kuka *kukaArm) stopConnection() *connection {
 	kuka.tcpConn.mu.Lock()
 	defer kuka.tcpConn.mu.Unlock()
+	return kuka.tcpConn.stop
+}
+
+// Write queues the command to be written to the kuka device and waits for it to be written.
+func (kuka *kukaArm) Write(command []byte) error {
+	c := kuka.activeConnection()
+	if c == nil {
+		return errNotConnected
+	}
+	return kuka.queueWrite(c, c.writes, command)
+}
 
-	kuka.tcpConn.conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
-	recv := make([]byte, defaultReadBufSize)
-	n, err := kuka.tcpConn.conn.Read(recv)
+// queueWrite queues the command to be written to the connection and waits for it to be written.
+func (kuka *kukaArm) queueWrite(c *connection, queue chan writeRequest, command []byte) error {
+	kuka.logger.Debugf("Sending command: %v", string(command))
+	req := writeRequest{data: command, done: make(chan error, 1)}
+	select {
+	case queue <- req:
+	case <-c.closing:
+		return errConnectionClosed
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-c.closing:
+		return errConnectionClosed
+	}
+}
+
+// writeCommand frames a command and its comma separated arguments in the message format of the connection, then
+// writes it to the kuka device.
+func (kuka *kukaArm) writeCommand(EKICommand, args string) error {
+	c := kuka.activeConnection()
+	if c == nil {
+		return errNotConnected
+	}
+	data, err := c.frame(EKICommand, args)
 	if err != nil {
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			return nil, nil
+		return err
+	}
+	return kuka.queueWrite(c, c.writes, data)
+}
+
+// writeStop writes setStop to the stop channel if it is open, otherwise to the main connection ahead of the commands
+// queued there. Returns the channel closed once the connection it was written to is closed.
+func (kuka *kukaArm) writeStop() (<-chan struct{}, error) {
+	c := kuka.stopConnection()
+	if c == nil {
+		if c = kuka.activeConnection(); c == nil {
+			return nil, errNotConnected
 		}
+	}
+	data, err := c.frame(ekiCommand.SetStop, "")
+	if err != nil {
 		return nil, err
 	}
+	return c.closing, kuka.queueWrite(c, c.stops, data)
+}
+
+// frame frames a command and its comma separated arguments in the message format of the connection.
+func (c *connection) frame(EKICommand, args string) ([]byte, error) {
+	if !c.xmlMessages {
+		return []byte(formatCommand(EKICommand, args)), nil
+	}
+
+	var argList []string
+	if args != "" {
+		argList = strings.Split(args, ",")
+	}
+	return ekixml.Encode(EKICommand, argList)
+}
 
-	response := recv[:n]
-	kuka.logger.Debugf("Received response: %v", string(response))
+// writeLoop writes the queued commands to the kuka device in order until the connection is closed. A queued stop is
+// written before any other command still queued, though not before the write in progress.
+func (kuka *kukaArm) writeLoop(c *connection) {
+	for {
+		select {
+		case req := <-c.stops:
+			c.write(req)
+			continue
+		default:
+		}
 
-	return response, nil
+		select {
+		case <-c.closing:
+			return
+		case req := <-c.stops:
+			c.write(req)
+		case req := <-c.writes:
+			c.write(req)
+		}
+	}
+}
+
+// write writes a queued command to the kuka device, passing on the result.
+func (c *connection) write(req writeRequest) {
+	_, err := c.conn.Write(req.data)
+	req.done <- err
+}
+
+// readLoop reads messages from the kuka device and queues them to be dispatched until the connection is closed. A
+// failed read, such as the kuka device dropping the connection, closes it.
+func (kuka *kukaArm) readLoop(c *connection) {
+	defer close(c.messages)
+
+	next := readTextMessages(c.conn)
+	if c.xmlMessages {
+		next = kuka.readXMLMessages(c.conn)
+	}
+	for {
+		message, err := next()
+		if err != nil {
+			select {
+			case <-c.closing:
+			default:
+				// Close the connection so that the requests and motion waiting on replies end, and no more commands
+				// are written to it
+				kuka.logger.Warnf("error reading from kuka device, closing the connection: %v", err)
+				utils.UncheckedError(c.close())
+			}
+			return
+		}
+
+		if message == "" {
+			continue
+		}
+		kuka.logger.Debugf("Received response: %v", message)
+
+		select {
+		case c.messages <- message:
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+// readTextMessages returns a function reading the next message terminated by ';'. Several messages received at once
+// are split, and a message split across reads is joined.
+func readTextMessages(r io.Reader) func() (string, error) {
+	reader := bufio.NewReaderSize(r, defaultReadBufSize)
+	return func() (string, error) {
+		data, err := reader.ReadString(';')
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(strings.TrimSuffix(data, ";")), nil
+	}
+}
+
+// readXMLMessages returns a function reading the next <Reply> element, returned as the message of the text format so
+// that it is handled the same. Invalid replies are logged and skipped.
+func (kuka *kukaArm) readXMLMessages(r io.Reader) func() (string, error) {
+	decoder := ekixml.NewDecoder(bufio.NewReaderSize(r, defaultReadBufSize))
+	return func() (string, error) {
+		for {
+			reply, err := decoder.Next()
+			if errors.Cause(err) == ekixml.ErrInvalidReply {
+				kuka.logger.Warnf("ignoring message from kuka device: %v", err)
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			return strings.Join(append([]string{reply.Name}, reply.Values...), ","), nil
+		}
+	}
+}
+
+// dispatchLoop handles the messages read from the kuka device in the order they were received.
+func (kuka *kukaArm) dispatchLoop(c *connection) {
+	for message := range c.messages {
+		kuka.handleMessage(message)
+	}
 }
@@ -0,0 +1,630 @@
+package kuka
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/viam-soleng/viam-kuka/inject"
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	"github.com/viam-soleng/viam-kuka/src/ekimanager"
+	"github.com/viam-soleng/viam-kuka/src/ekitrace"
+	pb "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/components/arm"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/rdk/referenceframe/urdf"
+	"go.viam.com/test"
+)
+
+// acknowledgingConn acknowledges with success every command still awaiting a reply once written, other than those
+// left unanswered, as the EKI program does for commands the injected writes do not answer themselves.
+type acknowledgingConn struct {
+	*inject.TCPConn
+	kuka       *kukaArm
+	unanswered map[string]bool
+}
+
+func (c *acknowledgingConn) Write(b []byte) (n int, err error) {
+	if n, err = c.TCPConn.Write(b); err != nil {
+		return n, err
+	}
+
+	command, _, _ := strings.Cut(strings.TrimSuffix(string(b), ";"), ",")
+	c.kuka.replyMutex.Lock()
+	awaited := len(c.kuka.replyWaiters[command]) > 0
+	c.kuka.replyMutex.Unlock()
+	if awaited && !c.unanswered[command] {
+		c.kuka.handleRobotResponses(command, []string{ekiCommand.ResponseSuccess})
+	}
+	return n, nil
+}
+
+// useInjectedConn starts the connection of the arm on the injected connection, closing it at the end of the test.
+// Reads block until the connection is closed, responses are given by the injected writes calling handleRobotResponses,
+// and commands they do not answer are acknowledged unless given as unanswered.
+func useInjectedConn(t *testing.T, kuka *kukaArm, conn *inject.TCPConn, unanswered ...string) {
+	closed := make(chan struct{})
+	var closeOnce sync.Once
+	conn.ReadFunc = func(b []byte) (n int, err error) {
+		<-closed
+		return 0, io.EOF
+	}
+	conn.CloseFunc = func() error {
+		closeOnce.Do(func() { close(closed) })
+		return nil
+	}
+
+	ackConn := &acknowledgingConn{TCPConn: conn, kuka: kuka, unanswered: map[string]bool{}}
+	for _, command := range unanswered {
+		ackConn.unanswered[command] = true
+	}
+	kuka.startConnection(ackConn)
+	t.Cleanup(func() { test.That(t, kuka.Disconnect(), test.ShouldBeNil) })
+}
+
+// newConnectedArm returns an arm connected to the kuka device of the config, closed at the end of the test.
+func newConnectedArm(tb testing.TB, conf *Config) *kukaArm {
+	urdfModel, err := urdf.ParseModelXMLFile(resolveFile(fmt.Sprintf("src/models/%v_model.urdf", kr10r900)), "test")
+	test.That(tb, err, test.ShouldBeNil)
+
+	// Benchmarks log nothing so that logging does not skew them
+	logger := logging.NewBlankLogger("test")
+	if t, ok := tb.(*testing.T); ok {
+		logger = logging.NewTestLogger(t)
+	}
+
+	kuka := &kukaArm{
+		Named:  arm.Named("test").AsNamed(),
+		logger: logger,
+		tcpConn: tcpConn{
+			ipAddress:     conf.IPAddress,
+			port:          conf.Port,
+			messageFormat: conf.EKIMessageFormat,
+			traceFile:     conf.TraceFile,
+			stopPort:      conf.StopPort,
+		},
+		stopCh: make(chan stopEvent, 1),
+		model:  urdfModel,
+	}
+	kuka.resetCurrentStateAndDeviceInfo()
+	kuka.currentState.joints = make([]float64, numJoints)
+	kuka.currentState.jointSpeed = defaultJointSpeed
+	for i := range kuka.currentState.jointLimits {
+		kuka.currentState.jointLimits[i] = referenceframe.Limit{Min: -170, Max: 170}
+	}
+
+	test.That(tb, kuka.Connect(context.Background()), test.ShouldBeNil)
+	tb.Cleanup(func() { test.That(tb, kuka.Close(context.Background()), test.ShouldBeNil) })
+	return kuka
+}
+
+var fakeDeviceResponses = map[string]string{
+	ekiCommand.GetRobotName:          "KR10",
+	ekiCommand.GetRobotOperatingMode: "Extern",
+	ekiCommand.GetJointPosition:      "0,0,0,0,0,0,0,0,0,0,0,0",
+	ekiCommand.GetEndPosition:        "500,0,600,0,90,0,2,35,0,0,0,0,0,0",
+	ekiCommand.GetToolData:           "0,0,100,0,0,0",
+	ekiCommand.GetMaxJointSpeed:      "5,5,5,8,8,10,0,0,0,0,0,0",
+	ekiCommand.GetStopMessage:        "false",
+	ekiCommand.GetRunMode:            "GO",
+	ekiCommand.GetEKIProgramState:    "EKIMAIN,Running",
+}
+
+func copyResponses(responses map[string]string) map[string]string {
+	copied := map[string]string{}
+	for command, response := range responses {
+		copied[command] = response
+	}
+	return copied
+}
+
+func TestConnectionFraming(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeEKIServer(t, copyResponses(fakeDeviceResponses))
+	kuka := newConnectedArm(t, server.config())
+
+	// A reply means the server has accepted the connection
+	_, err := kuka.request(ctx, ekiCommand.GetRobotName, "")
+	test.That(t, err, test.ShouldBeNil)
+
+	// Several messages in one write, and a message split across writes
+	server.mu.Lock()
+	server.send(ekiCommand.GetRobotType + ",KR10 R900-2;" + ekiCommand.GetRobotSerialNum + ",12345;" +
+		ekiCommand.GetRobotSoftwareVersion + ",8.")
+	server.send("6;\r\n")
+	server.mu.Unlock()
+
+	// Messages are handled in order, so they have been handled once a later request is answered
+	_, err = kuka.request(ctx, ekiCommand.GetRunMode, "")
+	test.That(t, err, test.ShouldBeNil)
+
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	test.That(t, kuka.deviceInfo.robotType, test.ShouldEqual, "KR10 R900-2")
+	test.That(t, kuka.deviceInfo.serialNum, test.ShouldEqual, "12345")
+	test.That(t, kuka.deviceInfo.softwareVersion, test.ShouldEqual, "8.6")
+}
+
+func TestConnectionDropped(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeEKIServer(t, copyResponses(fakeDeviceResponses))
+	server.setMoveTime(5 * time.Second)
+	kuka := newConnectedArm(t, server.config())
+
+	moveErr := make(chan error, 1)
+	go func() {
+		moveErr <- kuka.MoveToJointPositions(ctx, &pb.JointPositions{Values: []float64{10, 0, 0, 0, 0, 0}}, nil)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for !kuka.getCurrentStateSafe().isMoving {
+		if time.Now().After(deadline) {
+			t.Fatal("arm did not start moving")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The kuka device drops the connection during the move
+	server.mu.Lock()
+	test.That(t, server.conn.Close(), test.ShouldBeNil)
+	server.mu.Unlock()
+
+	select {
+	case err := <-moveErr:
+		test.That(t, err, test.ShouldEqual, errConnectionClosed)
+	case <-time.After(time.Second):
+		t.Fatal("move did not end when the connection was dropped")
+	}
+	test.That(t, kuka.getCurrentStateSafe().isMoving, test.ShouldBeFalse)
+
+	// Nothing more is written to the dropped connection
+	_, err := kuka.request(ctx, ekiCommand.GetRobotName, "")
+	test.That(t, err, test.ShouldEqual, errConnectionClosed)
+}
+
+func TestMoveCancelled(t *testing.T) {
+	server := newFakeEKIServer(t, copyResponses(fakeDeviceResponses))
+	server.setMoveTime(5 * time.Second)
+	kuka := newConnectedArm(t, server.config())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	moveErr := make(chan error, 1)
+	go func() {
+		moveErr <- kuka.MoveToJointPositions(ctx, &pb.JointPositions{Values: []float64{10, 0, 0, 0, 0, 0}}, nil)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for !kuka.getCurrentStateSafe().isMoving {
+		if time.Now().After(deadline) {
+			t.Fatal("arm did not start moving")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-moveErr:
+		test.That(t, err, test.ShouldEqual, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("move did not end when cancelled")
+	}
+
+	// The robot was stopped, and the next move is not refused as the arm still moving
+	server.mu.Lock()
+	test.That(t, server.moveStop, test.ShouldBeNil)
+	server.mu.Unlock()
+	test.That(t, kuka.getCurrentStateSafe().isMoving, test.ShouldBeFalse)
+
+	server.setMoveTime(0)
+	err := kuka.MoveToJointPositions(context.Background(), &pb.JointPositions{Values: []float64{10, 0, 0, 0, 0, 0}}, nil)
+	test.That(t, err, test.ShouldBeNil)
+}
+
+func TestXMLMessages(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeEKIServer(t, copyResponses(fakeDeviceResponses))
+	server.mu.Lock()
+	server.xmlMessages = true
+	server.mu.Unlock()
+	conf := server.config()
+	conf.EKIMessageFormat = ekimanager.MessageFormatXML
+	kuka := newConnectedArm(t, conf)
+
+	reply, err := kuka.request(ctx, ekiCommand.GetJointPosition, "")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, len(reply), test.ShouldEqual, 12)
+
+	test.That(t, kuka.sendCommand(ekiCommand.SetJointSpeed, "10"), test.ShouldBeNil)
+	server.mu.Lock()
+	test.That(t, server.xmlArgs[ekiCommand.SetJointSpeed], test.ShouldResemble, []string{"10"})
+	server.mu.Unlock()
+
+	// Commands are checked against the schema before being sent
+	err = kuka.sendCommand(ekiCommand.SetToolData, "0,0,100")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "takes 6 arguments")
+
+	// An invalid reply is skipped, the replies that follow are handled
+	server.mu.Lock()
+	server.send(`<Reply Name="getrobottype" Id="0" Count="2"><V>KR6</V></Reply>` + "\r\n" +
+		`<Reply Name="getrobottype" Id="0" Count="1"><V>KR10 R900-2</V></Reply>`)
+	server.mu.Unlock()
+	_, err = kuka.request(ctx, ekiCommand.GetRunMode, "")
+	test.That(t, err, test.ShouldBeNil)
+
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	test.That(t, kuka.deviceInfo.robotType, test.ShouldEqual, "KR10 R900-2")
+	test.That(t, kuka.currentState.runMode, test.ShouldEqual, "GO")
+}
+
+// serveStops reads the commands written to the device end of a connection, answering setStop, and returns them in the
+// order read. Each read is preceded by the given delay, as a kuka device slow to read.
+func serveStops(t *testing.T, device net.Conn, delay time.Duration) <-chan string {
+	t.Cleanup(func() { device.Close() })
+	commands := make(chan string, 64)
+	go func() {
+		reader := bufio.NewReader(device)
+		for {
+			time.Sleep(delay)
+			command, err := reader.ReadString(';')
+			if err != nil {
+				return
+			}
+			commands <- command
+			if command == ekiCommand.SetStop+";" {
+				device.Write([]byte(ekiCommand.SetStop + "," + ekiCommand.ResponseSuccess + ";"))
+			}
+		}
+	}()
+	return commands
+}
+
+func TestStopPriority(t *testing.T) {
+	ctx := context.Background()
+	const queued = 8
+
+	// newDisconnectedArm returns an arm whose connections are started by the test
+	newDisconnectedArm := func(t *testing.T) *kukaArm {
+		kuka := newConnectedArm(t, newFakeEKIServer(t, copyResponses(fakeDeviceResponses)).config())
+		test.That(t, kuka.Disconnect(), test.ShouldBeNil)
+		return kuka
+	}
+
+	// queueWrites queues commands from their own goroutines, returning once they are all queued or being written
+	queueWrites := func(t *testing.T, kuka *kukaArm) {
+		for i := 0; i < queued; i++ {
+			go kuka.Write([]byte(ekiCommand.GetJointPosition + ";"))
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for len(kuka.activeConnection().writes) < queued-1 {
+			if time.Now().After(deadline) {
+				t.Fatal("commands were not queued")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Run("ahead of queued commands", func(t *testing.T) {
+		const readDelay = 50 * time.Millisecond
+		kuka := newDisconnectedArm(t)
+		client, device := net.Pipe()
+		kuka.startConnection(client)
+		commands := serveStops(t, device, readDelay)
+		queueWrites(t, kuka)
+
+		start := time.Now()
+		test.That(t, kuka.requestStop(ctx), test.ShouldBeNil)
+		latency := time.Since(start)
+		t.Logf("stop answered after %v, ahead of %v queued commands read every %v", latency, queued, readDelay)
+		test.That(t, latency, test.ShouldBeLessThan, queued*readDelay/2)
+
+		// Only the command being written when the stop was queued is written before it
+		test.That(t, <-commands, test.ShouldEqual, ekiCommand.GetJointPosition+";")
+		test.That(t, <-commands, test.ShouldEqual, ekiCommand.SetStop+";")
+	})
+
+	t.Run("write in progress", func(t *testing.T) {
+		kuka := newDisconnectedArm(t)
+
+		// The main connection is never read, so the write in progress never ends
+		client, device := net.Pipe()
+		t.Cleanup(func() { device.Close() })
+		kuka.startConnection(client)
+		stopClient, stopDevice := net.Pipe()
+		kuka.startStopConnection(stopClient)
+		commands := serveStops(t, stopDevice, 0)
+		queueWrites(t, kuka)
+
+		start := time.Now()
+		test.That(t, kuka.requestStop(ctx), test.ShouldBeNil)
+		latency := time.Since(start)
+		t.Logf("stop answered on the stop channel after %v, behind a write in progress", latency)
+		test.That(t, latency, test.ShouldBeLessThan, 100*time.Millisecond)
+		test.That(t, <-commands, test.ShouldEqual, ekiCommand.SetStop+";")
+
+		// Without a stop channel, the stop waits for the write in progress until the connection is closed
+		test.That(t, kuka.stopConnection().close(), test.ShouldBeNil)
+		kuka.tcpConn.mu.Lock()
+		kuka.tcpConn.stop = nil
+		kuka.tcpConn.mu.Unlock()
+		stopped := make(chan error, 1)
+		go func() { stopped <- kuka.requestStop(ctx) }()
+		select {
+		case <-stopped:
+			t.Fatal("stop written behind a write in progress")
+		case <-time.After(100 * time.Millisecond):
+		}
+		test.That(t, kuka.Disconnect(), test.ShouldBeNil)
+		test.That(t, <-stopped, test.ShouldEqual, errConnectionClosed)
+	})
+
+	t.Run("stop channel ends the motion", func(t *testing.T) {
+		server := newFakeEKIServer(t, copyResponses(fakeDeviceResponses))
+		server.setMoveTime(5 * time.Second)
+		stopServer := newFakeEKIServer(t, map[string]string{})
+		conf := server.config()
+		conf.StopPort = stopServer.config().Port
+		kuka := newConnectedArm(t, conf)
+
+		moveErr := make(chan error, 1)
+		go func() {
+			moveErr <- kuka.MoveToJointPositions(ctx, &pb.JointPositions{Values: []float64{10, 0, 0, 0, 0, 0}}, nil)
+		}()
+		deadline := time.Now().Add(2 * time.Second)
+		for !kuka.getCurrentStateSafe().isMoving {
+			if time.Now().After(deadline) {
+				t.Fatal("arm did not start moving")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		start := time.Now()
+		test.That(t, kuka.requestStop(ctx), test.ShouldBeNil)
+		select {
+		case err := <-moveErr:
+			test.That(t, err, test.ShouldEqual, errMoveStopped)
+		case <-time.After(time.Second):
+			t.Fatal("move did not end on the stop")
+		}
+		t.Logf("motion ended %v after the stop", time.Since(start))
+
+		// The stop was only sent on the stop channel
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		test.That(t, server.moveStop, test.ShouldNotBeNil)
+	})
+}
+
+// replayTrace plays back a trace to the arm while run sends the commands of the session, returning the data written
+// once every message received has been handled.
+func replayTrace(t *testing.T, kuka *kukaArm, entries []ekitrace.Entry, run func()) []string {
+	replay := ekitrace.NewReplay(entries)
+	kuka.startConnection(replay.Conn)
+	run()
+	select {
+	case <-replay.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("trace was not played back")
+	}
+	test.That(t, kuka.Disconnect(), test.ShouldBeNil)
+	return replay.Written()
+}
+
+func TestTrace(t *testing.T) {
+	ctx := context.Background()
+	newArm := func() *kukaArm {
+		kuka := &kukaArm{
+			Named:  arm.Named("test").AsNamed(),
+			logger: logging.NewTestLogger(t),
+			stopCh: make(chan stopEvent, 1),
+		}
+		kuka.resetCurrentStateAndDeviceInfo()
+		return kuka
+	}
+
+	t.Run("record and replay", func(t *testing.T) {
+		server := newFakeEKIServer(t, copyResponses(fakeDeviceResponses))
+		conf := server.config()
+		conf.TraceFile = filepath.Join(t.TempDir(), "trace.jsonl")
+		kuka := newConnectedArm(t, conf)
+
+		session := func(kuka *kukaArm) func() {
+			return func() {
+				_, err := kuka.request(ctx, ekiCommand.GetEndPosition, "")
+				test.That(t, err, test.ShouldBeNil)
+				test.That(t, kuka.sendCommand(ekiCommand.SetJointSpeed, "10"), test.ShouldBeNil)
+			}
+		}
+		session(kuka)()
+		test.That(t, kuka.Disconnect(), test.ShouldBeNil)
+
+		entries, err := ekitrace.ReadFile(conf.TraceFile)
+		test.That(t, err, test.ShouldBeNil)
+		sent := ekitrace.Data(entries, ekitrace.Sent)
+		test.That(t, sent, test.ShouldResemble, []string{ekiCommand.GetEndPosition + ";", ekiCommand.SetJointSpeed + ",10;"})
+		test.That(t, len(ekitrace.Data(entries, ekitrace.Received)), test.ShouldBeGreaterThan, 0)
+
+		replayed := newArm()
+		test.That(t, replayTrace(t, replayed, entries, session(replayed)), test.ShouldResemble, sent)
+		test.That(t, replayed.currentState.endStatus, test.ShouldEqual, 2)
+		test.That(t, replayed.currentState.endEffectorPose.Point().X, test.ShouldAlmostEqual, 500)
+	})
+
+	t.Run("split state stream", func(t *testing.T) {
+		entries, err := ekitrace.ReadFile(resolveFile("src/testdata/traces/split_state_stream.jsonl"))
+		test.That(t, err, test.ShouldBeNil)
+
+		kuka := newArm()
+		written := replayTrace(t, kuka, entries, func() {
+			reply, err := kuka.request(ctx, ekiCommand.GetRobotName, "")
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, reply, test.ShouldResemble, []string{"KR10"})
+		})
+		test.That(t, written, test.ShouldResemble, ekitrace.Data(entries, ekitrace.Sent))
+
+		// The state split across reads is joined, and the invalid one that follows ignored
+		test.That(t, kuka.currentState.joints, test.ShouldResemble, []float64{10, -80, 85, 5, 40, -5})
+		test.That(t, kuka.currentState.override, test.ShouldEqual, 75)
+		test.That(t, kuka.deviceInfo.operatingMode, test.ShouldEqual, "Extern")
+		test.That(t, kuka.deviceInfo.name, test.ShouldEqual, "KR10")
+	})
+}
+
+func TestConcurrentUse(t *testing.T) {
+	ctx := context.Background()
+	target := &pb.JointPositions{Values: []float64{10, -10, 10, 0, 10, 0}}
+
+	// waitForMoving waits for the arm to start moving, failing the test if it does not
+	waitForMoving := func(t *testing.T, kuka *kukaArm) {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			if isMoving, _ := kuka.IsMoving(ctx); isMoving {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("arm did not start moving")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Run("motion with state reads and requests", func(t *testing.T) {
+		server := newFakeEKIServer(t, copyResponses(fakeDeviceResponses))
+		server.setMoveTime(300 * time.Millisecond)
+		kuka := newConnectedArm(t, server.config())
+
+		moveErr := make(chan error, 1)
+		go func() { moveErr <- kuka.MoveToJointPositions(ctx, target, nil) }()
+
+		var wg sync.WaitGroup
+		readErrs := make(chan error, 8)
+		done := make(chan struct{})
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					if _, err := kuka.JointPositions(ctx, nil); err != nil {
+						readErrs <- err
+						return
+					}
+					if _, err := kuka.EndPosition(ctx, nil); err != nil {
+						readErrs <- err
+						return
+					}
+					if _, err := kuka.Geometries(ctx, nil); err != nil {
+						readErrs <- err
+						return
+					}
+					if _, err := kuka.DoCommand(ctx, map[string]interface{}{"command": getToolDataCommand}); err != nil {
+						readErrs <- err
+						return
+					}
+				}
+			}()
+		}
+
+		select {
+		case err := <-moveErr:
+			test.That(t, err, test.ShouldBeNil)
+		case <-time.After(5 * time.Second):
+			t.Fatal("move did not complete")
+		}
+		close(done)
+		wg.Wait()
+		close(readErrs)
+		for err := range readErrs {
+			test.That(t, err, test.ShouldBeNil)
+		}
+
+		isMoving, err := kuka.IsMoving(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, isMoving, test.ShouldBeFalse)
+	})
+
+	t.Run("stop during motion", func(t *testing.T) {
+		server := newFakeEKIServer(t, copyResponses(fakeDeviceResponses))
+		server.setMoveTime(10 * time.Second)
+		kuka := newConnectedArm(t, server.config())
+
+		moveErr := make(chan error, 1)
+		go func() { moveErr <- kuka.MoveToJointPositions(ctx, target, nil) }()
+		waitForMoving(t, kuka)
+
+		test.That(t, kuka.Stop(ctx, nil), test.ShouldBeNil)
+		select {
+		case err := <-moveErr:
+			test.That(t, err, test.ShouldEqual, errMoveStopped)
+		case <-time.After(2 * time.Second):
+			t.Fatal("move did not end on stop")
+		}
+
+		// A move refused by the device returns its reply
+		server.mu.Lock()
+		server.moveStop = make(chan struct{})
+		server.mu.Unlock()
+		err := kuka.MoveToJointPositions(ctx, target, nil)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "kuka device refused ptptojointpos: robotBusy")
+	})
+
+	t.Run("close during motion and requests", func(t *testing.T) {
+		server := newFakeEKIServer(t, copyResponses(fakeDeviceResponses))
+		server.setMoveTime(10 * time.Second)
+		server.setResponse(ekiCommand.GetBaseData, "")
+		kuka := newConnectedArm(t, server.config())
+
+		moveErr := make(chan error, 1)
+		go func() { moveErr <- kuka.MoveToJointPositions(ctx, target, nil) }()
+		waitForMoving(t, kuka)
+
+		requestErr := make(chan error, 1)
+		go func() {
+			_, err := kuka.DoCommand(ctx, map[string]interface{}{"command": getBaseDataCommand})
+			requestErr <- err
+		}()
+		go func() {
+			for {
+				if _, err := kuka.JointPositions(ctx, nil); err != nil {
+					return
+				}
+				if kuka.closed.Load() {
+					return
+				}
+			}
+		}()
+
+		closeErr := make(chan error, 1)
+		go func() { closeErr <- kuka.Close(ctx) }()
+		select {
+		case err := <-closeErr:
+			test.That(t, err, test.ShouldBeNil)
+		case <-time.After(2 * time.Second):
+			t.Fatal("close did not return")
+		}
+
+		for _, errCh := range []chan error{moveErr, requestErr} {
+			select {
+			case err := <-errCh:
+				test.That(t, err, test.ShouldNotBeNil)
+			case <-time.After(time.Second):
+				t.Fatal("wait on the kuka device did not end on close")
+			}
+		}
+
+		_, err := kuka.request(ctx, ekiCommand.GetRobotName, "")
+		test.That(t, err, test.ShouldBeError, errNotConnected)
+	})
+}
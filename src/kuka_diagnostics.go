@@ -0,0 +1,310 @@
+package kuka
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/components/arm"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/rdk/spatialmath"
+
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+)
+
+const diagnosticsResponseBufferSize = 64
+
+// Response is a message received from the kuka device.
+type Response struct {
+	Time    time.Time
+	Command string
+	Args    []string
+}
+
+// String returns the response as sent by the kuka device, without its terminator.
+func (r Response) String() string {
+	return strings.Join(append([]string{r.Command}, r.Args...), ",")
+}
+
+// DeviceReport is a snapshot of the kuka device and the EKI program running on it.
+type DeviceReport struct {
+	Name            string
+	SerialNum       string
+	RobotType       string
+	SoftwareVersion string
+	OperatingMode   string
+	// ProtocolVersion is the protocol version of the EKI program, 0 if it predates reporting it
+	ProtocolVersion int
+	MissingCommands []string
+
+	ProgramName  string
+	ProgramState ekiCommand.ProgramStatus
+	RunMode      string
+	StopMessage  bool
+
+	Joints      []float64
+	JointLimits []referenceframe.Limit
+	Pose        spatialmath.Pose
+	ToolFrame   []float64
+	BaseFrame   []float64
+}
+
+// ConnectionCheck is the result of a connectivity and latency check.
+type ConnectionCheck struct {
+	Address      string
+	Sent         int
+	Received     int
+	Latencies    []time.Duration
+	ProgramName  string
+	ProgramState ekiCommand.ProgramStatus
+}
+
+// Min returns the shortest round trip time of the requests that were answered.
+func (c ConnectionCheck) Min() time.Duration {
+	var min time.Duration
+	for i, latency := range c.Latencies {
+		if i == 0 || latency < min {
+			min = latency
+		}
+	}
+	return min
+}
+
+// Avg returns the mean round trip time of the requests that were answered.
+func (c ConnectionCheck) Avg() time.Duration {
+	if len(c.Latencies) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, latency := range c.Latencies {
+		total += latency
+	}
+	return total / time.Duration(len(c.Latencies))
+}
+
+// Max returns the longest round trip time of the requests that were answered.
+func (c ConnectionCheck) Max() time.Duration {
+	var max time.Duration
+	for _, latency := range c.Latencies {
+		if latency > max {
+			max = latency
+		}
+	}
+	return max
+}
+
+// Err returns why the arm would not come up on this connection, or nil if the check passed.
+func (c ConnectionCheck) Err() error {
+	if c.Received < c.Sent {
+		return errors.Errorf("%v of %v requests to %v went unanswered", c.Sent-c.Received, c.Sent, c.Address)
+	}
+	if c.ProgramState != ekiCommand.StatusRunning {
+		return errors.Errorf("associated program on your kuka device is %v, please get the program running before continuing",
+			c.ProgramState)
+	}
+	return nil
+}
+
+// Diagnostics is a connection to a kuka device made without a viam-server, sharing the connection and response
+// handling of the arm, to check the controller and the EKI program when an arm does not come up.
+type Diagnostics struct {
+	// AllowMotion allows commands that move the robot to be sent.
+	AllowMotion bool
+
+	kuka      *kukaArm
+	address   string
+	responses chan Response
+}
+
+// NewDiagnostics connects to the kuka device of the given config. Only the connection attributes are used, nothing is
+// sent to the kuka device until requested.
+func NewDiagnostics(ctx context.Context, conf *Config, logger logging.Logger) (*Diagnostics, error) {
+	settings := conf.ControllerSettings()
+	kuka := &kukaArm{
+		Named:  arm.Named("diagnostics").AsNamed(),
+		logger: logger,
+		tcpConn: tcpConn{
+			ipAddress:     settings.IPAddress,
+			port:          settings.Port,
+			messageFormat: settings.MessageFormat,
+			traceFile:     conf.TraceFile,
+		},
+		stopCh: make(chan stopEvent, 1),
+	}
+	kuka.resetCurrentStateAndDeviceInfo()
+
+	d := &Diagnostics{
+		kuka:      kuka,
+		address:   fmt.Sprintf("%v:%v", settings.IPAddress, settings.Port),
+		responses: make(chan Response, diagnosticsResponseBufferSize),
+	}
+	kuka.responseHook = d.record
+
+	if err := kuka.Connect(ctx); err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to %v", d.address)
+	}
+	return d, nil
+}
+
+// Address returns the address of the kuka device.
+func (d *Diagnostics) Address() string {
+	return d.address
+}
+
+// record passes a response on to Responses, dropping it if they are not being read.
+func (d *Diagnostics) record(command string, args []string) {
+	select {
+	case d.responses <- Response{Time: time.Now(), Command: command, Args: args}:
+	default:
+	}
+}
+
+// Responses returns every message received from the kuka device.
+func (d *Diagnostics) Responses() <-chan Response {
+	return d.responses
+}
+
+// Send sends a command as typed, such as "getcurrentjoints" or "setjointspeed,10", to the kuka device. The arguments of
+// commands of the registry are checked first, others are sent as typed. Responses are delivered through Responses.
+func (d *Diagnostics) Send(line string) error {
+	line = strings.TrimSuffix(strings.TrimSpace(line), ";")
+	if line == "" {
+		return errors.New("no command given")
+	}
+
+	command, args, found := strings.Cut(line, ",")
+	if descriptor, ok := ekiCommand.Lookup(command); ok {
+		if descriptor.Motion && !d.AllowMotion {
+			return errors.Errorf("%v moves the robot, motion must be allowed to send it", command)
+		}
+		var values []string
+		if found {
+			values = strings.Split(args, ",")
+		}
+		if err := descriptor.Check(values); err != nil {
+			return errors.Errorf("%v, usage: %v", err, descriptor.Usage())
+		}
+	}
+	return d.kuka.writeCommand(command, args)
+}
+
+// Report requests the device info, program state, joints, pose, limits and modes of the kuka device, waiting up to
+// timeout for every reply.
+func (d *Diagnostics) Report(ctx context.Context, timeout time.Duration) (*DeviceReport, error) {
+	reportCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := d.kuka.checkProtocol(reportCtx); err != nil {
+		return nil, err
+	}
+	queries := append(append([]string{}, deviceInfoQueries...), stateQueries...)
+	queries = append(queries, ekiCommand.GetToolData, ekiCommand.GetBaseData, ekiCommand.GetRunMode)
+	if err := d.kuka.requestAll(reportCtx, queries...); err != nil {
+		return nil, err
+	}
+	if _, err := d.kuka.checkEKIProgramState(reportCtx); err != nil {
+		return nil, errors.Wrap(err, "kuka device did not report its program state")
+	}
+
+	d.kuka.stateMutex.Lock()
+	defer d.kuka.stateMutex.Unlock()
+	info := d.kuka.deviceInfo
+	current := d.kuka.currentState
+	report := &DeviceReport{
+		Name:            info.name,
+		SerialNum:       info.serialNum,
+		RobotType:       info.robotType,
+		SoftwareVersion: info.softwareVersion,
+		OperatingMode:   info.operatingMode,
+		ProgramName:     current.programName,
+		ProgramState:    current.programState,
+		RunMode:         current.runMode,
+		StopMessage:     current.stopMessage,
+		Joints:          append([]float64{}, current.joints...),
+		JointLimits:     append([]referenceframe.Limit{}, current.jointLimits...),
+		Pose:            current.endEffectorPose,
+		ToolFrame:       append([]float64{}, current.toolFrame...),
+		BaseFrame:       append([]float64{}, current.baseFrame...),
+	}
+	if protocol := d.kuka.protocol.Load(); protocol != nil {
+		report.ProtocolVersion = protocol.version
+		report.MissingCommands = protocol.missingCommands()
+	}
+	return report, nil
+}
+
+// Check measures the time taken by the kuka device to answer count requests, each given timeout to be answered once
+// written, and checks the EKI program is running. Responses must not be read elsewhere while checking.
+func (d *Diagnostics) Check(ctx context.Context, count int, timeout time.Duration) (ConnectionCheck, error) {
+	result := ConnectionCheck{Address: d.address, ProgramState: ekiCommand.StatusUnknown}
+
+	for i := 0; i < count; i++ {
+		d.drainResponses()
+
+		if err := d.kuka.writeCommand(ekiCommand.GetRobotName, ""); err != nil {
+			return result, err
+		}
+		start := time.Now()
+		result.Sent++
+
+		answered, err := d.waitForResponse(ctx, ekiCommand.GetRobotName, timeout)
+		if err != nil {
+			return result, err
+		}
+		if answered {
+			result.Received++
+			result.Latencies = append(result.Latencies, time.Since(start))
+		}
+	}
+
+	stateCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if programState, err := d.kuka.checkEKIProgramState(stateCtx); err == nil {
+		result.ProgramState = programState
+		result.ProgramName = d.kuka.getCurrentStateSafe().programName
+	} else if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	return result, nil
+}
+
+// waitForResponse waits up to timeout for a response to the given command, returning whether it arrived.
+func (d *Diagnostics) waitForResponse(ctx context.Context, command string, timeout time.Duration) (bool, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-timer.C:
+			return false, nil
+		case resp := <-d.responses:
+			if resp.Command == command {
+				return true, nil
+			}
+		}
+	}
+}
+
+// drainResponses discards responses left over from earlier requests.
+func (d *Diagnostics) drainResponses() {
+	for {
+		select {
+		case <-d.responses:
+		default:
+			return
+		}
+	}
+}
+
+// Close closes the connection to the kuka device.
+func (d *Diagnostics) Close() error {
+	d.kuka.closed.Store(true)
+	err := d.kuka.Disconnect()
+	d.kuka.activeBackgroundWorkers.Wait()
+	return err
+}
@@ -0,0 +1,301 @@
+package kuka
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	"github.com/viam-soleng/viam-kuka/src/ekixml"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+// fakeEKIServer answers commands over TCP the way the EKI program does. Set commands without a response are answered
+// with success, other commands without a response are ignored. Commands of the registry given arguments it does not
+// describe are refused with invalidValue.
+// Motions are answered once moveTime has passed, unless stopped first, in which case only the stop is answered. Until
+// then other sync commands are refused with robotBusy.
+// With xmlMessages set before the client connects, commands and replies are framed as XML, the arguments of each
+// command being kept in xmlArgs.
+type fakeEKIServer struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu          sync.Mutex
+	conn        net.Conn
+	responses   map[string]string
+	moveTime    time.Duration
+	moveStop    chan struct{}
+	xmlMessages bool
+	xmlArgs     map[string][]string
+}
+
+func newFakeEKIServer(tb testing.TB, responses map[string]string) *fakeEKIServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	test.That(tb, err, test.ShouldBeNil)
+
+	server := &fakeEKIServer{listener: listener, responses: responses}
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		server.mu.Lock()
+		server.conn = conn
+		xmlMessages := server.xmlMessages
+		server.mu.Unlock()
+
+		if xmlMessages {
+			server.serveXML(conn)
+			return
+		}
+		reader := bufio.NewReader(conn)
+		for {
+			request, err := reader.ReadString(';')
+			if err != nil {
+				return
+			}
+			command, rest, found := strings.Cut(strings.TrimSuffix(request, ";"), ",")
+			var args []string
+			if found {
+				args = strings.Split(rest, ",")
+			}
+			server.handle(command, args)
+		}
+	}()
+	tb.Cleanup(func() {
+		listener.Close()
+		server.mu.Lock()
+		if server.conn != nil {
+			server.conn.Close()
+		}
+		if server.moveStop != nil {
+			close(server.moveStop)
+			server.moveStop = nil
+		}
+		server.mu.Unlock()
+		server.wg.Wait()
+	})
+	return server
+}
+
+func (s *fakeEKIServer) handle(command string, args []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	descriptor, known := ekiCommand.Lookup(command)
+	switch {
+	case known && descriptor.Check(args) != nil:
+		s.reply(command, ekiCommand.ResponseInvalidValue)
+	case known && descriptor.Kind == ekiCommand.Sync && command != ekiCommand.SetStop && s.moveStop != nil:
+		s.reply(command, ekiCommand.ResponseBusy)
+	case known && descriptor.Kind == ekiCommand.Sync && descriptor.Motion:
+		stop := make(chan struct{})
+		s.moveStop = stop
+		moveTime := s.moveTime
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			select {
+			case <-time.After(moveTime):
+			case <-stop:
+				return
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.moveStop == stop {
+				s.moveStop = nil
+				s.reply(command, ekiCommand.ResponseSuccess)
+			}
+		}()
+	case command == ekiCommand.SetStop:
+		if s.moveStop != nil {
+			close(s.moveStop)
+			s.moveStop = nil
+		}
+		s.reply(command, ekiCommand.ResponseSuccess)
+	default:
+		if response, ok := s.responses[command]; ok {
+			s.reply(command, response)
+		} else if strings.HasPrefix(command, "set") {
+			s.reply(command, ekiCommand.ResponseSuccess)
+		}
+	}
+}
+
+// serveXML handles the commands read as XML elements until the connection is closed.
+func (s *fakeEKIServer) serveXML(conn net.Conn) {
+	decoder := xml.NewDecoder(conn)
+	for {
+		var command ekixml.Command
+		if err := decoder.Decode(&command); err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.xmlArgs == nil {
+			s.xmlArgs = map[string][]string{}
+		}
+		s.xmlArgs[command.Name] = command.Args
+		s.mu.Unlock()
+		s.handle(command.Name, command.Args)
+	}
+}
+
+// reply sends the reply to a command, given as comma separated values, in the message format of the server.
+func (s *fakeEKIServer) reply(command, response string) {
+	if !s.xmlMessages {
+		s.send(command + "," + response + ";")
+		return
+	}
+	values := strings.Split(response, ",")
+	data, _ := xml.Marshal(ekixml.Reply{Name: command, Count: len(values), Values: values})
+	s.send(string(data))
+}
+
+// send writes data to the connected client as is, so several messages can be sent at once.
+func (s *fakeEKIServer) send(data string) {
+	if s.conn != nil {
+		s.conn.Write([]byte(data))
+	}
+}
+
+func (s *fakeEKIServer) config() *Config {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return &Config{IPAddress: addr.IP.String(), Port: addr.Port}
+}
+
+func (s *fakeEKIServer) setMoveTime(moveTime time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.moveTime = moveTime
+}
+
+func (s *fakeEKIServer) setResponse(command, response string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if response == "" {
+		delete(s.responses, command)
+		return
+	}
+	s.responses[command] = response
+}
+
+func TestDiagnostics(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	server := newFakeEKIServer(t, map[string]string{
+		ekiCommand.GetRobotName:            "KR10",
+		ekiCommand.GetRobotSerialNum:       "12345",
+		ekiCommand.GetRobotType:            "KR10 R900-2",
+		ekiCommand.GetRobotSoftwareVersion: "8.6",
+		ekiCommand.GetRobotOperatingMode:   "Extern",
+		ekiCommand.GetJointNegLimit:        "-170,-190,-120,-185,-120,-350,0,0,0,0,0,0",
+		ekiCommand.GetJointPosLimit:        "170,45,156,185,120,350,0,0,0,0,0,0",
+		ekiCommand.GetJointPosition:        "0,-90,90,0,45,0,0,0,0,0,0,0",
+		ekiCommand.GetEndPosition:          "500,0,600,0,90,0,2,35,0,0,0,0,0,0",
+		ekiCommand.GetToolData:             "0,0,100,0,0,0",
+		ekiCommand.GetBaseData:             "0,0,0,0,0,0",
+		ekiCommand.GetStopMessage:          "false",
+		ekiCommand.GetRunMode:              "GO",
+		ekiCommand.GetEKIProgramState:      "EKIMAIN,Running",
+		ekiCommand.GetProtocolInfo:         protocolInfoResponse(ekiCommand.SetBaseData),
+	})
+
+	d, err := NewDiagnostics(ctx, server.config(), logger)
+	test.That(t, err, test.ShouldBeNil)
+	defer func() { test.That(t, d.Close(), test.ShouldBeNil) }()
+
+	t.Run("report", func(t *testing.T) {
+		report, err := d.Report(ctx, time.Second)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, report.Name, test.ShouldEqual, "KR10")
+		test.That(t, report.SerialNum, test.ShouldEqual, "12345")
+		test.That(t, report.OperatingMode, test.ShouldEqual, "Extern")
+		test.That(t, report.ProgramName, test.ShouldEqual, "EKIMAIN")
+		test.That(t, report.ProgramState, test.ShouldEqual, ekiCommand.StatusRunning)
+		test.That(t, report.RunMode, test.ShouldEqual, runModeGO)
+		test.That(t, report.Joints, test.ShouldResemble, []float64{0, -90, 90, 0, 45, 0})
+		test.That(t, report.JointLimits[1].Min, test.ShouldEqual, -190)
+		test.That(t, report.JointLimits[1].Max, test.ShouldEqual, 45)
+		test.That(t, report.Pose.Point().X, test.ShouldAlmostEqual, 500)
+		test.That(t, report.ToolFrame, test.ShouldResemble, []float64{0, 0, 100, 0, 0, 0})
+		test.That(t, report.ProtocolVersion, test.ShouldEqual, ekiCommand.ProtocolVersion)
+		test.That(t, report.MissingCommands, test.ShouldResemble, []string{ekiCommand.SetBaseData})
+	})
+
+	t.Run("send", func(t *testing.T) {
+		d.drainResponses()
+		test.That(t, d.Send("getrobotname"), test.ShouldBeNil)
+		select {
+		case resp := <-d.Responses():
+			test.That(t, resp.String(), test.ShouldEqual, "getrobotname,KR10")
+		case <-time.After(time.Second):
+			t.Fatal("no response received")
+		}
+
+		err := d.Send(ekiCommand.SetJointPosition + ",0,0,0,0,0,0,0,0,0,0,0,0")
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "moves the robot")
+
+		test.That(t, d.Send(" ; "), test.ShouldNotBeNil)
+
+		err = d.Send(ekiCommand.SetJointSpeed)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "usage: setjointspeed,<speed>")
+		test.That(t, d.Send(ekiCommand.SetJointSpeed+",fast"), test.ShouldNotBeNil)
+	})
+
+	t.Run("check", func(t *testing.T) {
+		result, err := d.Check(ctx, 3, time.Second)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, result.Sent, test.ShouldEqual, 3)
+		test.That(t, result.Received, test.ShouldEqual, 3)
+		test.That(t, len(result.Latencies), test.ShouldEqual, 3)
+		test.That(t, result.Min(), test.ShouldBeLessThanOrEqualTo, result.Avg())
+		test.That(t, result.Avg(), test.ShouldBeLessThanOrEqualTo, result.Max())
+		test.That(t, result.ProgramName, test.ShouldEqual, "EKIMAIN")
+		test.That(t, result.Err(), test.ShouldBeNil)
+	})
+
+	t.Run("check program stopped", func(t *testing.T) {
+		server.setResponse(ekiCommand.GetEKIProgramState, "EKIMAIN,Stopped")
+		defer server.setResponse(ekiCommand.GetEKIProgramState, "EKIMAIN,Running")
+
+		result, err := d.Check(ctx, 1, time.Second)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, result.Err(), test.ShouldNotBeNil)
+		test.That(t, result.Err().Error(), test.ShouldContainSubstring, "program on your kuka device is Stopped")
+	})
+
+	t.Run("check unanswered", func(t *testing.T) {
+		server.setResponse(ekiCommand.GetRobotName, "")
+
+		result, err := d.Check(ctx, 2, 300*time.Millisecond)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, result.Received, test.ShouldEqual, 0)
+		test.That(t, result.Err(), test.ShouldNotBeNil)
+		test.That(t, result.Err().Error(), test.ShouldContainSubstring, "2 of 2 requests")
+	})
+}
+
+func TestDiagnosticsConnectionRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	test.That(t, err, test.ShouldBeNil)
+	addr := listener.Addr().(*net.TCPAddr)
+	test.That(t, listener.Close(), test.ShouldBeNil)
+
+	_, err = NewDiagnostics(context.Background(), &Config{IPAddress: addr.IP.String(), Port: addr.Port}, logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "unable to connect to "+addr.String())
+}
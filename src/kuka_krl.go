@@ -0,0 +1,334 @@
+package kuka
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/viam-soleng/viam-kuka/src/krl"
+	pb "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/rdk/spatialmath"
+	"go.viam.com/rdk/utils"
+)
+
+// DoCommand names for importing and exporting KRL motion programs
+const (
+	importKRLCommand = "import_krl"
+	exportKRLCommand = "export_krl"
+)
+
+// Motion types used when exporting a plan as a KRL program
+const (
+	krlMotionPTP = "ptp"
+	krlMotionLIN = "lin"
+)
+
+// krlInstructionToStep converts an instruction of a KRL program into a sequence step.
+func krlInstructionToStep(instruction krl.Instruction) (sequenceStep, error) {
+	var step sequenceStep
+	switch instruction.Kind {
+	case krl.KindPTP:
+		if instruction.Axis != nil {
+			step = sequenceStep{Type: stepMoveJoints, Joints: instruction.Axis.Joints[:]}
+			break
+		}
+		step = sequenceStep{Type: stepMoveCartesian, Pose: krlFrameToSlice(instruction.Pos.Frame)}
+		if instruction.Pos.HasST {
+			status, turn := instruction.Pos.S, instruction.Pos.T
+			step.Status, step.Turn = &status, &turn
+		}
+	case krl.KindLIN:
+		step = sequenceStep{Type: stepMoveLinear, Pose: krlFrameToSlice(instruction.Pos.Frame)}
+	case krl.KindWait:
+		step = sequenceStep{Type: stepWait, Seconds: instruction.Value}
+	case krl.KindJointSpeed:
+		step = sequenceStep{Type: stepSetJointSpeed, Value: instruction.Value}
+	case krl.KindCartSpeed:
+		step = sequenceStep{Type: stepSetCartSpeed, Value: instruction.Value}
+	case krl.KindOverride:
+		step = sequenceStep{Type: stepSetOverride, Value: instruction.Value}
+	case krl.KindTool:
+		step = sequenceStep{Type: stepSetTool, Frame: krlFrameToSlice(*instruction.Frame)}
+	case krl.KindBase:
+		step = sequenceStep{Type: stepSetBase, Frame: krlFrameToSlice(*instruction.Frame)}
+	default:
+		return sequenceStep{}, errors.Errorf("unsupported instruction %v", instruction.Kind)
+	}
+	return step, step.validate()
+}
+
+// krlProgramToSteps converts a parsed KRL program into a list of validated sequence steps.
+func krlProgramToSteps(prog *krl.Program) ([]sequenceStep, error) {
+	steps := make([]sequenceStep, 0, len(prog.Instructions))
+	for _, instruction := range prog.Instructions {
+		step, err := krlInstructionToStep(instruction)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %v", instruction.Line)
+		}
+		steps = append(steps, step)
+	}
+	if len(steps) == 0 {
+		return nil, errors.Errorf("program %v has no executable instructions", prog.Name)
+	}
+	return steps, nil
+}
+
+func krlFrameToSlice(frame krl.Frame) []float64 {
+	return []float64{frame.X, frame.Y, frame.Z, frame.A, frame.B, frame.C}
+}
+
+// readKRLArg returns the KRL source given by key, either directly or read from the file given by key + "_path".
+func readKRLArg(cmd map[string]interface{}, key string) (string, error) {
+	if content, ok := cmd[key].(string); ok {
+		return content, nil
+	}
+	path, ok := cmd[key+"_path"].(string)
+	if !ok {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to read %v", path)
+	}
+	return string(data), nil
+}
+
+// importKRL parses a KRL program (src) with its point declarations (dat, plus an optional config_dat such as
+// $config.dat) into sequence steps, returning the steps and optionally running them when run is true.
+func (kuka *kukaArm) importKRL(cmd map[string]interface{}) (map[string]interface{}, error) {
+	src, err := readKRLArg(cmd, "src")
+	if err != nil {
+		return nil, err
+	}
+	if src == "" {
+		return nil, errors.New("src or src_path must be given")
+	}
+
+	dat, err := readKRLArg(cmd, "dat")
+	if err != nil {
+		return nil, err
+	}
+	// Default to the .dat file next to the program
+	if srcPath, ok := cmd["src_path"].(string); ok && dat == "" {
+		datPath := strings.TrimSuffix(srcPath, filepath.Ext(srcPath)) + ".dat"
+		if data, err := os.ReadFile(datPath); err == nil {
+			dat = string(data)
+		}
+	}
+	configDat, err := readKRLArg(cmd, "config_dat")
+	if err != nil {
+		return nil, err
+	}
+
+	prog, err := krl.Parse(src, dat, configDat)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse KRL program")
+	}
+	steps, err := krlProgramToSteps(prog)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to convert KRL program %v", prog.Name)
+	}
+	for _, warning := range prog.Warnings {
+		kuka.logger.Warnf("KRL program %v: %v", prog.Name, warning)
+	}
+
+	resp, err := toResponseMap(map[string]interface{}{
+		"name":     prog.Name,
+		"steps":    steps,
+		"warnings": prog.Warnings,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if run, _ := cmd["run"].(bool); run {
+		status, err := kuka.startSequence(steps)
+		if err != nil {
+			return nil, err
+		}
+		resp["sequence"] = status
+	}
+	return resp, nil
+}
+
+// krlExportOptions are the motion type, tool, base and speeds used by an exported KRL program. Tool and base are
+// kuka frames (x,y,z,a,b,c) and are only set by the program when given.
+type krlExportOptions struct {
+	Motion     string    `json:"motion"`
+	Tool       []float64 `json:"tool"`
+	Base       []float64 `json:"base"`
+	JointSpeed float64   `json:"joint_speed"`
+	CartSpeed  float64   `json:"cartesian_speed"`
+}
+
+// planToKRLProgram converts a plan of joint inputs, such as those given to GoToInputs, into a KRL program. PTP
+// motions move to the joint positions directly while LIN motions move to the pose of the tool in the base, computed
+// from the kinematic model, after a PTP to the first waypoint.
+func (kuka *kukaArm) planToKRLProgram(name string, plan [][]referenceframe.Input, opts krlExportOptions) (*krl.Program, error) {
+	if len(plan) == 0 {
+		return nil, errors.New("plan has no waypoints")
+	}
+	if kuka.model == nil {
+		return nil, errors.New("no kinematic model loaded, unable to export plan")
+	}
+	if opts.Motion == "" {
+		opts.Motion = krlMotionPTP
+	}
+	if opts.Motion != krlMotionPTP && opts.Motion != krlMotionLIN {
+		return nil, errors.Errorf("motion (%v) must be %q or %q", opts.Motion, krlMotionPTP, krlMotionLIN)
+	}
+
+	prog := &krl.Program{Name: name}
+	toolPose, basePose := spatialmath.NewZeroPose(), spatialmath.NewZeroPose()
+	if opts.Tool != nil {
+		frame, err := sliceToKRLFrame(opts.Tool)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid tool")
+		}
+		toolPose = kukaFrameToPose(opts.Tool)
+		prog.Instructions = append(prog.Instructions, krl.Instruction{Kind: krl.KindTool, Frame: frame})
+	}
+	if opts.Base != nil {
+		frame, err := sliceToKRLFrame(opts.Base)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid base")
+		}
+		basePose = kukaFrameToPose(opts.Base)
+		prog.Instructions = append(prog.Instructions, krl.Instruction{Kind: krl.KindBase, Frame: frame})
+	}
+	if opts.JointSpeed != 0 {
+		prog.Instructions = append(prog.Instructions, krl.Instruction{Kind: krl.KindJointSpeed, Value: opts.JointSpeed})
+	}
+	if opts.CartSpeed != 0 {
+		prog.Instructions = append(prog.Instructions, krl.Instruction{Kind: krl.KindCartSpeed, Value: opts.CartSpeed})
+	}
+
+	for i, inputs := range plan {
+		joints := kuka.model.ProtobufFromInput(inputs).Values
+		if len(joints) != numJoints {
+			return nil, errors.Errorf("waypoint %v has %v joint values (should be %v)", i+1, len(joints), numJoints)
+		}
+
+		// The first motion of a stand-alone program is a PTP so the controller can safely reach the start of the plan
+		if opts.Motion == krlMotionPTP || i == 0 {
+			axis := &krl.Axis{}
+			copy(axis.Joints[:], joints)
+			prog.Instructions = append(prog.Instructions, krl.Instruction{Kind: krl.KindPTP, Axis: axis})
+			continue
+		}
+
+		flangePose, err := kuka.model.Transform(inputs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to compute pose of waypoint %v", i+1)
+		}
+		pose := spatialmath.Compose(spatialmath.PoseInverse(basePose), spatialmath.Compose(flangePose, toolPose))
+		prog.Instructions = append(prog.Instructions, krl.Instruction{Kind: krl.KindLIN, Pos: &krl.Pos{Frame: poseToKRLFrame(pose)}})
+	}
+
+	for _, instruction := range prog.Instructions {
+		if _, err := krlInstructionToStep(instruction); err != nil {
+			return nil, errors.Wrap(err, "invalid export")
+		}
+	}
+	return prog, nil
+}
+
+// exportKRL converts a plan of joint positions (degrees) into a KRL .src/.dat pair, returning their contents and
+// optionally writing them to output_dir in the module data directory. The tool, base and joint speed default to those
+// currently set on the arm.
+func (kuka *kukaArm) exportKRL(cmd map[string]interface{}) (map[string]interface{}, error) {
+	name, err := getStringArg(cmd, "name")
+	if err != nil {
+		return nil, err
+	}
+	if kuka.model == nil {
+		return nil, errors.New("no kinematic model loaded, unable to export plan")
+	}
+
+	currentState := kuka.getCurrentStateSafe()
+	opts := krlExportOptions{
+		Tool:       currentState.toolFrame,
+		Base:       currentState.baseFrame,
+		JointSpeed: currentState.jointSpeed,
+	}
+	req := struct {
+		krlExportOptions
+		Plan [][]float64 `json:"plan"`
+	}{krlExportOptions: opts}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, errors.Wrap(err, "unable to parse export request")
+	}
+
+	plan := make([][]referenceframe.Input, 0, len(req.Plan))
+	for _, joints := range req.Plan {
+		plan = append(plan, kuka.model.InputFromProtobuf(&pb.JointPositions{Values: joints}))
+	}
+
+	prog, err := kuka.planToKRLProgram(name, plan, req.krlExportOptions)
+	if err != nil {
+		return nil, err
+	}
+	src, dat, err := krl.Format(prog)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := map[string]interface{}{"name": name, "src": src, "dat": dat}
+	if outputDir, ok := cmd["output_dir"].(string); ok {
+		outputDir, err := kuka.krlOutputDir(outputDir)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(outputDir, 0o750); err != nil {
+			return nil, err
+		}
+		srcPath := filepath.Join(outputDir, name+".src")
+		datPath := filepath.Join(outputDir, name+".dat")
+		if err := os.WriteFile(srcPath, []byte(src), 0o640); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(datPath, []byte(dat), 0o640); err != nil {
+			return nil, err
+		}
+		resp["src_path"], resp["dat_path"] = srcPath, datPath
+	}
+	return resp, nil
+}
+
+// krlOutputDir returns the directory exported programs are written to for the given output_dir, which must be a relative
+// path within the module data directory.
+func (kuka *kukaArm) krlOutputDir(outputDir string) (string, error) {
+	if !filepath.IsLocal(outputDir) {
+		return "", errors.Errorf("output_dir (%q) must be a relative path within the module data directory", outputDir)
+	}
+
+	dataDir := os.Getenv(moduleDataEnvVar)
+	if dataDir == "" {
+		kuka.logger.Warnf("%v is not set, exporting KRL programs to the working directory", moduleDataEnvVar)
+	}
+	return filepath.Join(dataDir, outputDir), nil
+}
+
+func sliceToKRLFrame(frame []float64) (*krl.Frame, error) {
+	if len(frame) != 6 {
+		return nil, errors.Errorf("frame must have 6 values (x,y,z,a,b,c), %v given", len(frame))
+	}
+	return &krl.Frame{X: frame[0], Y: frame[1], Z: frame[2], A: frame[3], B: frame[4], C: frame[5]}, nil
+}
+
+// poseToKRLFrame converts a pose into a kuka frame.
+func poseToKRLFrame(pose spatialmath.Pose) krl.Frame {
+	pt := pose.Point()
+	angles := pose.Orientation().EulerAngles()
+	return krl.Frame{
+		X: pt.X, Y: pt.Y, Z: pt.Z,
+		A: utils.RadToDeg(angles.Yaw), B: utils.RadToDeg(angles.Pitch), C: utils.RadToDeg(angles.Roll),
+	}
+}
@@ -0,0 +1,221 @@
+package kuka
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/viam-soleng/viam-kuka/inject"
+	"github.com/viam-soleng/viam-kuka/src/krl"
+	pb "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/rdk/referenceframe/urdf"
+	"go.viam.com/rdk/spatialmath"
+	"go.viam.com/test"
+	"go.viam.com/utils/testutils"
+)
+
+const testKRLSrc = `DEF demo( )
+BAS(#VEL_PTP, 25)
+PTP XP1
+$BASE = {X 100,Y 0,Z 0,A 0,B 0,C 0}
+LIN XP2 C_DIS
+WAIT SEC 0.5
+END
+`
+
+const testKRLDat = `DEFDAT demo
+DECL E6AXIS XP1={A1 0,A2 -90,A3 90,A4 0,A5 0,A6 0}
+DECL E6POS XP2={X 500,Y 0,Z 600,A 0,B 90,C 0,S 2,T 10}
+ENDDAT
+`
+
+func TestImportKRL(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	kuka := &kukaArm{
+		logger:     logger,
+		stateMutex: sync.Mutex{},
+	}
+
+	conn := inject.NewTCPConn()
+	conn.WriteFunc = func(b []byte) (n int, err error) {
+		return len(b), nil
+	}
+	useInjectedConn(t, kuka, conn)
+
+	t.Run("missing src", func(t *testing.T) {
+		_, err := kuka.DoCommand(ctx, map[string]interface{}{"command": importKRLCommand})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "src or src_path")
+	})
+
+	t.Run("unresolved point", func(t *testing.T) {
+		_, err := kuka.DoCommand(ctx, map[string]interface{}{"command": importKRLCommand, "src": testKRLSrc})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "unresolved point XP1")
+	})
+
+	t.Run("from files", func(t *testing.T) {
+		dir := t.TempDir()
+		srcPath := filepath.Join(dir, "demo.src")
+		test.That(t, os.WriteFile(srcPath, []byte(testKRLSrc), 0o600), test.ShouldBeNil)
+		test.That(t, os.WriteFile(filepath.Join(dir, "demo.dat"), []byte(testKRLDat), 0o600), test.ShouldBeNil)
+
+		resp, err := kuka.DoCommand(ctx, map[string]interface{}{"command": importKRLCommand, "src_path": srcPath})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["name"], test.ShouldEqual, "demo")
+		test.That(t, resp["sequence"], test.ShouldBeNil)
+
+		steps := resp["steps"].([]interface{})
+		test.That(t, len(steps), test.ShouldEqual, 5)
+		test.That(t, steps[0], test.ShouldResemble, map[string]interface{}{"type": stepSetJointSpeed, "value": 25.0})
+		test.That(t, steps[1].(map[string]interface{})["joints"], test.ShouldResemble, []interface{}{0.0, -90.0, 90.0, 0.0, 0.0, 0.0})
+		test.That(t, steps[2].(map[string]interface{})["type"], test.ShouldEqual, stepSetBase)
+		test.That(t, steps[3], test.ShouldResemble, map[string]interface{}{
+			"type": stepMoveLinear,
+			"pose": []interface{}{500.0, 0.0, 600.0, 0.0, 90.0, 0.0},
+		})
+		test.That(t, steps[4], test.ShouldResemble, map[string]interface{}{"type": stepWait, "seconds": 0.5})
+	})
+
+	t.Run("invalid step", func(t *testing.T) {
+		_, err := kuka.DoCommand(ctx, map[string]interface{}{
+			"command": importKRLCommand,
+			"src":     "DEF fast()\nBAS(#VEL_PTP, 150)\nEND",
+		})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "line 2")
+	})
+
+	t.Run("run", func(t *testing.T) {
+		resp, err := kuka.DoCommand(ctx, map[string]interface{}{
+			"command": importKRLCommand,
+			"src":     "DEF wait()\nWAIT SEC 0.01\nEND",
+			"run":     true,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["sequence"].(map[string]interface{})["total"], test.ShouldEqual, 1)
+
+		// Let the sequence finish before the test ends
+		testutils.WaitForAssertion(t, func(tb testing.TB) {
+			status, err := kuka.DoCommand(ctx, map[string]interface{}{"command": sequenceStatusCommand})
+			test.That(tb, err, test.ShouldBeNil)
+			test.That(tb, status["state"], test.ShouldEqual, string(sequenceCompleted))
+		})
+	})
+}
+
+func TestExportKRL(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	urdfModel, err := urdf.ParseModelXMLFile(resolveFile(fmt.Sprintf("src/models/%v_model.urdf", kr10r900)), "test")
+	test.That(t, err, test.ShouldBeNil)
+
+	kuka := &kukaArm{
+		logger:       logger,
+		stateMutex:   sync.Mutex{},
+		model:        urdfModel,
+		currentState: state{jointSpeed: 20},
+	}
+
+	plan := []interface{}{
+		[]interface{}{0, -90, 90, 0, 45, 0},
+		[]interface{}{10, -80, 85, 0, 40, 5},
+		[]interface{}{20, -70, 80, 0, 35, 10},
+	}
+
+	t.Run("ptp round trip", func(t *testing.T) {
+		dataDir := t.TempDir()
+		t.Setenv(moduleDataEnvVar, dataDir)
+		resp, err := kuka.DoCommand(ctx, map[string]interface{}{
+			"command":    exportKRLCommand,
+			"name":       "viam_plan",
+			"plan":       plan,
+			"tool":       []interface{}{0, 0, 100, 0, 0, 0},
+			"output_dir": "programs",
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["src_path"], test.ShouldEqual, filepath.Join(dataDir, "programs", "viam_plan.src"))
+
+		imported, err := kuka.DoCommand(ctx, map[string]interface{}{"command": importKRLCommand, "src_path": resp["src_path"]})
+		test.That(t, err, test.ShouldBeNil)
+		steps := imported["steps"].([]interface{})
+		test.That(t, len(steps), test.ShouldEqual, 5)
+		test.That(t, steps[0], test.ShouldResemble, map[string]interface{}{
+			"type":  stepSetTool,
+			"frame": []interface{}{0.0, 0.0, 100.0, 0.0, 0.0, 0.0},
+		})
+		test.That(t, steps[1], test.ShouldResemble, map[string]interface{}{"type": stepSetJointSpeed, "value": 20.0})
+		test.That(t, steps[4], test.ShouldResemble, map[string]interface{}{
+			"type":   stepMoveJoints,
+			"joints": []interface{}{20.0, -70.0, 80.0, 0.0, 35.0, 10.0},
+		})
+	})
+
+	t.Run("output dir outside the module data directory", func(t *testing.T) {
+		t.Setenv(moduleDataEnvVar, t.TempDir())
+		for _, outputDir := range []string{t.TempDir(), "../programs", "programs/../../programs"} {
+			_, err := kuka.DoCommand(ctx, map[string]interface{}{
+				"command":    exportKRLCommand,
+				"name":       "viam_plan",
+				"plan":       plan,
+				"output_dir": outputDir,
+			})
+			test.That(t, err, test.ShouldNotBeNil)
+			test.That(t, err.Error(), test.ShouldContainSubstring, "module data directory")
+		}
+	})
+
+	t.Run("lin", func(t *testing.T) {
+		tool := []float64{0, 0, 100, 0, 0, 0}
+		base := []float64{500, 0, 0, 90, 0, 0}
+		inputs := make([][]referenceframe.Input, 0, len(plan))
+		for _, joints := range [][]float64{{0, -90, 90, 0, 45, 0}, {10, -80, 85, 0, 40, 5}} {
+			inputs = append(inputs, urdfModel.InputFromProtobuf(&pb.JointPositions{Values: joints}))
+		}
+
+		prog, err := kuka.planToKRLProgram("viam_lin", inputs, krlExportOptions{Motion: krlMotionLIN, Tool: tool, Base: base})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(prog.Instructions), test.ShouldEqual, 4)
+		test.That(t, prog.Instructions[2].Kind, test.ShouldEqual, krl.KindPTP)
+
+		lin := prog.Instructions[3]
+		test.That(t, lin.Kind, test.ShouldEqual, krl.KindLIN)
+
+		// The target is the tool pose expressed in the base
+		flangePose, err := urdfModel.Transform(inputs[1])
+		test.That(t, err, test.ShouldBeNil)
+		expected := spatialmath.Compose(flangePose, kukaFrameToPose(tool))
+		actual := spatialmath.Compose(kukaFrameToPose(base), kukaFrameToPose(krlFrameToSlice(lin.Pos.Frame)))
+		test.That(t, spatialmath.PoseAlmostEqual(expected, actual), test.ShouldBeTrue)
+
+		src, dat, err := krl.Format(prog)
+		test.That(t, err, test.ShouldBeNil)
+		parsed, err := krl.Parse(src, dat)
+		test.That(t, err, test.ShouldBeNil)
+		steps, err := krlProgramToSteps(parsed)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, steps[3].Type, test.ShouldEqual, stepMoveLinear)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := kuka.DoCommand(ctx, map[string]interface{}{"command": exportKRLCommand, "name": "p", "plan": []interface{}{}})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "no waypoints")
+
+		_, err = kuka.DoCommand(ctx, map[string]interface{}{"command": exportKRLCommand, "name": "p", "plan": plan, "motion": "circ"})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "motion (circ)")
+
+		_, err = kuka.DoCommand(ctx, map[string]interface{}{"command": exportKRLCommand, "name": "p", "plan": plan, "joint_speed": 200})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "invalid export")
+	})
+}
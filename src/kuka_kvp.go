@@ -0,0 +1,401 @@
+package kuka
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	pb "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/rdk/services/motion"
+	"go.viam.com/rdk/spatialmath"
+	"go.viam.com/utils"
+
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	"github.com/viam-soleng/viam-kuka/src/krl"
+	"github.com/viam-soleng/viam-kuka/src/kvp"
+)
+
+// Variables read and written through KukaVarProxy. The viam* globals are declared in viamKvp.dat and used by the
+// viamKvp program to run the moves requested by the arm.
+const (
+	kvpAxisActVar    = "$AXIS_ACT"
+	kvpPosActVar     = "$POS_ACT"
+	kvpOverrideVar   = "$OV_PRO"
+	kvpModeOpVar     = "$MODE_OP"
+	kvpProStateVar   = "$PRO_STATE1"
+	kvpMoveIDVar     = "viamMoveId"
+	kvpDoneIDVar     = "viamDoneId"
+	kvpJointVelVar   = "viamJointVel"
+	kvpStopVar       = "viamStop"
+	kvpTargetAxisVar = "viamTargetAxis"
+
+	// kvpMovePollInterval is how often the end of a move is checked for
+	kvpMovePollInterval = 20 * time.Millisecond
+)
+
+// DoCommand names of the kvp arm
+const (
+	readVariableCommand  = "read_variable"
+	writeVariableCommand = "write_variable"
+	getOverrideCommand   = "get_override"
+)
+
+// variableClient reads and writes the variables of the kuka device as KRL literals, e.g. "TRUE", "42" or
+// "{E6AXIS: A1 0.0, ...}". Write returns the value the device reports back.
+type variableClient interface {
+	Read(name string) (string, error)
+	Write(name, value string) (string, error)
+	Close() error
+}
+
+// kvpArm is an arm reading and writing the variables of the kuka device, through KukaVarProxy or KUKA.OPC UA, rather
+// than talking to the EKI program. Moves are run by the viamKvp program, which must be running on the kuka device.
+type kvpArm struct {
+	resource.Named
+	resource.AlwaysRebuild
+	logger logging.Logger
+
+	model            referenceframe.Model
+	client           variableClient
+	protocol         string
+	allowRawCommands bool
+
+	// moveMutex serializes moves, moveID being the id of the last one requested. moveID is only written while holding
+	// moveMutex, and is read without it to tell if that move is done.
+	moveMutex sync.Mutex
+	moveID    atomic.Int64
+}
+
+// newKVPArm creates an arm connected to KukaVarProxy on the kuka device.
+func newKVPArm(ctx context.Context, conf resource.Config, newConf *Config, logger logging.Logger) (*kvpArm, error) {
+	port := newConf.Port
+	if port == 0 {
+		port = kvp.DefaultPort
+	}
+	client, err := kvp.Dial(ctx, net.JoinHostPort(newConf.IPAddress, strconv.Itoa(port)), variableTimeout(newConf))
+	if err != nil {
+		return nil, err
+	}
+	a, err := newVariableArm(conf, newConf, logger, client, protocolKVP)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("connected to KukaVarProxy on %v:%v", newConf.IPAddress, port)
+	return a, nil
+}
+
+// variableTimeout returns how long each read and write of a variable may take.
+func variableTimeout(newConf *Config) time.Duration {
+	if newConf.CommandTimeoutMs > 0 {
+		return time.Duration(newConf.CommandTimeoutMs) * time.Millisecond
+	}
+	return replyTimeout
+}
+
+// newVariableArm creates an arm using the given client, closing it if the arm cannot be created.
+func newVariableArm(
+	conf resource.Config, newConf *Config, logger logging.Logger, client variableClient, protocol string,
+) (*kvpArm, error) {
+	model, err := loadModel(newConf.Model, conf.ResourceName().ShortName(), logger)
+	if err != nil {
+		utils.UncheckedError(client.Close())
+		return nil, err
+	}
+
+	a := &kvpArm{
+		Named:            conf.ResourceName().AsNamed(),
+		logger:           logger,
+		model:            model,
+		client:           client,
+		protocol:         protocol,
+		allowRawCommands: newConf.AllowRawCommands,
+	}
+
+	// Moves continue from the id of the last one done, which survives the module restarting
+	doneID, err := a.readInt(kvpDoneIDVar)
+	if err != nil {
+		utils.UncheckedError(client.Close())
+		return nil, errors.Wrap(err, "failed to read the viamKvp globals, is viamKvp.dat on the kuka device")
+	}
+	a.moveID.Store(int64(doneID))
+	jointSpeed := newConf.JointSpeed
+	if jointSpeed == 0 {
+		jointSpeed = defaultJointSpeed
+	}
+	if err := a.setJointSpeed(jointSpeed); err != nil {
+		utils.UncheckedError(client.Close())
+		return nil, err
+	}
+	return a, nil
+}
+
+// Close closes the connection to the kuka device.
+func (a *kvpArm) Close(ctx context.Context) error {
+	return a.client.Close()
+}
+
+// readInt reads an INT variable.
+func (a *kvpArm) readInt(name string) (int, error) {
+	value, err := a.client.Read(name)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, errors.Errorf("%v (%q) is not an integer", name, value)
+	}
+	return i, nil
+}
+
+// setJointSpeed sets the speed, in percent of the maximum, of the joints in the moves that follow.
+func (a *kvpArm) setJointSpeed(speed float64) error {
+	if speed <= 0 || speed > 100 {
+		return errors.Errorf("joint speed (%v) must be in the range (0, 100]", speed)
+	}
+	_, err := a.client.Write(kvpJointVelVar, strconv.FormatFloat(speed, 'f', -1, 64))
+	return err
+}
+
+// JointPositions returns the axis positions read from $AXIS_ACT.
+func (a *kvpArm) JointPositions(ctx context.Context, extra map[string]interface{}) (*pb.JointPositions, error) {
+	value, err := a.client.Read(kvpAxisActVar)
+	if err != nil {
+		return nil, err
+	}
+	axis, err := krl.ParseAxis(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", kvpAxisActVar)
+	}
+	return &pb.JointPositions{Values: axis.Joints[:]}, nil
+}
+
+// EndPosition returns the cartesian position read from $POS_ACT.
+func (a *kvpArm) EndPosition(ctx context.Context, extra map[string]interface{}) (spatialmath.Pose, error) {
+	value, err := a.client.Read(kvpPosActVar)
+	if err != nil {
+		return nil, err
+	}
+	pos, err := krl.ParsePos(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %v", kvpPosActVar)
+	}
+	return kukaFrameToPose(krlFrameToSlice(pos.Frame)), nil
+}
+
+// MoveToPosition moves the arm to the given pose through motion planning.
+func (a *kvpArm) MoveToPosition(ctx context.Context, pose spatialmath.Pose, extra map[string]interface{}) error {
+	return motion.MoveArm(ctx, a.logger, a, pose)
+}
+
+// MoveToJointPositions has the viamKvp program move the axes to the given positions, waiting for the move to end.
+func (a *kvpArm) MoveToJointPositions(ctx context.Context, positionDegs *pb.JointPositions, extra map[string]interface{}) error {
+	joints := positionDegs.Values
+	if err := checkModelJointLimits(a.model, joints); err != nil {
+		return err
+	}
+
+	a.moveMutex.Lock()
+	defer a.moveMutex.Unlock()
+
+	target := &krl.Axis{}
+	copy(target.Joints[:], joints)
+	if _, err := a.client.Write(kvpTargetAxisVar, krl.FormatAxis(target)); err != nil {
+		return err
+	}
+	// The new id is written last, starting the move
+	id := int(a.moveID.Load()) + 1
+	if _, err := a.client.Write(kvpMoveIDVar, strconv.Itoa(id)); err != nil {
+		return err
+	}
+	a.moveID.Store(int64(id))
+	return a.waitForMove(ctx, id)
+}
+
+// waitForMove waits for the viamKvp program to report the move with the given id done, stopping the robot if ctx is
+// done first.
+func (a *kvpArm) waitForMove(ctx context.Context, id int) error {
+	ticker := time.NewTicker(kvpMovePollInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(motionTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := a.Stop(context.Background(), nil); err != nil {
+				a.logger.Warnf("failed to stop move %v: %v", id, err)
+			}
+			return ctx.Err()
+		case <-timeout.C:
+			return errors.Errorf("move %v was not done by the viamKvp program within %v, is it running", id, motionTimeout)
+		case <-ticker.C:
+		}
+
+		doneID, err := a.readInt(kvpDoneIDVar)
+		if err != nil {
+			return err
+		}
+		if doneID == id {
+			return nil
+		}
+	}
+}
+
+// IsMoving returns whether the viamKvp program has yet to finish the last move requested by the arm. Only viamDoneId
+// is read, as reading viamMoveId separately could see a move started or finished in between the two reads.
+func (a *kvpArm) IsMoving(ctx context.Context) (bool, error) {
+	doneID, err := a.readInt(kvpDoneIDVar)
+	if err != nil {
+		return false, err
+	}
+	return int64(doneID) != a.moveID.Load(), nil
+}
+
+// Stop has the viamKvp program brake and end the move in progress.
+func (a *kvpArm) Stop(ctx context.Context, extra map[string]interface{}) error {
+	_, err := a.client.Write(kvpStopVar, "TRUE")
+	return err
+}
+
+// ModelFrame returns the model of the arm.
+func (a *kvpArm) ModelFrame() referenceframe.Model {
+	return a.model
+}
+
+// CurrentInputs returns the current joint positions in the form of Inputs.
+func (a *kvpArm) CurrentInputs(ctx context.Context) ([]referenceframe.Input, error) {
+	joints, err := a.JointPositions(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return a.model.InputFromProtobuf(joints), nil
+}
+
+// GoToInputs moves through the given inputSteps using sequential calls to MoveToJointPositions.
+func (a *kvpArm) GoToInputs(ctx context.Context, inputSteps ...[]referenceframe.Input) error {
+	for _, goal := range inputSteps {
+		if err := a.MoveToJointPositions(ctx, a.model.ProtobufFromInput(goal), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Geometries returns the geometries of the arm at its current joint positions.
+func (a *kvpArm) Geometries(ctx context.Context, extra map[string]interface{}) ([]spatialmath.Geometry, error) {
+	inputs, err := a.CurrentInputs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	geometries, err := a.model.Geometries(inputs)
+	if err != nil {
+		return nil, err
+	}
+	return geometries.Geometries(), nil
+}
+
+// DoCommand reads any variable of the kuka device, gets the operating mode and program state, gets and sets the
+// override, sets the joint speed, and writes any variable if allow_raw_commands is set.
+func (a *kvpArm) DoCommand(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	switch name := cmd["command"]; name {
+	case readVariableCommand:
+		variable, err := getStringArg(cmd, "variable")
+		if err != nil {
+			return nil, err
+		}
+		value, err := a.client.Read(variable)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"variable": variable, "value": value}, nil
+	case writeVariableCommand:
+		if !a.allowRawCommands {
+			return nil, errors.Errorf("%v requires allow_raw_commands to be set", writeVariableCommand)
+		}
+		variable, err := getStringArg(cmd, "variable")
+		if err != nil {
+			return nil, err
+		}
+		value, err := getStringArg(cmd, "value")
+		if err != nil {
+			return nil, err
+		}
+		if value, err = a.client.Write(variable, value); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"variable": variable, "value": value}, nil
+	case getOverrideCommand:
+		override, err := a.readInt(kvpOverrideVar)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"value": override}, nil
+	case getOperatingModeCommand:
+		value, err := a.client.Read(kvpModeOpVar)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"operating_mode": parseModeOp(value)}, nil
+	case programStatusCommand:
+		value, err := a.client.Read(kvpProStateVar)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"state": parseProState(value).String()}, nil
+	case setJointSpeedCommand, setOverrideCommand:
+		value, err := getFloatArg(cmd, "value")
+		if err != nil {
+			return nil, err
+		}
+		if name == setJointSpeedCommand {
+			err = a.setJointSpeed(value)
+		} else {
+			err = a.setOverride(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"value": value}, nil
+	default:
+		return nil, errors.Errorf("unknown command (%v) given, %v are available with protocol %v", name,
+			[]string{
+				readVariableCommand, writeVariableCommand, getOperatingModeCommand, programStatusCommand, getOverrideCommand,
+				setJointSpeedCommand, setOverrideCommand,
+			},
+			a.protocol)
+	}
+}
+
+// parseProState converts the state of the submit interpreter's program read from $PRO_STATE1, e.g. "#P_ACTIVE".
+func parseProState(value string) ekiCommand.ProgramStatus {
+	switch strings.TrimSpace(value) {
+	case "#P_FREE":
+		return ekiCommand.StatusFree
+	case "#P_RESET":
+		return ekiCommand.StatusReset
+	case "#P_ACTIVE":
+		return ekiCommand.StatusRunning
+	case "#P_STOP":
+		return ekiCommand.StatusStopped
+	case "#P_END":
+		return ekiCommand.StatusEnded
+	default:
+		return ekiCommand.StatusUnknown
+	}
+}
+
+// setOverride sets the program override, in percent.
+func (a *kvpArm) setOverride(override float64) error {
+	if override < 0 || override > 100 || override != float64(int(override)) {
+		return errors.Errorf("override (%v) must be a whole number in the range [0, 100]", override)
+	}
+	_, err := a.client.Write(kvpOverrideVar, strconv.Itoa(int(override)))
+	return err
+}
@@ -0,0 +1,205 @@
+package kuka
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/test"
+
+	"github.com/viam-soleng/viam-kuka/src/kvp"
+)
+
+const testAxisAct = "{E6AXIS: A1 0.0, A2 -90.0, A3 90.0, A4 0.0, A5 45.0, A6 0.0, E1 0.0, E2 0.0, E3 0.0, E4 0.0, " +
+	"E5 0.0, E6 0.0}"
+
+// newFakeKVPController returns a fake KukaVarProxy running the viamKvp program, which finishes each move after a short
+// time unless hold is set, and ends it when stopped.
+func newFakeKVPController(t *testing.T, hold *atomic.Bool) *kvp.FakeServer {
+	server, err := kvp.NewFakeServer(map[string]string{
+		kvpAxisActVar:    testAxisAct,
+		kvpPosActVar:     "{E6POS: X 500.0, Y 0.0, Z 600.0, A 0.0, B 45.0, C 0.0, S 2, T 35, E1 0.0}",
+		kvpOverrideVar:   "100",
+		kvpMoveIDVar:     "7",
+		kvpDoneIDVar:     "7",
+		kvpJointVelVar:   "10.0",
+		kvpStopVar:       "FALSE",
+		kvpTargetAxisVar: testAxisAct,
+	})
+	test.That(t, err, test.ShouldBeNil)
+	t.Cleanup(func() { test.That(t, server.Close(), test.ShouldBeNil) })
+
+	server.OnWrite(func(name, value string) {
+		switch name {
+		case kvpMoveIDVar:
+			server.Set(kvpStopVar, "FALSE")
+			time.Sleep(50 * time.Millisecond)
+			if hold.Load() {
+				return
+			}
+			target, _ := server.Get(kvpTargetAxisVar)
+			server.Set(kvpAxisActVar, target)
+			server.Set(kvpDoneIDVar, value)
+		case kvpStopVar:
+			moveID, _ := server.Get(kvpMoveIDVar)
+			server.Set(kvpDoneIDVar, moveID)
+		}
+	})
+	return server
+}
+
+func newTestKVPArm(t *testing.T, server *kvp.FakeServer, allowRawCommands bool) *kvpArm {
+	host, port, err := net.SplitHostPort(server.Addr())
+	test.That(t, err, test.ShouldBeNil)
+	portNum, err := strconv.Atoi(port)
+	test.That(t, err, test.ShouldBeNil)
+
+	conf := resource.Config{Name: "arm"}
+	newConf := &Config{IPAddress: host, Port: portNum, Protocol: protocolKVP, JointSpeed: 20, AllowRawCommands: allowRawCommands}
+	a, err := newKVPArm(context.Background(), conf, newConf, logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	t.Cleanup(func() { test.That(t, a.Close(context.Background()), test.ShouldBeNil) })
+	return a
+}
+
+func TestKVPArm(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("state", func(t *testing.T) {
+		server := newFakeKVPController(t, &atomic.Bool{})
+		a := newTestKVPArm(t, server, false)
+		test.That(t, a.moveID.Load(), test.ShouldEqual, 7)
+		speed, _ := server.Get(kvpJointVelVar)
+		test.That(t, speed, test.ShouldEqual, "20")
+
+		joints, err := a.JointPositions(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, joints.Values, test.ShouldResemble, []float64{0, -90, 90, 0, 45, 0})
+
+		pose, err := a.EndPosition(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, pose.Point().X, test.ShouldAlmostEqual, 500)
+		test.That(t, pose.Point().Z, test.ShouldAlmostEqual, 600)
+
+		server.Set(kvpAxisActVar, "garbage")
+		_, err = a.JointPositions(ctx, nil)
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("move", func(t *testing.T) {
+		server := newFakeKVPController(t, &atomic.Bool{})
+		a := newTestKVPArm(t, server, false)
+
+		target := []float64{10, -80, 85, 5, 40, -5}
+		test.That(t, a.MoveToJointPositions(ctx, &v1.JointPositions{Values: target}, nil), test.ShouldBeNil)
+		moveID, _ := server.Get(kvpMoveIDVar)
+		test.That(t, moveID, test.ShouldEqual, "8")
+		joints, err := a.JointPositions(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, joints.Values, test.ShouldResemble, target)
+
+		isMoving, err := a.IsMoving(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, isMoving, test.ShouldBeFalse)
+
+		// Moves are only reported by the id the arm sent last, not by viamMoveId changing on its own
+		server.Set(kvpMoveIDVar, "9")
+		isMoving, err = a.IsMoving(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, isMoving, test.ShouldBeFalse)
+		server.Set(kvpMoveIDVar, "8")
+
+		err = a.MoveToJointPositions(ctx, &v1.JointPositions{Values: []float64{200, -80, 85, 5, 40, -5}}, nil)
+		test.That(t, err, test.ShouldNotBeNil)
+		moveID, _ = server.Get(kvpMoveIDVar)
+		test.That(t, moveID, test.ShouldEqual, "8")
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		hold := &atomic.Bool{}
+		hold.Store(true)
+		server := newFakeKVPController(t, hold)
+		a := newTestKVPArm(t, server, false)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- a.MoveToJointPositions(ctx, &v1.JointPositions{Values: []float64{10, -80, 85, 5, 40, -5}}, nil)
+		}()
+		time.Sleep(100 * time.Millisecond)
+		isMoving, err := a.IsMoving(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, isMoving, test.ShouldBeTrue)
+
+		test.That(t, a.Stop(ctx, nil), test.ShouldBeNil)
+		test.That(t, <-done, test.ShouldBeNil)
+		stop, _ := server.Get(kvpStopVar)
+		test.That(t, stop, test.ShouldEqual, "TRUE")
+
+		// A cancelled move stops the robot
+		server.Set(kvpStopVar, "FALSE")
+		cancelCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		err = a.MoveToJointPositions(cancelCtx, &v1.JointPositions{Values: []float64{10, -80, 85, 5, 40, -5}}, nil)
+		test.That(t, err, test.ShouldBeError, context.DeadlineExceeded)
+		stop, _ = server.Get(kvpStopVar)
+		test.That(t, stop, test.ShouldEqual, "TRUE")
+	})
+
+	t.Run("do command", func(t *testing.T) {
+		server := newFakeKVPController(t, &atomic.Bool{})
+		a := newTestKVPArm(t, server, false)
+
+		resp, err := a.DoCommand(ctx, map[string]interface{}{"command": readVariableCommand, "variable": "$OV_PRO"})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["value"], test.ShouldEqual, "100")
+
+		_, err = a.DoCommand(ctx, map[string]interface{}{"command": setOverrideCommand, "value": 50.0})
+		test.That(t, err, test.ShouldBeNil)
+		override, _ := server.Get(kvpOverrideVar)
+		test.That(t, override, test.ShouldEqual, "50")
+		resp, err = a.DoCommand(ctx, map[string]interface{}{"command": getOverrideCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["value"], test.ShouldEqual, 50)
+		_, err = a.DoCommand(ctx, map[string]interface{}{"command": setOverrideCommand, "value": 150.0})
+		test.That(t, err, test.ShouldNotBeNil)
+
+		_, err = a.DoCommand(ctx, map[string]interface{}{"command": setJointSpeedCommand, "value": 35.5})
+		test.That(t, err, test.ShouldBeNil)
+		speed, _ := server.Get(kvpJointVelVar)
+		test.That(t, speed, test.ShouldEqual, "35.5")
+
+		writeCmd := map[string]interface{}{"command": writeVariableCommand, "variable": kvpStopVar, "value": "TRUE"}
+		_, err = a.DoCommand(ctx, writeCmd)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "allow_raw_commands")
+
+		a.allowRawCommands = true
+		resp, err = a.DoCommand(ctx, writeCmd)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["value"], test.ShouldEqual, "TRUE")
+
+		_, err = a.DoCommand(ctx, map[string]interface{}{"command": "get_device_info"})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("missing globals", func(t *testing.T) {
+		server, err := kvp.NewFakeServer(map[string]string{kvpAxisActVar: testAxisAct})
+		test.That(t, err, test.ShouldBeNil)
+		defer func() { test.That(t, server.Close(), test.ShouldBeNil) }()
+
+		host, port, err := net.SplitHostPort(server.Addr())
+		test.That(t, err, test.ShouldBeNil)
+		portNum, err := strconv.Atoi(port)
+		test.That(t, err, test.ShouldBeNil)
+		_, err = newKVPArm(ctx, resource.Config{Name: "arm"}, &Config{IPAddress: host, Port: portNum},
+			logging.NewTestLogger(t))
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "viamKvp.dat")
+	})
+}
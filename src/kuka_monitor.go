@@ -1,280 +1,206 @@
 package kuka
 
 import (
-	"fmt"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang/geo/r3"
 	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
-	"go.viam.com/rdk/referenceframe"
 	"go.viam.com/rdk/spatialmath"
 	"go.viam.com/rdk/utils"
-
-	gutils "go.viam.com/utils"
 )
 
-// startResponseMonitor starts up a background process to monitor responses from the TCP connection.
-func (kuka *kukaArm) startResponseMonitor() error {
-
-	kuka.activeBackgroundWorkers.Add(1)
-	gutils.PanicCapturingGo(func() {
-		defer kuka.activeBackgroundWorkers.Done()
-
-		kuka.responseMonitor()
-	})
-	return nil
+// handleMessage splits a message received from the kuka device into its command and arguments and handles it.
+func (kuka *kukaArm) handleMessage(message string) {
+	dataList := strings.Split(message, ",")
+	if kuka.responseHook != nil {
+		kuka.responseHook(dataList[0], dataList[1:])
+	}
+	kuka.handleRobotResponses(dataList[0], dataList[1:])
 }
 
-// responseMonitor monitors the responses from the TCP connection and sends them to the associated handler.
-func (kuka *kukaArm) responseMonitor() {
-	for {
-		if kuka.closed {
-			fmt.Println("closed")
-			break
-		}
-
-		// Read response
-		data, err := kuka.Read()
-		if err != nil {
-			kuka.logger.Warnf("error reading line: %v", err)
-		}
-		if data == nil {
-			continue
-		}
-
-		// Handle response data
-		dataList := strings.Split(string(data[:len(data)-1]), ",")
-		kuka.handleRobotResponses(dataList[0], dataList[1:])
-	}
+// responseHandlers update the state of the arm from the reply to each command, or message pushed by the kuka device,
+// decoded as described by the registry of commands. Replies to other commands of the registry are only checked before
+// being passed on to the request waiting on them.
+var responseHandlers = map[string]func(kuka *kukaArm, reply ekiCommand.Reply){
+	// Get robot info
+	ekiCommand.GetRobotName:            (*kukaArm).handleRobotName,
+	ekiCommand.GetRobotSerialNum:       (*kukaArm).handleRobotSerialNumber,
+	ekiCommand.GetRobotType:            (*kukaArm).handleRobotType,
+	ekiCommand.GetRobotSoftwareVersion: (*kukaArm).handleRobotSoftwareVersion,
+	ekiCommand.GetRobotOperatingMode:   (*kukaArm).handleRobotOperatingMode,
+	ekiCommand.GetEKIProgramState:      (*kukaArm).handleProgramState,
+	ekiCommand.GetProtocolInfo:         (*kukaArm).handleProtocolInfo,
+	// Get robot status
+	ekiCommand.GetJointPosition: (*kukaArm).handleGetJointPositions,
+	ekiCommand.GetEndPosition:   (*kukaArm).handleGetEndPositions,
+	ekiCommand.GetJointNegLimit: (*kukaArm).handleMinJointPositions,
+	ekiCommand.GetJointPosLimit: (*kukaArm).handleMaxJointPositions,
+	ekiCommand.GetToolData:      (*kukaArm).handleGetToolData,
+	ekiCommand.GetBaseData:      (*kukaArm).handleGetBaseData,
+	ekiCommand.GetStopMessage:   (*kukaArm).handleStopMessage,
+	ekiCommand.GetRunMode:       (*kukaArm).handleRunMode,
+	ekiCommand.StateStream:      (*kukaArm).handleStateStream,
 }
 
-// handleRobotResponses calls the associated handler function for each possible command
+// handleRobotResponses decodes each response and calls the handler of its command. A request waiting on the command is
+// given the response once it has been handled, so the state it reads is up to date.
 func (kuka *kukaArm) handleRobotResponses(command string, args []string) {
+	defer kuka.deliverReply(command, args)
+
+	// End the motion in progress on the reply to its command, or on the robot being stopped
+	if kuka.endMove(command, args) {
+		return
+	}
 
 	// Check for success status
-	if len(args) > 0 {
-		if args[0] == "success" {
-			kuka.stateMutex.Lock()
-			// Send response to channel if a move request is being processed
-			if kuka.currentState.isMoving {
-				kuka.responseCh <- true
-			}
+	if len(args) > 0 && args[0] == ekiCommand.ResponseSuccess {
+		return
+	}
 
-			kuka.currentState.isMoving = false
-			kuka.stateMutex.Unlock()
-			return
+	// A refused command changes nothing on the kuka device
+	if len(args) == 1 && refusalReplies[args[0]] {
+		switch command {
+		case ekiCommand.SelectProgram, ekiCommand.StartProgram, ekiCommand.StopProgram, ekiCommand.ResetProgram:
+			kuka.handleProgramRefusal(command, args[0])
 		}
+		return
 	}
 
-	// Handle responses to commands
-	switch command {
-	// Get robot info
-	case ekiCommand.GetRobotName:
-		kuka.handleRobotName(args)
-	case ekiCommand.GetRobotSerialNum:
-		kuka.handleRobotSerialNumber(args)
-	case ekiCommand.GetRobotType:
-		kuka.handleRobotType(args)
-	case ekiCommand.GetRobotSoftwareVersion:
-		kuka.handleRobotSoftwareVersion(args)
-	case ekiCommand.GetRobotOperatingMode:
-		kuka.handleRobotOperatingMode(args)
-	case ekiCommand.GetEKIProgramState:
-		kuka.handleProgramState(args)
-	// Get robot status
-	case ekiCommand.GetJointPosition:
-		kuka.handleGetJointPositions(args)
-	case ekiCommand.GetEndPosition:
-		kuka.handleGetEndPositions(args)
-	case ekiCommand.GetJointNegLimit:
-		kuka.handleMinJointPositions(args)
-	case ekiCommand.GetJointPosLimit:
-		kuka.handleMaxJointPositions(args)
-	// Get response from move
-	case ekiCommand.SetJointPosition:
-		kuka.handleSetJointPositions(args)
-	default:
+	descriptor, ok := ekiCommand.Lookup(command)
+	if !ok {
 		kuka.logger.Infof("UNHANDLED RESPONSE: %v", args)
+		return
 	}
-}
-
-// Get robot info
-func (kuka *kukaArm) handleRobotName(data []string) {
-	if len(data) != 1 {
-		kuka.logger.Warnf("incorrect amount of data returned for robot name: %v  (should be 1)", data)
+	reply, err := descriptor.Decode(args)
+	if err != nil {
+		kuka.logger.Warnf("issue parsing response: %v", err)
 		return
 	}
+	if handler, ok := responseHandlers[descriptor.Name]; ok {
+		handler(kuka, reply)
+	}
+}
 
+// Get robot info
+func (kuka *kukaArm) handleRobotName(reply ekiCommand.Reply) {
 	kuka.stateMutex.Lock()
 	defer kuka.stateMutex.Unlock()
-	kuka.deviceInfo.name = data[0]
+	kuka.deviceInfo.name = reply.Text(0)
 }
 
-func (kuka *kukaArm) handleRobotSerialNumber(data []string) {
-	if len(data) != 1 {
-		kuka.logger.Warnf("incorrect amount of data returned for robot serial number: %v  (should be 1)", data)
-		return
-	}
-
+func (kuka *kukaArm) handleRobotSerialNumber(reply ekiCommand.Reply) {
 	kuka.stateMutex.Lock()
 	defer kuka.stateMutex.Unlock()
-	kuka.deviceInfo.serialNum = data[0]
+	kuka.deviceInfo.serialNum = reply.Text(0)
 }
 
-func (kuka *kukaArm) handleRobotType(data []string) {
-	if len(data) != 1 {
-		kuka.logger.Warnf("incorrect amount of data returned for robot type: %v  (should be 1)", data)
-		return
-	}
-
+func (kuka *kukaArm) handleRobotType(reply ekiCommand.Reply) {
 	kuka.stateMutex.Lock()
 	defer kuka.stateMutex.Unlock()
-	kuka.deviceInfo.robotType = data[0]
+	kuka.deviceInfo.robotType = reply.Text(0)
 }
 
-func (kuka *kukaArm) handleRobotSoftwareVersion(data []string) {
-	if len(data) != 1 {
-		kuka.logger.Warnf("incorrect amount of data returned for robot software version mode: %v  (should be 1)", data)
-		return
-	}
-
+func (kuka *kukaArm) handleRobotSoftwareVersion(reply ekiCommand.Reply) {
 	kuka.stateMutex.Lock()
 	defer kuka.stateMutex.Unlock()
-	kuka.deviceInfo.softwareVersion = data[0]
+	kuka.deviceInfo.softwareVersion = reply.Text(0)
 }
 
-func (kuka *kukaArm) handleRobotOperatingMode(data []string) {
-	if len(data) != 1 {
-		kuka.logger.Warnf("incorrect amount of data returned for robot operating mode: %v  (should be 1)", data)
-		return
-	}
+func (kuka *kukaArm) handleRobotOperatingMode(reply ekiCommand.Reply) {
+	kuka.setOperatingMode(reply.Text(0))
+}
 
+// setOperatingMode records the operating mode reported by the kuka device, warning if it changed.
+func (kuka *kukaArm) setOperatingMode(mode string) {
 	kuka.stateMutex.Lock()
 	defer kuka.stateMutex.Unlock()
-	kuka.deviceInfo.operatingMode = data[0]
+	if previous := kuka.deviceInfo.operatingMode; previous != "" && previous != mode {
+		kuka.logger.Warnf("operating mode of kuka device changed from %v to %v", previous, mode)
+	}
+	kuka.deviceInfo.operatingMode = mode
+	kuka.currentState.operatingModeUpdated = time.Now()
 }
 
 // Get robot status
-func (kuka *kukaArm) handleMinJointPositions(data []string) {
-	if len(data) != numJoints+numExternalJoints {
-		kuka.logger.Warnf("incorrect amount of data returned for negative joint position limits: %v  (should be 12)", data)
-		return
-	}
-
-	// Parse data and update current state
-	jointLimits := make([]referenceframe.Limit, numJoints)
-	for i := 0; i < numJoints; i++ {
-		val, err := strconv.ParseFloat(data[i], 64)
-		if err != nil {
-			kuka.logger.Warnf("issue parsing response to floats, failed to parse %v", data)
-			return
-		}
-		jointLimits[i].Min = val
-	}
-
+func (kuka *kukaArm) handleMinJointPositions(reply ekiCommand.Reply) {
 	kuka.stateMutex.Lock()
 	defer kuka.stateMutex.Unlock()
-	for i := range jointLimits {
-		kuka.currentState.jointLimits[i].Min = jointLimits[i].Min
+	for i := range kuka.currentState.jointLimits {
+		kuka.currentState.jointLimits[i].Min = reply.Float(i)
 	}
 }
 
-func (kuka *kukaArm) handleMaxJointPositions(data []string) {
-	if len(data) != numJoints+numExternalJoints {
-		kuka.logger.Warnf("incorrect amount of data returned for positive joint position limits: %v  (should be 12)", data)
-		return
-	}
-
-	// Parse data and update current state
-	jointLimits := make([]referenceframe.Limit, numJoints)
-	for i := 0; i < numJoints; i++ {
-		val, err := strconv.ParseFloat(data[i], 64)
-		if err != nil {
-			kuka.logger.Warnf("issue parsing response to floats, failed to parse %v", data)
-			return
-		}
-		jointLimits[i].Max = val
-	}
-
+func (kuka *kukaArm) handleMaxJointPositions(reply ekiCommand.Reply) {
 	kuka.stateMutex.Lock()
 	defer kuka.stateMutex.Unlock()
-	for i := range jointLimits {
-		kuka.currentState.jointLimits[i].Max = jointLimits[i].Max
+	for i := range kuka.currentState.jointLimits {
+		kuka.currentState.jointLimits[i].Max = reply.Float(i)
 	}
 }
 
 // handleGetJointPositions is blocking
-func (kuka *kukaArm) handleGetJointPositions(data []string) {
-	if len(data) != numJoints+numExternalJoints {
-		kuka.logger.Warnf("incorrect amount of data returned for joint position: %v (should be 12)", data)
-		return
-	}
-
-	// Parse values to floats
-	jointList := make([]float64, numJoints)
-	for i := 0; i < numJoints; i++ {
-		c, err := strconv.ParseFloat(data[i], 64)
-		if err != nil {
-			kuka.logger.Warnf("issue parsing response to floats, failed to parse %v", data)
-			return
-		}
-		jointList[i] = c
-	}
-
-	// Update current state
+func (kuka *kukaArm) handleGetJointPositions(reply ekiCommand.Reply) {
 	kuka.stateMutex.Lock()
 	defer kuka.stateMutex.Unlock()
-	kuka.currentState.joints = jointList
+	kuka.currentState.joints = reply.Floats(0, numJoints)
+	kuka.currentState.jointsUpdated = time.Now()
 }
 
-func (kuka *kukaArm) handleGetEndPositions(data []string) {
-	if len(data) != 8+numExternalJoints {
-		kuka.logger.Warnf("incorrect amount of data returned for end position limits: %v (should be 14)", data)
-		return
-	}
-
-	// Parse values to floats
-	endPositionList := make([]float64, 8)
-	for i := 0; i < numJoints; i++ {
-		c, err := strconv.ParseFloat(data[i], 64)
-		if err != nil {
-			kuka.logger.Warnf("issue parsing response to floats, failed to parse %v", data)
-			return
-		}
-		endPositionList[i] = c
-	}
-
-	// Update current state
+func (kuka *kukaArm) handleGetEndPositions(reply ekiCommand.Reply) {
 	kuka.stateMutex.Lock()
 	defer kuka.stateMutex.Unlock()
-	kuka.currentState.endEffectorPose = spatialmath.NewPose(
-		r3.Vector{X: endPositionList[0], Y: endPositionList[1], Z: endPositionList[2]},
-		&spatialmath.EulerAngles{
-			Yaw:   utils.RadToDeg(endPositionList[3]),
-			Pitch: utils.RadToDeg(endPositionList[4]),
-			Roll:  utils.RadToDeg(endPositionList[5]),
-		},
+	kuka.currentState.endStatus = reply.Int(6)
+	kuka.currentState.endTurn = reply.Int(7)
+	kuka.currentState.endEffectorPose = kukaFrameToPose(reply.Floats(0, 6))
+	kuka.currentState.poseUpdated = time.Now()
+}
+
+// kukaFrameToPose converts a kuka frame (x,y,z,a,b,c), such as an end position reported by the kuka device, into a
+// pose. The a,b,c angles are rotations in degrees about z,y,x.
+func kukaFrameToPose(frame []float64) spatialmath.Pose {
+	return spatialmath.NewPose(
+		r3.Vector{X: frame[0], Y: frame[1], Z: frame[2]},
+		&spatialmath.EulerAngles{Yaw: utils.DegToRad(frame[3]), Pitch: utils.DegToRad(frame[4]), Roll: utils.DegToRad(frame[5])},
 	)
 }
 
-// handleProgramState is blocking
-func (kuka *kukaArm) handleProgramState(data []string) {
-	if len(data) != 2 {
-		kuka.logger.Warnf("incorrect amount of data returned for robot programming state: %v (should be 2)", data)
-		return
-	}
+// handleStateStream updates the joints, end position, override and operating mode pushed together by ekiStateStream.
+func (kuka *kukaArm) handleStateStream(reply ekiCommand.Reply) {
+	const endStart = numJoints + numExternalJoints
 
-	// Update current state
+	now := time.Now()
+	kuka.stateMutex.Lock()
+	kuka.currentState.joints = reply.Floats(0, numJoints)
+	kuka.currentState.endStatus = reply.Int(endStart + 6)
+	kuka.currentState.endTurn = reply.Int(endStart + 7)
+	kuka.currentState.endEffectorPose = kukaFrameToPose(reply.Floats(endStart, endStart+6))
+	kuka.currentState.override = reply.Int(reply.Len() - 2)
+	kuka.currentState.jointsUpdated = now
+	kuka.currentState.poseUpdated = now
+	kuka.currentState.streamUpdated = now
+	kuka.stateMutex.Unlock()
+
+	kuka.setOperatingMode(reply.Text(reply.Len() - 1))
+}
+
+func (kuka *kukaArm) handleGetToolData(reply ekiCommand.Reply) {
 	kuka.stateMutex.Lock()
 	defer kuka.stateMutex.Unlock()
-	kuka.currentState.programName = data[0]
-	kuka.currentState.programState = ekiCommand.StringToProgramStatus(data[1])
+	kuka.currentState.toolFrame = reply.Floats(0, 6)
+}
 
-	kuka.responseCh <- false
+func (kuka *kukaArm) handleGetBaseData(reply ekiCommand.Reply) {
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	kuka.currentState.baseFrame = reply.Floats(0, 6)
 }
 
-// Set
-func (kuka *kukaArm) handleSetJointPositions(data []string) {
-	// Nothing to do currently
+func (kuka *kukaArm) handleProgramState(reply ekiCommand.Reply) {
+	// Update current state
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	kuka.currentState.programName = reply.Text(0)
+	kuka.currentState.programState = ekiCommand.StringToProgramStatus(reply.Text(1))
+	kuka.currentState.programStateUpdated = time.Now()
 }
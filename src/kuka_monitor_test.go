@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/geo/r3"
 	eki_command "github.com/viam-soleng/viam-kuka/src/ekicommands"
@@ -26,15 +28,15 @@ func TestHandleDeviceInfo(t *testing.T) {
 	}
 
 	deviceInfoFunctions := []struct {
-		name     string
-		testFunc func([]string)
-		value    string
+		name    string
+		command string
+		value   string
 	}{
-		{name: "robot_name", testFunc: kuka.handleRobotName},
-		{name: "serial_number", testFunc: kuka.handleRobotSerialNumber},
-		{name: "robot_type", testFunc: kuka.handleRobotType},
-		{name: "software_version", testFunc: kuka.handleRobotSoftwareVersion},
-		{name: "operating_mode", testFunc: kuka.handleRobotOperatingMode},
+		{name: "robot_name", command: eki_command.GetRobotName},
+		{name: "serial_number", command: eki_command.GetRobotSerialNum},
+		{name: "robot_type", command: eki_command.GetRobotType},
+		{name: "software_version", command: eki_command.GetRobotSoftwareVersion},
+		{name: "operating_mode", command: eki_command.GetRobotOperatingMode},
 	}
 
 	tests := []struct {
@@ -52,7 +54,7 @@ func TestHandleDeviceInfo(t *testing.T) {
 			kuka.deviceInfo = deviceInfo{}
 
 			t.Run(fmt.Sprintf("%v given %v", deviceInfoFunc.name, tt.description), func(t *testing.T) {
-				deviceInfoFunc.testFunc(tt.expectedData)
+				kuka.handleRobotResponses(deviceInfoFunc.command, tt.expectedData)
 
 				var value string
 				switch deviceInfoFunc.name {
@@ -86,7 +88,6 @@ func TestHandleJointLimit(t *testing.T) {
 		logger:       logger,
 		stateMutex:   sync.Mutex{},
 		currentState: state{},
-		responseCh:   make(chan bool, 1),
 	}
 
 	jointLimitsTests := []struct {
@@ -103,8 +104,8 @@ func TestHandleJointLimit(t *testing.T) {
 		kuka.currentState = state{jointLimits: make([]referenceframe.Limit, numJoints)}
 
 		t.Run(tt.description, func(t *testing.T) {
-			kuka.handleMinJointPositions(tt.data)
-			kuka.handleMaxJointPositions(tt.data)
+			kuka.handleRobotResponses(eki_command.GetJointNegLimit, tt.data)
+			kuka.handleRobotResponses(eki_command.GetJointPosLimit, tt.data)
 			if tt.success {
 				expectedMinResult := helperStringListToFloats(tt.data[0:6])
 				expectedMaxResult := helperStringListToFloats(tt.data[0:6])
@@ -126,7 +127,6 @@ func TestHandleJointPosition(t *testing.T) {
 		logger:       logger,
 		stateMutex:   sync.Mutex{},
 		currentState: state{},
-		responseCh:   make(chan bool, 1),
 	}
 
 	jointPositionTests := []struct {
@@ -143,7 +143,7 @@ func TestHandleJointPosition(t *testing.T) {
 		kuka.currentState = state{}
 
 		t.Run(tt.description, func(t *testing.T) {
-			kuka.handleGetJointPositions(tt.data)
+			kuka.handleRobotResponses(eki_command.GetJointPosition, tt.data)
 			if tt.success {
 				expectedResult := helperStringListToFloats(tt.data[0:6])
 				test.That(t, kuka.currentState.joints, test.ShouldResemble, expectedResult)
@@ -161,7 +161,6 @@ func TestHandleEndPosition(t *testing.T) {
 		logger:       logger,
 		stateMutex:   sync.Mutex{},
 		currentState: state{},
-		responseCh:   make(chan bool, 1),
 	}
 
 	endPositionTests := []struct {
@@ -178,15 +177,15 @@ func TestHandleEndPosition(t *testing.T) {
 		kuka.currentState = state{}
 
 		t.Run(tt.description, func(t *testing.T) {
-			kuka.handleGetEndPositions(tt.data)
+			kuka.handleRobotResponses(eki_command.GetEndPosition, tt.data)
 			if tt.success {
 				dataFloats := helperStringListToFloats(tt.data)
 				expectedResult := spatialmath.NewPose(
 					r3.Vector{X: dataFloats[0], Y: dataFloats[1], Z: dataFloats[2]},
 					&spatialmath.EulerAngles{
-						Yaw:   utils.RadToDeg(dataFloats[3]),
-						Pitch: utils.RadToDeg(dataFloats[4]),
-						Roll:  utils.RadToDeg(dataFloats[5]),
+						Yaw:   utils.DegToRad(dataFloats[3]),
+						Pitch: utils.DegToRad(dataFloats[4]),
+						Roll:  utils.DegToRad(dataFloats[5]),
 					})
 				test.That(t, kuka.currentState.endEffectorPose, test.ShouldResemble, expectedResult)
 			} else {
@@ -196,6 +195,43 @@ func TestHandleEndPosition(t *testing.T) {
 	}
 }
 
+func TestHandleFrameData(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+
+	kuka := &kukaArm{
+		logger:       logger,
+		stateMutex:   sync.Mutex{},
+		currentState: state{},
+	}
+
+	frameTests := []struct {
+		description string
+		data        []string
+		success     bool
+	}{
+		{description: "incorrect amount of data", data: []string{"0", "0", "0"}, success: false},
+		{description: "correct amount of data bad format", data: []string{"1", "2", "3", "hi", "0", "0"}, success: false},
+		{description: "correct amount of data", data: []string{"1", "2", "3", "90", "0", "-90"}, success: true},
+	}
+
+	for _, tt := range frameTests {
+		kuka.currentState = state{}
+
+		t.Run(tt.description, func(t *testing.T) {
+			kuka.handleRobotResponses(eki_command.GetToolData, tt.data)
+			kuka.handleRobotResponses(eki_command.GetBaseData, tt.data)
+			if tt.success {
+				expectedResult := helperStringListToFloats(tt.data)
+				test.That(t, kuka.currentState.toolFrame, test.ShouldResemble, expectedResult)
+				test.That(t, kuka.currentState.baseFrame, test.ShouldResemble, expectedResult)
+			} else {
+				test.That(t, kuka.currentState.toolFrame, test.ShouldBeNil)
+				test.That(t, kuka.currentState.baseFrame, test.ShouldBeNil)
+			}
+		})
+	}
+}
+
 func TestHandleProgramState(t *testing.T) {
 	logger := logging.NewTestLogger(t)
 
@@ -203,7 +239,6 @@ func TestHandleProgramState(t *testing.T) {
 		logger:       logger,
 		stateMutex:   sync.Mutex{},
 		currentState: state{},
-		responseCh:   make(chan bool, 1),
 	}
 
 	programStateTests := []struct {
@@ -219,7 +254,7 @@ func TestHandleProgramState(t *testing.T) {
 		kuka.currentState = state{}
 
 		t.Run(tt.description, func(t *testing.T) {
-			kuka.handleProgramState(tt.data)
+			kuka.handleRobotResponses(eki_command.GetEKIProgramState, tt.data)
 			if tt.success {
 				test.That(t, kuka.currentState.programName, test.ShouldResemble, tt.data[0])
 				test.That(t, kuka.currentState.programState, test.ShouldResemble, eki_command.StatusRunning)
@@ -238,22 +273,89 @@ func TestHandleSuccess(t *testing.T) {
 	kuka := &kukaArm{
 		logger:       logger,
 		stateMutex:   sync.Mutex{},
-		currentState: state{isMoving: true},
-		responseCh:   make(chan bool, 1),
+		currentState: state{isMoving: true, moveCommand: eki_command.SetJointPosition},
 	}
 
 	isMoving, err := kuka.IsMoving(ctx)
 	test.That(t, err, test.ShouldBeNil)
 	test.That(t, isMoving, test.ShouldBeTrue)
 
-	// Send 'success'
-	kuka.handleRobotResponses("command", []string{"success"})
+	// Send 'success' for another command
+	kuka.handleRobotResponses(eki_command.SetOverride, []string{"success"})
+
+	isMoving, err = kuka.IsMoving(ctx)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, isMoving, test.ShouldBeTrue)
+
+	// Send 'success' for the move
+	kuka.handleRobotResponses(eki_command.SetJointPosition, []string{"success"})
 
 	isMoving, err = kuka.IsMoving(ctx)
 	test.That(t, err, test.ShouldBeNil)
 	test.That(t, isMoving, test.ShouldBeFalse)
 }
 
+func TestHandleStateStream(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+
+	kuka := &kukaArm{
+		logger:       logger,
+		stateMutex:   sync.Mutex{},
+		currentState: state{},
+	}
+
+	joints := "1,2,3,4,5,6,0,0,0,0,0,0"
+	end := "500,0,600,0,90,0,2,35,0,0,0,0,0,0"
+
+	stateStreamTests := []struct {
+		description string
+		data        string
+		success     bool
+	}{
+		{description: "incorrect amount of data", data: joints + ",50,T1", success: false},
+		{description: "bad joint format", data: "hi" + joints[1:] + "," + end + ",50,T1", success: false},
+		{description: "bad override format", data: joints + "," + end + ",fast,T1", success: false},
+		{description: "correct amount of data", data: joints + "," + end + ",50,T1", success: true},
+	}
+
+	for _, tt := range stateStreamTests {
+		kuka.currentState = state{}
+		kuka.deviceInfo = deviceInfo{}
+
+		t.Run(tt.description, func(t *testing.T) {
+			before := time.Now()
+			kuka.handleRobotResponses(eki_command.StateStream, strings.Split(tt.data, ","))
+			if tt.success {
+				test.That(t, kuka.currentState.joints, test.ShouldResemble, []float64{1, 2, 3, 4, 5, 6})
+				test.That(t, kuka.currentState.endEffectorPose, test.ShouldResemble, kukaFrameToPose([]float64{500, 0, 600, 0, 90, 0}))
+				test.That(t, kuka.currentState.endStatus, test.ShouldEqual, 2)
+				test.That(t, kuka.currentState.endTurn, test.ShouldEqual, 35)
+				test.That(t, kuka.currentState.override, test.ShouldEqual, 50)
+				test.That(t, kuka.deviceInfo.operatingMode, test.ShouldEqual, "T1")
+				test.That(t, kuka.currentState.jointsUpdated.Before(before), test.ShouldBeFalse)
+				test.That(t, kuka.currentState.poseUpdated, test.ShouldEqual, kuka.currentState.jointsUpdated)
+				test.That(t, kuka.currentState.streamUpdated, test.ShouldEqual, kuka.currentState.jointsUpdated)
+			} else {
+				test.That(t, kuka.currentState.joints, test.ShouldBeNil)
+				test.That(t, kuka.currentState.endEffectorPose, test.ShouldBeNil)
+				test.That(t, kuka.currentState.streamUpdated.IsZero(), test.ShouldBeTrue)
+				test.That(t, kuka.deviceInfo.operatingMode, test.ShouldEqual, "")
+			}
+		})
+	}
+
+	t.Run("active while pushed", func(t *testing.T) {
+		kuka.currentState = state{streamUpdated: time.Now()}
+		test.That(t, kuka.stateStreamActive(), test.ShouldBeFalse)
+
+		kuka.stateStreamPeriod = 20 * time.Millisecond
+		test.That(t, kuka.stateStreamActive(), test.ShouldBeTrue)
+
+		kuka.currentState.streamUpdated = time.Now().Add(-stateStreamMissedPeriods * kuka.stateStreamPeriod)
+		test.That(t, kuka.stateStreamActive(), test.ShouldBeFalse)
+	})
+}
+
 // helperStringListToFloats
 func helperStringListToFloats(data []string) []float64 {
 	floatList := make([]float64, len(data))
@@ -266,3 +368,34 @@ func helperStringListToFloats(data []string) []float64 {
 	}
 	return floatList
 }
+
+func TestKukaFrameToPose(t *testing.T) {
+	// Where a point 1mm along the x axis of the frame ends up, for rotations of 90 degrees about z, y and x
+	frameTests := []struct {
+		description string
+		frame       []float64
+		point       r3.Vector
+		expected    r3.Vector
+	}{
+		{description: "a about z", frame: []float64{500, 0, 600, 90, 0, 0}, point: r3.Vector{X: 1}, expected: r3.Vector{X: 500, Y: 1, Z: 600}},
+		{description: "b about y", frame: []float64{500, 0, 600, 0, 90, 0}, point: r3.Vector{X: 1}, expected: r3.Vector{X: 500, Z: 599}},
+		{description: "c about x", frame: []float64{500, 0, 600, 0, 0, 90}, point: r3.Vector{Y: 1}, expected: r3.Vector{X: 500, Z: 601}},
+	}
+
+	for _, tt := range frameTests {
+		t.Run(tt.description, func(t *testing.T) {
+			moved := spatialmath.Compose(kukaFrameToPose(tt.frame), spatialmath.NewPoseFromPoint(tt.point)).Point()
+			test.That(t, moved.X, test.ShouldAlmostEqual, tt.expected.X)
+			test.That(t, moved.Y, test.ShouldAlmostEqual, tt.expected.Y)
+			test.That(t, moved.Z, test.ShouldAlmostEqual, tt.expected.Z)
+		})
+	}
+
+	// The state stream and end position replies give the same pose
+	kuka := &kukaArm{logger: logging.NewTestLogger(t)}
+	kuka.handleRobotResponses(eki_command.StateStream, strings.Split("0,0,0,0,0,0,0,0,0,0,0,0,500,0,600,90,0,0,2,35,0,0,0,0,0,0,100,T1", ","))
+	streamed := kuka.currentState.endEffectorPose
+	kuka.handleRobotResponses(eki_command.GetEndPosition, strings.Split("500,0,600,90,0,0,2,35,0,0,0,0,0,0", ","))
+	test.That(t, spatialmath.PoseAlmostEqual(streamed, kuka.currentState.endEffectorPose), test.ShouldBeTrue)
+	test.That(t, spatialmath.PoseAlmostEqual(streamed, kukaFrameToPose([]float64{500, 0, 600, 90, 0, 0})), test.ShouldBeTrue)
+}
@@ -0,0 +1,247 @@
+package kuka
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+
+	"github.com/viam-soleng/viam-kuka/src/opcua"
+)
+
+// opcuaBrowseDepth bounds how far below the objects folder variables are looked for
+const opcuaBrowseDepth = 4
+
+// newOPCUAArm creates an arm connected to KUKA.OPC UA on the kuka device.
+func newOPCUAArm(ctx context.Context, conf resource.Config, newConf *Config, logger logging.Logger) (*kvpArm, error) {
+	port := newConf.Port
+	if port == 0 {
+		port = opcua.DefaultPort
+	}
+	endpoint := fmt.Sprintf("opc.tcp://%v", net.JoinHostPort(newConf.IPAddress, strconv.Itoa(port)))
+	client, err := opcua.Dial(ctx, endpoint, variableTimeout(newConf))
+	if err != nil {
+		return nil, err
+	}
+	a, err := newVariableArm(conf, newConf, logger, &opcuaVariables{client: client}, protocolOPCUA)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("connected to KUKA.OPC UA at %v", endpoint)
+	return a, nil
+}
+
+// opcuaVariables reads and writes the variables of the kuka device through an OPC UA client. Variables are found by
+// browsing the address space for a variable node whose browse name is the KRL name, ignoring case, and their values
+// converted to and from KRL literals.
+type opcuaVariables struct {
+	client *opcua.Client
+
+	mu    sync.Mutex
+	nodes map[string]opcua.NodeID
+}
+
+// node returns the node of the variable with the given name, browsing the address space if it has not been found yet.
+func (v *opcuaVariables) node(name string) (opcua.NodeID, error) {
+	key := strings.ToUpper(name)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if id, ok := v.nodes[key]; ok {
+		return id, nil
+	}
+
+	nodes, err := v.browse()
+	if err != nil {
+		return opcua.NodeID{}, errors.Wrap(err, "failed to browse the OPC UA server")
+	}
+	v.nodes = nodes
+	id, ok := nodes[key]
+	if !ok {
+		return opcua.NodeID{}, errors.Errorf("variable %v was not found on the OPC UA server", name)
+	}
+	return id, nil
+}
+
+// browse returns the variables below the objects folder by their upper case browse names, the first found keeping its
+// name. The nodes of the OPC UA namespace, such as the Server object, are skipped.
+func (v *opcuaVariables) browse() (map[string]opcua.NodeID, error) {
+	nodes := map[string]opcua.NodeID{}
+	visited := map[opcua.NodeID]bool{opcua.ObjectsFolder: true}
+	level := []opcua.NodeID{opcua.ObjectsFolder}
+	for depth := 0; depth < opcuaBrowseDepth && len(level) > 0; depth++ {
+		var next []opcua.NodeID
+		for _, parent := range level {
+			refs, err := v.client.Browse(parent)
+			if err != nil {
+				return nil, err
+			}
+			for _, ref := range refs {
+				if ref.NodeID.Namespace == 0 || visited[ref.NodeID] {
+					continue
+				}
+				visited[ref.NodeID] = true
+				switch ref.NodeClass {
+				case opcua.NodeClassVariable:
+					if key := strings.ToUpper(ref.BrowseName.Name); key != "" {
+						if _, ok := nodes[key]; !ok {
+							nodes[key] = ref.NodeID
+						}
+					}
+				case opcua.NodeClassObject:
+					next = append(next, ref.NodeID)
+				}
+			}
+		}
+		level = next
+	}
+	return nodes, nil
+}
+
+// Read reads a variable as a KRL literal.
+func (v *opcuaVariables) Read(name string) (string, error) {
+	id, err := v.node(name)
+	if err != nil {
+		return "", err
+	}
+	value, err := v.client.Read(id)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read %v", name)
+	}
+	return formatOPCUAValue(name, value)
+}
+
+// Write writes a variable from a KRL literal, converted to the type of its current value, and returns the value read
+// back.
+func (v *opcuaVariables) Write(name, value string) (string, error) {
+	id, err := v.node(name)
+	if err != nil {
+		return "", err
+	}
+	current, err := v.client.Read(id)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read %v", name)
+	}
+	parsed, err := parseOPCUAValue(value, current)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to write %v", name)
+	}
+	if err := v.client.Write(id, parsed); err != nil {
+		return "", errors.Wrapf(err, "failed to write %v", name)
+	}
+	return v.Read(name)
+}
+
+// Close closes the session with the OPC UA server.
+func (v *opcuaVariables) Close() error {
+	return v.client.Close()
+}
+
+// formatOPCUAValue formats the value of a variable as a KRL literal.
+func formatOPCUAValue(name string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case int8, uint8, int16, uint16, int32, uint32, int64, uint64:
+		return fmt.Sprint(v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case string:
+		return v, nil
+	default:
+		return "", errors.Errorf("%v holds a %T, which cannot be given as a KRL literal", name, value)
+	}
+}
+
+// parseOPCUAValue parses a KRL literal into a value of the same type as current.
+func parseOPCUAValue(value string, current interface{}) (interface{}, error) {
+	value = strings.TrimSpace(value)
+	var err error
+	switch current.(type) {
+	case bool:
+		switch strings.ToUpper(value) {
+		case "TRUE":
+			return true, nil
+		case "FALSE":
+			return false, nil
+		}
+		return nil, errors.Errorf("%q is not TRUE or FALSE", value)
+	case int8, int16, int32, int64:
+		var i int64
+		if i, err = strconv.ParseInt(value, 10, bitSize(current)); err == nil {
+			return convertInt(current, i), nil
+		}
+	case uint8, uint16, uint32, uint64:
+		var u uint64
+		if u, err = strconv.ParseUint(value, 10, bitSize(current)); err == nil {
+			return convertUint(current, u), nil
+		}
+	case float32:
+		var f float64
+		if f, err = strconv.ParseFloat(value, 32); err == nil {
+			return float32(f), nil
+		}
+	case float64:
+		var f float64
+		if f, err = strconv.ParseFloat(value, 64); err == nil {
+			return f, nil
+		}
+	case string:
+		return value, nil
+	default:
+		return nil, errors.Errorf("variables holding a %T cannot be written", current)
+	}
+	return nil, errors.Wrapf(err, "%q is not a valid %T", value, current)
+}
+
+// bitSize returns the size in bits of an integer value.
+func bitSize(value interface{}) int {
+	switch value.(type) {
+	case int8, uint8:
+		return 8
+	case int16, uint16:
+		return 16
+	case int32, uint32:
+		return 32
+	default:
+		return 64
+	}
+}
+
+// convertInt converts i, already checked to fit, to the signed type of current.
+func convertInt(current interface{}, i int64) interface{} {
+	switch current.(type) {
+	case int8:
+		return int8(i)
+	case int16:
+		return int16(i)
+	case int32:
+		return int32(i)
+	default:
+		return i
+	}
+}
+
+// convertUint converts u, already checked to fit, to the unsigned type of current.
+func convertUint(current interface{}, u uint64) interface{} {
+	switch current.(type) {
+	case uint8:
+		return uint8(u)
+	case uint16:
+		return uint16(u)
+	case uint32:
+		return uint32(u)
+	default:
+		return u
+	}
+}
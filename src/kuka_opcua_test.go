@@ -0,0 +1,245 @@
+package kuka
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/test"
+
+	"github.com/viam-soleng/viam-kuka/src/opcua"
+)
+
+// opcuaVariableNode returns the node of a variable of the fake KUKA.OPC UA server.
+func opcuaVariableNode(name string) opcua.NodeID {
+	return opcua.NewStringNodeID(2, name)
+}
+
+// newFakeOPCUAController returns a fake KUKA.OPC UA server running the viamKvp program, which finishes each move after
+// a short time unless hold is set, and ends it when stopped. The system variables and the viamKvp globals are in
+// folders of their own.
+func newFakeOPCUAController(t *testing.T, hold *atomic.Bool) *opcua.FakeServer {
+	server, err := opcua.NewFakeServer()
+	test.That(t, err, test.ShouldBeNil)
+	t.Cleanup(func() { test.That(t, server.Close(), test.ShouldBeNil) })
+
+	robot := opcua.NewStringNodeID(2, "Robot")
+	system := opcua.NewStringNodeID(2, "System")
+	program := opcua.NewStringNodeID(2, "Program")
+	server.AddObject(opcua.ObjectsFolder, robot, "Robot")
+	server.AddObject(robot, system, "System")
+	server.AddObject(robot, program, "Program")
+	for name, value := range map[string]interface{}{
+		kvpAxisActVar:  testAxisAct,
+		kvpPosActVar:   "{E6POS: X 500.0, Y 0.0, Z 600.0, A 0.0, B 45.0, C 0.0, S 2, T 35, E1 0.0}",
+		kvpOverrideVar: int32(100),
+		kvpModeOpVar:   "#AUT",
+		kvpProStateVar: "#P_ACTIVE",
+	} {
+		server.AddVariable(system, opcuaVariableNode(name), name, value)
+	}
+	for name, value := range map[string]interface{}{
+		"viamMoveId":     int32(7),
+		"viamDoneId":     int32(7),
+		"viamJointVel":   float32(10),
+		"viamStop":       false,
+		"viamTargetAxis": testAxisAct,
+	} {
+		server.AddVariable(program, opcuaVariableNode(name), name, value)
+	}
+	// Nodes of the OPC UA namespace are not searched
+	serverObject := opcua.NewNumericNodeID(0, 2253)
+	server.AddObject(opcua.ObjectsFolder, serverObject, "Server")
+	server.AddVariable(serverObject, opcua.NewNumericNodeID(0, 1), "viamDoneId", int32(0))
+
+	server.OnWrite(func(node opcua.NodeID, value interface{}) {
+		switch node {
+		case opcuaVariableNode(kvpMoveIDVar):
+			server.Set(opcuaVariableNode(kvpStopVar), false)
+			time.Sleep(50 * time.Millisecond)
+			if hold.Load() {
+				return
+			}
+			target, _ := server.Get(opcuaVariableNode(kvpTargetAxisVar))
+			server.Set(opcuaVariableNode(kvpAxisActVar), target)
+			server.Set(opcuaVariableNode(kvpDoneIDVar), value)
+		case opcuaVariableNode(kvpStopVar):
+			moveID, _ := server.Get(opcuaVariableNode(kvpMoveIDVar))
+			server.Set(opcuaVariableNode(kvpDoneIDVar), moveID)
+		}
+	})
+	return server
+}
+
+// opcuaConfig returns the config of an arm connecting to the given fake server.
+func opcuaConfig(t *testing.T, server *opcua.FakeServer) *Config {
+	u, err := url.Parse(server.Endpoint())
+	test.That(t, err, test.ShouldBeNil)
+	host, port, err := net.SplitHostPort(u.Host)
+	test.That(t, err, test.ShouldBeNil)
+	portNum, err := strconv.Atoi(port)
+	test.That(t, err, test.ShouldBeNil)
+	return &Config{IPAddress: host, Port: portNum, Protocol: protocolOPCUA, JointSpeed: 20}
+}
+
+func newTestOPCUAArm(t *testing.T, server *opcua.FakeServer, allowRawCommands bool) *kvpArm {
+	newConf := opcuaConfig(t, server)
+	newConf.AllowRawCommands = allowRawCommands
+	a, err := newOPCUAArm(context.Background(), resource.Config{Name: "arm"}, newConf, logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	t.Cleanup(func() { test.That(t, a.Close(context.Background()), test.ShouldBeNil) })
+	return a
+}
+
+func TestOPCUAArm(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("state", func(t *testing.T) {
+		server := newFakeOPCUAController(t, &atomic.Bool{})
+		a := newTestOPCUAArm(t, server, false)
+		test.That(t, a.moveID.Load(), test.ShouldEqual, 7)
+		speed, _ := server.Get(opcuaVariableNode(kvpJointVelVar))
+		test.That(t, speed, test.ShouldEqual, float32(20))
+
+		joints, err := a.JointPositions(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, joints.Values, test.ShouldResemble, []float64{0, -90, 90, 0, 45, 0})
+
+		pose, err := a.EndPosition(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, pose.Point().X, test.ShouldAlmostEqual, 500)
+		test.That(t, pose.Point().Z, test.ShouldAlmostEqual, 600)
+	})
+
+	t.Run("move", func(t *testing.T) {
+		server := newFakeOPCUAController(t, &atomic.Bool{})
+		a := newTestOPCUAArm(t, server, false)
+
+		target := []float64{10, -80, 85, 5, 40, -5}
+		test.That(t, a.MoveToJointPositions(ctx, &v1.JointPositions{Values: target}, nil), test.ShouldBeNil)
+		moveID, _ := server.Get(opcuaVariableNode(kvpMoveIDVar))
+		test.That(t, moveID, test.ShouldEqual, int32(8))
+		joints, err := a.JointPositions(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, joints.Values, test.ShouldResemble, target)
+
+		isMoving, err := a.IsMoving(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, isMoving, test.ShouldBeFalse)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		hold := &atomic.Bool{}
+		hold.Store(true)
+		server := newFakeOPCUAController(t, hold)
+		a := newTestOPCUAArm(t, server, false)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- a.MoveToJointPositions(ctx, &v1.JointPositions{Values: []float64{10, -80, 85, 5, 40, -5}}, nil)
+		}()
+		time.Sleep(100 * time.Millisecond)
+		isMoving, err := a.IsMoving(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, isMoving, test.ShouldBeTrue)
+
+		test.That(t, a.Stop(ctx, nil), test.ShouldBeNil)
+		test.That(t, <-done, test.ShouldBeNil)
+		stop, _ := server.Get(opcuaVariableNode(kvpStopVar))
+		test.That(t, stop, test.ShouldEqual, true)
+	})
+
+	t.Run("do command", func(t *testing.T) {
+		server := newFakeOPCUAController(t, &atomic.Bool{})
+		a := newTestOPCUAArm(t, server, false)
+
+		// Variables are found ignoring case
+		resp, err := a.DoCommand(ctx, map[string]interface{}{"command": readVariableCommand, "variable": "$ov_pro"})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["value"], test.ShouldEqual, "100")
+		_, err = a.DoCommand(ctx, map[string]interface{}{"command": readVariableCommand, "variable": "$UNKNOWN"})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "not found")
+
+		resp, err = a.DoCommand(ctx, map[string]interface{}{"command": getOperatingModeCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["operating_mode"], test.ShouldEqual, opModeAUT)
+		resp, err = a.DoCommand(ctx, map[string]interface{}{"command": programStatusCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["state"], test.ShouldEqual, "Running")
+
+		_, err = a.DoCommand(ctx, map[string]interface{}{"command": setOverrideCommand, "value": 50.0})
+		test.That(t, err, test.ShouldBeNil)
+		override, _ := server.Get(opcuaVariableNode(kvpOverrideVar))
+		test.That(t, override, test.ShouldEqual, int32(50))
+
+		_, err = a.DoCommand(ctx, map[string]interface{}{"command": setJointSpeedCommand, "value": 35.5})
+		test.That(t, err, test.ShouldBeNil)
+		speed, _ := server.Get(opcuaVariableNode(kvpJointVelVar))
+		test.That(t, speed, test.ShouldEqual, float32(35.5))
+
+		a.allowRawCommands = true
+		writeCmd := map[string]interface{}{"command": writeVariableCommand, "variable": kvpStopVar, "value": "TRUE"}
+		resp, err = a.DoCommand(ctx, writeCmd)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["value"], test.ShouldEqual, "TRUE")
+		writeCmd["value"] = "maybe"
+		_, err = a.DoCommand(ctx, writeCmd)
+		test.That(t, err, test.ShouldNotBeNil)
+
+		_, err = a.DoCommand(ctx, map[string]interface{}{"command": "get_device_info"})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, protocolOPCUA)
+	})
+
+	t.Run("missing globals", func(t *testing.T) {
+		server, err := opcua.NewFakeServer()
+		test.That(t, err, test.ShouldBeNil)
+		defer func() { test.That(t, server.Close(), test.ShouldBeNil) }()
+		server.AddVariable(opcua.ObjectsFolder, opcuaVariableNode(kvpAxisActVar), kvpAxisActVar, testAxisAct)
+
+		_, err = newOPCUAArm(ctx, resource.Config{Name: "arm"}, opcuaConfig(t, server), logging.NewTestLogger(t))
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "viamKvp.dat")
+	})
+}
+
+func TestOPCUAValues(t *testing.T) {
+	for _, tt := range []struct {
+		current interface{}
+		literal string
+		value   interface{}
+	}{
+		{true, "FALSE", false},
+		{int8(0), "-12", int8(-12)},
+		{uint16(0), "300", uint16(300)},
+		{int32(0), "70000", int32(70000)},
+		{uint64(0), "5", uint64(5)},
+		{float32(0), "0.25", float32(0.25)},
+		{0.0, "12.5", 12.5},
+		{"", "{E6AXIS: A1 0.0}", "{E6AXIS: A1 0.0}"},
+	} {
+		value, err := parseOPCUAValue(tt.literal, tt.current)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, value, test.ShouldEqual, tt.value)
+		literal, err := formatOPCUAValue("var", value)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, literal, test.ShouldEqual, tt.literal)
+	}
+
+	_, err := parseOPCUAValue("300", int8(0))
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = parseOPCUAValue("-1", uint32(0))
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = parseOPCUAValue("1", []byte{})
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = formatOPCUAValue("var", []interface{}{1.0})
+	test.That(t, err, test.ShouldNotBeNil)
+}
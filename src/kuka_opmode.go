@@ -0,0 +1,208 @@
+package kuka
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+)
+
+// Operating modes of the kuka controller, as named on the pendant
+const (
+	opModeT1  = "T1"
+	opModeT2  = "T2"
+	opModeAUT = "AUT"
+	opModeEXT = "EXT"
+)
+
+const getOperatingModeCommand = "get_operating_mode"
+
+var (
+	supportedOperatingModes = []string{opModeT1, opModeT2, opModeAUT, opModeEXT}
+	// defaultOperatingModes are the modes motion is allowed in when allowed_operating_modes is not configured.
+	defaultOperatingModes = []string{opModeAUT, opModeEXT}
+)
+
+// operatingModeDescriptions are used to explain refused moves.
+var operatingModeDescriptions = map[string]string{
+	opModeT1:  "manual reduced velocity",
+	opModeT2:  "manual high velocity",
+	opModeAUT: "automatic",
+	opModeEXT: "automatic external",
+}
+
+// isManualMode returns if the operating mode requires an operator at the pendant holding the enabling switch.
+func isManualMode(mode string) bool {
+	return mode == opModeT1 || mode == opModeT2
+}
+
+// parseOperatingMode converts the operating mode reported by the kuka device into its pendant name, returning an
+// empty string if it is not recognized.
+func parseOperatingMode(reported string) string {
+	switch reported {
+	case "T1":
+		return opModeT1
+	case "T2":
+		return opModeT2
+	case "Auto":
+		return opModeAUT
+	case "Extern":
+		return opModeEXT
+	default:
+		return ""
+	}
+}
+
+// parseModeOp converts the operating mode read from $MODE_OP, e.g. "#AUT", into its pendant name, returning an empty
+// string if it is not recognized.
+func parseModeOp(value string) string {
+	switch strings.TrimSpace(value) {
+	case "#T1":
+		return opModeT1
+	case "#T2":
+		return opModeT2
+	case "#AUT":
+		return opModeAUT
+	case "#EX":
+		return opModeEXT
+	default:
+		return ""
+	}
+}
+
+// operatingModePolicy decides which operating modes motion is allowed in. The zero value allows motion in the default
+// modes.
+type operatingModePolicy struct {
+	allowedModes    []string
+	allowManualMode bool
+	t1MaxJointSpeed float64
+}
+
+// newOperatingModePolicy creates the policy for a validated config. Manual modes are allowed by default once
+// allow_manual_mode is set.
+func newOperatingModePolicy(conf *Config) operatingModePolicy {
+	allowedModes := conf.AllowedOperatingModes
+	if len(allowedModes) == 0 && conf.AllowManualMode {
+		allowedModes = supportedOperatingModes
+	}
+	return operatingModePolicy{
+		allowedModes:    allowedModes,
+		allowManualMode: conf.AllowManualMode,
+		t1MaxJointSpeed: conf.T1MaxJointSpeed,
+	}
+}
+
+// validateOperatingModes ensures the configured operating mode policy is consistent.
+func validateOperatingModes(path string, modes []string, allowManualMode bool, t1MaxJointSpeed float64) error {
+	for _, mode := range modes {
+		if _, ok := operatingModeDescriptions[mode]; !ok {
+			return errors.Errorf("%v: unknown operating mode %q in allowed_operating_modes, must be one of %v",
+				path, mode, supportedOperatingModes)
+		}
+		if isManualMode(mode) && !allowManualMode {
+			return errors.Errorf("%v: allowed_operating_modes includes the manual mode %v, which requires allow_manual_mode",
+				path, mode)
+		}
+	}
+	if t1MaxJointSpeed < 0 || t1MaxJointSpeed > 100 {
+		return errors.Errorf("t1_max_joint_speed (%v) must be in the range [0, 100]", t1MaxJointSpeed)
+	}
+	return nil
+}
+
+// modes returns the operating modes motion is allowed in.
+func (policy operatingModePolicy) modes() []string {
+	if len(policy.allowedModes) == 0 {
+		return defaultOperatingModes
+	}
+	return policy.allowedModes
+}
+
+// check returns an error explaining why motion is refused in the given operating mode.
+func (policy operatingModePolicy) check(mode string) error {
+	description, ok := operatingModeDescriptions[mode]
+	if !ok {
+		return errors.New("motion refused, the operating mode of the controller is unknown")
+	}
+	if isManualMode(mode) && !policy.allowManualMode {
+		return errors.Errorf("motion refused, the controller is in %v (%v) and allow_manual_mode is not set", mode, description)
+	}
+	for _, allowed := range policy.modes() {
+		if mode == allowed {
+			return nil
+		}
+	}
+	return errors.Errorf("motion refused, the controller is in %v (%v) which is not one of the allowed operating modes %v",
+		mode, description, policy.modes())
+}
+
+// jointSpeed returns the joint speed to command in the given operating mode.
+func (policy operatingModePolicy) jointSpeed(mode string, speed float64) float64 {
+	if mode == opModeT1 && policy.t1MaxJointSpeed > 0 && speed > policy.t1MaxJointSpeed {
+		return policy.t1MaxJointSpeed
+	}
+	return speed
+}
+
+// checkOperatingMode refreshes the operating mode of the kuka device and returns an error if motion is not allowed in
+// it. Otherwise the joint speed commanded to the device is capped according to the mode, and to maxJointSpeed if given.
+// The joint speed set on the arm is commanded again for the motions that follow once the cap no longer applies.
+func (kuka *kukaArm) checkOperatingMode(maxJointSpeed float64) error {
+	if err := kuka.sendCommand(ekiCommand.GetRobotOperatingMode, ""); err != nil {
+		return err
+	}
+
+	kuka.stateMutex.Lock()
+	mode := parseOperatingMode(kuka.deviceInfo.operatingMode)
+	policy := kuka.opModePolicy
+	requestedSpeed := kuka.currentState.jointSpeed
+	appliedSpeed := kuka.currentState.appliedJointSpeed
+	kuka.stateMutex.Unlock()
+
+	if err := policy.check(mode); err != nil {
+		return err
+	}
+
+	modeSpeed := policy.jointSpeed(mode, requestedSpeed)
+	speed := modeSpeed
+	if maxJointSpeed > 0 && speed > maxJointSpeed {
+		speed = maxJointSpeed
+	}
+	if speed == appliedSpeed {
+		return nil
+	}
+	if modeSpeed != requestedSpeed {
+		kuka.logger.Infof("controller is in %v, limiting joint speed to %v", mode, modeSpeed)
+	}
+	if _, err := kuka.requestReply(context.Background(), ekiCommand.SetJointSpeed, speed); err != nil {
+		return err
+	}
+
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	kuka.currentState.appliedJointSpeed = speed
+	return nil
+}
+
+// getOperatingMode returns the operating mode of the kuka device and whether motion is allowed in it.
+func (kuka *kukaArm) getOperatingMode() (map[string]interface{}, error) {
+	if err := kuka.sendCommand(ekiCommand.GetRobotOperatingMode, ""); err != nil {
+		return nil, err
+	}
+
+	kuka.stateMutex.Lock()
+	reported := kuka.deviceInfo.operatingMode
+	policy := kuka.opModePolicy
+	kuka.stateMutex.Unlock()
+
+	resp := map[string]interface{}{
+		"operating_mode": parseOperatingMode(reported),
+		"motion_allowed": true,
+	}
+	if err := policy.check(parseOperatingMode(reported)); err != nil {
+		resp["motion_allowed"] = false
+		resp["reason"] = err.Error()
+	}
+	return resp, nil
+}
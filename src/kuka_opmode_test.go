@@ -0,0 +1,152 @@
+package kuka
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/viam-soleng/viam-kuka/inject"
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	pb "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/test"
+)
+
+func TestValidateOperatingModes(t *testing.T) {
+	test.That(t, validateOperatingModes("path", nil, false, 0), test.ShouldBeNil)
+	test.That(t, validateOperatingModes("path", []string{opModeEXT, opModeT1}, true, 10), test.ShouldBeNil)
+
+	err := validateOperatingModes("path", []string{"Auto"}, false, 0)
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, `unknown operating mode "Auto"`)
+
+	err = validateOperatingModes("path", []string{opModeT2}, false, 0)
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "requires allow_manual_mode")
+
+	err = validateOperatingModes("path", nil, true, 150)
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "t1_max_joint_speed (150)")
+}
+
+func TestOperatingModePolicy(t *testing.T) {
+	policyTests := []struct {
+		description string
+		conf        Config
+		mode        string
+		errContains string
+	}{
+		{description: "default automatic", mode: opModeAUT},
+		{description: "default external", mode: opModeEXT},
+		{description: "default manual", mode: opModeT1, errContains: "in T1 (manual reduced velocity) and allow_manual_mode is not set"},
+		{description: "unknown", mode: "", errContains: "operating mode of the controller is unknown"},
+		{description: "manual allowed", conf: Config{AllowManualMode: true}, mode: opModeT2},
+		{
+			description: "not allowed",
+			conf:        Config{AllowedOperatingModes: []string{opModeEXT}},
+			mode:        opModeAUT,
+			errContains: "in AUT (automatic) which is not one of the allowed operating modes [EXT]",
+		},
+		{
+			description: "manual not in allowed modes",
+			conf:        Config{AllowedOperatingModes: []string{opModeT1}, AllowManualMode: true},
+			mode:        opModeT2,
+			errContains: "not one of the allowed operating modes [T1]",
+		},
+	}
+
+	for _, tt := range policyTests {
+		t.Run(tt.description, func(t *testing.T) {
+			err := newOperatingModePolicy(&tt.conf).check(tt.mode)
+			if tt.errContains == "" {
+				test.That(t, err, test.ShouldBeNil)
+				return
+			}
+			test.That(t, err, test.ShouldNotBeNil)
+			test.That(t, err.Error(), test.ShouldContainSubstring, tt.errContains)
+		})
+	}
+
+	policy := newOperatingModePolicy(&Config{AllowManualMode: true, T1MaxJointSpeed: 10})
+	test.That(t, policy.jointSpeed(opModeT1, 50), test.ShouldEqual, 10)
+	test.That(t, policy.jointSpeed(opModeT1, 5), test.ShouldEqual, 5)
+	test.That(t, policy.jointSpeed(opModeT2, 50), test.ShouldEqual, 50)
+}
+
+func TestCheckOperatingMode(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	kuka := &kukaArm{
+		logger:       logger,
+		stateMutex:   sync.Mutex{},
+		opModePolicy: newOperatingModePolicy(&Config{AllowManualMode: true, T1MaxJointSpeed: 10}),
+		currentState: state{
+			joints:            make([]float64, numJoints),
+			jointLimits:       make([]referenceframe.Limit, numJoints),
+			jointSpeed:        50,
+			appliedJointSpeed: 50,
+		},
+	}
+	for i := range kuka.currentState.jointLimits {
+		kuka.currentState.jointLimits[i] = referenceframe.Limit{Min: -170, Max: 170}
+	}
+
+	// The injected connection answers operating mode requests with the given mode
+	var mode string
+	var sent []string
+	conn := inject.NewTCPConn()
+	conn.WriteFunc = func(b []byte) (n int, err error) {
+		sent = append(sent, string(b))
+		switch {
+		case strings.HasPrefix(string(b), ekiCommand.GetRobotOperatingMode):
+			kuka.handleRobotResponses(ekiCommand.GetRobotOperatingMode, []string{mode})
+		case strings.HasPrefix(string(b), ekiCommand.SetJointPosition):
+			kuka.handleRobotResponses(ekiCommand.SetJointPosition, []string{"success"})
+		}
+		return len(b), nil
+	}
+	useInjectedConn(t, kuka, conn)
+
+	t.Run("speed capped in T1", func(t *testing.T) {
+		mode = "T1"
+		test.That(t, kuka.checkOperatingMode(0), test.ShouldBeNil)
+		test.That(t, sent, test.ShouldContain, ekiCommand.SetJointSpeed+",10;")
+		test.That(t, kuka.currentState.appliedJointSpeed, test.ShouldEqual, 10)
+		test.That(t, kuka.currentState.jointSpeed, test.ShouldEqual, 50)
+
+		// The requested speed is restored outside of T1
+		mode = "Auto"
+		sent = nil
+		test.That(t, kuka.checkOperatingMode(0), test.ShouldBeNil)
+		test.That(t, sent, test.ShouldContain, ekiCommand.SetJointSpeed+",50;")
+		test.That(t, kuka.currentState.appliedJointSpeed, test.ShouldEqual, 50)
+	})
+
+	t.Run("move refused", func(t *testing.T) {
+		kuka.opModePolicy = newOperatingModePolicy(&Config{})
+		defer func() { kuka.opModePolicy = newOperatingModePolicy(&Config{AllowManualMode: true}) }()
+
+		mode = "T2"
+		sent = nil
+		err := kuka.MoveToJointPositions(ctx, &pb.JointPositions{Values: make([]float64, numJoints)}, nil)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "allow_manual_mode is not set")
+		test.That(t, sent, test.ShouldResemble, []string{ekiCommand.GetRobotOperatingMode + ";"})
+		test.That(t, kuka.currentState.isMoving, test.ShouldBeFalse)
+
+		resp, err := kuka.DoCommand(ctx, map[string]interface{}{"command": getOperatingModeCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["operating_mode"], test.ShouldEqual, opModeT2)
+		test.That(t, resp["motion_allowed"], test.ShouldBeFalse)
+		test.That(t, resp["reason"], test.ShouldContainSubstring, "allow_manual_mode")
+	})
+
+	t.Run("move allowed", func(t *testing.T) {
+		mode = "Extern"
+		err := kuka.MoveToJointPositions(ctx, &pb.JointPositions{Values: make([]float64, numJoints)}, nil)
+		test.That(t, err, test.ShouldBeNil)
+	})
+}
@@ -0,0 +1,115 @@
+package kuka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/utils"
+
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+)
+
+// allowStaleExtra is the extra key of JointPositions and EndPosition that returns the last known values even when they
+// are older than max_state_age_ms
+const allowStaleExtra = "allow_stale"
+
+var (
+	// pollQueries are sent by the state poller to refresh the state of the robot
+	pollQueries = []string{
+		ekiCommand.GetJointPosition,
+		ekiCommand.GetEndPosition,
+		ekiCommand.GetRobotOperatingMode,
+		ekiCommand.GetEKIProgramState,
+	}
+
+	// streamedPollQueries are sent in place of pollQueries while ekiStateStream pushes the joints, end position and
+	// operating mode
+	streamedPollQueries = []string{
+		ekiCommand.GetEKIProgramState,
+	}
+)
+
+// statePoller refreshes the state of the robot at a fixed interval, whether or not the robot is in motion.
+type statePoller struct {
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+// startStatePoller starts refreshing the state of the robot every interval in the background.
+func (kuka *kukaArm) startStatePoller(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &statePoller{cancel: cancel}
+	kuka.poller = p
+
+	p.workers.Add(1)
+	utils.PanicCapturingGo(func() {
+		defer p.workers.Done()
+		kuka.statePollLoop(ctx, interval)
+	})
+}
+
+// stopStatePoller stops the state poller, if running, waiting for the refresh in progress to end.
+func (kuka *kukaArm) stopStatePoller() {
+	p := kuka.poller
+	if p == nil {
+		return
+	}
+	kuka.poller = nil
+
+	p.cancel()
+	p.workers.Wait()
+}
+
+// statePollLoop refreshes the state of the robot until cancelled, logging when refreshes start and stop failing rather
+// than on every failure.
+func (kuka *kukaArm) statePollLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failing := false
+	for {
+		queries := pollQueries
+		if kuka.stateStreamActive() {
+			queries = streamedPollQueries
+		}
+
+		err := kuka.requestAll(ctx, queries...)
+		switch {
+		case ctx.Err() != nil || kuka.closed.Load():
+			return
+		case err != nil && !failing:
+			kuka.logger.Warnf("error polling state of kuka device: %v", err)
+			failing = true
+		case err == nil && failing:
+			kuka.logger.Infof("polling state of kuka device resumed")
+			failing = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkStateAge returns an error if the named value, last reported by the kuka device at updated, is older than
+// maxAge, unless maxAge is zero or the request allows stale values through extra.
+func checkStateAge(name string, updated time.Time, maxAge time.Duration, extra map[string]interface{}) error {
+	if maxAge <= 0 {
+		return nil
+	}
+	if allowStale, _ := extra[allowStaleExtra].(bool); allowStale {
+		return nil
+	}
+	if updated.IsZero() {
+		return errors.Errorf("%v not yet reported by the kuka device", name)
+	}
+	if age := time.Since(updated); age > maxAge {
+		return errors.Errorf("stale %v, last reported by the kuka device %v ago (max_state_age_ms is %v)",
+			name, age.Round(time.Millisecond), maxAge.Milliseconds())
+	}
+	return nil
+}
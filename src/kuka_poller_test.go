@@ -0,0 +1,77 @@
+package kuka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	"go.viam.com/test"
+)
+
+func TestStatePoller(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeEKIServer(t, copyResponses(fakeDeviceResponses))
+	kuka := newConnectedArm(t, server.config())
+	kuka.maxStateAge = 200 * time.Millisecond
+
+	// waitForJoints waits for the polled joint positions to match, failing the test if they do not
+	waitForJoints := func(t *testing.T, expected []float64) {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			joints, err := kuka.JointPositions(ctx, nil)
+			if err == nil && len(joints.Values) > 0 && joints.Values[0] == expected[0] {
+				test.That(t, joints.Values, test.ShouldResemble, expected)
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("joint positions were not polled, last read %v (%v)", joints, err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	t.Run("never reported", func(t *testing.T) {
+		_, err := kuka.JointPositions(ctx, nil)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "joint positions not yet reported")
+	})
+
+	t.Run("refreshed while not moving", func(t *testing.T) {
+		kuka.startStatePoller(10 * time.Millisecond)
+		defer kuka.stopStatePoller()
+
+		waitForJoints(t, []float64{0, 0, 0, 0, 0, 0})
+		server.setResponse(ekiCommand.GetJointPosition, "10,20,30,40,50,60,0,0,0,0,0,0")
+		waitForJoints(t, []float64{10, 20, 30, 40, 50, 60})
+
+		_, err := kuka.EndPosition(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+
+		current := kuka.getCurrentStateSafe()
+		test.That(t, current.programState, test.ShouldEqual, ekiCommand.StatusRunning)
+		for _, updated := range []time.Time{
+			current.jointsUpdated, current.poseUpdated, current.operatingModeUpdated, current.programStateUpdated,
+		} {
+			test.That(t, time.Since(updated), test.ShouldBeLessThan, time.Second)
+		}
+	})
+
+	t.Run("stale once stopped", func(t *testing.T) {
+		kuka.stateMutex.Lock()
+		kuka.currentState.jointsUpdated = time.Now().Add(-time.Second)
+		kuka.currentState.poseUpdated = time.Now().Add(-time.Second)
+		kuka.stateMutex.Unlock()
+
+		_, err := kuka.JointPositions(ctx, nil)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "stale joint positions")
+		_, err = kuka.EndPosition(ctx, nil)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "stale end position")
+
+		joints, err := kuka.JointPositions(ctx, map[string]interface{}{allowStaleExtra: true})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, joints.Values, test.ShouldResemble, []float64{10, 20, 30, 40, 50, 60})
+	})
+}
@@ -0,0 +1,234 @@
+package kuka
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	"go.viam.com/utils"
+)
+
+// DoCommand names for controlling the EKI program on the kuka device
+const (
+	programStatusCommand = "program_status"
+	selectProgramCommand = "select_program"
+	startProgramCommand  = "start_program"
+	stopProgramCommand   = "stop_program"
+	resetProgramCommand  = "reset_program"
+)
+
+// Run modes of the robot interpreter, as named on the pendant
+const (
+	runModeGO    = "GO"
+	runModeMSTEP = "MSTEP"
+	runModeISTEP = "ISTEP"
+	runModeBSTEP = "BSTEP"
+	runModePSTEP = "PSTEP"
+	runModeCSTEP = "CSTEP"
+)
+
+var (
+	// programStateTimeout is how long a program command is given to change the state of the program.
+	programStateTimeout time.Duration = 5 * time.Second
+	// programStatePollInterval is the time between checks of the program state after a program command.
+	programStatePollInterval time.Duration = 250 * time.Millisecond
+)
+
+// parseRunMode converts the run mode reported by the kuka device into its pendant name, returning an empty string if
+// it is not recognized.
+func parseRunMode(reported string) string {
+	switch reported {
+	case "GO":
+		return runModeGO
+	case "MotionStep":
+		return runModeMSTEP
+	case "IncrementalStep":
+		return runModeISTEP
+	case "BackwardStep":
+		return runModeBSTEP
+	case "ProgramStep":
+		return runModePSTEP
+	case "ContinuousStep":
+		return runModeCSTEP
+	default:
+		return ""
+	}
+}
+
+func (kuka *kukaArm) handleRunMode(reply ekiCommand.Reply) {
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	kuka.currentState.runMode = parseRunMode(reply.Text(0))
+}
+
+// handleProgramRefusal records a program command being refused by the kuka device. Successful program commands are
+// handled as any other success response.
+func (kuka *kukaArm) handleProgramRefusal(command, refusal string) {
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	kuka.currentState.programCommandErr = &refusedError{command: command, reply: refusal}
+}
+
+// getRunMode requests and returns the run mode of the robot interpreter.
+func (kuka *kukaArm) getRunMode() (string, error) {
+	if err := kuka.sendCommand(ekiCommand.GetRunMode, ""); err != nil {
+		return "", err
+	}
+	return kuka.getCurrentStateSafe().runMode, nil
+}
+
+// programStatus returns the name and state of the program on the kuka device along with the modes that decide if it
+// can be started remotely.
+func (kuka *kukaArm) programStatus(ctx context.Context) (map[string]interface{}, error) {
+	programState, err := kuka.checkEKIProgramState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	runMode, err := kuka.getRunMode()
+	if err != nil {
+		return nil, err
+	}
+
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	return map[string]interface{}{
+		"name":           kuka.currentState.programName,
+		"state":          programState.String(),
+		"run_mode":       runMode,
+		"operating_mode": parseOperatingMode(kuka.deviceInfo.operatingMode),
+	}, nil
+}
+
+// changeProgramState sends a program command to the kuka device and waits for the program to reach one of the
+// expected states.
+func (kuka *kukaArm) changeProgramState(ctx context.Context, command string, expected ...ekiCommand.ProgramStatus) error {
+	if kuka.getCurrentStateSafe().isMoving {
+		return errors.New("robot is moving, the program cannot be changed until the movement is complete")
+	}
+
+	kuka.stateMutex.Lock()
+	kuka.currentState.programCommandErr = nil
+	kuka.stateMutex.Unlock()
+
+	if err := kuka.sendCommand(command, ""); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(programStateTimeout)
+	for {
+		if err := kuka.getCurrentStateSafe().programCommandErr; err != nil {
+			return err
+		}
+
+		programState, err := kuka.checkEKIProgramState(ctx)
+		if err != nil {
+			return err
+		}
+		for _, state := range expected {
+			if programState == state {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return errors.Errorf("program is %v, expected %v within %v of %v", programState, expected, programStateTimeout, command)
+		}
+		if !utils.SelectContextOrWait(ctx, programStatePollInterval) {
+			return ctx.Err()
+		}
+	}
+}
+
+// selectProgram selects the EKI program so it can be started.
+func (kuka *kukaArm) selectProgram(ctx context.Context) error {
+	return kuka.changeProgramState(ctx, ekiCommand.SelectProgram, ekiCommand.StatusReset)
+}
+
+// startProgram starts the selected EKI program. This is only possible in EXT with the program in GO, as the start key
+// on the pendant must be used in every other mode.
+func (kuka *kukaArm) startProgram(ctx context.Context) error {
+	if err := kuka.sendCommand(ekiCommand.GetRobotOperatingMode, ""); err != nil {
+		return err
+	}
+	kuka.stateMutex.Lock()
+	reported := kuka.deviceInfo.operatingMode
+	kuka.stateMutex.Unlock()
+	if parseOperatingMode(reported) != opModeEXT {
+		return errors.Errorf("the program can only be started remotely in %v, the controller is in %q, start it from the pendant",
+			opModeEXT, reported)
+	}
+
+	runMode, err := kuka.getRunMode()
+	if err != nil {
+		return err
+	}
+	if runMode != runModeGO {
+		return errors.Errorf("the program can only be started remotely in run mode %v, it is in %v", runModeGO, runMode)
+	}
+
+	kuka.logger.Warn("starting the program on the kuka device, the robot will move to its home position")
+	return kuka.changeProgramState(ctx, ekiCommand.StartProgram, ekiCommand.StatusRunning)
+}
+
+// stopProgram stops the EKI program if it is running.
+func (kuka *kukaArm) stopProgram(ctx context.Context) error {
+	programState, err := kuka.checkEKIProgramState(ctx)
+	if err != nil {
+		return err
+	}
+	if programState != ekiCommand.StatusRunning {
+		return nil
+	}
+	return kuka.changeProgramState(ctx, ekiCommand.StopProgram, ekiCommand.StatusStopped)
+}
+
+// resetProgram resets the EKI program to its start.
+func (kuka *kukaArm) resetProgram(ctx context.Context) error {
+	return kuka.changeProgramState(ctx, ekiCommand.ResetProgram, ekiCommand.StatusReset)
+}
+
+// ensureProgramRunning checks the EKI program is running, starting it first when autoStart is set and the controller
+// allows it.
+func (kuka *kukaArm) ensureProgramRunning(ctx context.Context, autoStart bool) error {
+	programState, err := kuka.checkEKIProgramState(ctx)
+	if err != nil {
+		return err
+	}
+	if programState == ekiCommand.StatusRunning {
+		return nil
+	}
+	if !autoStart {
+		return errors.Errorf("associated program on your kuka device is %v, please get the program running before continuing", programState)
+	}
+
+	// A program that has ended or was never selected must be selected before it can be started
+	if programState != ekiCommand.StatusStopped && programState != ekiCommand.StatusReset {
+		if err := kuka.selectProgram(ctx); err != nil {
+			return errors.Wrapf(err, "unable to select the program on your kuka device (%v)", programState)
+		}
+	}
+	if err := kuka.startProgram(ctx); err != nil {
+		return errors.Wrap(err, "unable to start the program on your kuka device")
+	}
+	return nil
+}
+
+// runProgramCommand performs the named program command for DoCommand, returning the resulting program status.
+func (kuka *kukaArm) runProgramCommand(ctx context.Context, name string) (map[string]interface{}, error) {
+	var err error
+	switch name {
+	case selectProgramCommand:
+		err = kuka.selectProgram(ctx)
+	case startProgramCommand:
+		err = kuka.startProgram(ctx)
+	case stopProgramCommand:
+		err = kuka.stopProgram(ctx)
+	case resetProgramCommand:
+		err = kuka.resetProgram(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kuka.programStatus(ctx)
+}
@@ -0,0 +1,139 @@
+package kuka
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/viam-soleng/viam-kuka/inject"
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+// fakeProgramController answers program commands the way the EKI submit program does.
+type fakeProgramController struct {
+	mu            sync.Mutex
+	operatingMode string
+	runMode       string
+	programState  string
+	refuse        map[string]bool
+}
+
+func (c *fakeProgramController) respond(kuka *kukaArm, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	command := strings.TrimSuffix(string(b), ";")
+	if c.refuse[command] {
+		kuka.handleRobotResponses(command, []string{ekiCommand.ResponseNotAllowed})
+		return
+	}
+
+	switch command {
+	case ekiCommand.GetEKIProgramState:
+		kuka.handleRobotResponses(command, []string{"EKIMAIN", c.programState})
+		return
+	case ekiCommand.GetRobotOperatingMode:
+		kuka.handleRobotResponses(command, []string{c.operatingMode})
+		return
+	case ekiCommand.GetRunMode:
+		kuka.handleRobotResponses(command, []string{c.runMode})
+		return
+	case ekiCommand.SelectProgram, ekiCommand.ResetProgram:
+		c.programState = "Reset"
+	case ekiCommand.StartProgram:
+		if c.operatingMode != "Extern" {
+			kuka.handleRobotResponses(command, []string{ekiCommand.ResponseNotAllowed})
+			return
+		}
+		c.programState = "Running"
+	case ekiCommand.StopProgram:
+		c.programState = "Stopped"
+	}
+	kuka.handleRobotResponses(command, []string{"success"})
+}
+
+func TestProgramLifecycle(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	kuka := &kukaArm{
+		logger:     logger,
+		stateMutex: sync.Mutex{},
+	}
+
+	controller := &fakeProgramController{operatingMode: "Extern", runMode: "GO", programState: "Free"}
+	conn := inject.NewTCPConn()
+	conn.WriteFunc = func(b []byte) (n int, err error) {
+		controller.respond(kuka, b)
+		return len(b), nil
+	}
+	useInjectedConn(t, kuka, conn)
+
+	t.Run("not started without auto start", func(t *testing.T) {
+		err := kuka.ensureProgramRunning(ctx, false)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "associated program on your kuka device is Free")
+	})
+
+	t.Run("auto start outside of EXT", func(t *testing.T) {
+		controller.operatingMode = "T1"
+		defer func() { controller.operatingMode = "Extern" }()
+
+		err := kuka.ensureProgramRunning(ctx, true)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, `can only be started remotely in EXT, the controller is in "T1"`)
+	})
+
+	t.Run("auto start in step mode", func(t *testing.T) {
+		controller.runMode = "MotionStep"
+		defer func() { controller.runMode = "GO" }()
+
+		err := kuka.ensureProgramRunning(ctx, true)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "run mode GO, it is in MSTEP")
+	})
+
+	t.Run("auto start", func(t *testing.T) {
+		controller.programState = "Ended"
+		test.That(t, kuka.ensureProgramRunning(ctx, true), test.ShouldBeNil)
+		test.That(t, controller.programState, test.ShouldEqual, "Running")
+	})
+
+	t.Run("do commands", func(t *testing.T) {
+		resp, err := kuka.DoCommand(ctx, map[string]interface{}{"command": stopProgramCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp, test.ShouldResemble, map[string]interface{}{
+			"name":           "EKIMAIN",
+			"state":          "Stopped",
+			"run_mode":       runModeGO,
+			"operating_mode": opModeEXT,
+		})
+
+		resp, err = kuka.DoCommand(ctx, map[string]interface{}{"command": resetProgramCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["state"], test.ShouldEqual, "Reset")
+
+		resp, err = kuka.DoCommand(ctx, map[string]interface{}{"command": startProgramCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["state"], test.ShouldEqual, "Running")
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		controller.refuse = map[string]bool{ekiCommand.SelectProgram: true}
+		_, err := kuka.DoCommand(ctx, map[string]interface{}{"command": selectProgramCommand})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "kuka device refused selectprogram: notAllowed")
+	})
+
+	t.Run("moving", func(t *testing.T) {
+		kuka.currentState.isMoving = true
+		defer func() { kuka.currentState.isMoving = false }()
+
+		_, err := kuka.DoCommand(ctx, map[string]interface{}{"command": resetProgramCommand})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "robot is moving")
+	})
+}
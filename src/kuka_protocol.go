@@ -0,0 +1,112 @@
+package kuka
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+)
+
+// updateInstructions tells how to update the EKI program on the kuka device.
+const updateInstructions = "install a controller package generated by ekipackage to update it"
+
+// protocolInfo is what the EKI program on the kuka device reported of its protocol. A legacy program predates
+// GetProtocolInfo, so what it supports is unknown.
+type protocolInfo struct {
+	legacy   bool
+	version  int
+	commands map[string]bool
+}
+
+// missingCommands returns the commands used by the arm that the EKI program did not report supporting, in order.
+func (info *protocolInfo) missingCommands() []string {
+	if info.legacy {
+		return nil
+	}
+	var missing []string
+	for _, command := range ekiCommand.Commands {
+		if !info.commands[command.Name] {
+			missing = append(missing, command.Name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// checkProtocol asks the EKI program on the kuka device for its protocol version and the commands it supports, logging
+// what is missing and how to update it. The commands it does not support are refused from then on, and state streaming
+// is disabled if it cannot push the state.
+func (kuka *kukaArm) checkProtocol(ctx context.Context) error {
+	_, err := kuka.request(ctx, ekiCommand.GetProtocolInfo, "")
+	var refused *refusedError
+	if errors.As(err, &refused) && refused.reply == ekiCommand.ResponseInvalidCommand {
+		kuka.protocol.Store(&protocolInfo{legacy: true})
+		kuka.logger.Warnf("the EKI program on the kuka device does not report its protocol version, so it predates "+
+			"version %v shipped with this module: %v", ekiCommand.ProtocolVersion, updateInstructions)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	info := kuka.protocol.Load()
+	if info == nil {
+		return errors.Errorf("kuka device gave an invalid reply to %v", ekiCommand.GetProtocolInfo)
+	}
+	missing := info.missingCommands()
+	switch {
+	case len(missing) > 0:
+		kuka.logger.Warnf("the EKI program on the kuka device (protocol version %v, version %v is shipped with this "+
+			"module) does not support %v, which will be refused: %v", info.version, ekiCommand.ProtocolVersion,
+			strings.Join(missing, ", "), updateInstructions)
+	case info.version < ekiCommand.ProtocolVersion:
+		kuka.logger.Warnf("the EKI program on the kuka device is protocol version %v, version %v is shipped with this "+
+			"module: %v", info.version, ekiCommand.ProtocolVersion, updateInstructions)
+	case info.version > ekiCommand.ProtocolVersion:
+		kuka.logger.Warnf("the EKI program on the kuka device is protocol version %v, newer than version %v shipped "+
+			"with this module, update the module", info.version, ekiCommand.ProtocolVersion)
+	}
+
+	if !info.commands[ekiCommand.StateStream] {
+		kuka.stateMutex.Lock()
+		if kuka.stateStreamPeriod > 0 {
+			kuka.logger.Warnf("state_stream_ms is ignored, the EKI program on the kuka device cannot push the state")
+			kuka.stateStreamPeriod = 0
+		}
+		kuka.stateMutex.Unlock()
+	}
+	return nil
+}
+
+// checkSupported returns an error if the EKI program on the kuka device reported it does not support the command.
+func (kuka *kukaArm) checkSupported(EKICommand string) error {
+	info := kuka.protocol.Load()
+	if info == nil || info.legacy || info.commands[strings.ToLower(EKICommand)] {
+		return nil
+	}
+	return errors.Errorf("%v is not supported by the EKI program on the kuka device (protocol version %v): %v",
+		EKICommand, info.version, updateInstructions)
+}
+
+// handleProtocolInfo stores the protocol version and commands reported by the EKI program.
+func (kuka *kukaArm) handleProtocolInfo(reply ekiCommand.Reply) {
+	info := &protocolInfo{version: reply.Int(0), commands: map[string]bool{}}
+	for _, command := range reply.Texts(1) {
+		info.commands[strings.ToLower(command)] = true
+	}
+	kuka.protocol.Store(info)
+}
+
+// refusedError is returned for a command the kuka device replied it did not perform.
+type refusedError struct {
+	command string
+	reply   string
+}
+
+func (e *refusedError) Error() string {
+	return fmt.Sprintf("kuka device refused %v: %v", e.command, e.reply)
+}
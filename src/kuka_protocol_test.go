@@ -0,0 +1,98 @@
+package kuka
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+)
+
+// protocolInfoResponse returns the reply of an EKI program of the current protocol version to GetProtocolInfo,
+// without the given commands.
+func protocolInfoResponse(without ...string) string {
+	skip := map[string]bool{}
+	for _, command := range without {
+		skip[command] = true
+	}
+	var commands []string
+	for _, command := range ekiCommand.Commands {
+		if !skip[command.Name] {
+			commands = append(commands, command.Name)
+		}
+	}
+	sort.Strings(commands)
+	return strconv.Itoa(ekiCommand.ProtocolVersion) + "," + strings.Join(commands, ",")
+}
+
+func TestCheckProtocol(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("supported", func(t *testing.T) {
+		responses := copyResponses(fakeDeviceResponses)
+		responses[ekiCommand.GetProtocolInfo] = protocolInfoResponse()
+		responses[ekiCommand.GetRobotSerialNum] = "12345"
+		responses[ekiCommand.GetRobotType] = "KR10 R900-2"
+		responses[ekiCommand.GetRobotSoftwareVersion] = "8.6"
+		kuka := newConnectedArm(t, newFakeEKIServer(t, responses).config())
+		kuka.stateStreamPeriod = 100 * time.Millisecond
+
+		test.That(t, kuka.checkProtocol(ctx), test.ShouldBeNil)
+		test.That(t, kuka.stateStreamPeriod, test.ShouldEqual, 100*time.Millisecond)
+		_, err := kuka.request(ctx, ekiCommand.SetOverride, "50")
+		test.That(t, err, test.ShouldBeNil)
+
+		info, err := kuka.requestDeviceInfo(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, info.ProtocolVersion, test.ShouldEqual, ekiCommand.ProtocolVersion)
+		test.That(t, info.MissingCommands, test.ShouldBeNil)
+	})
+
+	t.Run("missing commands", func(t *testing.T) {
+		responses := copyResponses(fakeDeviceResponses)
+		responses[ekiCommand.GetProtocolInfo] = protocolInfoResponse(ekiCommand.SetOverride, ekiCommand.StateStream)
+		kuka := newConnectedArm(t, newFakeEKIServer(t, responses).config())
+		kuka.stateStreamPeriod = 100 * time.Millisecond
+
+		test.That(t, kuka.checkProtocol(ctx), test.ShouldBeNil)
+		test.That(t, kuka.stateStreamPeriod, test.ShouldEqual, 0)
+
+		_, err := kuka.request(ctx, ekiCommand.SetOverride, "50")
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "setoverride is not supported")
+		test.That(t, err.Error(), test.ShouldContainSubstring, "ekipackage")
+		err = kuka.requestAll(ctx, ekiCommand.GetRobotName, ekiCommand.SetOverride)
+		test.That(t, err, test.ShouldNotBeNil)
+
+		test.That(t, kuka.protocol.Load().missingCommands(), test.ShouldResemble,
+			[]string{ekiCommand.SetOverride, ekiCommand.StateStream})
+	})
+
+	t.Run("legacy", func(t *testing.T) {
+		responses := copyResponses(fakeDeviceResponses)
+		responses[ekiCommand.GetProtocolInfo] = ekiCommand.ResponseInvalidCommand
+		kuka := newConnectedArm(t, newFakeEKIServer(t, responses).config())
+
+		test.That(t, kuka.checkProtocol(ctx), test.ShouldBeNil)
+		test.That(t, kuka.protocol.Load().legacy, test.ShouldBeTrue)
+		test.That(t, kuka.protocol.Load().missingCommands(), test.ShouldBeNil)
+		_, err := kuka.request(ctx, ekiCommand.SetOverride, "50")
+		test.That(t, err, test.ShouldBeNil)
+	})
+
+	t.Run("invalid reply", func(t *testing.T) {
+		responses := copyResponses(fakeDeviceResponses)
+		responses[ekiCommand.GetProtocolInfo] = "latest"
+		kuka := newConnectedArm(t, newFakeEKIServer(t, responses).config())
+
+		err := kuka.checkProtocol(ctx)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "invalid reply")
+		test.That(t, kuka.checkSupported(ekiCommand.SetOverride), test.ShouldBeNil)
+	})
+}
@@ -0,0 +1,241 @@
+package kuka
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+)
+
+// replyTimeout is how long the kuka device is given to reply to a command, unless command_timeout_ms is set.
+var replyTimeout time.Duration = 2 * time.Second
+
+// refusalReplies are the replies of the kuka device to a command it did not perform.
+var refusalReplies = map[string]bool{
+	ekiCommand.ResponseInvalidValue:   true,
+	ekiCommand.ResponseInvalidCommand: true,
+	ekiCommand.ResponseBusy:           true,
+	ekiCommand.ResponseNotAllowed:     true,
+}
+
+// request sends a command to the kuka device and waits for its reply, returning the values replied. A reply refusing
+// the command is returned as an error.
+func (kuka *kukaArm) request(ctx context.Context, EKICommand, args string) ([]string, error) {
+	if err := kuka.checkSupported(EKICommand); err != nil {
+		return nil, err
+	}
+	waiter := kuka.addReplyWaiter(EKICommand)
+
+	closed := kuka.connectionClosed()
+	if err := kuka.writeCommand(EKICommand, args); err != nil {
+		kuka.removeReplyWaiter(EKICommand, waiter, nil)
+		return nil, err
+	}
+	defer kuka.removeReplyWaiter(EKICommand, waiter, closed)
+	return kuka.awaitReply(ctx, EKICommand, waiter, closed)
+}
+
+// requestStop sends setStop to the kuka device ahead of every command waiting to be written, on the stop channel if
+// one is open, and waits for its reply.
+func (kuka *kukaArm) requestStop(ctx context.Context) error {
+	if err := kuka.checkSupported(ekiCommand.SetStop); err != nil {
+		return err
+	}
+	waiter := kuka.addReplyWaiter(ekiCommand.SetStop)
+
+	closed, err := kuka.writeStop()
+	if err != nil {
+		kuka.removeReplyWaiter(ekiCommand.SetStop, waiter, nil)
+		return err
+	}
+	defer kuka.removeReplyWaiter(ekiCommand.SetStop, waiter, closed)
+	_, err = kuka.awaitReply(ctx, ekiCommand.SetStop, waiter, closed)
+	return err
+}
+
+// requestReply sends a command of the registry with the given arguments, encoded as the registry describes, waits for
+// its reply and returns it decoded.
+func (kuka *kukaArm) requestReply(ctx context.Context, EKICommand string, args ...interface{}) (ekiCommand.Reply, error) {
+	command, encoded, err := encodeCommand(EKICommand, args...)
+	if err != nil {
+		return ekiCommand.Reply{}, err
+	}
+	values, err := kuka.request(ctx, command.Name, encoded)
+	if err != nil {
+		return ekiCommand.Reply{}, err
+	}
+	return command.Decode(values)
+}
+
+// encodeCommand returns the command of the registry of the given name along with its arguments encoded.
+func encodeCommand(EKICommand string, args ...interface{}) (*ekiCommand.Command, string, error) {
+	command, ok := ekiCommand.Lookup(EKICommand)
+	if !ok {
+		return nil, "", errors.Errorf("unknown command (%v) given", EKICommand)
+	}
+	encoded, err := command.Encode(args...)
+	if err != nil {
+		return nil, "", err
+	}
+	return command, encoded, nil
+}
+
+// floatArgs returns float values as arguments of a command.
+func floatArgs(values ...float64) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
+
+// requestAll sends queries to the kuka device without waiting between them, then waits for every reply. The device
+// answers them in the order sent, so independent queries are pipelined rather than each waiting a round trip.
+func (kuka *kukaArm) requestAll(ctx context.Context, EKICommands ...string) error {
+	for _, command := range EKICommands {
+		if err := kuka.checkSupported(command); err != nil {
+			return err
+		}
+	}
+	waiters := make([]*replyWaiter, len(EKICommands))
+	for i, command := range EKICommands {
+		waiters[i] = kuka.addReplyWaiter(command)
+	}
+
+	closed := kuka.connectionClosed()
+	written := 0
+	defer func() {
+		for i, command := range EKICommands {
+			if i < written {
+				kuka.removeReplyWaiter(command, waiters[i], closed)
+			} else {
+				kuka.removeReplyWaiter(command, waiters[i], nil)
+			}
+		}
+	}()
+	for _, command := range EKICommands {
+		if err := kuka.writeCommand(command, ""); err != nil {
+			return err
+		}
+		written++
+	}
+	for i, command := range EKICommands {
+		if _, err := kuka.awaitReply(ctx, command, waiters[i], closed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commandTimeout returns how long the kuka device is given to reply to a command.
+func (kuka *kukaArm) commandTimeout() time.Duration {
+	kuka.replyMutex.Lock()
+	defer kuka.replyMutex.Unlock()
+	if kuka.commandReplyTimeout > 0 {
+		return kuka.commandReplyTimeout
+	}
+	return replyTimeout
+}
+
+// replyWaiter is a request waiting on the reply to its command.
+type replyWaiter struct {
+	reply chan []string
+	// abandoned is set once the request has ended without its reply, which is then dropped when it arrives
+	abandoned bool
+}
+
+// addReplyWaiter registers a wait for the reply to a command, which must be done before the command is written.
+func (kuka *kukaArm) addReplyWaiter(EKICommand string) *replyWaiter {
+	waiter := &replyWaiter{reply: make(chan []string, 1)}
+
+	kuka.replyMutex.Lock()
+	defer kuka.replyMutex.Unlock()
+	if kuka.replyWaiters == nil {
+		kuka.replyWaiters = map[string][]*replyWaiter{}
+	}
+	kuka.replyWaiters[EKICommand] = append(kuka.replyWaiters[EKICommand], waiter)
+	return waiter
+}
+
+// awaitReply waits for the reply to a command written to the kuka device.
+func (kuka *kukaArm) awaitReply(ctx context.Context, EKICommand string, waiter *replyWaiter, closed <-chan struct{}) ([]string, error) {
+	timeout := kuka.commandTimeout()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-closed:
+		return nil, errConnectionClosed
+	case <-timer.C:
+		return nil, errors.Errorf("kuka device did not reply to %v within %v", EKICommand, timeout)
+	case reply := <-waiter.reply:
+		if len(reply) == 1 && refusalReplies[reply[0]] {
+			return nil, &refusedError{command: EKICommand, reply: reply[0]}
+		}
+		return reply, nil
+	}
+}
+
+// deliverReply passes a response to the oldest request waiting on its command, if any. The kuka device replies to
+// the commands of a connection in the order they were written, so a response for an abandoned request is its late
+// reply, which is dropped rather than given to a later request for the same command.
+func (kuka *kukaArm) deliverReply(command string, args []string) {
+	kuka.replyMutex.Lock()
+	defer kuka.replyMutex.Unlock()
+
+	waiters := kuka.replyWaiters[command]
+	if len(waiters) == 0 {
+		return
+	}
+	kuka.replyWaiters[command] = waiters[1:]
+	if waiters[0].abandoned {
+		kuka.logger.Debugf("dropping late reply to %v: %v", command, args)
+		return
+	}
+	waiters[0].reply <- args
+}
+
+// removeReplyWaiter stops a request waiting on its command, for when it ends without a reply. If the command was
+// written to a connection that is still open, given by closed, its reply may yet arrive, so the waiter is instead left
+// in place, abandoned, for the reply to be dropped.
+func (kuka *kukaArm) removeReplyWaiter(command string, waiter *replyWaiter, closed <-chan struct{}) {
+	kuka.replyMutex.Lock()
+	defer kuka.replyMutex.Unlock()
+
+	waiters := kuka.replyWaiters[command]
+	for i, w := range waiters {
+		if w != waiter {
+			continue
+		}
+		if closed != nil {
+			select {
+			case <-closed:
+			default:
+				waiter.abandoned = true
+				return
+			}
+		}
+		kuka.replyWaiters[command] = append(waiters[:i:i], waiters[i+1:]...)
+		return
+	}
+}
+
+// dropAbandonedReplyWaiters removes the requests abandoned on a previous connection, whose replies will not arrive.
+func (kuka *kukaArm) dropAbandonedReplyWaiters() {
+	kuka.replyMutex.Lock()
+	defer kuka.replyMutex.Unlock()
+
+	for command, waiters := range kuka.replyWaiters {
+		kept := waiters[:0:0]
+		for _, waiter := range waiters {
+			if !waiter.abandoned {
+				kept = append(kept, waiter)
+			}
+		}
+		kuka.replyWaiters[command] = kept
+	}
+}
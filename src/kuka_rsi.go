@@ -0,0 +1,470 @@
+package kuka
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	pb "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/rdk/services/motion"
+	"go.viam.com/rdk/spatialmath"
+	"go.viam.com/rdk/utils"
+
+	"github.com/viam-soleng/viam-kuka/src/rsi"
+)
+
+const (
+	defaultRSIPort            int     = 49152
+	defaultRSICycleMs         int     = 4
+	defaultRSIMaxMissedCycles int     = 10
+	defaultRSIMaxStep         float64 = 0.1
+
+	// rsiTolerance is how close, in degrees or millimeters, the robot must come to the target of a move
+	rsiTolerance float64 = 0.05
+	// defaultRSIJogDuration is how long a jog lasts unless given, so that jogs stop when no longer sent
+	defaultRSIJogDuration time.Duration = 100 * time.Millisecond
+)
+
+// DoCommand names of the rsi arm
+const (
+	rsiJogCommand    = "rsi_jog"
+	rsiStatusCommand = "rsi_status"
+)
+
+// rsiSessionConfig returns the settings of the RSI session that match the config.
+func (cfg *Config) rsiSessionConfig() rsi.Config {
+	sessionConf := rsi.Config{
+		Address:         fmt.Sprintf(":%v", cfg.RSIPort),
+		RobotIP:         cfg.IPAddress,
+		SensorType:      cfg.RSISensorType,
+		Mode:            rsi.CorrectionMode(cfg.RSICorrection),
+		Cycle:           time.Duration(cfg.RSICycleMs) * time.Millisecond,
+		MaxMissedCycles: cfg.RSIMaxMissedCycles,
+		MaxCorrection:   cfg.RSIMaxStep,
+	}
+	if cfg.RSIPort == 0 {
+		sessionConf.Address = fmt.Sprintf(":%v", defaultRSIPort)
+	}
+	if sessionConf.Mode == "" {
+		sessionConf.Mode = rsi.JointCorrection
+	}
+	if sessionConf.Cycle == 0 {
+		sessionConf.Cycle = time.Duration(defaultRSICycleMs) * time.Millisecond
+	}
+	if sessionConf.MaxMissedCycles == 0 {
+		sessionConf.MaxMissedCycles = defaultRSIMaxMissedCycles
+	}
+	if sessionConf.MaxCorrection == 0 {
+		sessionConf.MaxCorrection = defaultRSIMaxStep
+	}
+	return sessionConf
+}
+
+// validateRSI validates the settings of the RSI session.
+func (cfg *Config) validateRSI(path string) error {
+	if err := cfg.rsiSessionConfig().Validate(); err != nil {
+		return errors.Wrapf(err, "%v: invalid rsi settings", path)
+	}
+	return cfg.validateEKIOnly(path)
+}
+
+// checkModelJointLimits checks the given joint positions, in degrees, are within the limits of the model, for arms
+// whose limits are not read from the kuka device.
+func checkModelJointLimits(model referenceframe.Model, joints []float64) error {
+	if len(joints) != numJoints {
+		return errors.Errorf("%v joint positions given, the arm has %v", len(joints), numJoints)
+	}
+	for i, limit := range model.DoF() {
+		min, max := utils.RadToDeg(limit.Min), utils.RadToDeg(limit.Max)
+		if joints[i] <= min || joints[i] >= max {
+			return errors.Errorf("invalid joint position specified,  %v is outside of joint[%v] limits [%v, %v]",
+				joints[i], i, min, max)
+		}
+	}
+	return nil
+}
+
+// rsiArm is an arm driven by the corrections of an RSI session rather than by the EKI program, for correction cycles
+// of a few milliseconds. The robot must be running a program whose RSI context sends to the arm and applies the
+// corrections in relative mode.
+type rsiArm struct {
+	resource.Named
+	resource.AlwaysRebuild
+	logger logging.Logger
+
+	model   referenceframe.Model
+	session *rsi.Session
+	maxStep float64
+
+	mu sync.Mutex
+	// motion is the move or jog being corrected, if any
+	motion rsiCorrector
+}
+
+// rsiCorrector is a motion driven by the session, which can be stopped.
+type rsiCorrector interface {
+	rsi.Corrector
+	stop()
+	active() bool
+}
+
+// newRSIArm creates an arm answering the RSI context of the robot.
+func newRSIArm(conf resource.Config, newConf *Config, logger logging.Logger) (*rsiArm, error) {
+	model, err := loadModel(newConf.Model, conf.ResourceName().ShortName(), logger)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionConf := newConf.rsiSessionConfig()
+	session, err := rsi.Listen(sessionConf, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("answering rsi telegrams from %v on %v", newConf.IPAddress, session.Addr())
+
+	return &rsiArm{
+		Named:   conf.ResourceName().AsNamed(),
+		logger:  logger,
+		model:   model,
+		session: session,
+		maxStep: sessionConf.MaxCorrection,
+	}, nil
+}
+
+// Close stops the motion in progress and stops answering the robot.
+func (a *rsiArm) Close(ctx context.Context) error {
+	a.stopMotion()
+	return a.session.Close()
+}
+
+// JointPositions returns the axis positions last reported by the robot.
+func (a *rsiArm) JointPositions(ctx context.Context, extra map[string]interface{}) (*pb.JointPositions, error) {
+	state, _, err := a.session.State()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.JointPositions{Values: state.Joints[:]}, nil
+}
+
+// EndPosition returns the cartesian position last reported by the robot.
+func (a *rsiArm) EndPosition(ctx context.Context, extra map[string]interface{}) (spatialmath.Pose, error) {
+	state, _, err := a.session.State()
+	if err != nil {
+		return nil, err
+	}
+	return kukaFrameToPose(state.Pose[:]), nil
+}
+
+// MoveToPosition moves the arm to the given pose, through the corrections of the tool with cartesian corrections and
+// through motion planning otherwise.
+func (a *rsiArm) MoveToPosition(ctx context.Context, pose spatialmath.Pose, extra map[string]interface{}) error {
+	if a.session.Mode() != rsi.CartesianCorrection {
+		return motion.MoveArm(ctx, a.logger, a, pose)
+	}
+
+	frame := poseToKRLFrame(pose)
+	target := [6]float64{frame.X, frame.Y, frame.Z, frame.A, frame.B, frame.C}
+	return a.move(ctx, newRSIMove(rsi.CartesianCorrection, target, a.maxStep))
+}
+
+// MoveToJointPositions moves the axes to the given positions through joint corrections.
+func (a *rsiArm) MoveToJointPositions(ctx context.Context, positionDegs *pb.JointPositions, extra map[string]interface{}) error {
+	if a.session.Mode() != rsi.JointCorrection {
+		return errors.Errorf("joint moves require rsi_correction %v", rsi.JointCorrection)
+	}
+
+	joints := positionDegs.Values
+	if err := checkModelJointLimits(a.model, joints); err != nil {
+		return err
+	}
+
+	var target [6]float64
+	copy(target[:], joints)
+	return a.move(ctx, newRSIMove(rsi.JointCorrection, target, a.maxStep))
+}
+
+// move corrects the robot toward the target of the move until it is reached, stopped or ctx is done.
+func (a *rsiArm) move(ctx context.Context, m *rsiMove) error {
+	a.mu.Lock()
+	if a.motion != nil && a.motion.active() {
+		a.mu.Unlock()
+		return errors.New("robot is still moving")
+	}
+	a.motion = m
+	a.session.SetCorrector(m)
+	a.mu.Unlock()
+
+	defer a.endMotion(m)
+
+	timer := time.NewTimer(motionTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return errors.Errorf("robot did not reach the target within %v", motionTimeout)
+	case err := <-m.done:
+		return err
+	case <-m.stopped:
+		return nil
+	}
+}
+
+// endMotion holds the robot in place if the given motion is still being corrected.
+func (a *rsiArm) endMotion(m rsiCorrector) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.motion == m {
+		a.session.SetCorrector(nil)
+		a.motion = nil
+	}
+}
+
+// stopMotion holds the robot in place, ending the motion in progress.
+func (a *rsiArm) stopMotion() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.session.SetCorrector(nil)
+	if a.motion != nil {
+		a.motion.stop()
+		a.motion = nil
+	}
+}
+
+// IsMoving returns whether a move or jog is being corrected.
+func (a *rsiArm) IsMoving(ctx context.Context) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.motion != nil && a.motion.active(), nil
+}
+
+// Stop holds the robot in place.
+func (a *rsiArm) Stop(ctx context.Context, extra map[string]interface{}) error {
+	a.stopMotion()
+	return nil
+}
+
+// ModelFrame returns the model of the arm.
+func (a *rsiArm) ModelFrame() referenceframe.Model {
+	return a.model
+}
+
+// CurrentInputs returns the current joint positions in the form of Inputs.
+func (a *rsiArm) CurrentInputs(ctx context.Context) ([]referenceframe.Input, error) {
+	joints, err := a.JointPositions(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return a.model.InputFromProtobuf(joints), nil
+}
+
+// GoToInputs moves through the given inputSteps using sequential calls to MoveToJointPositions.
+func (a *rsiArm) GoToInputs(ctx context.Context, inputSteps ...[]referenceframe.Input) error {
+	for _, goal := range inputSteps {
+		if err := a.MoveToJointPositions(ctx, a.model.ProtobufFromInput(goal), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Geometries returns the geometries of the arm at its current joint positions.
+func (a *rsiArm) Geometries(ctx context.Context, extra map[string]interface{}) ([]spatialmath.Geometry, error) {
+	inputs, err := a.CurrentInputs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	geometries, err := a.model.Geometries(inputs)
+	if err != nil {
+		return nil, err
+	}
+	return geometries.Geometries(), nil
+}
+
+// DoCommand handles jogging, for visual servoing and teleoperation, and reports the state of the session.
+func (a *rsiArm) DoCommand(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	switch cmd["command"] {
+	case rsiJogCommand:
+		return a.jog(cmd)
+	case rsiStatusCommand:
+		stats := a.session.Stats()
+		return map[string]interface{}{
+			"connected":      stats.Connected,
+			"telegrams":      stats.Telegrams,
+			"missed_cycles":  stats.MissedCycles,
+			"watchdog_trips": stats.WatchdogTrips,
+			"delay":          stats.Delay,
+			"ipoc":           stats.LastIPOC,
+			"correction":     string(a.session.Mode()),
+		}, nil
+	default:
+		return nil, errors.Errorf("unknown command (%v) given, %v and %v are available with protocol %v",
+			cmd["command"], rsiJogCommand, rsiStatusCommand, protocolRSI)
+	}
+}
+
+// jog corrects the robot at the given "velocity", in degrees or millimeters per second, for "duration_ms", replacing
+// any jog in progress. Jogs are expected to be sent again before they end for continuous motion.
+func (a *rsiArm) jog(cmd map[string]interface{}) (map[string]interface{}, error) {
+	velocity, err := getFloatListArg(cmd, "velocity")
+	if err != nil {
+		return nil, err
+	}
+	if len(velocity) != numJoints {
+		return nil, errors.Errorf("velocity must have %v values, %v given", numJoints, len(velocity))
+	}
+	duration := defaultRSIJogDuration
+	if _, ok := cmd["duration_ms"]; ok {
+		ms, err := getFloatArg(cmd, "duration_ms")
+		if err != nil {
+			return nil, err
+		}
+		if ms <= 0 || ms > float64(motionTimeout.Milliseconds()) {
+			return nil, errors.Errorf("duration_ms (%v) must be in the range (0, %v]", ms, motionTimeout.Milliseconds())
+		}
+		duration = time.Duration(ms * float64(time.Millisecond))
+	}
+
+	cycleSeconds := a.session.Cycle().Seconds()
+	var step [6]float64
+	for i, v := range velocity {
+		step[i] = v * cycleSeconds
+		if math.Abs(step[i]) > a.maxStep {
+			return nil, errors.Errorf("velocity (%v) exceeds rsi_max_step (%v) per %v cycle",
+				v, a.maxStep, a.session.Cycle())
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if m, ok := a.motion.(*rsiMove); ok && m.active() {
+		return nil, errors.New("robot is executing a move, stop it before jogging")
+	}
+	j := &rsiJog{step: step, until: time.Now().Add(duration), logger: a.logger}
+	a.motion = j
+	a.session.SetCorrector(j)
+	return map[string]interface{}{"velocity": velocity, "duration_ms": duration.Milliseconds()}, nil
+}
+
+// rsiMove steps a commanded position from where the robot was toward the target, by at most maxStep each cycle, and
+// ends once the robot reports being at the target. Correct and Abort are only called by the session, one at a time.
+type rsiMove struct {
+	mode      rsi.CorrectionMode
+	target    [6]float64
+	maxStep   float64
+	commanded [6]float64
+	started   bool
+	finished  bool
+
+	done        chan error
+	stopped     chan struct{}
+	stopOnce    sync.Once
+	activeMutex sync.Mutex
+	isActive    bool
+}
+
+func newRSIMove(mode rsi.CorrectionMode, target [6]float64, maxStep float64) *rsiMove {
+	return &rsiMove{
+		mode:     mode,
+		target:   target,
+		maxStep:  maxStep,
+		done:     make(chan error, 1),
+		stopped:  make(chan struct{}),
+		isActive: true,
+	}
+}
+
+func (m *rsiMove) Correct(state rsi.State) [6]float64 {
+	actual := state.Joints
+	if m.mode == rsi.CartesianCorrection {
+		actual = state.Pose
+	}
+	if !m.started {
+		m.commanded = actual
+		m.started = true
+	}
+
+	var correction [6]float64
+	reached := true
+	for i := range correction {
+		step := math.Max(-m.maxStep, math.Min(m.maxStep, m.difference(i, m.target[i], m.commanded[i])))
+		m.commanded[i] += step
+		correction[i] = step
+		if math.Abs(m.difference(i, m.target[i], actual[i])) > rsiTolerance {
+			reached = false
+		}
+	}
+	if reached {
+		m.finish(nil)
+	}
+	return correction
+}
+
+func (m *rsiMove) Abort(err error) {
+	m.finish(errors.Wrap(err, "move aborted"))
+}
+
+// difference returns target - value, the a,b,c angles of a cartesian move taking the shorter way around.
+func (m *rsiMove) difference(i int, target, value float64) float64 {
+	diff := target - value
+	if m.mode == rsi.CartesianCorrection && i >= 3 {
+		diff = math.Remainder(diff, 360)
+	}
+	return diff
+}
+
+func (m *rsiMove) finish(err error) {
+	if m.finished {
+		return
+	}
+	m.finished = true
+	m.setActive(false)
+	m.done <- err
+}
+
+func (m *rsiMove) stop() {
+	m.setActive(false)
+	m.stopOnce.Do(func() { close(m.stopped) })
+}
+
+func (m *rsiMove) setActive(isActive bool) {
+	m.activeMutex.Lock()
+	defer m.activeMutex.Unlock()
+	m.isActive = isActive
+}
+
+func (m *rsiMove) active() bool {
+	m.activeMutex.Lock()
+	defer m.activeMutex.Unlock()
+	return m.isActive
+}
+
+// rsiJog corrects the robot by a fixed step each cycle until its time runs out.
+type rsiJog struct {
+	step   [6]float64
+	until  time.Time
+	logger logging.Logger
+}
+
+func (j *rsiJog) Correct(state rsi.State) [6]float64 {
+	if !j.active() {
+		return [6]float64{}
+	}
+	return j.step
+}
+
+func (j *rsiJog) Abort(err error) {
+	j.logger.Warnf("jog aborted: %v", err)
+}
+
+func (j *rsiJog) stop() {}
+
+func (j *rsiJog) active() bool {
+	return time.Now().Before(j.until)
+}
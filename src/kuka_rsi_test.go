@@ -0,0 +1,163 @@
+package kuka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+
+	"github.com/viam-soleng/viam-kuka/src/rsi"
+)
+
+// newTestRSIArm returns an rsi arm answering a simulated robot.
+func newTestRSIArm(t *testing.T, mode rsi.CorrectionMode) (*rsiArm, *rsi.Simulator) {
+	logger := logging.NewTestLogger(t)
+	cfg := &Config{IPAddress: "127.0.0.1", RSICorrection: string(mode)}
+	sessionConf := cfg.rsiSessionConfig()
+	sessionConf.Address = "127.0.0.1:0"
+
+	session, err := rsi.Listen(sessionConf, logger)
+	test.That(t, err, test.ShouldBeNil)
+	model, err := loadModel(kr10r900, "test", logger)
+	test.That(t, err, test.ShouldBeNil)
+	a := &rsiArm{logger: logger, model: model, session: session, maxStep: sessionConf.MaxCorrection}
+	t.Cleanup(func() { test.That(t, a.Close(context.Background()), test.ShouldBeNil) })
+
+	initial := rsi.State{Joints: [6]float64{0, -90, 90, 0, 45, 0}, Pose: [6]float64{500, 0, 600, 0, 45, 179.9}, IPOC: 1}
+	sim, err := rsi.NewSimulator(session.Addr().String(), sessionConf.Mode, sessionConf.Cycle, initial)
+	test.That(t, err, test.ShouldBeNil)
+	t.Cleanup(func() { test.That(t, sim.Close(), test.ShouldBeNil) })
+
+	deadline := time.Now().Add(2 * time.Second)
+	for session.Stats().Telegrams == 0 {
+		test.That(t, time.Now().Before(deadline), test.ShouldBeTrue)
+		time.Sleep(time.Millisecond)
+	}
+	return a, sim
+}
+
+func TestRSIArm(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("joint move", func(t *testing.T) {
+		a, sim := newTestRSIArm(t, rsi.JointCorrection)
+		joints, err := a.JointPositions(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, joints.Values, test.ShouldResemble, []float64{0, -90, 90, 0, 45, 0})
+
+		target := []float64{3, -89, 90, 0, 44.5, 1}
+		test.That(t, a.MoveToJointPositions(ctx, &v1.JointPositions{Values: target}, nil), test.ShouldBeNil)
+		for i, value := range sim.State().Joints {
+			test.That(t, value, test.ShouldAlmostEqual, target[i], rsiTolerance)
+		}
+		isMoving, err := a.IsMoving(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, isMoving, test.ShouldBeFalse)
+
+		err = a.MoveToJointPositions(ctx, &v1.JointPositions{Values: []float64{200, -90, 90, 0, 45, 0}}, nil)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "outside of joint[0] limits")
+	})
+
+	t.Run("cartesian move", func(t *testing.T) {
+		a, sim := newTestRSIArm(t, rsi.CartesianCorrection)
+		err := a.MoveToJointPositions(ctx, &v1.JointPositions{Values: []float64{0, -90, 90, 0, 45, 0}}, nil)
+		test.That(t, err, test.ShouldNotBeNil)
+
+		// c goes the short way around from 179.9 to -179.5
+		target := []float64{502, 1, 599, 0, 45, -179.5}
+		test.That(t, a.MoveToPosition(ctx, kukaFrameToPose(target), nil), test.ShouldBeNil)
+		pose := sim.State().Pose
+		test.That(t, pose[0], test.ShouldAlmostEqual, 502, rsiTolerance)
+		test.That(t, pose[2], test.ShouldAlmostEqual, 599, rsiTolerance)
+		test.That(t, pose[5], test.ShouldAlmostEqual, 180.5, rsiTolerance)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		a, sim := newTestRSIArm(t, rsi.JointCorrection)
+		done := make(chan error, 1)
+		go func() {
+			done <- a.MoveToJointPositions(ctx, &v1.JointPositions{Values: []float64{90, -90, 90, 0, 45, 0}}, nil)
+		}()
+		for sim.State().Joints[0] < 1 {
+			time.Sleep(time.Millisecond)
+		}
+		isMoving, err := a.IsMoving(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, isMoving, test.ShouldBeTrue)
+
+		test.That(t, a.Stop(ctx, nil), test.ShouldBeNil)
+		test.That(t, <-done, test.ShouldBeNil)
+		time.Sleep(20 * time.Millisecond)
+		joint := sim.State().Joints[0]
+		time.Sleep(20 * time.Millisecond)
+		test.That(t, sim.State().Joints[0], test.ShouldEqual, joint)
+		test.That(t, joint, test.ShouldBeLessThan, 90)
+	})
+
+	t.Run("watchdog aborts move", func(t *testing.T) {
+		a, sim := newTestRSIArm(t, rsi.JointCorrection)
+		done := make(chan error, 1)
+		go func() {
+			done <- a.MoveToJointPositions(ctx, &v1.JointPositions{Values: []float64{90, -90, 90, 0, 45, 0}}, nil)
+		}()
+		for sim.State().Joints[0] < 1 {
+			time.Sleep(time.Millisecond)
+		}
+		sim.Pause(true)
+		err := <-done
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "move aborted")
+
+		_, err = a.JointPositions(ctx, nil)
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("jog", func(t *testing.T) {
+		a, sim := newTestRSIArm(t, rsi.JointCorrection)
+		_, err := a.DoCommand(ctx, map[string]interface{}{"command": rsiJogCommand, "velocity": []interface{}{100.0, 0.0, 0.0, 0.0, 0.0, 0.0}})
+		test.That(t, err, test.ShouldNotBeNil)
+
+		resp, err := a.DoCommand(ctx, map[string]interface{}{
+			"command":     rsiJogCommand,
+			"velocity":    []interface{}{0.0, 0.0, 0.0, 0.0, 0.0, -10.0},
+			"duration_ms": 100.0,
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["duration_ms"], test.ShouldEqual, 100)
+		time.Sleep(150 * time.Millisecond)
+		isMoving, err := a.IsMoving(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, isMoving, test.ShouldBeFalse)
+		test.That(t, sim.State().Joints[5], test.ShouldBeBetween, -1.1, -0.5)
+
+		status, err := a.DoCommand(ctx, map[string]interface{}{"command": rsiStatusCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, status["connected"], test.ShouldBeTrue)
+		test.That(t, status["correction"], test.ShouldEqual, "joint")
+	})
+}
+
+func TestValidateRSI(t *testing.T) {
+	cfg := &Config{IPAddress: "10.0.0.2", Protocol: protocolRSI}
+	_, err := cfg.Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+
+	cfg.RSICycleMs = 8
+	_, err = cfg.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "invalid rsi settings")
+
+	cfg.RSICycleMs = 0
+	cfg.InputController = "gamepad"
+	_, err = cfg.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+
+	cfg = &Config{IPAddress: "10.0.0.2", Protocol: "modbus"}
+	_, err = cfg.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "protocol (modbus)")
+}
@@ -0,0 +1,403 @@
+package kuka
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	pb "go.viam.com/api/component/arm/v1"
+
+	gutils "go.viam.com/utils"
+)
+
+// DoCommand names for running motion sequences
+const (
+	runSequenceCommand    = "run_sequence"
+	sequenceStatusCommand = "sequence_status"
+	pauseSequenceCommand  = "pause_sequence"
+	resumeSequenceCommand = "resume_sequence"
+	abortSequenceCommand  = "abort_sequence"
+)
+
+// errNoSequence is returned when aborting while no sequence is running.
+var errNoSequence = errors.New("no sequence is running")
+
+// Types of steps in a motion sequence
+const (
+	stepMoveJoints    = "move_joints"         // joints: [a1,a2,a3,a4,a5,a6] in degrees
+	stepMoveCartesian = "move_cartesian"      // pose: [x,y,z,a,b,c] in the active base and tool, optional status and turn
+	stepMoveLinear    = "move_linear"         // pose: [x,y,z,a,b,c] in the active base and tool
+	stepSetJointSpeed = "set_joint_speed"     // value: percentage of maximum joint speed
+	stepSetCartSpeed  = "set_cartesian_speed" // value: linear speed in m/s
+	stepSetOverride   = "set_override"        // value: percentage of programmed speed
+	stepWait          = "wait"                // seconds: time to wait
+	stepSetTool       = "set_tool"            // frame: [x,y,z,a,b,c]
+	stepSetBase       = "set_base"            // frame: [x,y,z,a,b,c]
+)
+
+// sequenceStep is a single step of a motion sequence.
+type sequenceStep struct {
+	Type    string    `json:"type"`
+	Joints  []float64 `json:"joints,omitempty"`
+	Pose    []float64 `json:"pose,omitempty"`
+	Status  *int      `json:"status,omitempty"`
+	Turn    *int      `json:"turn,omitempty"`
+	Frame   []float64 `json:"frame,omitempty"`
+	Value   float64   `json:"value,omitempty"`
+	Seconds float64   `json:"seconds,omitempty"`
+}
+
+// validate checks that the step is of a known type and has the arguments required by that type.
+func (step sequenceStep) validate() error {
+	switch step.Type {
+	case stepMoveJoints:
+		if len(step.Joints) != numJoints {
+			return errors.Errorf("%v requires %v joints, %v given", step.Type, numJoints, len(step.Joints))
+		}
+	case stepMoveCartesian, stepMoveLinear:
+		if len(step.Pose) != 6 {
+			return errors.Errorf("%v requires a pose of 6 values (x,y,z,a,b,c), %v given", step.Type, len(step.Pose))
+		}
+	case stepSetJointSpeed:
+		if step.Value <= 0 || step.Value > 100 {
+			return errors.Errorf("%v value (%v) must be in the range (0, 100]", step.Type, step.Value)
+		}
+	case stepSetCartSpeed:
+		if step.Value <= 0 {
+			return errors.Errorf("%v value (%v) must be positive", step.Type, step.Value)
+		}
+	case stepSetOverride:
+		if step.Value < 0 || step.Value > 100 || step.Value != math.Trunc(step.Value) {
+			return errors.Errorf("%v value (%v) must be an integer in the range [0, 100]", step.Type, step.Value)
+		}
+	case stepWait:
+		if step.Seconds < 0 {
+			return errors.Errorf("%v seconds (%v) must not be negative", step.Type, step.Seconds)
+		}
+	case stepSetTool, stepSetBase:
+		if len(step.Frame) != 6 {
+			return errors.Errorf("%v requires a frame of 6 values (x,y,z,a,b,c), %v given", step.Type, len(step.Frame))
+		}
+	default:
+		return errors.Errorf("unknown step type %q", step.Type)
+	}
+	return nil
+}
+
+// parseSequenceSteps converts the steps given in a DoCommand request into a validated list of sequence steps.
+func parseSequenceSteps(rawSteps interface{}) ([]sequenceStep, error) {
+	if rawSteps == nil {
+		return nil, errors.New("no steps given")
+	}
+
+	data, err := json.Marshal(rawSteps)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []sequenceStep
+	if err := json.Unmarshal(data, &steps); err != nil {
+		return nil, errors.Wrap(err, "unable to parse sequence steps")
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("no steps given")
+	}
+
+	for i, step := range steps {
+		if err := step.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid step %v", i+1)
+		}
+	}
+	return steps, nil
+}
+
+type sequenceState string
+
+const (
+	sequenceRunning   sequenceState = "running"
+	sequencePaused    sequenceState = "paused"
+	sequenceCompleted sequenceState = "completed"
+	sequenceAborted   sequenceState = "aborted"
+	sequenceFailed    sequenceState = "failed"
+)
+
+// sequenceRunner tracks the progress of a motion sequence executing in the background.
+type sequenceRunner struct {
+	steps []sequenceStep
+
+	mu       sync.Mutex
+	state    sequenceState
+	step     int
+	err      error
+	started  time.Time
+	finished time.Time
+
+	resumeCh chan struct{}
+	cancel   context.CancelFunc
+}
+
+func newSequenceRunner(steps []sequenceStep, cancel context.CancelFunc) *sequenceRunner {
+	return &sequenceRunner{
+		steps:    steps,
+		state:    sequenceRunning,
+		started:  time.Now(),
+		resumeCh: make(chan struct{}, 1),
+		cancel:   cancel,
+	}
+}
+
+// isActive returns if the sequence is running or paused.
+func (runner *sequenceRunner) isActive() bool {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	return runner.state == sequenceRunning || runner.state == sequencePaused
+}
+
+// status returns the progress of the sequence in a form that can be returned by DoCommand.
+func (runner *sequenceRunner) status() map[string]interface{} {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+
+	end := time.Now()
+	if !runner.finished.IsZero() {
+		end = runner.finished
+	}
+
+	status := map[string]interface{}{
+		"state":       string(runner.state),
+		"step":        runner.step + 1,
+		"total":       len(runner.steps),
+		"step_type":   runner.steps[runner.step].Type,
+		"elapsed_sec": end.Sub(runner.started).Seconds(),
+	}
+	if runner.err != nil {
+		status["error"] = runner.err.Error()
+	}
+	return status
+}
+
+func (runner *sequenceRunner) setStep(step int) {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	runner.step = step
+}
+
+func (runner *sequenceRunner) finish(state sequenceState, err error) {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	runner.state = state
+	runner.err = err
+	runner.finished = time.Now()
+}
+
+// waitIfPaused blocks while the sequence is paused, returning false if the sequence was aborted.
+func (runner *sequenceRunner) waitIfPaused(ctx context.Context) bool {
+	for {
+		runner.mu.Lock()
+		paused := runner.state == sequencePaused
+		runner.mu.Unlock()
+		if !paused {
+			return ctx.Err() == nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-runner.resumeCh:
+		}
+	}
+}
+
+// runSequence validates the given steps and starts executing them in the background.
+func (kuka *kukaArm) runSequence(cmd map[string]interface{}) (map[string]interface{}, error) {
+	steps, err := parseSequenceSteps(cmd["steps"])
+	if err != nil {
+		return nil, err
+	}
+
+	return kuka.startSequence(steps)
+}
+
+// startSequence starts executing the given validated steps in the background.
+func (kuka *kukaArm) startSequence(steps []sequenceStep) (map[string]interface{}, error) {
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+
+	if kuka.sequence != nil && kuka.sequence.isActive() {
+		return nil, errors.New("a sequence is already running, abort it before starting another")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runner := newSequenceRunner(steps, cancel)
+	kuka.sequence = runner
+
+	kuka.activeBackgroundWorkers.Add(1)
+	gutils.PanicCapturingGo(func() {
+		defer kuka.activeBackgroundWorkers.Done()
+		defer cancel()
+		kuka.executeSequence(ctx, runner)
+	})
+
+	return runner.status(), nil
+}
+
+// executeSequence runs each step of the sequence in order, stopping on the first error.
+func (kuka *kukaArm) executeSequence(ctx context.Context, runner *sequenceRunner) {
+	for i, step := range runner.steps {
+		if !runner.waitIfPaused(ctx) {
+			runner.finish(sequenceAborted, nil)
+			return
+		}
+
+		runner.setStep(i)
+		kuka.logger.Infof("sequence step %v/%v: %v", i+1, len(runner.steps), step.Type)
+
+		if err := kuka.executeSequenceStep(ctx, step); err != nil {
+			if ctx.Err() != nil {
+				runner.finish(sequenceAborted, nil)
+				return
+			}
+			kuka.logger.Warnf("sequence failed at step %v: %v", i+1, err)
+			runner.finish(sequenceFailed, errors.Wrapf(err, "step %v (%v) failed", i+1, step.Type))
+			return
+		}
+	}
+
+	if ctx.Err() != nil {
+		runner.finish(sequenceAborted, nil)
+		return
+	}
+	runner.finish(sequenceCompleted, nil)
+}
+
+// executeSequenceStep performs a single step of a sequence.
+func (kuka *kukaArm) executeSequenceStep(ctx context.Context, step sequenceStep) error {
+	switch step.Type {
+	case stepMoveJoints:
+		return kuka.MoveToJointPositions(ctx, &pb.JointPositions{Values: step.Joints}, nil)
+	case stepMoveCartesian:
+		currentState := kuka.getCurrentStateSafe()
+		status, turn := currentState.endStatus, currentState.endTurn
+		if step.Status != nil {
+			status = *step.Status
+		}
+		if step.Turn != nil {
+			turn = *step.Turn
+		}
+		return kuka.moveToCartesianPosition(ctx, step.Pose, status, turn)
+	case stepMoveLinear:
+		return kuka.moveLinear(ctx, step.Pose)
+	case stepSetJointSpeed:
+		return kuka.setJointSpeed(ctx, step.Value)
+	case stepSetCartSpeed:
+		return kuka.setCartSpeed(ctx, step.Value)
+	case stepSetOverride:
+		return kuka.setOverride(ctx, int(step.Value))
+	case stepWait:
+		timer := time.NewTimer(time.Duration(step.Seconds * float64(time.Second)))
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		}
+	case stepSetTool:
+		return kuka.setToolData(ctx, step.Frame)
+	case stepSetBase:
+		return kuka.setBaseData(ctx, step.Frame)
+	default:
+		return errors.Errorf("unknown step type %q", step.Type)
+	}
+}
+
+// currentSequence returns the most recently started sequence.
+func (kuka *kukaArm) currentSequence() (*sequenceRunner, error) {
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	if kuka.sequence == nil {
+		return nil, errors.New("no sequence has been run")
+	}
+	return kuka.sequence, nil
+}
+
+// sequenceStatus returns the progress of the most recently started sequence.
+func (kuka *kukaArm) sequenceStatus() (map[string]interface{}, error) {
+	runner, err := kuka.currentSequence()
+	if err != nil {
+		return nil, err
+	}
+	return runner.status(), nil
+}
+
+// pauseSequence pauses the running sequence once its current step completes.
+func (kuka *kukaArm) pauseSequence() (map[string]interface{}, error) {
+	runner, err := kuka.currentSequence()
+	if err != nil {
+		return nil, err
+	}
+
+	runner.mu.Lock()
+	if state := runner.state; state != sequenceRunning {
+		runner.mu.Unlock()
+		return nil, errors.Errorf("unable to pause sequence, sequence is %v", state)
+	}
+	runner.state = sequencePaused
+	runner.mu.Unlock()
+
+	return runner.status(), nil
+}
+
+// resumeSequence resumes a paused sequence.
+func (kuka *kukaArm) resumeSequence() (map[string]interface{}, error) {
+	runner, err := kuka.currentSequence()
+	if err != nil {
+		return nil, err
+	}
+
+	runner.mu.Lock()
+	if state := runner.state; state != sequencePaused {
+		runner.mu.Unlock()
+		return nil, errors.Errorf("unable to resume sequence, sequence is %v", state)
+	}
+	runner.state = sequenceRunning
+	runner.mu.Unlock()
+
+	select {
+	case runner.resumeCh <- struct{}{}:
+	default:
+	}
+	return runner.status(), nil
+}
+
+// stopSequence aborts the running sequence, if any, as the arm is reconfigured or closed, logging a failure to stop the
+// robot.
+func (kuka *kukaArm) stopSequence(ctx context.Context) {
+	if _, err := kuka.abortSequence(ctx); err != nil && !errors.Is(err, errNoSequence) {
+		kuka.logger.Warnf("error aborting sequence: %v", err)
+	}
+}
+
+// abortSequence aborts the running sequence, stopping the robot if it is in motion.
+func (kuka *kukaArm) abortSequence(ctx context.Context) (map[string]interface{}, error) {
+	kuka.stateMutex.Lock()
+	runner := kuka.sequence
+	kuka.stateMutex.Unlock()
+
+	if runner == nil || !runner.isActive() {
+		return nil, errNoSequence
+	}
+
+	runner.cancel()
+	if isMoving, _ := kuka.IsMoving(ctx); isMoving {
+		if err := kuka.requestStop(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	runner.finish(sequenceAborted, nil)
+	return runner.status(), nil
+}
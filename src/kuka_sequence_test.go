@@ -0,0 +1,197 @@
+package kuka
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/viam-soleng/viam-kuka/inject"
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+	"go.viam.com/utils/testutils"
+)
+
+func TestParseSequenceSteps(t *testing.T) {
+	stepTests := []struct {
+		description string
+		steps       interface{}
+		errContains string
+	}{
+		{description: "no steps", steps: nil, errContains: "no steps given"},
+		{description: "empty steps", steps: []interface{}{}, errContains: "no steps given"},
+		{description: "unknown type", steps: []interface{}{map[string]interface{}{"type": "jump"}}, errContains: "unknown step type"},
+		{
+			description: "too few joints",
+			steps:       []interface{}{map[string]interface{}{"type": stepMoveJoints, "joints": []interface{}{1, 2}}},
+			errContains: "requires 6 joints",
+		},
+		{
+			description: "override out of range",
+			steps:       []interface{}{map[string]interface{}{"type": stepSetOverride, "value": 150}},
+			errContains: "must be an integer",
+		},
+		{
+			description: "valid",
+			steps: []interface{}{
+				map[string]interface{}{"type": stepSetJointSpeed, "value": 20},
+				map[string]interface{}{"type": stepMoveJoints, "joints": []interface{}{1, 2, 3, 4, 5, 6}},
+				map[string]interface{}{"type": stepMoveCartesian, "pose": []interface{}{500, 0, 600, 0, 90, 0}, "status": 2},
+				map[string]interface{}{"type": stepWait, "seconds": 0.5},
+				map[string]interface{}{"type": stepSetTool, "frame": []interface{}{0, 0, 100, 0, 0, 0}},
+			},
+		},
+	}
+
+	for _, tt := range stepTests {
+		t.Run(tt.description, func(t *testing.T) {
+			steps, err := parseSequenceSteps(tt.steps)
+			if tt.errContains != "" {
+				test.That(t, err, test.ShouldNotBeNil)
+				test.That(t, err.Error(), test.ShouldContainSubstring, tt.errContains)
+				return
+			}
+			test.That(t, err, test.ShouldBeNil)
+			test.That(t, len(steps), test.ShouldEqual, 5)
+			test.That(t, *steps[2].Status, test.ShouldEqual, 2)
+			test.That(t, steps[2].Turn, test.ShouldBeNil)
+		})
+	}
+}
+
+func TestRunSequence(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	kuka := &kukaArm{
+		logger:     logger,
+		stateMutex: sync.Mutex{},
+	}
+
+	var mu sync.Mutex
+	var commands []string
+	conn := inject.NewTCPConn()
+	conn.WriteFunc = func(b []byte) (n int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		command := strings.Split(string(b), ",")[0]
+		commands = append(commands, command)
+		// Set commands wait for the device to reply
+		if strings.HasPrefix(command, "set") {
+			kuka.handleRobotResponses(command, []string{ekiCommand.ResponseSuccess})
+		}
+		return len(b), nil
+	}
+	useInjectedConn(t, kuka, conn)
+
+	waitForState := func(t *testing.T, expected sequenceState) map[string]interface{} {
+		var status map[string]interface{}
+		testutils.WaitForAssertion(t, func(tb testing.TB) {
+			var err error
+			status, err = kuka.DoCommand(ctx, map[string]interface{}{"command": sequenceStatusCommand})
+			test.That(tb, err, test.ShouldBeNil)
+			test.That(tb, status["state"], test.ShouldEqual, string(expected))
+		})
+		return status
+	}
+
+	t.Run("no sequence", func(t *testing.T) {
+		_, err := kuka.DoCommand(ctx, map[string]interface{}{"command": sequenceStatusCommand})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("completed", func(t *testing.T) {
+		resp, err := kuka.DoCommand(ctx, map[string]interface{}{
+			"command": runSequenceCommand,
+			"steps": []interface{}{
+				map[string]interface{}{"type": stepSetOverride, "value": 50},
+				map[string]interface{}{"type": stepWait, "seconds": 0.01},
+				map[string]interface{}{"type": stepSetTool, "frame": []interface{}{0, 0, 100, 0, 0, 0}},
+			},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["total"], test.ShouldEqual, 3)
+
+		status := waitForState(t, sequenceCompleted)
+		test.That(t, status["step"], test.ShouldEqual, 3)
+
+		mu.Lock()
+		test.That(t, commands, test.ShouldResemble, []string{"setoverride", "settooldata"})
+		mu.Unlock()
+		test.That(t, kuka.getCurrentStateSafe().toolFrame, test.ShouldResemble, []float64{0, 0, 100, 0, 0, 0})
+	})
+
+	t.Run("pause resume and abort", func(t *testing.T) {
+		_, err := kuka.DoCommand(ctx, map[string]interface{}{
+			"command": runSequenceCommand,
+			"steps": []interface{}{
+				map[string]interface{}{"type": stepWait, "seconds": 0.1},
+				map[string]interface{}{"type": stepWait, "seconds": 60},
+			},
+		})
+		test.That(t, err, test.ShouldBeNil)
+
+		_, err = kuka.DoCommand(ctx, map[string]interface{}{"command": runSequenceCommand, "steps": []interface{}{
+			map[string]interface{}{"type": stepWait, "seconds": 0},
+		}})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "already running")
+
+		_, err = kuka.DoCommand(ctx, map[string]interface{}{"command": pauseSequenceCommand})
+		test.That(t, err, test.ShouldBeNil)
+
+		// The sequence stays on the first step while paused
+		time.Sleep(200 * time.Millisecond)
+		status := waitForState(t, sequencePaused)
+		test.That(t, status["step"], test.ShouldEqual, 1)
+
+		_, err = kuka.DoCommand(ctx, map[string]interface{}{"command": resumeSequenceCommand})
+		test.That(t, err, test.ShouldBeNil)
+		testutils.WaitForAssertion(t, func(tb testing.TB) {
+			status, err := kuka.DoCommand(ctx, map[string]interface{}{"command": sequenceStatusCommand})
+			test.That(tb, err, test.ShouldBeNil)
+			test.That(tb, status["step"], test.ShouldEqual, 2)
+		})
+
+		_, err = kuka.DoCommand(ctx, map[string]interface{}{"command": abortSequenceCommand})
+		test.That(t, err, test.ShouldBeNil)
+		waitForState(t, sequenceAborted)
+
+		_, err = kuka.DoCommand(ctx, map[string]interface{}{"command": resumeSequenceCommand})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
+
+func TestCloseDuringSequence(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeEKIServer(t, copyResponses(fakeDeviceResponses))
+	server.setMoveTime(5 * time.Second)
+	kuka := newConnectedArm(t, server.config())
+
+	_, err := kuka.DoCommand(ctx, map[string]interface{}{
+		"command": runSequenceCommand,
+		"steps": []interface{}{
+			map[string]interface{}{"type": stepMoveJoints, "joints": []interface{}{10, 0, 0, 0, 0, 0}},
+			map[string]interface{}{"type": stepMoveJoints, "joints": []interface{}{20, 0, 0, 0, 0, 0}},
+		},
+	})
+	test.That(t, err, test.ShouldBeNil)
+	testutils.WaitForAssertion(t, func(tb testing.TB) {
+		test.That(tb, kuka.getCurrentStateSafe().isMoving, test.ShouldBeTrue)
+	})
+
+	// Close stops the robot and waits for the sequence to end, so no further move is sent
+	test.That(t, kuka.Close(ctx), test.ShouldBeNil)
+	kuka.stateMutex.Lock()
+	runner := kuka.sequence
+	kuka.stateMutex.Unlock()
+	test.That(t, runner.isActive(), test.ShouldBeFalse)
+	test.That(t, runner.status()["state"], test.ShouldEqual, string(sequenceAborted))
+
+	time.Sleep(50 * time.Millisecond)
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	test.That(t, server.moveStop, test.ShouldBeNil)
+}
@@ -0,0 +1,126 @@
+package kuka
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+)
+
+// DoCommand names for the controller stop history
+const (
+	getStopHistoryCommand   = "get_stop_history"
+	clearStopHistoryCommand = "clear_stop_history"
+)
+
+// stopHistorySize is the number of controller stops kept in the stop history.
+const stopHistorySize = 50
+
+// stopEvent records the kuka device reporting a stop message, such as an E-stop or a safety fault.
+type stopEvent struct {
+	Time          time.Time  `json:"time"`
+	Cleared       *time.Time `json:"cleared,omitempty"`
+	Motion        string     `json:"motion,omitempty"`
+	Joints        []float64  `json:"joints,omitempty"`
+	OperatingMode string     `json:"operating_mode,omitempty"`
+	ProgramState  string     `json:"program_state,omitempty"`
+}
+
+// errMoveStopped is returned to a motion in progress when a stop requested by the module ends it before it completes.
+var errMoveStopped = errors.New("motion stopped before it completed")
+
+// StoppedByControllerError is returned to a motion in progress when the kuka device stops the robot, for example due
+// to an E-stop or safety fault, before the move completes.
+type StoppedByControllerError struct {
+	Time   time.Time
+	Motion string
+}
+
+func (e *StoppedByControllerError) Error() string {
+	return fmt.Sprintf("robot stopped by controller during %v at %v, check the stop message on the pendant before continuing",
+		e.Motion, e.Time.Format(time.RFC3339))
+}
+
+// handleStopMessage tracks the stop message flag ($STOPMESS) of the kuka device. A new stop message is added to the
+// stop history and ends any motion in progress with a StoppedByControllerError.
+func (kuka *kukaArm) handleStopMessage(reply ekiCommand.Reply) {
+	active := reply.Bool(0)
+
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+
+	wasActive := kuka.currentState.stopMessage
+	kuka.currentState.stopMessage = active
+
+	if !active {
+		if wasActive && len(kuka.stopHistory) > 0 {
+			cleared := time.Now()
+			kuka.stopHistory[len(kuka.stopHistory)-1].Cleared = &cleared
+		}
+		return
+	}
+	if wasActive {
+		return
+	}
+
+	event := stopEvent{
+		Time:          time.Now(),
+		Joints:        append([]float64(nil), kuka.currentState.joints...),
+		OperatingMode: parseOperatingMode(kuka.deviceInfo.operatingMode),
+	}
+	if programState, err := ekiCommand.ProgramStatusToString(kuka.currentState.programState); err == nil {
+		event.ProgramState = programState
+	}
+	if kuka.currentState.isMoving {
+		event.Motion = kuka.currentState.moveCommand
+	}
+
+	kuka.stopHistory = append(kuka.stopHistory, event)
+	if len(kuka.stopHistory) > stopHistorySize {
+		kuka.stopHistory = kuka.stopHistory[len(kuka.stopHistory)-stopHistorySize:]
+	}
+	kuka.logger.Warnf("robot stopped by controller at joint positions %v", formatJoints(event.Joints))
+
+	// End the motion in progress, a success for it is no longer expected
+	if kuka.currentState.isMoving {
+		kuka.currentState.isMoving = false
+		select {
+		case kuka.stopCh <- event:
+		default:
+		}
+	}
+}
+
+// checkStopMessage returns an error if the kuka device has an unacknowledged stop message, refreshing the flag first
+// as it is otherwise only updated while the arm is moving.
+func (kuka *kukaArm) checkStopMessage() error {
+	if !kuka.getCurrentStateSafe().stopMessage {
+		return nil
+	}
+	if err := kuka.sendCommand(ekiCommand.GetStopMessage, ""); err != nil {
+		return err
+	}
+	if kuka.getCurrentStateSafe().stopMessage {
+		return errors.New("motion refused, the robot was stopped by the controller, acknowledge the stop message on the pendant before continuing")
+	}
+	return nil
+}
+
+// getStopHistory returns the controller stops recorded since the module started, oldest first.
+func (kuka *kukaArm) getStopHistory() (map[string]interface{}, error) {
+	kuka.stateMutex.Lock()
+	stops := append([]stopEvent{}, kuka.stopHistory...)
+	active := kuka.currentState.stopMessage
+	kuka.stateMutex.Unlock()
+
+	return toResponseMap(map[string]interface{}{"stops": stops, "stop_message": active})
+}
+
+// clearStopHistory removes all recorded controller stops.
+func (kuka *kukaArm) clearStopHistory() (map[string]interface{}, error) {
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	kuka.stopHistory = nil
+	return map[string]interface{}{}, nil
+}
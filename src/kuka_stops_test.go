@@ -0,0 +1,133 @@
+package kuka
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/viam-soleng/viam-kuka/inject"
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	pb "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/test"
+)
+
+func TestHandleStopMessage(t *testing.T) {
+	logger := logging.NewTestLogger(t)
+
+	kuka := &kukaArm{
+		logger:       logger,
+		stateMutex:   sync.Mutex{},
+		deviceInfo:   deviceInfo{operatingMode: "Extern"},
+		currentState: state{joints: []float64{1, 2, 3, 4, 5, 6}, programState: ekiCommand.StatusRunning},
+	}
+
+	kuka.handleRobotResponses(ekiCommand.GetStopMessage, []string{"false"})
+	test.That(t, kuka.stopHistory, test.ShouldBeEmpty)
+
+	kuka.handleRobotResponses(ekiCommand.GetStopMessage, []string{"true"})
+	kuka.handleRobotResponses(ekiCommand.GetStopMessage, []string{"true"})
+	test.That(t, len(kuka.stopHistory), test.ShouldEqual, 1)
+	test.That(t, kuka.stopHistory[0].Joints, test.ShouldResemble, []float64{1, 2, 3, 4, 5, 6})
+	test.That(t, kuka.stopHistory[0].OperatingMode, test.ShouldEqual, opModeEXT)
+	test.That(t, kuka.stopHistory[0].ProgramState, test.ShouldEqual, "Running")
+	test.That(t, kuka.stopHistory[0].Cleared, test.ShouldBeNil)
+	test.That(t, kuka.currentState.stopMessage, test.ShouldBeTrue)
+
+	kuka.handleRobotResponses(ekiCommand.GetStopMessage, []string{"false"})
+	test.That(t, kuka.stopHistory[0].Cleared, test.ShouldNotBeNil)
+
+	kuka.handleRobotResponses(ekiCommand.GetStopMessage, []string{"maybe"})
+	test.That(t, kuka.currentState.stopMessage, test.ShouldBeFalse)
+
+	for i := 0; i < stopHistorySize+5; i++ {
+		kuka.handleRobotResponses(ekiCommand.GetStopMessage, []string{"true"})
+		kuka.handleRobotResponses(ekiCommand.GetStopMessage, []string{"false"})
+	}
+	test.That(t, len(kuka.stopHistory), test.ShouldEqual, stopHistorySize)
+}
+
+func TestStoppedByController(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	kuka := &kukaArm{
+		logger:     logger,
+		stateMutex: sync.Mutex{},
+		deviceInfo: deviceInfo{operatingMode: "Auto"},
+		currentState: state{
+			joints:      make([]float64, numJoints),
+			jointLimits: make([]referenceframe.Limit, numJoints),
+		},
+		stopCh: make(chan stopEvent, 1),
+	}
+	for i := range kuka.currentState.jointLimits {
+		kuka.currentState.jointLimits[i] = referenceframe.Limit{Min: -170, Max: 170}
+	}
+
+	// The injected connection reports the given stop message state and never completes moves
+	var mu sync.Mutex
+	stopMessage := "false"
+	conn := inject.NewTCPConn()
+	conn.WriteFunc = func(b []byte) (n int, err error) {
+		if strings.HasPrefix(string(b), ekiCommand.GetStopMessage) {
+			mu.Lock()
+			state := stopMessage
+			mu.Unlock()
+			kuka.handleRobotResponses(ekiCommand.GetStopMessage, []string{state})
+		}
+		if strings.HasPrefix(string(b), ekiCommand.SetJointPosition) {
+			mu.Lock()
+			stopMessage = "true"
+			mu.Unlock()
+		}
+		return len(b), nil
+	}
+	useInjectedConn(t, kuka, conn)
+
+	t.Run("motion in progress", func(t *testing.T) {
+		err := kuka.MoveToJointPositions(ctx, &pb.JointPositions{Values: make([]float64, numJoints)}, nil)
+		test.That(t, err, test.ShouldNotBeNil)
+
+		var stopErr *StoppedByControllerError
+		test.That(t, errors.As(err, &stopErr), test.ShouldBeTrue)
+		test.That(t, stopErr.Motion, test.ShouldEqual, ekiCommand.SetJointPosition)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "robot stopped by controller during ptptojointpos")
+
+		isMoving, err := kuka.IsMoving(ctx)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, isMoving, test.ShouldBeFalse)
+	})
+
+	t.Run("refused until acknowledged", func(t *testing.T) {
+		err := kuka.MoveToJointPositions(ctx, &pb.JointPositions{Values: make([]float64, numJoints)}, nil)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "acknowledge the stop message")
+
+		mu.Lock()
+		stopMessage = "false"
+		mu.Unlock()
+		test.That(t, kuka.checkStopMessage(), test.ShouldBeNil)
+	})
+
+	t.Run("history", func(t *testing.T) {
+		resp, err := kuka.DoCommand(ctx, map[string]interface{}{"command": getStopHistoryCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["stop_message"], test.ShouldBeFalse)
+		stops := resp["stops"].([]interface{})
+		test.That(t, len(stops), test.ShouldEqual, 1)
+		stop := stops[0].(map[string]interface{})
+		test.That(t, stop["motion"], test.ShouldEqual, ekiCommand.SetJointPosition)
+		test.That(t, stop["operating_mode"], test.ShouldEqual, opModeAUT)
+		test.That(t, stop["cleared"], test.ShouldNotBeNil)
+
+		_, err = kuka.DoCommand(ctx, map[string]interface{}{"command": clearStopHistoryCommand})
+		test.That(t, err, test.ShouldBeNil)
+		resp, err = kuka.DoCommand(ctx, map[string]interface{}{"command": getStopHistoryCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["stops"], test.ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,363 @@
+package kuka
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/components/input"
+	"go.viam.com/rdk/utils"
+
+	gutils "go.viam.com/utils"
+
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+)
+
+const (
+	defaultTeleopEnableButton  input.Control = input.ButtonLT
+	defaultTeleopMaxJointSpeed float64       = 10 // degs/sec at full stick deflection
+	defaultTeleopTimeout                     = 500 * time.Millisecond
+
+	// teleopDeadband is the stick deflection below which an axis is treated as centered.
+	teleopDeadband float64 = 0.1
+	// jogLimitMargin keeps jog targets strictly inside the joint limits reported by the kuka device.
+	jogLimitMargin float64 = 0.5
+)
+
+var (
+	teleopTickInterval time.Duration = 50 * time.Millisecond
+	// jogStepDuration is how far ahead (in time at the requested speed) each jog target is placed.
+	jogStepDuration time.Duration = 500 * time.Millisecond
+)
+
+// teleopJointControls maps each robot joint (a1-a6) to the gamepad axis that jogs it.
+var teleopJointControls = []input.Control{
+	input.AbsoluteX,
+	input.AbsoluteY,
+	input.AbsoluteRX,
+	input.AbsoluteRY,
+	input.AbsoluteHat0X,
+	input.AbsoluteHat0Y,
+}
+
+// teleop holds the state of an input controller used to jog the arm. Motion is only commanded while the
+// enable button is held and the controller is seen, by its events or by reading its state, within the deadman timeout.
+type teleop struct {
+	controller    input.Controller
+	enableButton  input.Control
+	maxJointSpeed float64
+	timeout       time.Duration
+	// jogSpeed is the joint speed, as a percentage of the maximum, jog steps are limited to so that no joint moves
+	// faster than maxJointSpeed
+	jogSpeed float64
+
+	mu      sync.Mutex
+	enabled bool
+	axes    []float64
+	// lastSeen is when the controller last sent an event or was read with the enable button held
+	lastSeen time.Time
+	jogging  bool
+	// halted is whether the arm has been stopped since the last jog step was issued
+	halted bool
+
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+// newTeleop creates the teleoperation state for the given input controller from the arm config.
+func newTeleop(controller input.Controller, conf *Config) *teleop {
+	t := &teleop{
+		controller:    controller,
+		enableButton:  defaultTeleopEnableButton,
+		maxJointSpeed: defaultTeleopMaxJointSpeed,
+		timeout:       defaultTeleopTimeout,
+		axes:          make([]float64, numJoints),
+		halted:        true,
+	}
+
+	if conf.TeleopEnableButton != "" {
+		t.enableButton = input.Control(conf.TeleopEnableButton)
+	}
+	if conf.TeleopMaxJointSpeed != 0 {
+		t.maxJointSpeed = conf.TeleopMaxJointSpeed
+	}
+	if conf.TeleopTimeoutMs != 0 {
+		t.timeout = time.Duration(conf.TeleopTimeoutMs) * time.Millisecond
+	}
+	return t
+}
+
+// startTeleop registers callbacks on the given input controller and starts the background jog loop.
+func (kuka *kukaArm) startTeleop(ctx context.Context, controller input.Controller, conf *Config) error {
+	t := newTeleop(controller, conf)
+
+	// Jog no faster than teleop_max_joint_speed, whatever the joint speed set on the arm
+	jogSpeed, err := kuka.jogJointSpeed(ctx, t.maxJointSpeed)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the maximum joint speeds to limit teleop jogs to teleop_max_joint_speed")
+	}
+	t.jogSpeed = jogSpeed
+
+	enableTriggers := []input.EventType{input.ButtonPress, input.ButtonRelease, input.Disconnect}
+	if err := controller.RegisterControlCallback(ctx, t.enableButton, enableTriggers, t.handleEnableEvent, nil); err != nil {
+		return err
+	}
+	for _, control := range teleopJointControls {
+		if err := controller.RegisterControlCallback(ctx, control, []input.EventType{input.PositionChangeAbs}, t.handleAxisEvent, nil); err != nil {
+			return err
+		}
+	}
+
+	kuka.logger.Infof("teleoperation enabled via %v, hold %v to jog", controller.Name().ShortName(), t.enableButton)
+
+	loopCtx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	kuka.teleop = t
+
+	t.workers.Add(1)
+	gutils.PanicCapturingGo(func() {
+		defer t.workers.Done()
+		kuka.teleopLoop(loopCtx, t)
+	})
+	return nil
+}
+
+// stopTeleop stops the jog loop and waits for it and the jog step it issued to end, unregisters the input controller
+// callbacks and halts the arm if a jog step was issued since it was last halted.
+func (kuka *kukaArm) stopTeleop(ctx context.Context) {
+	t := kuka.teleop
+	if t == nil {
+		return
+	}
+	kuka.teleop = nil
+
+	t.cancel()
+	t.workers.Wait()
+
+	controls := append([]input.Control{t.enableButton}, teleopJointControls...)
+	for _, control := range controls {
+		triggers := []input.EventType{input.ButtonPress, input.ButtonRelease, input.Disconnect, input.PositionChangeAbs}
+		if err := t.controller.RegisterControlCallback(ctx, control, triggers, nil, nil); err != nil {
+			kuka.logger.Warnf("error unregistering teleop callback for %v: %v", control, err)
+		}
+	}
+
+	// The last jog step may still be running on the kuka device even once it is no longer waited on
+	if t.haltPending() {
+		if err := kuka.requestStop(context.Background()); err != nil {
+			kuka.logger.Warnf("error stopping teleop jog: %v", err)
+		}
+	}
+}
+
+// teleopLoop periodically reads the controller state and converts it into jog steps, and halts the arm via setstop as
+// soon as the enable button is released, the sticks are centered or the controller is no longer seen.
+func (kuka *kukaArm) teleopLoop(ctx context.Context, t *teleop) {
+	ticker := time.NewTicker(teleopTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if kuka.closed.Load() {
+			return
+		}
+
+		if err := t.refresh(ctx); err != nil {
+			kuka.logger.Debugf("error reading teleop controller state: %v", err)
+		}
+		deltas, active := t.jogDeltas(time.Now())
+		if !active {
+			if t.shouldHalt() {
+				if err := kuka.requestStop(context.Background()); err != nil {
+					kuka.logger.Warnf("error stopping teleop jog: %v", err)
+				}
+			}
+			continue
+		}
+
+		if !t.startJog() {
+			continue
+		}
+		t.workers.Add(1)
+		gutils.PanicCapturingGo(func() {
+			defer t.workers.Done()
+			defer t.finishJog()
+			// A jog step is expected to be stopped when the arm is halted
+			if err := kuka.jogJoints(ctx, deltas, t.jogSpeed); err != nil && !errors.Is(err, errMoveStopped) {
+				kuka.logger.Warnf("teleop jog failed: %v", err)
+			}
+		})
+	}
+}
+
+// jogJoints moves each joint by the given offset (in degrees) from its current position, at no more than the given
+// joint speed as a percentage of the maximum. Targets are clamped to stay inside the joint limits so that holding a
+// stick against a limit does not produce errors.
+func (kuka *kukaArm) jogJoints(ctx context.Context, deltas []float64, maxJointSpeed float64) error {
+	currentState := kuka.getCurrentStateSafe()
+	if len(currentState.joints) != numJoints || len(currentState.jointLimits) != numJoints {
+		return errors.New("current joint positions are unknown, unable to jog")
+	}
+
+	target := make([]float64, numJoints)
+	for i := 0; i < numJoints; i++ {
+		limit := currentState.jointLimits[i]
+		target[i] = math.Max(limit.Min+jogLimitMargin, math.Min(limit.Max-jogLimitMargin, currentState.joints[i]+deltas[i]))
+	}
+
+	if err := kuka.moveToJointPositions(ctx, target, maxJointSpeed); err != nil {
+		return err
+	}
+
+	return kuka.updateState()
+}
+
+// jogJointSpeed returns the joint speed, as a percentage of the maximum, at which no joint moves faster than the given
+// speed in degrees per second, from the maximum speed of each joint reported by the kuka device.
+func (kuka *kukaArm) jogJointSpeed(ctx context.Context, maxJointSpeed float64) (float64, error) {
+	reply, err := kuka.requestReply(ctx, ekiCommand.GetMaxJointSpeed)
+	if err != nil {
+		return 0, err
+	}
+
+	// Every joint moves at the same percentage of its own maximum, so the fastest joint sets the limit
+	var fastest float64
+	for _, speed := range reply.Floats(0, numJoints) {
+		fastest = math.Max(fastest, utils.RadToDeg(speed))
+	}
+	if fastest <= 0 {
+		return 0, errors.New("kuka device reported no maximum joint speeds")
+	}
+	return math.Min(100, 100*maxJointSpeed/fastest), nil
+}
+
+// handleEnableEvent tracks the enable (deadman) button. A controller disconnect is treated as a release.
+func (t *teleop) handleEnableEvent(ctx context.Context, ev input.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.enabled = ev.Event == input.ButtonPress
+	t.lastSeen = ev.Time
+}
+
+// handleAxisEvent records the latest deflection of a stick mapped to a joint.
+func (t *teleop) handleAxisEvent(ctx context.Context, ev input.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, control := range teleopJointControls {
+		if control == ev.Control {
+			t.axes[i] = ev.Value
+		}
+	}
+	t.lastSeen = ev.Time
+}
+
+// refresh reads the current state of the input controller, as the enable button and sticks held steady send no events.
+// The controller is seen while the enable button is read as held, and a button read as released disables
+// teleoperation. Reading the button held does not enable teleoperation, which still takes a press.
+func (t *teleop) refresh(ctx context.Context) error {
+	// A controller that does not answer must not hold up the deadman timeout
+	ctx, cancel := context.WithTimeout(ctx, teleopTickInterval)
+	defer cancel()
+	events, err := t.controller.Events(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ev, ok := events[t.enableButton]; ok {
+		switch {
+		case ev.Value == 0:
+			t.enabled = false
+		case t.enabled:
+			t.lastSeen = time.Now()
+		}
+	}
+	for i, control := range teleopJointControls {
+		if ev, ok := events[control]; ok {
+			t.axes[i] = ev.Value
+		}
+	}
+	return nil
+}
+
+// jogDeltas returns the per joint jog offsets for the current controller state and whether the arm should be jogging.
+// If the controller has not been seen within the timeout, teleoperation is disabled until the enable button is pressed
+// again.
+func (t *teleop) jogDeltas(now time.Time) ([]float64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.enabled && now.Sub(t.lastSeen) > t.timeout {
+		t.enabled = false
+	}
+	if !t.enabled {
+		return nil, false
+	}
+
+	active := false
+	deltas := make([]float64, numJoints)
+	for i, value := range t.axes {
+		if math.Abs(value) < teleopDeadband {
+			continue
+		}
+		deltas[i] = value * t.maxJointSpeed * jogStepDuration.Seconds()
+		active = true
+	}
+	return deltas, active
+}
+
+// startJog marks a jog step as in progress, returning false if one is already running.
+func (t *teleop) startJog() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.jogging {
+		return false
+	}
+	t.jogging = true
+	t.halted = false
+	return true
+}
+
+// finishJog marks the current jog step as complete.
+func (t *teleop) finishJog() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.jogging = false
+}
+
+// shouldHalt returns true once for each jog step that is still running after teleoperation became inactive.
+func (t *teleop) shouldHalt() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.jogging || t.halted {
+		return false
+	}
+	t.halted = true
+	return true
+}
+
+// haltPending returns true once if a jog step was issued since the arm was last stopped, whether or not it is still
+// waited on.
+func (t *teleop) haltPending() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.halted {
+		return false
+	}
+	t.halted = true
+	return true
+}
+
+// isJogging returns if a jog step is in progress.
+func (t *teleop) isJogging() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.jogging
+}
@@ -0,0 +1,330 @@
+package kuka
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/viam-soleng/viam-kuka/inject"
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	pb "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/components/input"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/rdk/utils"
+	"go.viam.com/test"
+)
+
+func TestTeleopJogDeltas(t *testing.T) {
+	now := time.Now()
+	tp := newTeleop(nil, &Config{TeleopMaxJointSpeed: 20, TeleopTimeoutMs: 100})
+
+	t.Run("not enabled", func(t *testing.T) {
+		tp.handleAxisEvent(context.Background(), input.Event{Control: input.AbsoluteX, Value: 1, Time: now})
+		_, active := tp.jogDeltas(now)
+		test.That(t, active, test.ShouldBeFalse)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		tp.handleEnableEvent(context.Background(), input.Event{Event: input.ButtonPress, Control: tp.enableButton, Time: now})
+		tp.handleAxisEvent(context.Background(), input.Event{Control: input.AbsoluteRY, Value: -0.5, Time: now})
+		tp.handleAxisEvent(context.Background(), input.Event{Control: input.AbsoluteY, Value: 0.05, Time: now})
+
+		deltas, active := tp.jogDeltas(now)
+		test.That(t, active, test.ShouldBeTrue)
+		test.That(t, deltas, test.ShouldResemble, []float64{
+			20 * jogStepDuration.Seconds(), 0, 0, -10 * jogStepDuration.Seconds(), 0, 0,
+		})
+	})
+
+	t.Run("deadman timeout", func(t *testing.T) {
+		_, active := tp.jogDeltas(now.Add(200 * time.Millisecond))
+		test.That(t, active, test.ShouldBeFalse)
+
+		// Further axis events do not re-enable motion until the button is pressed again
+		tp.handleAxisEvent(context.Background(), input.Event{Control: input.AbsoluteX, Value: 1, Time: now.Add(time.Second)})
+		_, active = tp.jogDeltas(now.Add(time.Second))
+		test.That(t, active, test.ShouldBeFalse)
+	})
+
+	t.Run("held steady", func(t *testing.T) {
+		var events map[input.Control]input.Event
+		controller := inject.NewInputController("gamepad")
+		controller.EventsFunc = func(ctx context.Context, extra map[string]interface{}) (map[input.Control]input.Event, error) {
+			return events, nil
+		}
+		steady := newTeleop(controller, &Config{TeleopMaxJointSpeed: 20, TeleopTimeoutMs: 100})
+		start := time.Now()
+		steady.handleEnableEvent(context.Background(), input.Event{Event: input.ButtonPress, Control: steady.enableButton, Time: start})
+		steady.handleAxisEvent(context.Background(), input.Event{Control: input.AbsoluteX, Value: 1, Time: start})
+
+		// Reading the enable button held keeps the arm jogging without further events
+		events = map[input.Control]input.Event{
+			steady.enableButton: {Control: steady.enableButton, Value: 1},
+			input.AbsoluteX:     {Control: input.AbsoluteX, Value: 1},
+		}
+		time.Sleep(150 * time.Millisecond)
+		test.That(t, steady.refresh(context.Background()), test.ShouldBeNil)
+		_, active := steady.jogDeltas(time.Now())
+		test.That(t, active, test.ShouldBeTrue)
+
+		// Reading the enable button released stops it, even though no release event arrived
+		events[steady.enableButton] = input.Event{Control: steady.enableButton, Value: 0}
+		test.That(t, steady.refresh(context.Background()), test.ShouldBeNil)
+		_, active = steady.jogDeltas(time.Now())
+		test.That(t, active, test.ShouldBeFalse)
+
+		// Reading the enable button held again does not re-enable motion until it is pressed again
+		events[steady.enableButton] = input.Event{Control: steady.enableButton, Value: 1}
+		test.That(t, steady.refresh(context.Background()), test.ShouldBeNil)
+		_, active = steady.jogDeltas(time.Now())
+		test.That(t, active, test.ShouldBeFalse)
+	})
+
+	t.Run("controller not read", func(t *testing.T) {
+		controller := inject.NewInputController("gamepad")
+		controller.EventsFunc = func(ctx context.Context, extra map[string]interface{}) (map[input.Control]input.Event, error) {
+			return nil, errors.New("controller disconnected")
+		}
+		unread := newTeleop(controller, &Config{TeleopMaxJointSpeed: 20, TeleopTimeoutMs: 100})
+		start := time.Now()
+		unread.handleEnableEvent(context.Background(), input.Event{Event: input.ButtonPress, Control: unread.enableButton, Time: start})
+		unread.handleAxisEvent(context.Background(), input.Event{Control: input.AbsoluteX, Value: 1, Time: start})
+
+		test.That(t, unread.refresh(context.Background()), test.ShouldNotBeNil)
+		_, active := unread.jogDeltas(start.Add(200 * time.Millisecond))
+		test.That(t, active, test.ShouldBeFalse)
+	})
+
+	t.Run("released", func(t *testing.T) {
+		tp.handleEnableEvent(context.Background(), input.Event{Event: input.ButtonPress, Control: tp.enableButton, Time: now})
+		tp.handleEnableEvent(context.Background(), input.Event{Event: input.ButtonRelease, Control: tp.enableButton, Time: now})
+		_, active := tp.jogDeltas(now)
+		test.That(t, active, test.ShouldBeFalse)
+	})
+
+	t.Run("halt once per jog", func(t *testing.T) {
+		test.That(t, tp.shouldHalt(), test.ShouldBeFalse)
+		test.That(t, tp.startJog(), test.ShouldBeTrue)
+		test.That(t, tp.startJog(), test.ShouldBeFalse)
+		test.That(t, tp.shouldHalt(), test.ShouldBeTrue)
+		test.That(t, tp.shouldHalt(), test.ShouldBeFalse)
+		tp.finishJog()
+		test.That(t, tp.isJogging(), test.ShouldBeFalse)
+
+		// A stop is pending on shutdown for a jog issued since the last halt, even once it is no longer waited on
+		test.That(t, tp.haltPending(), test.ShouldBeFalse)
+		test.That(t, tp.startJog(), test.ShouldBeTrue)
+		tp.finishJog()
+		test.That(t, tp.haltPending(), test.ShouldBeTrue)
+		test.That(t, tp.haltPending(), test.ShouldBeFalse)
+	})
+}
+
+func TestStartStopTeleop(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	kuka := &kukaArm{
+		logger:     logger,
+		stateMutex: sync.Mutex{},
+	}
+	conn := inject.NewTCPConn()
+	conn.WriteFunc = func(b []byte) (n int, err error) {
+		if strings.HasPrefix(string(b), ekiCommand.GetMaxJointSpeed) {
+			kuka.handleRobotResponses(ekiCommand.GetMaxJointSpeed, strings.Split(fakeDeviceResponses[ekiCommand.GetMaxJointSpeed], ","))
+		}
+		return len(b), nil
+	}
+	useInjectedConn(t, kuka, conn)
+
+	var mu sync.Mutex
+	callbacks := map[input.Control]input.ControlFunction{}
+	controller := inject.NewInputController("gamepad")
+	controller.EventsFunc = func(ctx context.Context, extra map[string]interface{}) (map[input.Control]input.Event, error) {
+		return map[input.Control]input.Event{}, nil
+	}
+	controller.RegisterControlCallbackFunc = func(
+		ctx context.Context,
+		control input.Control,
+		triggers []input.EventType,
+		ctrlFunc input.ControlFunction,
+		extra map[string]interface{},
+	) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if ctrlFunc == nil {
+			delete(callbacks, control)
+		} else {
+			callbacks[control] = ctrlFunc
+		}
+		return nil
+	}
+
+	err := kuka.startTeleop(ctx, controller, &Config{TeleopEnableButton: "ButtonRT"})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, kuka.teleop, test.ShouldNotBeNil)
+	test.That(t, kuka.teleop.jogSpeed, test.ShouldAlmostEqual, 100*defaultTeleopMaxJointSpeed/utils.RadToDeg(10))
+
+	mu.Lock()
+	test.That(t, len(callbacks), test.ShouldEqual, len(teleopJointControls)+1)
+	enableFunc := callbacks[input.ButtonRT]
+	mu.Unlock()
+	test.That(t, enableFunc, test.ShouldNotBeNil)
+
+	enableFunc(ctx, input.Event{Event: input.ButtonPress, Control: input.ButtonRT, Time: time.Now()})
+	_, active := kuka.teleop.jogDeltas(time.Now())
+	test.That(t, active, test.ShouldBeFalse)
+
+	kuka.stopTeleop(ctx)
+	test.That(t, kuka.teleop, test.ShouldBeNil)
+	test.That(t, len(callbacks), test.ShouldEqual, 0)
+}
+
+func TestStopTeleopHaltsJog(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeEKIServer(t, copyResponses(fakeDeviceResponses))
+	server.setMoveTime(5 * time.Second)
+	kuka := newConnectedArm(t, server.config())
+
+	callbacks := map[input.Control]input.ControlFunction{}
+	controller := inject.NewInputController("gamepad")
+	controller.EventsFunc = func(ctx context.Context, extra map[string]interface{}) (map[input.Control]input.Event, error) {
+		return map[input.Control]input.Event{
+			defaultTeleopEnableButton: {Control: defaultTeleopEnableButton, Value: 1},
+			input.AbsoluteX:           {Control: input.AbsoluteX, Value: 1},
+		}, nil
+	}
+	controller.RegisterControlCallbackFunc = func(
+		ctx context.Context,
+		control input.Control,
+		triggers []input.EventType,
+		ctrlFunc input.ControlFunction,
+		extra map[string]interface{},
+	) error {
+		if ctrlFunc != nil {
+			callbacks[control] = ctrlFunc
+		}
+		return nil
+	}
+	test.That(t, kuka.startTeleop(ctx, controller, &Config{}), test.ShouldBeNil)
+
+	// Hold the enable button and deflect a stick until a jog step is running on the kuka device
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		now := time.Now()
+		callbacks[defaultTeleopEnableButton](ctx, input.Event{Event: input.ButtonPress, Control: defaultTeleopEnableButton, Time: now})
+		callbacks[input.AbsoluteX](ctx, input.Event{Event: input.PositionChangeAbs, Control: input.AbsoluteX, Value: 1, Time: now})
+		server.mu.Lock()
+		moving := server.moveStop != nil
+		server.mu.Unlock()
+		if moving {
+			break
+		}
+		if now.After(deadline) {
+			t.Fatal("no jog step was issued")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Stopping teleoperation ends the jog step waited on and stops the robot
+	kuka.stopTeleop(ctx)
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	test.That(t, server.moveStop, test.ShouldBeNil)
+}
+
+func TestJogJoints(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	kuka := &kukaArm{
+		logger:     logger,
+		stateMutex: sync.Mutex{},
+		deviceInfo: deviceInfo{operatingMode: "Auto"},
+		currentState: state{
+			joints: []float64{10, 10, 10, 10, 10, 10},
+			jointLimits: []referenceframe.Limit{
+				{Min: 0, Max: 100},
+				{Min: 0, Max: 100},
+				{Min: 0, Max: 100},
+				{Min: 0, Max: 100},
+				{Min: 0, Max: 100},
+				{Min: 0, Max: 100},
+			},
+		},
+	}
+
+	t.Run("unknown joints", func(t *testing.T) {
+		jogKuka := &kukaArm{logger: logger}
+		err := jogKuka.jogJoints(ctx, make([]float64, numJoints), 0)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "unable to jog")
+	})
+
+	var sentCommands []string
+	conn := inject.NewTCPConn()
+	conn.WriteFunc = func(b []byte) (n int, err error) {
+		if strings.HasPrefix(string(b), ekiCommand.SetJointSpeed) {
+			sentCommands = append(sentCommands, string(b))
+		}
+		if strings.HasPrefix(string(b), ekiCommand.SetJointPosition) {
+			sentCommands = append(sentCommands, string(b))
+			kuka.handleRobotResponses(ekiCommand.SetJointPosition, []string{ekiCommand.ResponseSuccess})
+		}
+		return len(b), nil
+	}
+	useInjectedConn(t, kuka, conn)
+
+	t.Run("clamped to limits", func(t *testing.T) {
+		sentCommands = nil
+		err := kuka.jogJoints(ctx, []float64{5, -20, 200, 0, 0, 0}, 0)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, sentCommands, test.ShouldResemble, []string{"ptptojointpos,15,0.5,99.5,10,10,10,0,0,0,0,0,0;"})
+	})
+
+	t.Run("limited to the jog speed", func(t *testing.T) {
+		kuka.stateMutex.Lock()
+		kuka.currentState.jointSpeed = defaultJointSpeed
+		kuka.currentState.appliedJointSpeed = defaultJointSpeed
+		kuka.stateMutex.Unlock()
+
+		sentCommands = nil
+		err := kuka.jogJoints(ctx, []float64{1, 0, 0, 0, 0, 0}, 2)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, sentCommands, test.ShouldResemble, []string{
+			"setjointspeed,2;", "ptptojointpos,11,10,10,10,10,10,0,0,0,0,0,0;",
+		})
+
+		// The joint speed set on the arm applies again to the motions that follow
+		sentCommands = nil
+		err = kuka.MoveToJointPositions(ctx, &pb.JointPositions{Values: []float64{10, 10, 10, 10, 10, 10}}, nil)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, sentCommands, test.ShouldResemble, []string{
+			"setjointspeed,10;", "ptptojointpos,10,10,10,10,10,10,0,0,0,0,0,0;",
+		})
+	})
+}
+
+func TestJogJointSpeed(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeEKIServer(t, copyResponses(fakeDeviceResponses))
+	kuka := newConnectedArm(t, server.config())
+
+	// 10 degrees per second is a small percentage of the fastest joint
+	speed, err := kuka.jogJointSpeed(ctx, 10)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, speed, test.ShouldAlmostEqual, 100*10/utils.RadToDeg(10))
+
+	// A limit beyond the fastest joint leaves the joints at their full speed
+	speed, err = kuka.jogJointSpeed(ctx, 1000)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, speed, test.ShouldEqual, 100)
+
+	server.setResponse(ekiCommand.GetMaxJointSpeed, "0,0,0,0,0,0,0,0,0,0,0,0")
+	_, err = kuka.jogJointSpeed(ctx, 10)
+	test.That(t, err, test.ShouldNotBeNil)
+}
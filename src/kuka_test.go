@@ -3,16 +3,21 @@ package kuka
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/geo/r3"
 	"github.com/viam-soleng/viam-kuka/inject"
 	eki_command "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	"github.com/viam-soleng/viam-kuka/src/ekimanager"
 	v1 "go.viam.com/api/component/arm/v1"
 	"go.viam.com/rdk/logging"
 	"go.viam.com/rdk/referenceframe"
 	"go.viam.com/rdk/referenceframe/urdf"
+	"go.viam.com/rdk/resource"
 	"go.viam.com/rdk/spatialmath"
 	"go.viam.com/test"
 )
@@ -68,6 +73,7 @@ func TestSetterEndpoints(t *testing.T) {
 	kuka := &kukaArm{
 		logger:     logger,
 		stateMutex: sync.Mutex{},
+		deviceInfo: deviceInfo{operatingMode: "Auto"},
 		currentState: state{
 			joints: make([]float64, numJoints),
 			jointLimits: []referenceframe.Limit{
@@ -79,23 +85,25 @@ func TestSetterEndpoints(t *testing.T) {
 				{Min: 0, Max: 100},
 			},
 		},
-		responseCh: make(chan bool, 1),
 	}
 
+	// The injected connection completes moves to the joint positions given, reporting the program as running
 	conn := inject.NewTCPConn()
+	useInjectedConn(t, kuka, conn)
+	conn.WriteFunc = func(b []byte) (n int, err error) {
+		switch command := strings.Split(strings.TrimSuffix(string(b), ";"), ","); command[0] {
+		case eki_command.GetEKIProgramState:
+			kuka.handleRobotResponses(command[0], []string{"ekiMain", "Running"})
+		case eki_command.SetJointPosition:
+			kuka.handleRobotResponses(eki_command.GetJointPosition, command[1:])
+			kuka.handleRobotResponses(command[0], []string{eki_command.ResponseSuccess})
+		}
+		return len(b), nil
+	}
 
 	t.Run("outside joint limits", func(t *testing.T) {
 		expectedJoints := []float64{-1, 1, 2, 3, 4, 5}
 
-		conn.WriteFunc = func(b []byte) (n int, err error) {
-			kuka.currentState.joints = expectedJoints
-			kuka.currentState.programState = eki_command.StatusRunning
-			kuka.currentState.isMoving = false
-			return 0, nil
-		}
-
-		kuka.tcpConn.conn = conn
-
 		err := kuka.MoveToJointPositions(ctx, &v1.JointPositions{Values: expectedJoints}, nil)
 		test.That(t, err, test.ShouldNotBeNil)
 		test.That(t, err.Error(), test.ShouldContainSubstring, "invalid joint position specified")
@@ -103,53 +111,20 @@ func TestSetterEndpoints(t *testing.T) {
 
 	t.Run("successful", func(t *testing.T) {
 		expectedJoints := []float64{1, 1, 2, 3, 4, 5}
-		var trackInt int
-
-		conn.WriteFunc = func(b []byte) (n int, err error) {
-			// Update required info when 5 commands come through
-			if trackInt == 4 {
-				kuka.currentState.joints = expectedJoints
-				kuka.currentState.programState = eki_command.StatusRunning
-				kuka.currentState.isMoving = false
-				kuka.responseCh <- true
-			}
-			trackInt++
-			return 0, nil
-		}
-
-		kuka.tcpConn.conn = conn
 
 		err := kuka.MoveToJointPositions(ctx, &v1.JointPositions{Values: expectedJoints}, nil)
 		test.That(t, err, test.ShouldBeNil)
+		test.That(t, kuka.getCurrentStateSafe().joints, test.ShouldResemble, expectedJoints)
 	})
 
 	t.Run("successful safemode", func(t *testing.T) {
 		kuka.safeMode = true
-		expectedJoints := []float64{1, 1, 2, 3, 4, 5}
-		var trackInt int
-
-		conn.WriteFunc = func(b []byte) (n int, err error) {
-			// Update required info when the ProgramState command comes through
-			if trackInt == 0 {
-				kuka.currentState.programState = eki_command.StatusRunning
-				trackInt++
-				kuka.responseCh <- true
-				return 0, nil
-			}
-			// Update required info after 3 more commands have come through
-			if trackInt == 4 {
-				kuka.currentState.joints = expectedJoints
-				kuka.currentState.isMoving = false
-				kuka.responseCh <- true
-			}
-			trackInt++
-			return 0, nil
-		}
-
-		kuka.tcpConn.conn = conn
+		expectedJoints := []float64{2, 1, 2, 3, 4, 5}
 
 		err := kuka.MoveToJointPositions(ctx, &v1.JointPositions{Values: expectedJoints}, nil)
 		test.That(t, err, test.ShouldBeNil)
+		test.That(t, kuka.getCurrentStateSafe().joints, test.ShouldResemble, expectedJoints)
+		test.That(t, kuka.getCurrentStateSafe().programState, test.ShouldEqual, eki_command.StatusRunning)
 	})
 
 	t.Run("is still moving", func(t *testing.T) {
@@ -161,3 +136,74 @@ func TestSetterEndpoints(t *testing.T) {
 		test.That(t, err.Error(), test.ShouldContainSubstring, "robot is still moving")
 	})
 }
+
+func TestControllerSettings(t *testing.T) {
+	cfg := &Config{IPAddress: "10.0.0.2"}
+	settings := cfg.ControllerSettings()
+	test.That(t, settings.Port, test.ShouldEqual, defaultTCPPort)
+	test.That(t, settings.ConfigName, test.ShouldEqual, ekimanager.DefaultConfigName)
+	test.That(t, settings.AliveFlag, test.ShouldEqual, ekimanager.DefaultAliveFlag)
+	test.That(t, settings.ReceiveFlag, test.ShouldEqual, ekimanager.DefaultReceiveFlag)
+
+	_, err := cfg.Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+
+	cfg.StateStreamMs = 20
+	test.That(t, cfg.ControllerSettings().StreamPeriodMs, test.ShouldEqual, 20)
+	_, err = cfg.Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+
+	cfg.EKIReceiveFlag = ekimanager.DefaultAliveFlag
+	_, err = cfg.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "invalid controller settings")
+}
+
+func TestValidateJointSpeed(t *testing.T) {
+	cfg := &Config{IPAddress: "10.0.0.2", JointSpeed: 100}
+	_, err := cfg.Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+
+	for _, speed := range []float64{-1, 100.5} {
+		cfg.JointSpeed = speed
+		_, err = cfg.Validate("path")
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "must be in the range (0, 100]")
+	}
+}
+
+// reconfigureResponses are the replies of the fake EKI server to what the arm asks when it is configured.
+func reconfigureResponses() map[string]string {
+	responses := copyResponses(fakeDeviceResponses)
+	responses[eki_command.GetProtocolInfo] = protocolInfoResponse()
+	responses[eki_command.GetRobotSerialNum] = "12345"
+	responses[eki_command.GetRobotType] = "KR10 R900-2"
+	responses[eki_command.GetRobotSoftwareVersion] = "8.6"
+	responses[eki_command.GetJointNegLimit] = "-170,-190,-120,-185,-120,-350,0,0,0,0,0,0"
+	responses[eki_command.GetJointPosLimit] = "170,45,156,185,120,350,0,0,0,0,0,0"
+	return responses
+}
+
+func TestReconfigure(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeEKIServer(t, reconfigureResponses())
+	conf := server.config()
+	conf.JointSpeed = 25
+	conf.WaypointFile = filepath.Join(t.TempDir(), "waypoints.json")
+
+	// The replies to the commands sent while configuring are handled while the arm waits on them
+	start := time.Now()
+	a, err := newKukaArm(ctx, nil, resource.Config{Name: "arm", ConvertedAttributes: conf}, logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	defer func() { test.That(t, a.Close(ctx), test.ShouldBeNil) }()
+	test.That(t, time.Since(start), test.ShouldBeLessThan, replyTimeout)
+
+	kuka := a.(*kukaArm)
+	state := kuka.getCurrentStateSafe()
+	test.That(t, state.appliedJointSpeed, test.ShouldEqual, 25)
+	test.That(t, kuka.deviceInfo.serialNum, test.ShouldEqual, "12345")
+	test.That(t, state.jointLimits[1], test.ShouldResemble, referenceframe.Limit{Min: -190, Max: 45})
+
+	// Setting the joint speed again waits on its reply the same way
+	test.That(t, kuka.setInitialValues(), test.ShouldBeNil)
+}
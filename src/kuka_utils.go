@@ -2,12 +2,14 @@ package kuka
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"runtime"
 	"time"
 
 	"github.com/pkg/errors"
+	"go.viam.com/rdk/logging"
 	"go.viam.com/rdk/referenceframe"
 	"go.viam.com/rdk/referenceframe/urdf"
 
@@ -16,8 +18,38 @@ import (
 	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
 )
 
+// stateStreamMissedPeriods is how many periods ekiStateStream may go without pushing the state before it is no longer
+// relied on
+const stateStreamMissedPeriods = 3
+
 var (
-	sendInfoCommandSleep time.Duration = 200 * time.Millisecond
+	// deviceInfoQueries are sent on startup to gather information from robot name and model to limits on joint movement
+	deviceInfoQueries = []string{
+		ekiCommand.GetRobotName,
+		ekiCommand.GetRobotSerialNum,
+		ekiCommand.GetRobotType,
+		ekiCommand.GetRobotSoftwareVersion,
+		ekiCommand.GetRobotOperatingMode,
+		ekiCommand.GetJointNegLimit,
+		ekiCommand.GetJointPosLimit,
+	}
+
+	// stateQueries are sent to refresh the current state of the robot
+	stateQueries = []string{
+		ekiCommand.GetJointPosition,
+		ekiCommand.GetEndPosition,
+		ekiCommand.GetRobotOperatingMode,
+		ekiCommand.GetStopMessage,
+	}
+
+	// streamedStateQueries are sent in place of stateQueries while ekiStateStream pushes the joints, end position and
+	// operating mode
+	streamedStateQueries = []string{
+		ekiCommand.GetStopMessage,
+	}
+
+	// updateStateInterval is the shortest time between refreshes of the state while the robot is in motion
+	updateStateInterval time.Duration = 20 * time.Millisecond
 )
 
 // ResolveFile returns the path of the given file relative to the root of the codebase.
@@ -32,21 +64,23 @@ func resolveFile(fn string) string {
 }
 
 // sendCommand will send the desired command (with any and all arguments), in the proper format,
-// to the kuka device via the TCP connection.
+// to the kuka device via the TCP connection, returning once the device has replied and the reply has been handled.
 func (kuka *kukaArm) sendCommand(EKICommand, args string) error {
-	var command string
-	if args != "" {
-		command = fmt.Sprintf("%v,%v;", EKICommand, args)
-	} else {
-		command = fmt.Sprintf("%v;", EKICommand)
-	}
+	_, err := kuka.request(context.Background(), EKICommand, args)
+	return err
+}
 
-	if err := kuka.Write([]byte(command)); err != nil {
-		return err
-	}
+// sendQueries sends the given queries to the kuka device, pipelined, returning once every reply has been handled.
+func (kuka *kukaArm) sendQueries(EKICommands ...string) error {
+	return kuka.requestAll(context.Background(), EKICommands...)
+}
 
-	time.Sleep(sendInfoCommandSleep)
-	return nil
+// formatCommand formats a command and its arguments as expected by the kuka device in the text message format.
+func formatCommand(EKICommand, args string) string {
+	if args != "" {
+		return fmt.Sprintf("%v,%v;", EKICommand, args)
+	}
+	return fmt.Sprintf("%v;", EKICommand)
 }
 
 // parseConfig parses the given config, updating the kuka device info as necessary.
@@ -55,6 +89,9 @@ func (kuka *kukaArm) parseConfig(newConf *Config) error {
 	defer kuka.stateMutex.Unlock()
 
 	kuka.tcpConn.ipAddress = newConf.IPAddress
+	kuka.tcpConn.messageFormat = newConf.EKIMessageFormat
+	kuka.tcpConn.traceFile = newConf.TraceFile
+	kuka.tcpConn.stopPort = newConf.StopPort
 
 	if newConf.Port != 0 {
 		kuka.tcpConn.port = newConf.Port
@@ -66,33 +103,50 @@ func (kuka *kukaArm) parseConfig(newConf *Config) error {
 	if newConf.JointSpeed != 0 {
 		kuka.currentState.jointSpeed = newConf.JointSpeed
 	} else {
-		kuka.logger.Warnf("No joint speed specified, using default of %v", defaultJointSpeed)
+		kuka.logger.Warnf("No joint speed specified, using default of %v%%", defaultJointSpeed)
 		kuka.currentState.jointSpeed = defaultJointSpeed
 	}
 
-	switch newConf.Model {
+	urdfModel, err := loadModel(newConf.Model, kuka.Name().ShortName(), kuka.logger)
+	if err != nil {
+		return err
+	}
+	kuka.model = urdfModel
+
+	kuka.safeMode = newConf.SafeMode
+	kuka.allowRawCommands = newConf.AllowRawCommands
+	kuka.stateStreamPeriod = time.Duration(newConf.StateStreamMs) * time.Millisecond
+	kuka.maxStateAge = time.Duration(newConf.MaxStateAgeMs) * time.Millisecond
+	if newConf.MaxStateAgeMs > 0 && newConf.StatePollMs == 0 && newConf.StateStreamMs == 0 {
+		kuka.logger.Warnf("max_state_age_ms is set without state_poll_ms or state_stream_ms, the state will be stale " +
+			"whenever the arm is not moving")
+	}
+
+	kuka.replyMutex.Lock()
+	kuka.commandReplyTimeout = time.Duration(newConf.CommandTimeoutMs) * time.Millisecond
+	kuka.replyMutex.Unlock()
+
+	return nil
+}
+
+// loadModel loads the URDF model of the given kuka model, the KR10 R900-2 if none is given.
+func loadModel(model, name string, logger logging.Logger) (referenceframe.Model, error) {
+	switch model {
 	case kr10r900, "": // use the kr10r900 as the default value
-		model := kr10r900
-		urdfModel, err := urdf.ParseModelXMLFile(
-			resolveFile(fmt.Sprintf("src/models/%v_model.urdf", model)),
-			kuka.Name().ShortName(),
-		)
+		model = kr10r900
+		urdfModel, err := urdf.ParseModelXMLFile(resolveFile(fmt.Sprintf("src/models/%v_model.urdf", model)), name)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		kuka.logger.Infof("loading URDF model: %v", fmt.Sprintf("src/models/%v_model.urdf", model))
-		kuka.model = urdfModel
+		logger.Infof("loading URDF model: %v", fmt.Sprintf("src/models/%v_model.urdf", model))
+		return urdfModel, nil
 	default:
-		return errors.Errorf("given model (%v) not in list of supported models (%v), no URDF files are available for desired model",
-			newConf.Model,
+		return nil, errors.Errorf("given model (%v) not in list of supported models (%v), no URDF files are available for desired model",
+			model,
 			supportedKukaKRModels,
 		)
 	}
-
-	kuka.safeMode = newConf.SafeMode
-
-	return nil
 }
 
 // resetCurrentStateAndDeviceInfo resets the device's info and stored current state.
@@ -105,27 +159,16 @@ func (kuka *kukaArm) resetCurrentStateAndDeviceInfo() {
 		programState: ekiCommand.StatusUnknown,
 	}
 	kuka.deviceInfo = deviceInfo{}
+	kuka.protocol.Store(nil)
 }
 
 // getDeviceInfo will send a series of commands to the device to gather information from robot name and model to limits on joint movement
 // and starting positions.
 func (kuka *kukaArm) getDeviceInfo() error {
 
-	// List of startup commands
-	startUpCommandList := []string{
-		ekiCommand.GetRobotName,
-		ekiCommand.GetRobotSerialNum,
-		ekiCommand.GetRobotType,
-		ekiCommand.GetRobotSoftwareVersion,
-		ekiCommand.GetRobotOperatingMode,
-		ekiCommand.GetJointNegLimit,
-		ekiCommand.GetJointPosLimit,
-	}
-
-	for _, command := range startUpCommandList {
-		if err := kuka.sendCommand(command, ""); err != nil {
-			return err
-		}
+	// Send startup commands
+	if err := kuka.sendQueries(deviceInfoQueries...); err != nil {
+		return err
 	}
 
 	// Update current state of kuka device
@@ -136,48 +179,297 @@ func (kuka *kukaArm) getDeviceInfo() error {
 	return nil
 }
 
-// getDeviceInfo will send a series of commands to the device to gather information from robot name and model to limits on joint movement
-// and starting positions.
+// setInitialValues sends the configured joint speed to the device when connecting, recording it as the speed applied so
+// that motions only set it again when it changes. The state is not locked while waiting for the reply, which is handled
+// under the lock.
 func (kuka *kukaArm) setInitialValues() error {
 	kuka.stateMutex.Lock()
-	defer kuka.stateMutex.Unlock()
+	jointSpeed := kuka.currentState.jointSpeed
+	kuka.stateMutex.Unlock()
 
 	// Set joint speed
-	if err := kuka.sendCommand(ekiCommand.SetJointSpeed, fmt.Sprintf("%v", kuka.currentState.jointSpeed)); err != nil {
+	if _, err := kuka.requestReply(context.Background(), ekiCommand.SetJointSpeed, jointSpeed); err != nil {
 		return err
 	}
 
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	kuka.currentState.appliedJointSpeed = jointSpeed
+
 	return nil
 }
 
-// updateState pings the kuka device for its current joint positions and end position.
-func (kuka *kukaArm) updateState() error {
-	if err := kuka.sendCommand(ekiCommand.GetJointPosition, ""); err != nil {
+// executeMove sends the given motion command and arguments to the kuka device and blocks until the device reports the motion has
+// completed or the context is cancelled. The current state is polled in the background while the robot is in motion.
+// The joint speed, as a percentage of the maximum, is limited to maxJointSpeed if it is given.
+func (kuka *kukaArm) executeMove(ctx context.Context, maxJointSpeed float64, EKICommand string, values ...interface{}) error {
+	if err := kuka.checkSupported(EKICommand); err != nil {
+		return err
+	}
+	_, args, err := encodeCommand(EKICommand, values...)
+	if err != nil {
+		return err
+	}
+	if isMoving, _ := kuka.IsMoving(ctx); isMoving {
+		return errors.New("robot is still moving, please try again after previous movement is complete")
+	}
+
+	// Check EKI program state before issuing move command
+	if kuka.safeMode {
+		programState, err := kuka.checkEKIProgramState(ctx)
+		if err != nil {
+			return err
+		}
+		if programState != ekiCommand.StatusRunning {
+			return errors.Errorf("associated program on your kuka device is %v, please get the program running before continuing", programState)
+		}
+	}
+
+	// Check motion is allowed in the current operating mode
+	if err := kuka.checkOperatingMode(maxJointSpeed); err != nil {
 		return err
 	}
 
-	if err := kuka.sendCommand(ekiCommand.GetEndPosition, ""); err != nil {
+	// Check the robot has not been stopped by the controller
+	if err := kuka.checkStopMessage(); err != nil {
 		return err
 	}
 
+	// Discard any stop of a previous move that was not waited on
+	select {
+	case <-kuka.stopCh:
+	default:
+	}
+
+	// Send command
+	done := make(chan error, 1)
+	kuka.stateMutex.Lock()
+	kuka.currentState.isMoving = true
+	kuka.currentState.moveCommand = EKICommand
+	kuka.moveDone = done
+	kuka.stateMutex.Unlock()
+	closed := kuka.connectionClosed()
+	if err := kuka.writeCommand(EKICommand, args); err != nil {
+		kuka.stateMutex.Lock()
+		kuka.currentState.isMoving = false
+		kuka.stateMutex.Unlock()
+		return err
+	}
+
+	// Loop until operation ends
+	cancelCtx, cancelFunc := context.WithCancel(ctx)
+	defer cancelFunc()
+	kuka.activeBackgroundWorkers.Add(1)
+	utils.PanicCapturingGo(func() {
+		defer kuka.activeBackgroundWorkers.Done()
+		kuka.updateStateLoop(cancelCtx)
+	})
+
+	select {
+	case <-ctx.Done():
+		// Stop the robot rather than leave it moving with nothing waiting on the motion
+		if err := kuka.requestStop(context.Background()); err != nil {
+			kuka.logger.Warnf("failed to stop the cancelled %v motion: %v", EKICommand, err)
+		}
+		kuka.abandonMove(done)
+		return ctx.Err()
+	case <-closed:
+		// No reply can arrive anymore, so the motion is no longer waited on
+		kuka.abandonMove(done)
+		return errConnectionClosed
+	case err := <-done:
+		return err
+	case stop := <-kuka.stopCh:
+		return &StoppedByControllerError{Time: stop.Time, Motion: stop.Motion}
+	}
+}
+
+// abandonMove ends the motion sent with the given done channel if it is still in progress, for when it is no longer
+// waited on, so that later motions are not refused as the robot still moving.
+func (kuka *kukaArm) abandonMove(done chan error) {
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	if kuka.moveDone == done {
+		kuka.currentState.isMoving = false
+		kuka.moveDone = nil
+	}
+}
+
+// endMove ends the motion in progress if the response is the reply of the kuka device to its command, or to a stop
+// of the robot, passing the result on to the move waiting on it: nil once completed, a refusedError if refused and
+// errMoveStopped if stopped first. Returns whether the motion was ended.
+func (kuka *kukaArm) endMove(command string, args []string) bool {
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+
+	if !kuka.currentState.isMoving {
+		return false
+	}
+
+	var reply string
+	if len(args) > 0 {
+		reply = args[0]
+	}
+	stopped := command == ekiCommand.SetStop && reply == ekiCommand.ResponseSuccess
+	if command != kuka.currentState.moveCommand && !stopped {
+		return false
+	}
+
+	var err error
+	switch {
+	case stopped:
+		err = errMoveStopped
+	case refusalReplies[reply]:
+		err = &refusedError{command: command, reply: reply}
+	}
+
+	kuka.currentState.isMoving = false
+	if kuka.moveDone != nil {
+		kuka.moveDone <- err
+		kuka.moveDone = nil
+	}
+	return true
+}
+
+// moveToCartesianPosition moves the arm point to point to the given kuka frame (x,y,z,a,b,c) in the active base and
+// tool, using the given status and turn to select the robot configuration.
+func (kuka *kukaArm) moveToCartesianPosition(ctx context.Context, frame []float64, status, turn int) error {
+	if len(frame) != 6 {
+		return errors.Errorf("cartesian position must have 6 values (x,y,z,a,b,c), %v given", len(frame))
+	}
+
+	if err := kuka.checkKeepOutZonesCartesian(frame, false); err != nil {
+		return err
+	}
+
+	args := append(floatArgs(frame...), status, turn)
+	args = append(args, floatArgs(make([]float64, numExternalJoints)...)...)
+	return kuka.executeMove(ctx, 0, ekiCommand.SetCartesianPosition, args...)
+}
+
+// moveLinear moves the arm in a straight line to the given kuka frame (x,y,z,a,b,c) in the active base and tool.
+func (kuka *kukaArm) moveLinear(ctx context.Context, frame []float64) error {
+	if len(frame) != 6 {
+		return errors.Errorf("cartesian position must have 6 values (x,y,z,a,b,c), %v given", len(frame))
+	}
+
+	if err := kuka.checkKeepOutZonesCartesian(frame, true); err != nil {
+		return err
+	}
+
+	args := floatArgs(append(frame[:6:6], make([]float64, numExternalJoints)...)...)
+	return kuka.executeMove(ctx, 0, ekiCommand.SetLinearPosition, args...)
+}
+
+// setJointSpeed sets the joint speed, as a percentage of the maximum, used for subsequent motions.
+func (kuka *kukaArm) setJointSpeed(ctx context.Context, speed float64) error {
+	if speed <= 0 || speed > 100 {
+		return errors.Errorf("joint speed (%v) must be in the range (0, 100]", speed)
+	}
+
+	if _, err := kuka.requestReply(ctx, ekiCommand.SetJointSpeed, speed); err != nil {
+		return err
+	}
+
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	kuka.currentState.jointSpeed = speed
+	kuka.currentState.appliedJointSpeed = speed
+	return nil
+}
+
+// setCartSpeed sets the cartesian speed, in m/s, used for subsequent linear motions.
+func (kuka *kukaArm) setCartSpeed(ctx context.Context, speed float64) error {
+	if speed <= 0 {
+		return errors.Errorf("cartesian speed (%v) must be positive", speed)
+	}
+
+	_, err := kuka.requestReply(ctx, ekiCommand.SetCartSpeed, speed)
+	return err
+}
+
+// setOverride sets the program override, the percentage of the programmed speed the robot moves at.
+func (kuka *kukaArm) setOverride(ctx context.Context, override int) error {
+	if override < 0 || override > 100 {
+		return errors.Errorf("override (%v) must be in the range [0, 100]", override)
+	}
+
+	_, err := kuka.requestReply(ctx, ekiCommand.SetOverride, override)
+	return err
+}
+
+// setToolData sets the active tool frame (x,y,z,a,b,c) on the kuka device.
+func (kuka *kukaArm) setToolData(ctx context.Context, frame []float64) error {
+	if len(frame) != 6 {
+		return errors.Errorf("tool frame must have 6 values (x,y,z,a,b,c), %v given", len(frame))
+	}
+
+	if _, err := kuka.requestReply(ctx, ekiCommand.SetToolData, floatArgs(frame...)...); err != nil {
+		return err
+	}
+
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	kuka.currentState.toolFrame = append([]float64{}, frame...)
 	return nil
 }
 
-// updateStateLoop repeatedly pings the kuka device for current state information when the robot is in motion.
+// setBaseData sets the active base frame (x,y,z,a,b,c) on the kuka device.
+func (kuka *kukaArm) setBaseData(ctx context.Context, frame []float64) error {
+	if len(frame) != 6 {
+		return errors.Errorf("base frame must have 6 values (x,y,z,a,b,c), %v given", len(frame))
+	}
+
+	if _, err := kuka.requestReply(ctx, ekiCommand.SetBaseData, floatArgs(frame...)...); err != nil {
+		return err
+	}
+
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	kuka.currentState.baseFrame = append([]float64{}, frame...)
+	return nil
+}
+
+// updateState pings the kuka device for its current joint positions and end position, unless they are being pushed
+// by ekiStateStream.
+func (kuka *kukaArm) updateState() error {
+	if kuka.stateStreamActive() {
+		return kuka.sendQueries(streamedStateQueries...)
+	}
+	return kuka.sendQueries(stateQueries...)
+}
+
+// stateStreamActive returns whether ekiStateStream is pushing the state of the robot, having done so recently.
+func (kuka *kukaArm) stateStreamActive() bool {
+	kuka.stateMutex.Lock()
+	defer kuka.stateMutex.Unlock()
+	period := kuka.stateStreamPeriod
+	return period > 0 && time.Since(kuka.currentState.streamUpdated) < stateStreamMissedPeriods*period
+}
+
+// updateStateLoop repeatedly pings the kuka device for current state information when the robot is in motion, each
+// refresh starting once the previous one has been answered.
 func (kuka *kukaArm) updateStateLoop(cancelCtx context.Context) {
 	startTime := time.Now()
+	ticker := time.NewTicker(updateStateInterval)
+	defer ticker.Stop()
 
 	for {
 		if err := cancelCtx.Err(); err != nil {
 			break
 		}
-		if kuka.closed || time.Now().After(startTime.Add(motionTimeout)) {
+		if kuka.closed.Load() || time.Now().After(startTime.Add(motionTimeout)) {
 			break
 		}
 
-		if err := kuka.updateState(); err != nil {
+		if err := kuka.updateState(); err != nil && !kuka.closed.Load() {
 			kuka.logger.Warnf("error updating status: %v", err)
 		}
+
+		select {
+		case <-cancelCtx.Done():
+		case <-ticker.C:
+		}
 	}
 }
 
@@ -190,15 +482,10 @@ func (kuka *kukaArm) getCurrentStateSafe() state {
 
 // checkEKIProgramState will ping and wait for the program state to be returned.
 func (kuka *kukaArm) checkEKIProgramState(ctx context.Context) (ekiCommand.ProgramStatus, error) {
-	if err := kuka.sendCommand(ekiCommand.GetEKIProgramState, ""); err != nil {
+	if _, err := kuka.request(ctx, ekiCommand.GetEKIProgramState, ""); err != nil {
 		return ekiCommand.StatusUnknown, err
 	}
 
-	// Wait until response is returned
-	if !utils.SelectContextOrWaitChan(ctx, kuka.responseCh) {
-		return ekiCommand.StatusUnknown, errors.New("closed")
-	}
-
 	kuka.stateMutex.Lock()
 	defer kuka.stateMutex.Unlock()
 	return kuka.currentState.programState, nil
@@ -225,3 +512,58 @@ func (kuka *kukaArm) checkDesiredJointPositions(desiredJointPositions []float64)
 	}
 	return nil
 }
+
+// getStringArg returns the string value of the given key in a DoCommand request.
+func getStringArg(cmd map[string]interface{}, key string) (string, error) {
+	val, ok := cmd[key].(string)
+	if !ok || val == "" {
+		return "", errors.Errorf("request value for %q (%v) was not a non-empty string", key, cmd[key])
+	}
+	return val, nil
+}
+
+// getFloatArg returns the numeric value of the given key in a DoCommand request.
+func getFloatArg(cmd map[string]interface{}, key string) (float64, error) {
+	switch val := cmd[key].(type) {
+	case float64:
+		return val, nil
+	case int:
+		return float64(val), nil
+	default:
+		return 0, errors.Errorf("request value for %q (%v) was not a number", key, cmd[key])
+	}
+}
+
+// getFloatListArg returns the list of numbers of the given key in a DoCommand request.
+func getFloatListArg(cmd map[string]interface{}, key string) ([]float64, error) {
+	switch vals := cmd[key].(type) {
+	case []float64:
+		return vals, nil
+	case []interface{}:
+		list := make([]float64, len(vals))
+		for i, v := range vals {
+			val, ok := v.(float64)
+			if !ok {
+				return nil, errors.Errorf("request value for %q (%v) was not a list of numbers", key, cmd[key])
+			}
+			list[i] = val
+		}
+		return list, nil
+	default:
+		return nil, errors.Errorf("request value for %q (%v) was not a list of numbers", key, cmd[key])
+	}
+}
+
+// toResponseMap converts the given value into a map of plain types that can be returned by DoCommand.
+func toResponseMap(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp map[string]interface{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -2,6 +2,9 @@ package kuka
 
 import (
 	"context"
+	"net"
+	"os"
+	"strconv"
 	"sync"
 	"testing"
 
@@ -113,16 +116,15 @@ func TestCommunication(t *testing.T) {
 		tcpConn: tcpConn{
 			mu: sync.Mutex{},
 		},
-		responseCh: make(chan bool, 1),
 	}
 
 	conn := inject.NewTCPConn()
+	useInjectedConn(t, kuka, conn)
 
 	t.Run("Send Commands", func(t *testing.T) {
 		conn.WriteFunc = func(b []byte) (n int, err error) {
 			return 0, nil
 		}
-		kuka.tcpConn.conn = conn
 
 		err := kuka.sendCommand("command", "arguments")
 		test.That(t, err, test.ShouldBeNil)
@@ -131,18 +133,15 @@ func TestCommunication(t *testing.T) {
 		conn.WriteFunc = func(b []byte) (n int, err error) {
 			return 0, nil
 		}
-		kuka.tcpConn.conn = conn
 
 		err := kuka.getDeviceInfo()
 		test.That(t, err, test.ShouldBeNil)
 	})
 	t.Run("Check EKI Program State", func(t *testing.T) {
 		conn.WriteFunc = func(b []byte) (n int, err error) {
-			kuka.currentState.programState = eki_command.StatusRunning
-			kuka.responseCh <- true
+			kuka.handleRobotResponses(eki_command.GetEKIProgramState, []string{"ekiMain", "Running"})
 			return 0, nil
 		}
-		kuka.tcpConn.conn = conn
 
 		status, err := kuka.checkEKIProgramState(ctx)
 		test.That(t, err, test.ShouldBeNil)
@@ -188,3 +187,28 @@ func TestCheckJointLimits(t *testing.T) {
 		test.That(t, err, test.ShouldBeNil)
 	})
 }
+
+// BenchmarkUpdateState measures the rate the state is refreshed at during motion. It runs against a fake controller
+// unless KUKA_BENCH_ADDRESS is set to the <ip>:<port> of a controller or simulator running the EKI program.
+func BenchmarkUpdateState(b *testing.B) {
+	conf := &Config{}
+	if address := os.Getenv("KUKA_BENCH_ADDRESS"); address != "" {
+		host, port, err := net.SplitHostPort(address)
+		test.That(b, err, test.ShouldBeNil)
+		conf.IPAddress = host
+		conf.Port, err = strconv.Atoi(port)
+		test.That(b, err, test.ShouldBeNil)
+	} else {
+		conf = newFakeEKIServer(b, copyResponses(fakeDeviceResponses)).config()
+	}
+
+	kuka := newConnectedArm(b, conf)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := kuka.updateState(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "refreshes/s")
+}
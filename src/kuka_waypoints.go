@@ -0,0 +1,260 @@
+package kuka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	pb "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/spatialmath"
+)
+
+// DoCommand names for managing waypoints
+const (
+	saveWaypointCommand   = "save_waypoint"
+	listWaypointsCommand  = "list_waypoints"
+	deleteWaypointCommand = "delete_waypoint"
+	moveToWaypointCommand = "move_to_waypoint"
+)
+
+// moduleDataEnvVar is set by viam-server to a directory the module may persist data in.
+const moduleDataEnvVar = "VIAM_MODULE_DATA"
+
+// waypointPose is the cartesian pose of a waypoint, stored as a point (mm) and orientation vector (degrees).
+type waypointPose struct {
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Z     float64 `json:"z"`
+	OX    float64 `json:"o_x"`
+	OY    float64 `json:"o_y"`
+	OZ    float64 `json:"o_z"`
+	Theta float64 `json:"theta"`
+}
+
+// waypoint is a named robot state captured from the kuka device. Tool and base are kuka frames (x,y,z,a,b,c).
+type waypoint struct {
+	Name    string        `json:"name"`
+	Joints  []float64     `json:"joints"`
+	Pose    *waypointPose `json:"pose,omitempty"`
+	Tool    []float64     `json:"tool,omitempty"`
+	Base    []float64     `json:"base,omitempty"`
+	Created time.Time     `json:"created"`
+}
+
+// waypointStore is a set of named waypoints persisted as a JSON file.
+type waypointStore struct {
+	mu        sync.Mutex
+	path      string
+	waypoints map[string]waypoint
+}
+
+// newWaypointStore creates a waypoint store backed by the given file, loading any waypoints already saved to it.
+func newWaypointStore(path string) (*waypointStore, error) {
+	store := &waypointStore{
+		path:      path,
+		waypoints: map[string]waypoint{},
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return nil, err
+	}
+
+	var waypoints []waypoint
+	if err := json.Unmarshal(data, &waypoints); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse waypoint file %v", path)
+	}
+	for _, wp := range waypoints {
+		store.waypoints[wp.Name] = wp
+	}
+	return store, nil
+}
+
+// get returns the waypoint with the given name.
+func (store *waypointStore) get(name string) (waypoint, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	wp, ok := store.waypoints[name]
+	return wp, ok
+}
+
+// list returns all waypoints sorted by name.
+func (store *waypointStore) list() []waypoint {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.sortedLocked()
+}
+
+// save adds or replaces a waypoint and persists the store.
+func (store *waypointStore) save(wp waypoint) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	prev, existed := store.waypoints[wp.Name]
+	store.waypoints[wp.Name] = wp
+	if err := store.writeLocked(); err != nil {
+		if existed {
+			store.waypoints[wp.Name] = prev
+		} else {
+			delete(store.waypoints, wp.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// delete removes a waypoint and persists the store.
+func (store *waypointStore) delete(name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	wp, ok := store.waypoints[name]
+	if !ok {
+		return errors.Errorf("no waypoint named %q", name)
+	}
+	delete(store.waypoints, name)
+	if err := store.writeLocked(); err != nil {
+		store.waypoints[name] = wp
+		return err
+	}
+	return nil
+}
+
+func (store *waypointStore) sortedLocked() []waypoint {
+	waypoints := make([]waypoint, 0, len(store.waypoints))
+	for _, wp := range store.waypoints {
+		waypoints = append(waypoints, wp)
+	}
+	sort.Slice(waypoints, func(i, j int) bool { return waypoints[i].Name < waypoints[j].Name })
+	return waypoints
+}
+
+// writeLocked writes the waypoints to a temporary file and renames it over the store file so a failed write
+// never leaves a partial file behind.
+func (store *waypointStore) writeLocked() error {
+	data, err := json.MarshalIndent(store.sortedLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(store.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return err
+		}
+	}
+
+	tmpPath := store.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, store.path)
+}
+
+// waypointFilePath returns the waypoint file given in the config or, by default, a file named after the arm in the
+// module data directory.
+func (kuka *kukaArm) waypointFilePath(conf *Config) string {
+	if conf.WaypointFile != "" {
+		return conf.WaypointFile
+	}
+
+	dataDir := os.Getenv(moduleDataEnvVar)
+	if dataDir == "" {
+		kuka.logger.Warnf("%v is not set, storing waypoints in the working directory", moduleDataEnvVar)
+	}
+	return filepath.Join(dataDir, fmt.Sprintf("%v_waypoints.json", kuka.Name().ShortName()))
+}
+
+// saveWaypoint captures the current joints, pose, tool and base of the kuka device under the given name.
+func (kuka *kukaArm) saveWaypoint(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	name, err := getStringArg(cmd, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	// Refresh the state before capturing it
+	if err := kuka.updateState(); err != nil {
+		return nil, err
+	}
+	if err := kuka.sendQueries(ekiCommand.GetToolData, ekiCommand.GetBaseData); err != nil {
+		return nil, err
+	}
+
+	currentState := kuka.getCurrentStateSafe()
+	if len(currentState.joints) != numJoints {
+		return nil, errors.New("current joint positions are unknown, unable to save waypoint")
+	}
+
+	wp := waypoint{
+		Name:    name,
+		Joints:  currentState.joints,
+		Tool:    currentState.toolFrame,
+		Base:    currentState.baseFrame,
+		Created: time.Now(),
+	}
+	if currentState.endEffectorPose != nil {
+		wp.Pose = newWaypointPose(currentState.endEffectorPose)
+	}
+
+	if err := kuka.waypoints.save(wp); err != nil {
+		return nil, err
+	}
+	kuka.logger.Infof("saved waypoint %q at joints %v", name, wp.Joints)
+
+	return toResponseMap(map[string]interface{}{"waypoint": wp})
+}
+
+// listWaypoints returns all stored waypoints.
+func (kuka *kukaArm) listWaypoints() (map[string]interface{}, error) {
+	return toResponseMap(map[string]interface{}{"waypoints": kuka.waypoints.list()})
+}
+
+// deleteWaypoint removes the waypoint with the given name.
+func (kuka *kukaArm) deleteWaypoint(cmd map[string]interface{}) (map[string]interface{}, error) {
+	name, err := getStringArg(cmd, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := kuka.waypoints.delete(name); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"deleted": name}, nil
+}
+
+// moveToWaypoint moves the arm to the joint positions of the waypoint with the given name.
+func (kuka *kukaArm) moveToWaypoint(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	name, err := getStringArg(cmd, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	wp, ok := kuka.waypoints.get(name)
+	if !ok {
+		return nil, errors.Errorf("no waypoint named %q", name)
+	}
+	if len(wp.Joints) != numJoints {
+		return nil, errors.Errorf("waypoint %q has %v joint values (should be %v)", name, len(wp.Joints), numJoints)
+	}
+
+	if err := kuka.MoveToJointPositions(ctx, &pb.JointPositions{Values: wp.Joints}, nil); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"waypoint": name}, nil
+}
+
+// newWaypointPose converts a pose to its stored representation.
+func newWaypointPose(pose spatialmath.Pose) *waypointPose {
+	pt := pose.Point()
+	ov := pose.Orientation().OrientationVectorDegrees()
+	return &waypointPose{X: pt.X, Y: pt.Y, Z: pt.Z, OX: ov.OX, OY: ov.OY, OZ: ov.OZ, Theta: ov.Theta}
+}
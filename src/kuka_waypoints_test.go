@@ -0,0 +1,133 @@
+package kuka
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/golang/geo/r3"
+	"github.com/viam-soleng/viam-kuka/inject"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/rdk/spatialmath"
+	"go.viam.com/test"
+)
+
+func TestWaypointStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "arm_waypoints.json")
+
+	store, err := newWaypointStore(path)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, store.list(), test.ShouldBeEmpty)
+
+	t.Run("save and reload", func(t *testing.T) {
+		test.That(t, store.save(waypoint{Name: "pick", Joints: []float64{1, 2, 3, 4, 5, 6}}), test.ShouldBeNil)
+		test.That(t, store.save(waypoint{Name: "home", Joints: []float64{0, 0, 0, 0, 0, 0}, Tool: []float64{0, 0, 100, 0, 0, 0}}), test.ShouldBeNil)
+
+		waypoints := store.list()
+		test.That(t, len(waypoints), test.ShouldEqual, 2)
+		test.That(t, waypoints[0].Name, test.ShouldEqual, "home")
+		test.That(t, waypoints[1].Name, test.ShouldEqual, "pick")
+
+		reloaded, err := newWaypointStore(path)
+		test.That(t, err, test.ShouldBeNil)
+		wp, ok := reloaded.get("home")
+		test.That(t, ok, test.ShouldBeTrue)
+		test.That(t, wp.Tool, test.ShouldResemble, []float64{0, 0, 100, 0, 0, 0})
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		test.That(t, store.delete("pick"), test.ShouldBeNil)
+		_, ok := store.get("pick")
+		test.That(t, ok, test.ShouldBeFalse)
+
+		err := store.delete("pick")
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "no waypoint named")
+
+		reloaded, err := newWaypointStore(path)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(reloaded.list()), test.ShouldEqual, 1)
+	})
+
+	t.Run("corrupt file", func(t *testing.T) {
+		badPath := filepath.Join(t.TempDir(), "bad.json")
+		test.That(t, os.WriteFile(badPath, []byte("{not json"), 0o600), test.ShouldBeNil)
+		_, err := newWaypointStore(badPath)
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
+
+func TestWaypointCommands(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	store, err := newWaypointStore(filepath.Join(t.TempDir(), "waypoints.json"))
+	test.That(t, err, test.ShouldBeNil)
+
+	kuka := &kukaArm{
+		logger:     logger,
+		stateMutex: sync.Mutex{},
+		currentState: state{
+			joints:          []float64{1, 2, 3, 4, 5, 6},
+			endEffectorPose: spatialmath.NewPoseFromPoint(r3.Vector{X: 100, Y: 200, Z: 300}),
+			toolFrame:       []float64{0, 0, 50, 0, 0, 0},
+			jointLimits: []referenceframe.Limit{
+				{Min: 0, Max: 100},
+				{Min: 0, Max: 100},
+				{Min: 0, Max: 100},
+				{Min: 0, Max: 100},
+				{Min: 0, Max: 100},
+				{Min: 0, Max: 100},
+			},
+		},
+		waypoints: store,
+	}
+
+	conn := inject.NewTCPConn()
+	conn.WriteFunc = func(b []byte) (n int, err error) {
+		return len(b), nil
+	}
+	useInjectedConn(t, kuka, conn)
+
+	t.Run("save", func(t *testing.T) {
+		_, err := kuka.DoCommand(ctx, map[string]interface{}{"command": saveWaypointCommand})
+		test.That(t, err, test.ShouldNotBeNil)
+
+		resp, err := kuka.DoCommand(ctx, map[string]interface{}{"command": saveWaypointCommand, "name": "pick"})
+		test.That(t, err, test.ShouldBeNil)
+		wp := resp["waypoint"].(map[string]interface{})
+		test.That(t, wp["joints"], test.ShouldResemble, []interface{}{1.0, 2.0, 3.0, 4.0, 5.0, 6.0})
+		test.That(t, wp["pose"].(map[string]interface{})["z"], test.ShouldEqual, 300.0)
+		test.That(t, wp["tool"], test.ShouldResemble, []interface{}{0.0, 0.0, 50.0, 0.0, 0.0, 0.0})
+	})
+
+	t.Run("list", func(t *testing.T) {
+		resp, err := kuka.DoCommand(ctx, map[string]interface{}{"command": listWaypointsCommand})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(resp["waypoints"].([]interface{})), test.ShouldEqual, 1)
+	})
+
+	t.Run("move outside joint limits", func(t *testing.T) {
+		test.That(t, store.save(waypoint{Name: "bad", Joints: []float64{-10, 2, 3, 4, 5, 6}}), test.ShouldBeNil)
+		_, err := kuka.DoCommand(ctx, map[string]interface{}{"command": moveToWaypointCommand, "name": "bad"})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "invalid joint position specified")
+	})
+
+	t.Run("move to unknown waypoint", func(t *testing.T) {
+		_, err := kuka.DoCommand(ctx, map[string]interface{}{"command": moveToWaypointCommand, "name": "missing"})
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "no waypoint named")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		resp, err := kuka.DoCommand(ctx, map[string]interface{}{"command": deleteWaypointCommand, "name": "pick"})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, resp["deleted"], test.ShouldEqual, "pick")
+		_, ok := store.get("pick")
+		test.That(t, ok, test.ShouldBeFalse)
+	})
+}
@@ -0,0 +1,176 @@
+package kuka
+
+import (
+	"math"
+
+	"github.com/golang/geo/r3"
+	"github.com/pkg/errors"
+	ekiCommand "github.com/viam-soleng/viam-kuka/src/ekicommands"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/rdk/spatialmath"
+)
+
+// Shapes of keep-out zones
+const (
+	zoneBox      = "box"
+	zoneSphere   = "sphere"
+	zoneCylinder = "cylinder"
+)
+
+// Frames keep-out zones can be given in
+const (
+	zoneFrameWorld = "world"
+	zoneFrameBase  = "base"
+)
+
+// zoneLinearStep is the distance, in mm, between tool positions checked along a linear move.
+const zoneLinearStep = 10.0
+
+// KeepOutZone is a region of space the arm must not enter. The pose (x,y,z in mm, optionally followed by a,b,c in
+// degrees) places the center of the zone in the world frame, or in the base of the arm when frame is "base".
+// Cylinders extend height/2 either side of their center along their z axis.
+type KeepOutZone struct {
+	Name   string    `json:"name"`
+	Type   string    `json:"type"`
+	Frame  string    `json:"frame,omitempty"`
+	Pose   []float64 `json:"pose"`
+	Dims   []float64 `json:"dims,omitempty"`
+	Radius float64   `json:"radius,omitempty"`
+	Height float64   `json:"height,omitempty"`
+}
+
+// Validate ensures the keep-out zone is a supported shape with valid dimensions.
+func (zone *KeepOutZone) Validate(path string) error {
+	if zone.Name == "" {
+		return errors.Errorf("%v: keep-out zones must have a name", path)
+	}
+	if zone.Frame != "" && zone.Frame != zoneFrameWorld && zone.Frame != zoneFrameBase {
+		return errors.Errorf("%v: frame (%v) must be %q or %q", path, zone.Frame, zoneFrameWorld, zoneFrameBase)
+	}
+	if len(zone.Pose) != 3 && len(zone.Pose) != 6 {
+		return errors.Errorf("%v: pose must have 3 (x,y,z) or 6 (x,y,z,a,b,c) values, %v given", path, len(zone.Pose))
+	}
+
+	switch zone.Type {
+	case zoneBox:
+		if len(zone.Dims) != 3 || zone.Dims[0] <= 0 || zone.Dims[1] <= 0 || zone.Dims[2] <= 0 {
+			return errors.Errorf("%v: box zones require positive dims (x,y,z)", path)
+		}
+	case zoneSphere:
+		if zone.Radius <= 0 {
+			return errors.Errorf("%v: sphere zones require a positive radius", path)
+		}
+	case zoneCylinder:
+		if zone.Radius <= 0 || zone.Height <= 0 {
+			return errors.Errorf("%v: cylinder zones require a positive radius and height", path)
+		}
+	default:
+		return errors.Errorf("%v: unknown keep-out zone type %q, must be one of %v", path, zone.Type,
+			[]string{zoneBox, zoneSphere, zoneCylinder})
+	}
+	return nil
+}
+
+// geometry returns the zone as a geometry in the base frame of the arm, which is placed at armPose in the world.
+// Cylinders are checked conservatively as the capsule enclosing them.
+func (zone *KeepOutZone) geometry(armPose spatialmath.Pose) (spatialmath.Geometry, error) {
+	frame := make([]float64, 6)
+	copy(frame, zone.Pose)
+	pose := kukaFrameToPose(frame)
+	if zone.Frame != zoneFrameBase {
+		pose = spatialmath.Compose(spatialmath.PoseInverse(armPose), pose)
+	}
+
+	switch zone.Type {
+	case zoneBox:
+		return spatialmath.NewBox(pose, r3.Vector{X: zone.Dims[0], Y: zone.Dims[1], Z: zone.Dims[2]}, zone.Name)
+	case zoneSphere:
+		return spatialmath.NewSphere(pose, zone.Radius, zone.Name)
+	case zoneCylinder:
+		return spatialmath.NewCapsule(pose, zone.Radius, zone.Height+2*zone.Radius, zone.Name)
+	default:
+		return nil, errors.Errorf("unknown keep-out zone type %q", zone.Type)
+	}
+}
+
+// armPoseInWorld returns the pose of the base of the arm in the world from its frame config, which must have the
+// world as its parent.
+func armPoseInWorld(armFrame *referenceframe.LinkConfig) (spatialmath.Pose, error) {
+	if armFrame == nil || (armFrame.Parent != "" && armFrame.Parent != referenceframe.World) {
+		return nil, errors.New("the arm's frame must have the world as its parent")
+	}
+	return armFrame.Pose()
+}
+
+// newKeepOutZones converts the configured zones into geometries in the base frame of the arm. Zones in the world frame
+// require the arm's frame to be configured with the world as its parent.
+func newKeepOutZones(zones []KeepOutZone, armFrame *referenceframe.LinkConfig) ([]spatialmath.Geometry, error) {
+	geometries := make([]spatialmath.Geometry, 0, len(zones))
+	for _, zone := range zones {
+		armPose := spatialmath.NewZeroPose()
+		if zone.Frame != zoneFrameBase {
+			pose, err := armPoseInWorld(armFrame)
+			if err != nil {
+				return nil, errors.Wrapf(err, "keep-out zone %q is in the world frame", zone.Name)
+			}
+			armPose = pose
+		}
+
+		geometry, err := zone.geometry(armPose)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid keep-out zone %q", zone.Name)
+		}
+		geometries = append(geometries, geometry)
+	}
+	return geometries, nil
+}
+
+// checkKeepOutZonesCartesian checks that the tool does not enter a keep-out zone at the target position (x,y,z,a,b,c
+// in the active base) or, for linear moves, along the line to it. As the joint positions of cartesian targets are
+// resolved by the controller, only the tool position is checked.
+func (kuka *kukaArm) checkKeepOutZonesCartesian(target []float64, linear bool) error {
+	kuka.stateMutex.Lock()
+	zones := kuka.keepOutZones
+	kuka.stateMutex.Unlock()
+
+	if len(zones) == 0 {
+		return nil
+	}
+
+	// Refresh the base and current position the target is relative to
+	if err := kuka.sendQueries(ekiCommand.GetBaseData, ekiCommand.GetEndPosition); err != nil {
+		return err
+	}
+	currentState := kuka.getCurrentStateSafe()
+
+	base := spatialmath.NewZeroPose()
+	if len(currentState.baseFrame) == 6 {
+		base = kukaFrameToPose(currentState.baseFrame)
+	}
+	end := spatialmath.Compose(base, spatialmath.NewPoseFromPoint(r3.Vector{X: target[0], Y: target[1], Z: target[2]})).Point()
+
+	points := []r3.Vector{end}
+	if linear && currentState.endEffectorPose != nil {
+		start := spatialmath.Compose(base, spatialmath.NewPoseFromPoint(currentState.endEffectorPose.Point())).Point()
+		numSteps := int(math.Max(1, math.Ceil(end.Sub(start).Norm()/zoneLinearStep)))
+		points = make([]r3.Vector, 0, numSteps)
+		for n := 1; n <= numSteps; n++ {
+			points = append(points, start.Add(end.Sub(start).Mul(float64(n)/float64(numSteps))))
+		}
+	}
+
+	for _, pt := range points {
+		tool := spatialmath.NewPoint(pt, "tool")
+		for _, zone := range zones {
+			collides, err := tool.CollidesWith(zone, 0)
+			if err != nil {
+				return err
+			}
+			if collides {
+				return errors.Errorf("move rejected, the tool would enter keep-out zone %q at (%.1f, %.1f, %.1f)",
+					zone.Label(), pt.X, pt.Y, pt.Z)
+			}
+		}
+	}
+	return nil
+}
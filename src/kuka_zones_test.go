@@ -0,0 +1,153 @@
+package kuka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/golang/geo/r3"
+	"github.com/viam-soleng/viam-kuka/inject"
+	pb "go.viam.com/api/component/arm/v1"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/referenceframe"
+	"go.viam.com/rdk/referenceframe/urdf"
+	"go.viam.com/rdk/spatialmath"
+	"go.viam.com/test"
+)
+
+func TestKeepOutZoneValidate(t *testing.T) {
+	zoneTests := []struct {
+		description string
+		zone        KeepOutZone
+		errContains string
+	}{
+		{description: "no name", zone: KeepOutZone{Type: zoneSphere, Pose: []float64{0, 0, 0}, Radius: 1}, errContains: "must have a name"},
+		{description: "bad frame", zone: KeepOutZone{Name: "z", Type: zoneSphere, Frame: "tool", Pose: []float64{0, 0, 0}, Radius: 1}, errContains: "frame (tool)"},
+		{description: "bad pose", zone: KeepOutZone{Name: "z", Type: zoneSphere, Pose: []float64{0, 0}, Radius: 1}, errContains: "pose must have"},
+		{description: "bad box", zone: KeepOutZone{Name: "z", Type: zoneBox, Pose: []float64{0, 0, 0}, Dims: []float64{1, 0, 1}}, errContains: "positive dims"},
+		{description: "bad cylinder", zone: KeepOutZone{Name: "z", Type: zoneCylinder, Pose: []float64{0, 0, 0}, Radius: 1}, errContains: "positive radius and height"},
+		{description: "unknown type", zone: KeepOutZone{Name: "z", Type: "cone", Pose: []float64{0, 0, 0}}, errContains: "unknown keep-out zone type"},
+		{description: "valid", zone: KeepOutZone{Name: "z", Type: zoneBox, Frame: zoneFrameBase, Pose: []float64{0, 0, 0, 90, 0, 0}, Dims: []float64{1, 2, 3}}},
+	}
+
+	for _, tt := range zoneTests {
+		t.Run(tt.description, func(t *testing.T) {
+			err := tt.zone.Validate("path")
+			if tt.errContains == "" {
+				test.That(t, err, test.ShouldBeNil)
+				return
+			}
+			test.That(t, err, test.ShouldNotBeNil)
+			test.That(t, err.Error(), test.ShouldContainSubstring, tt.errContains)
+		})
+	}
+}
+
+func TestNewKeepOutZones(t *testing.T) {
+	zones := []KeepOutZone{
+		{Name: "table", Type: zoneBox, Pose: []float64{1000, 0, 0}, Dims: []float64{100, 100, 100}},
+		{Name: "column", Type: zoneCylinder, Frame: zoneFrameBase, Pose: []float64{0, 500, 0}, Radius: 50, Height: 1000},
+	}
+
+	t.Run("world zones need the arm frame", func(t *testing.T) {
+		_, err := newKeepOutZones(zones, nil)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, `"table" is in the world frame`)
+
+		_, err = newKeepOutZones(zones, &referenceframe.LinkConfig{Parent: "gantry"})
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+
+	t.Run("placed in base frame", func(t *testing.T) {
+		geometries, err := newKeepOutZones(zones, &referenceframe.LinkConfig{
+			Parent:      referenceframe.World,
+			Translation: r3.Vector{X: 200, Y: 0, Z: 100},
+		})
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(geometries), test.ShouldEqual, 2)
+
+		// The table is offset by the arm's position in the world, the column is already in the base frame
+		inTable := spatialmath.NewPoint(r3.Vector{X: 800, Y: 0, Z: -100}, "")
+		collides, err := inTable.CollidesWith(geometries[0], 0)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, collides, test.ShouldBeTrue)
+
+		inColumn := spatialmath.NewPoint(r3.Vector{X: 0, Y: 540, Z: 450}, "")
+		collides, err = inColumn.CollidesWith(geometries[1], 0)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, collides, test.ShouldBeTrue)
+		test.That(t, geometries[1].Label(), test.ShouldEqual, "column")
+	})
+}
+
+func TestInterpolateJoints(t *testing.T) {
+	configurations := interpolateJoints([]float64{0, 0, 0, 0, 0, 0}, []float64{5, -2, 0, 0, 0, 1}, 2)
+	test.That(t, len(configurations), test.ShouldEqual, 3)
+	test.That(t, configurations[2], test.ShouldResemble, []float64{5, -2, 0, 0, 0, 1})
+
+	configurations = interpolateJoints([]float64{1, 1, 1, 1, 1, 1}, []float64{1, 1, 1, 1, 1, 1}, 2)
+	test.That(t, configurations, test.ShouldResemble, [][]float64{{1, 1, 1, 1, 1, 1}})
+}
+
+func TestCheckKeepOutZones(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	urdfModel, err := urdf.ParseModelXMLFile(resolveFile(fmt.Sprintf("src/models/%v_model.urdf", kr10r900)), "test")
+	test.That(t, err, test.ShouldBeNil)
+
+	// Place a zone where the flange is when a1 passes through 0
+	midPose, err := urdfModel.Transform(urdfModel.InputFromProtobuf(&pb.JointPositions{Values: []float64{0, -90, 90, 0, 45, 0}}))
+	test.That(t, err, test.ShouldBeNil)
+	zone, err := spatialmath.NewSphere(spatialmath.NewPoseFromPoint(midPose.Point()), 50, "fixture")
+	test.That(t, err, test.ShouldBeNil)
+
+	writes := 0
+	conn := inject.NewTCPConn()
+	conn.WriteFunc = func(b []byte) (n int, err error) {
+		writes++
+		return len(b), nil
+	}
+
+	limits := make([]referenceframe.Limit, numJoints)
+	for i := range limits {
+		limits[i] = referenceframe.Limit{Min: -170, Max: 170}
+	}
+	kuka := &kukaArm{
+		logger:       logger,
+		stateMutex:   sync.Mutex{},
+		model:        urdfModel,
+		keepOutZones: []spatialmath.Geometry{zone},
+		currentState: state{
+			joints:          []float64{-90, -90, 90, 0, 45, 0},
+			jointLimits:     limits,
+			endEffectorPose: spatialmath.NewPoseFromPoint(r3.Vector{X: 0, Y: -600, Z: 600}),
+		},
+	}
+	useInjectedConn(t, kuka, conn)
+
+	t.Run("joint move through zone", func(t *testing.T) {
+		err := kuka.MoveToJointPositions(ctx, &pb.JointPositions{Values: []float64{90, -90, 90, 0, 45, 0}}, nil)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, `would enter keep-out zone "fixture"`)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "test:")
+		test.That(t, writes, test.ShouldEqual, 0)
+	})
+
+	t.Run("joint move clear of zone", func(t *testing.T) {
+		test.That(t, kuka.checkJointMove([]float64{-80, -90, 90, 0, 45, 0}), test.ShouldBeNil)
+	})
+
+	t.Run("cartesian moves", func(t *testing.T) {
+		pt := midPose.Point()
+		err := kuka.checkKeepOutZonesCartesian([]float64{pt.X, pt.Y, pt.Z, 0, 90, 0}, false)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, `the tool would enter keep-out zone "fixture"`)
+
+		// The line from the current position passes through the zone
+		beyond := []float64{2 * pt.X, 600 + 2*pt.Y, 2*pt.Z - 600, 0, 90, 0}
+		test.That(t, kuka.checkKeepOutZonesCartesian(beyond, false), test.ShouldBeNil)
+		test.That(t, kuka.checkKeepOutZonesCartesian(beyond, true), test.ShouldNotBeNil)
+	})
+}
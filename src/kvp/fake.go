@@ -0,0 +1,146 @@
+package kvp
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"go.viam.com/utils"
+)
+
+// FakeServer stands in for KukaVarProxy, for testing without a controller. It holds the variables it was given, read
+// and written by name regardless of case as KRL is, and fails requests for any other variable.
+type FakeServer struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	vars    map[string]string
+	onWrite func(name, value string)
+	conns   map[net.Conn]struct{}
+
+	workers sync.WaitGroup
+}
+
+// NewFakeServer starts a fake server on a local port with the given variables.
+func NewFakeServer(vars map[string]string) (*FakeServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &FakeServer{listener: listener, vars: map[string]string{}, conns: map[net.Conn]struct{}{}}
+	for name, value := range vars {
+		s.vars[strings.ToUpper(name)] = value
+	}
+
+	s.workers.Add(1)
+	utils.PanicCapturingGo(func() {
+		defer s.workers.Done()
+		s.accept()
+	})
+	return s, nil
+}
+
+// Addr returns the address of the server.
+func (s *FakeServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and closes its connections.
+func (s *FakeServer) Close() error {
+	err := s.listener.Close()
+	s.Disconnect()
+	s.workers.Wait()
+	return err
+}
+
+// Disconnect closes the connections of the clients, as when the controller restarts KukaVarProxy.
+func (s *FakeServer) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		utils.UncheckedError(conn.Close())
+	}
+}
+
+// Get returns the value of a variable and whether it exists.
+func (s *FakeServer) Get(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.vars[strings.ToUpper(name)]
+	return value, ok
+}
+
+// Set sets a variable, declaring it if needed.
+func (s *FakeServer) Set(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vars[strings.ToUpper(name)] = value
+}
+
+// OnWrite sets a function called, in its own goroutine, after each variable written by a client, to simulate the
+// program on the controller.
+func (s *FakeServer) OnWrite(onWrite func(name, value string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onWrite = onWrite
+}
+
+func (s *FakeServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.workers.Add(1)
+		utils.PanicCapturingGo(func() {
+			defer s.workers.Done()
+			s.serve(conn)
+		})
+	}
+}
+
+func (s *FakeServer) serve(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		utils.UncheckedError(conn.Close())
+	}()
+
+	for {
+		req, err := readRequest(conn)
+		if err != nil {
+			return
+		}
+		value, ok, onWrite := s.handle(req)
+		if _, err := conn.Write(encodeReply(req.id, req.function, value, ok)); err != nil {
+			return
+		}
+		if onWrite != nil {
+			s.workers.Add(1)
+			utils.PanicCapturingGo(func() {
+				defer s.workers.Done()
+				onWrite(req.name, req.value)
+			})
+		}
+	}
+}
+
+// handle reads or writes the variable of a request, returning its value, whether it exists and the write hook to call.
+func (s *FakeServer) handle(req message) (string, bool, func(name, value string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := strings.ToUpper(req.name)
+	if _, ok := s.vars[name]; !ok {
+		return "", false, nil
+	}
+	if req.function == functionWrite {
+		s.vars[name] = req.value
+		return req.value, true, s.onWrite
+	}
+	return s.vars[name], true, nil
+}
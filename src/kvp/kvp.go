@@ -0,0 +1,247 @@
+// Package kvp implements the client side of the KukaVarProxy (OpenShowVar) protocol, used to read and write the
+// variables of a KUKA controller over TCP. Every request and reply starts with a message id and the length of the rest
+// of the message, both big endian uint16, followed by the function and the variable name and value:
+//
+//	request: id, length, function (0 read, 1 write), name length, name[, value length, value]
+//	reply:   id, length, function, value length, value, status (3 bytes, the last being 1 on success)
+//
+// Values are written and read as KRL literals, e.g. "TRUE", "42" or "{E6AXIS: A1 0.0, ...}".
+package kvp
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/utils"
+)
+
+// DefaultPort is the port KukaVarProxy listens on.
+const DefaultPort = 7000
+
+const (
+	functionRead  byte = 0
+	functionWrite byte = 1
+
+	headerSize = 4
+	statusSize = 3
+	// maxFieldSize bounds variable names and values, whose lengths are sent as uint16
+	maxFieldSize = 0xFFFF - 16
+)
+
+// message is a request to or reply from KukaVarProxy.
+type message struct {
+	id       uint16
+	function byte
+	name     string
+	value    string
+	ok       bool
+}
+
+// encodeRequest encodes a read request, or a write request if function is functionWrite.
+func encodeRequest(id uint16, function byte, name, value string) []byte {
+	body := []byte{function}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(name)))
+	body = append(body, name...)
+	if function == functionWrite {
+		body = binary.BigEndian.AppendUint16(body, uint16(len(value)))
+		body = append(body, value...)
+	}
+	return append(encodeHeader(id, len(body)), body...)
+}
+
+// encodeReply encodes the reply to a request, giving the value of the variable.
+func encodeReply(id uint16, function byte, value string, ok bool) []byte {
+	body := []byte{function}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(value)))
+	body = append(body, value...)
+	status := []byte{0, 1, 0}
+	if ok {
+		status[2] = 1
+	}
+	body = append(body, status...)
+	return append(encodeHeader(id, len(body)), body...)
+}
+
+func encodeHeader(id uint16, length int) []byte {
+	header := binary.BigEndian.AppendUint16(nil, id)
+	return binary.BigEndian.AppendUint16(header, uint16(length))
+}
+
+// readBody reads a message from r, returning its id and the body following the header.
+func readBody(r io.Reader) (uint16, []byte, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint16(header), body, nil
+}
+
+// readField reads a uint16 length prefixed field from the start of data, returning it and the rest of data.
+func readField(data []byte) (string, []byte, error) {
+	if len(data) < 2 {
+		return "", nil, errors.New("message too short")
+	}
+	length := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+length {
+		return "", nil, errors.Errorf("field of %v bytes exceeds the message", length)
+	}
+	return string(data[2 : 2+length]), data[2+length:], nil
+}
+
+// readRequest reads a request from r.
+func readRequest(r io.Reader) (message, error) {
+	id, body, err := readBody(r)
+	if err != nil {
+		return message{}, err
+	}
+	if len(body) < 1 {
+		return message{}, errors.New("empty request")
+	}
+	req := message{id: id, function: body[0]}
+	name, rest, err := readField(body[1:])
+	if err != nil {
+		return message{}, err
+	}
+	req.name = name
+	if req.function == functionWrite {
+		if req.value, _, err = readField(rest); err != nil {
+			return message{}, err
+		}
+	}
+	return req, nil
+}
+
+// readReply reads a reply from r.
+func readReply(r io.Reader) (message, error) {
+	id, body, err := readBody(r)
+	if err != nil {
+		return message{}, err
+	}
+	if len(body) < 1+2+statusSize {
+		return message{}, errors.Errorf("reply of %v bytes is too short", len(body))
+	}
+	reply := message{id: id, function: body[0], ok: body[len(body)-1] == 1}
+	value, rest, err := readField(body[1 : len(body)-statusSize])
+	if err != nil {
+		return message{}, err
+	}
+	if len(rest) > 0 {
+		return message{}, errors.Errorf("%v unexpected bytes in reply", len(rest))
+	}
+	reply.value = value
+	return reply, nil
+}
+
+// Client reads and writes variables through KukaVarProxy. KukaVarProxy handles one request at a time per connection,
+// so requests are serialized. After a request fails to be sent or answered, the next one reconnects.
+type Client struct {
+	address string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	nextID uint16
+}
+
+// Dial connects to KukaVarProxy at the given address, with timeout bounding each request.
+func Dial(ctx context.Context, address string, timeout time.Duration) (*Client, error) {
+	c := &Client{address: address, timeout: timeout}
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) connect(ctx context.Context) error {
+	var d net.Dialer
+	dialCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn, err := d.DialContext(dialCtx, "tcp", c.address)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to KukaVarProxy at %v", c.address)
+	}
+	c.conn = conn
+	return nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Read returns the value of the named variable, e.g. "$OV_PRO".
+func (c *Client) Read(name string) (string, error) {
+	return c.request(functionRead, name, "")
+}
+
+// Write sets the named variable to value, returning the value read back by KukaVarProxy.
+func (c *Client) Write(name, value string) (string, error) {
+	return c.request(functionWrite, name, value)
+}
+
+func (c *Client) request(function byte, name, value string) (string, error) {
+	if name == "" {
+		return "", errors.New("variable name must not be empty")
+	}
+	if len(name) > maxFieldSize || len(value) > maxFieldSize {
+		return "", errors.Errorf("variable %v is too long to send", name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(context.Background()); err != nil {
+			return "", err
+		}
+	}
+	reply, err := c.exchange(encodeRequest(c.nextID, function, name, value), c.nextID)
+	c.nextID++
+	if err != nil {
+		// The connection may be out of step with the replies, so it is replaced
+		utils.UncheckedError(c.conn.Close())
+		c.conn = nil
+		return "", errors.Wrapf(err, "request for %v failed", name)
+	}
+	if !reply.ok {
+		if function == functionWrite {
+			return "", errors.Errorf("KukaVarProxy failed to write %v = %v", name, value)
+		}
+		return "", errors.Errorf("KukaVarProxy failed to read %v", name)
+	}
+	return reply.value, nil
+}
+
+// exchange sends a request and reads its reply.
+func (c *Client) exchange(request []byte, id uint16) (message, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return message{}, err
+	}
+	if _, err := c.conn.Write(request); err != nil {
+		return message{}, err
+	}
+	reply, err := readReply(c.conn)
+	if err != nil {
+		return message{}, err
+	}
+	if reply.id != id {
+		return message{}, errors.Errorf("reply has message id %v, expected %v", reply.id, id)
+	}
+	return reply, nil
+}
@@ -0,0 +1,98 @@
+package kvp
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestMessages(t *testing.T) {
+	t.Run("read request", func(t *testing.T) {
+		data := encodeRequest(3, functionRead, "$OV_PRO", "")
+		test.That(t, data, test.ShouldResemble, append([]byte{0, 3, 0, 10, 0, 0, 7}, "$OV_PRO"...))
+
+		req, err := readRequest(bytes.NewReader(data))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, req, test.ShouldResemble, message{id: 3, function: functionRead, name: "$OV_PRO"})
+	})
+
+	t.Run("write request", func(t *testing.T) {
+		data := encodeRequest(0x0102, functionWrite, "VIAM_VEL", "25")
+		test.That(t, data[:5], test.ShouldResemble, []byte{1, 2, 0, 15, functionWrite})
+
+		req, err := readRequest(bytes.NewReader(data))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, req, test.ShouldResemble, message{id: 0x0102, function: functionWrite, name: "VIAM_VEL", value: "25"})
+	})
+
+	t.Run("reply", func(t *testing.T) {
+		data := encodeReply(3, functionRead, "100", true)
+		test.That(t, data, test.ShouldResemble, append(append([]byte{0, 3, 0, 9, 0, 0, 3}, "100"...), 0, 1, 1))
+
+		reply, err := readReply(bytes.NewReader(data))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, reply, test.ShouldResemble, message{id: 3, function: functionRead, value: "100", ok: true})
+
+		reply, err = readReply(bytes.NewReader(encodeReply(4, functionWrite, "", false)))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, reply.ok, test.ShouldBeFalse)
+
+		_, err = readReply(bytes.NewReader([]byte{0, 3, 0, 2, 0, 0}))
+		test.That(t, err, test.ShouldNotBeNil)
+		_, err = readReply(bytes.NewReader(data[:6]))
+		test.That(t, err, test.ShouldNotBeNil)
+	})
+}
+
+func TestClient(t *testing.T) {
+	server, err := NewFakeServer(map[string]string{"$OV_PRO": "100", "viam_vel": "10"})
+	test.That(t, err, test.ShouldBeNil)
+	defer func() { test.That(t, server.Close(), test.ShouldBeNil) }()
+
+	writes := make(chan string, 1)
+	server.OnWrite(func(name, value string) { writes <- name + "=" + value })
+
+	client, err := Dial(context.Background(), server.Addr(), time.Second)
+	test.That(t, err, test.ShouldBeNil)
+	defer func() { test.That(t, client.Close(), test.ShouldBeNil) }()
+
+	value, err := client.Read("$ov_pro")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, value, test.ShouldEqual, "100")
+
+	value, err = client.Write("VIAM_VEL", "25")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, value, test.ShouldEqual, "25")
+	test.That(t, <-writes, test.ShouldEqual, "VIAM_VEL=25")
+	value, ok := server.Get("viam_vel")
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, value, test.ShouldEqual, "25")
+
+	_, err = client.Read("$UNKNOWN")
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "failed to read $UNKNOWN")
+	_, err = client.Write("$UNKNOWN", "1")
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// The connection is still usable after a failed request
+	value, err = client.Read("$OV_PRO")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, value, test.ShouldEqual, "100")
+
+	_, err = client.Read("")
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// A dropped connection fails the request in progress and is replaced by the next
+	server.Disconnect()
+	_, err = client.Read("$OV_PRO")
+	test.That(t, err, test.ShouldNotBeNil)
+	value, err = client.Read("$OV_PRO")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, value, test.ShouldEqual, "100")
+
+	_, err = Dial(context.Background(), "127.0.0.1:1", 100*time.Millisecond)
+	test.That(t, err, test.ShouldNotBeNil)
+}
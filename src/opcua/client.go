@@ -0,0 +1,452 @@
+package opcua
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/utils"
+)
+
+const (
+	// channelLifetime and sessionTimeout are requested from the server, which may revise them
+	channelLifetime = time.Hour
+	sessionTimeout  = time.Hour
+	// defaultMaxReferences is the number of references asked for when browsing, the rest being read with BrowseNext
+	defaultMaxReferences = 1000
+)
+
+// Client reads, writes and browses the nodes of an OPC UA server, in an anonymous session over a secure channel without
+// security. Requests are serialized. After a request fails to be sent or answered, or once the secure channel or
+// session is due to expire, the next request connects again.
+type Client struct {
+	endpoint      string
+	address       string
+	timeout       time.Duration
+	maxReferences uint32
+
+	mu         sync.Mutex
+	conn       net.Conn
+	chunkLimit uint32
+	channelID  uint32
+	tokenID    uint32
+	sequence   uint32
+	requestID  uint32
+	handle     uint32
+	authToken  NodeID
+	// renewAt is when the connection is replaced, ahead of the secure channel or session expiring
+	renewAt time.Time
+}
+
+// Dial connects to the OPC UA server at the given endpoint url, e.g. "opc.tcp://172.31.1.147:4840", with timeout
+// bounding each request.
+func Dial(ctx context.Context, endpoint string, timeout time.Duration) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid endpoint %v", endpoint)
+	}
+	if u.Scheme != "opc.tcp" || u.Hostname() == "" {
+		return nil, errors.Errorf("endpoint %v must be an opc.tcp url with a host", endpoint)
+	}
+	port := u.Port()
+	if port == "" {
+		port = strconv.Itoa(DefaultPort)
+	}
+
+	c := &Client{
+		endpoint:      endpoint,
+		address:       net.JoinHostPort(u.Hostname(), port),
+		timeout:       timeout,
+		maxReferences: defaultMaxReferences,
+	}
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) connect(ctx context.Context) error {
+	var d net.Dialer
+	dialCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	conn, err := d.DialContext(dialCtx, "tcp", c.address)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to the OPC UA server at %v", c.endpoint)
+	}
+	c.conn = conn
+	c.channelID, c.tokenID, c.sequence, c.requestID, c.authToken = 0, 0, 0, 0, NodeID{}
+	if err := c.open(); err != nil {
+		utils.UncheckedError(conn.Close())
+		c.conn = nil
+		return errors.Wrapf(err, "failed to open a session with the OPC UA server at %v", c.endpoint)
+	}
+	return nil
+}
+
+// open agrees on the buffer sizes of the connection, then opens a secure channel and an anonymous session.
+func (c *Client) open() error {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+
+	hello := &encoder{}
+	hello.uint32(0) // protocol version
+	hello.uint32(bufferSize)
+	hello.uint32(bufferSize)
+	hello.uint32(maxMessageSize)
+	hello.uint32(0) // no chunk count limit
+	hello.string(c.endpoint)
+	if err := writeChunk(c.conn, messageHello, chunkFinal, hello.buf); err != nil {
+		return err
+	}
+	messageType, _, body, err := readChunk(c.conn)
+	if err != nil {
+		return err
+	}
+	switch messageType {
+	case messageAck:
+	case messageError:
+		return decodeError(body)
+	default:
+		return errors.Errorf("expected %v, got %v", messageAck, messageType)
+	}
+	ack := &decoder{data: body}
+	ack.uint32()
+	// The chunks sent must fit the receive buffer of the server
+	c.chunkLimit = ack.uint32()
+	if ack.err != nil {
+		return errors.Wrap(ack.err, "malformed acknowledge")
+	}
+
+	request := &encoder{}
+	request.uint32(0) // protocol version
+	request.uint32(0) // issue a token
+	request.uint32(securityModeNone)
+	request.byteString(nil)
+	request.uint32(uint32(channelLifetime.Milliseconds()))
+	d, err := c.exchange(messageOpen, idOpenSecureChannelRequest, idOpenSecureChannelResponse, request.buf)
+	if err != nil {
+		return err
+	}
+	d.uint32()
+	c.channelID = d.uint32()
+	c.tokenID = d.uint32()
+	d.dateTime()
+	lifetime := time.Duration(d.uint32()) * time.Millisecond
+	d.byteString()
+	if d.err != nil {
+		return errors.Wrap(d.err, "malformed open secure channel response")
+	}
+
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	request = &encoder{}
+	// Client application description
+	request.string("urn:viam-kuka:client")
+	request.string("urn:viam-kuka")
+	request.localizedText("viam-kuka")
+	request.uint32(applicationTypeClient)
+	request.string("")
+	request.string("")
+	request.int32(-1)
+	request.string("")
+	request.string(c.endpoint)
+	request.string("viam-kuka")
+	request.byteString(nonce)
+	request.byteString(nil)
+	request.float64(float64(sessionTimeout.Milliseconds()))
+	request.uint32(maxMessageSize)
+	if d, err = c.exchange(messageMsg, idCreateSessionRequest, idCreateSessionResponse, request.buf); err != nil {
+		return err
+	}
+	d.nodeID()
+	authToken := d.nodeID()
+	revisedTimeout := time.Duration(d.float64()) * time.Millisecond
+	d.byteString()
+	d.byteString()
+	endpoints := d.endpoints()
+	if d.err != nil {
+		return errors.Wrap(d.err, "malformed create session response")
+	}
+	policyID, err := anonymousPolicyID(endpoints)
+	if err != nil {
+		return err
+	}
+
+	c.authToken = authToken
+	request = &encoder{}
+	request.string("")
+	request.byteString(nil)
+	request.int32(-1)
+	request.int32(-1)
+	token := &encoder{}
+	token.string(policyID)
+	request.extensionObject(idAnonymousIdentityToken, token.buf)
+	request.string("")
+	request.byteString(nil)
+	if d, err = c.exchange(messageMsg, idActivateSessionRequest, idActivateSessionResponse, request.buf); err != nil {
+		return err
+	}
+	d.byteString()
+	results := d.statusCodes()
+	d.diagnosticInfos()
+	if d.err != nil {
+		return errors.Wrap(d.err, "malformed activate session response")
+	}
+	for _, result := range results {
+		if result.IsBad() {
+			return errors.Wrap(result, "session refused")
+		}
+	}
+
+	// Renew well before the first of the secure channel and the session can expire
+	renewAfter := lifetime
+	if revisedTimeout < renewAfter {
+		renewAfter = revisedTimeout
+	}
+	c.renewAt = time.Now().Add(renewAfter * 3 / 4)
+	return nil
+}
+
+// anonymousPolicyID returns the policy id of the anonymous user tokens accepted by an endpoint without security.
+func anonymousPolicyID(endpoints []endpoint) (string, error) {
+	for _, ep := range endpoints {
+		if ep.securityMode == securityModeNone && ep.securityPolicy == securityPolicyNone && ep.anonymousPolicyID != "" {
+			return ep.anonymousPolicyID, nil
+		}
+	}
+	return "", errors.New("the server has no endpoint accepting anonymous sessions without security")
+}
+
+// exchange sends a service request, of the given encoding id and fields following the request header, and reads its
+// response, returning a decoder of the fields following the response header.
+func (c *Client) exchange(messageType string, requestType, responseType uint32, fields []byte) (*decoder, error) {
+	c.handle++
+	c.sequence++
+	c.requestID++
+	e := &encoder{}
+	e.nodeID(NewNumericNodeID(0, requestType))
+	e.requestHeader(c.authToken, c.handle, c.timeout)
+	e.buf = append(e.buf, fields...)
+	msg := secureMessage{
+		messageType: messageType,
+		channelID:   c.channelID,
+		tokenID:     c.tokenID,
+		sequence:    c.sequence,
+		requestID:   c.requestID,
+		service:     e.buf,
+	}
+	if err := writeSecureMessage(&limitedWriter{conn: c.conn, limit: c.chunkLimit}, msg); err != nil {
+		return nil, err
+	}
+
+	reply, err := readSecureMessage(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	if reply.requestID != msg.requestID {
+		return nil, errors.Errorf("response to request %v, expected %v", reply.requestID, msg.requestID)
+	}
+	d := &decoder{data: reply.service}
+	typeID := d.nodeID()
+	result := d.responseHeader()
+	if d.err != nil {
+		return nil, errors.Wrap(d.err, "malformed response")
+	}
+	if typeID == NewNumericNodeID(0, idServiceFault) {
+		return nil, errors.Wrap(result, "service fault")
+	}
+	if typeID != NewNumericNodeID(0, responseType) {
+		return nil, errors.Errorf("response %v, expected %v", typeID, NewNumericNodeID(0, responseType))
+	}
+	if result.IsBad() {
+		return nil, result
+	}
+	return d, nil
+}
+
+// limitedWriter writes to the connection, refusing chunks larger than the receive buffer of the server.
+type limitedWriter struct {
+	conn  net.Conn
+	limit uint32
+}
+
+func (w *limitedWriter) Write(b []byte) (int, error) {
+	if w.limit > 0 && uint32(len(b)) > w.limit {
+		return 0, errors.Errorf("message of %v bytes exceeds the %v byte buffer of the server", len(b), w.limit)
+	}
+	return w.conn.Write(b)
+}
+
+// call sends a service request with the given fields following the request header, connecting first if needed, and
+// returns a decoder of the fields following the response header.
+func (c *Client) call(requestType, responseType uint32, fields []byte) (*decoder, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil && time.Now().After(c.renewAt) {
+		utils.UncheckedError(c.disconnect())
+	}
+	if c.conn == nil {
+		if err := c.connect(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	d, err := c.exchange(messageMsg, requestType, responseType, fields)
+	if err != nil {
+		// The connection may be out of step with the responses, or the session gone, so it is replaced
+		utils.UncheckedError(c.conn.Close())
+		c.conn = nil
+		return nil, err
+	}
+	return d, nil
+}
+
+// disconnect closes the session and secure channel, then the connection.
+func (c *Client) disconnect() error {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err == nil {
+		request := &encoder{}
+		request.boolean(true) // delete subscriptions
+		if _, err := c.exchange(messageMsg, idCloseSessionRequest, idCloseSessionResponse, request.buf); err == nil {
+			c.sequence++
+			c.requestID++
+			closeRequest := &encoder{}
+			closeRequest.nodeID(NewNumericNodeID(0, idCloseSecureChannelRequest))
+			closeRequest.requestHeader(c.authToken, c.handle, c.timeout)
+			utils.UncheckedError(writeSecureMessage(c.conn, secureMessage{
+				messageType: messageClose,
+				channelID:   c.channelID,
+				tokenID:     c.tokenID,
+				sequence:    c.sequence,
+				requestID:   c.requestID,
+				service:     closeRequest.buf,
+			}))
+		}
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Close closes the session and the connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	return c.disconnect()
+}
+
+// Read returns the value of a variable.
+func (c *Client) Read(node NodeID) (interface{}, error) {
+	request := &encoder{}
+	request.float64(0) // max age
+	request.uint32(timestampsNeither)
+	request.int32(1)
+	request.nodeID(node)
+	request.uint32(attributeValue)
+	request.string("")
+	request.qualifiedName(QualifiedName{})
+	d, err := c.call(idReadRequest, idReadResponse, request.buf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %v", node)
+	}
+
+	results := make([]DataValue, d.length())
+	for i := range results {
+		results[i] = d.dataValue()
+	}
+	d.diagnosticInfos()
+	if d.err != nil {
+		return nil, errors.Wrapf(d.err, "malformed read response for %v", node)
+	}
+	if len(results) != 1 {
+		return nil, errors.Errorf("%v values read for %v", len(results), node)
+	}
+	if results[0].Status.IsBad() {
+		return nil, errors.Wrapf(results[0].Status, "failed to read %v", node)
+	}
+	return results[0].Value, nil
+}
+
+// Write sets the value of a variable, which must be of the data type of the variable.
+func (c *Client) Write(node NodeID, value interface{}) error {
+	request := &encoder{}
+	request.int32(1)
+	request.nodeID(node)
+	request.uint32(attributeValue)
+	request.string("")
+	if err := request.dataValue(DataValue{Value: value}); err != nil {
+		return errors.Wrapf(err, "failed to write %v", node)
+	}
+	d, err := c.call(idWriteRequest, idWriteResponse, request.buf)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write %v", node)
+	}
+
+	results := d.statusCodes()
+	d.diagnosticInfos()
+	if d.err != nil {
+		return errors.Wrapf(d.err, "malformed write response for %v", node)
+	}
+	if len(results) != 1 {
+		return errors.Errorf("%v results written for %v", len(results), node)
+	}
+	if results[0].IsBad() {
+		return errors.Wrapf(results[0], "failed to write %v", node)
+	}
+	return nil
+}
+
+// Browse returns the nodes the given node references hierarchically, such as the objects organized by a folder and
+// the components and properties of an object.
+func (c *Client) Browse(node NodeID) ([]Reference, error) {
+	request := &encoder{}
+	// The default view
+	request.nodeID(NodeID{})
+	request.dateTime(time.Time{})
+	request.uint32(0)
+	request.uint32(c.maxReferences)
+	request.int32(1)
+	request.nodeID(node)
+	request.uint32(browseForward)
+	request.nodeID(NewNumericNodeID(0, idHierarchicalReferences))
+	request.boolean(true) // include subtypes
+	request.uint32(0)     // all node classes
+	request.uint32(browseResultAll)
+	d, err := c.call(idBrowseRequest, idBrowseResponse, request.buf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to browse %v", node)
+	}
+	refs, continuationPoint, err := d.browseResult()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to browse %v", node)
+	}
+
+	for len(continuationPoint) > 0 {
+		request = &encoder{}
+		request.boolean(false) // continue rather than release
+		request.int32(1)
+		request.byteString(continuationPoint)
+		if d, err = c.call(idBrowseNextRequest, idBrowseNextResponse, request.buf); err != nil {
+			return nil, errors.Wrapf(err, "failed to browse %v", node)
+		}
+		var more []Reference
+		if more, continuationPoint, err = d.browseResult(); err != nil {
+			return nil, errors.Wrapf(err, "failed to browse %v", node)
+		}
+		refs = append(refs, more...)
+	}
+	return refs, nil
+}
@@ -0,0 +1,663 @@
+package opcua
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Kinds of node identifier
+const (
+	nodeIDNumeric byte = iota
+	nodeIDString
+	nodeIDGUID
+	nodeIDOpaque
+)
+
+// NodeID identifies a node of the address space by a numeric, string, GUID or opaque identifier within a namespace.
+// The zero value is the null node id.
+type NodeID struct {
+	Namespace uint16
+	kind      byte
+	numeric   uint32
+	// text holds string identifiers, and the bytes of GUID and opaque ones
+	text string
+}
+
+// NewNumericNodeID returns the node id with the given numeric identifier.
+func NewNumericNodeID(namespace uint16, id uint32) NodeID {
+	return NodeID{Namespace: namespace, kind: nodeIDNumeric, numeric: id}
+}
+
+// NewStringNodeID returns the node id with the given string identifier.
+func NewStringNodeID(namespace uint16, id string) NodeID {
+	return NodeID{Namespace: namespace, kind: nodeIDString, text: id}
+}
+
+// String formats the node id as in the OPC UA specification, e.g. "ns=2;s=Robot".
+func (id NodeID) String() string {
+	switch id.kind {
+	case nodeIDString:
+		return fmt.Sprintf("ns=%v;s=%v", id.Namespace, id.text)
+	case nodeIDGUID:
+		return fmt.Sprintf("ns=%v;g=%x", id.Namespace, id.text)
+	case nodeIDOpaque:
+		return fmt.Sprintf("ns=%v;b=%x", id.Namespace, id.text)
+	default:
+		return fmt.Sprintf("ns=%v;i=%v", id.Namespace, id.numeric)
+	}
+}
+
+// QualifiedName is a name qualified by a namespace, such as the browse name of a node.
+type QualifiedName struct {
+	Namespace uint16
+	Name      string
+}
+
+// StatusCode is the result of an operation, bad codes having the top bit set.
+type StatusCode uint32
+
+// Status codes used by the client and the fake server
+const (
+	StatusGood                        StatusCode = 0
+	StatusBadDecodingError            StatusCode = 0x80070000
+	StatusBadServiceUnsupported       StatusCode = 0x800B0000
+	StatusBadIdentityTokenInvalid     StatusCode = 0x80200000
+	StatusBadSessionIDInvalid         StatusCode = 0x80250000
+	StatusBadNodeIDUnknown            StatusCode = 0x80340000
+	StatusBadAttributeIDInvalid       StatusCode = 0x80350000
+	StatusBadNotWritable              StatusCode = 0x803B0000
+	StatusBadTypeMismatch             StatusCode = 0x80740000
+	StatusBadContinuationPointInvalid StatusCode = 0x804A0000
+)
+
+var statusNames = map[StatusCode]string{
+	StatusGood:                        "Good",
+	StatusBadDecodingError:            "BadDecodingError",
+	StatusBadServiceUnsupported:       "BadServiceUnsupported",
+	StatusBadIdentityTokenInvalid:     "BadIdentityTokenInvalid",
+	StatusBadSessionIDInvalid:         "BadSessionIdInvalid",
+	StatusBadNodeIDUnknown:            "BadNodeIdUnknown",
+	StatusBadAttributeIDInvalid:       "BadAttributeIdInvalid",
+	StatusBadNotWritable:              "BadNotWritable",
+	StatusBadTypeMismatch:             "BadTypeMismatch",
+	StatusBadContinuationPointInvalid: "BadContinuationPointInvalid",
+}
+
+// IsBad returns whether the status reports a failure.
+func (s StatusCode) IsBad() bool {
+	return s&0x80000000 != 0
+}
+
+// Error returns the name of the status, if known, and its code.
+func (s StatusCode) Error() string {
+	if name, ok := statusNames[s]; ok {
+		return fmt.Sprintf("%v (0x%08X)", name, uint32(s))
+	}
+	return fmt.Sprintf("status 0x%08X", uint32(s))
+}
+
+// DataValue is the value of an attribute along with its status.
+type DataValue struct {
+	Value  interface{}
+	Status StatusCode
+}
+
+// Built-in types of variant values. Values are held as the Go type of the same size, DateTime as a time.Time and
+// ByteString as a []byte. Arrays are decoded as []interface{}.
+const (
+	typeNull       byte = 0
+	typeBoolean    byte = 1
+	typeSByte      byte = 2
+	typeByte       byte = 3
+	typeInt16      byte = 4
+	typeUInt16     byte = 5
+	typeInt32      byte = 6
+	typeUInt32     byte = 7
+	typeInt64      byte = 8
+	typeUInt64     byte = 9
+	typeFloat      byte = 10
+	typeDouble     byte = 11
+	typeString     byte = 12
+	typeDateTime   byte = 13
+	typeByteString byte = 15
+
+	variantArray      byte = 0x80
+	variantDimensions byte = 0x40
+)
+
+// Encoding masks of the optional fields of data values
+const (
+	dataValueValue        byte = 0x01
+	dataValueStatus       byte = 0x02
+	dataValueSourceTime   byte = 0x04
+	dataValueServerTime   byte = 0x08
+	dataValueSourcePicos  byte = 0x10
+	dataValueServerPicos  byte = 0x20
+	localizedTextLocale   byte = 0x01
+	localizedTextText     byte = 0x02
+	expandedNodeIDURI     byte = 0x80
+	expandedNodeIDServer  byte = 0x40
+	extensionObjectBinary byte = 0x01
+)
+
+// OPC UA DateTime values count 100 nanosecond ticks from 1601, which is too far from the Unix epoch for a
+// time.Duration, so they are converted through Unix seconds.
+const (
+	ticksPerSecond      = 10_000_000
+	unixEpochTickSecond = 11_644_473_600
+)
+
+// encoder appends values in the OPC UA binary encoding, which is little endian.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint8(v byte) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) boolean(v bool) {
+	if v {
+		e.uint8(1)
+	} else {
+		e.uint8(0)
+	}
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.buf = binary.LittleEndian.AppendUint16(e.buf, v)
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) int32(v int32) {
+	e.uint32(uint32(v))
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, v)
+}
+
+func (e *encoder) float64(v float64) {
+	e.uint64(math.Float64bits(v))
+}
+
+func (e *encoder) dateTime(t time.Time) {
+	if t.IsZero() {
+		e.uint64(0)
+		return
+	}
+	e.uint64(uint64((t.Unix()+unixEpochTickSecond)*ticksPerSecond + int64(t.Nanosecond()/100)))
+}
+
+// string encodes a string, the empty string being encoded as null.
+func (e *encoder) string(s string) {
+	if s == "" {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) byteString(b []byte) {
+	if b == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) strings(values []string) {
+	e.int32(int32(len(values)))
+	for _, s := range values {
+		e.string(s)
+	}
+}
+
+func (e *encoder) statusCodes(codes []StatusCode) {
+	e.int32(int32(len(codes)))
+	for _, code := range codes {
+		e.uint32(uint32(code))
+	}
+}
+
+// nodeID encodes a node id in its most compact form.
+func (e *encoder) nodeID(id NodeID) {
+	switch id.kind {
+	case nodeIDString:
+		e.uint8(0x03)
+		e.uint16(id.Namespace)
+		e.string(id.text)
+	case nodeIDGUID:
+		e.uint8(0x04)
+		e.uint16(id.Namespace)
+		e.buf = append(e.buf, id.text...)
+	case nodeIDOpaque:
+		e.uint8(0x05)
+		e.uint16(id.Namespace)
+		e.byteString([]byte(id.text))
+	default:
+		switch {
+		case id.Namespace == 0 && id.numeric <= 0xFF:
+			e.uint8(0x00)
+			e.uint8(byte(id.numeric))
+		case id.Namespace <= 0xFF && id.numeric <= 0xFFFF:
+			e.uint8(0x01)
+			e.uint8(byte(id.Namespace))
+			e.uint16(uint16(id.numeric))
+		default:
+			e.uint8(0x02)
+			e.uint16(id.Namespace)
+			e.uint32(id.numeric)
+		}
+	}
+}
+
+func (e *encoder) qualifiedName(name QualifiedName) {
+	e.uint16(name.Namespace)
+	e.string(name.Name)
+}
+
+func (e *encoder) localizedText(text string) {
+	if text == "" {
+		e.uint8(0)
+		return
+	}
+	e.uint8(localizedTextText)
+	e.string(text)
+}
+
+// extensionObject encodes a structure of the given binary encoding, or a null extension object if body is nil.
+func (e *encoder) extensionObject(typeID uint32, body []byte) {
+	if body == nil {
+		e.nodeID(NodeID{})
+		e.uint8(0)
+		return
+	}
+	e.nodeID(NewNumericNodeID(0, typeID))
+	e.uint8(extensionObjectBinary)
+	e.byteString(body)
+}
+
+// variant encodes a scalar value of one of the built-in types.
+func (e *encoder) variant(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		e.uint8(typeNull)
+	case bool:
+		e.uint8(typeBoolean)
+		e.boolean(v)
+	case int8:
+		e.uint8(typeSByte)
+		e.uint8(byte(v))
+	case uint8:
+		e.uint8(typeByte)
+		e.uint8(v)
+	case int16:
+		e.uint8(typeInt16)
+		e.uint16(uint16(v))
+	case uint16:
+		e.uint8(typeUInt16)
+		e.uint16(v)
+	case int32:
+		e.uint8(typeInt32)
+		e.int32(v)
+	case uint32:
+		e.uint8(typeUInt32)
+		e.uint32(v)
+	case int64:
+		e.uint8(typeInt64)
+		e.uint64(uint64(v))
+	case uint64:
+		e.uint8(typeUInt64)
+		e.uint64(v)
+	case float32:
+		e.uint8(typeFloat)
+		e.uint32(math.Float32bits(v))
+	case float64:
+		e.uint8(typeDouble)
+		e.float64(v)
+	case string:
+		e.uint8(typeString)
+		e.string(v)
+	case time.Time:
+		e.uint8(typeDateTime)
+		e.dateTime(v)
+	case []byte:
+		e.uint8(typeByteString)
+		e.byteString(v)
+	default:
+		return errors.Errorf("values of type %T are not supported", value)
+	}
+	return nil
+}
+
+// dataValue encodes a value with its status, leaving out a good status.
+func (e *encoder) dataValue(dv DataValue) error {
+	mask := dataValueValue
+	if dv.Status != StatusGood {
+		mask |= dataValueStatus
+	}
+	e.uint8(mask)
+	if err := e.variant(dv.Value); err != nil {
+		return err
+	}
+	if dv.Status != StatusGood {
+		e.uint32(uint32(dv.Status))
+	}
+	return nil
+}
+
+// decoder reads values in the OPC UA binary encoding. The first error is kept, and once it is set the values read are
+// zero, so that a message can be decoded in full before checking for errors.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.data) < n {
+		d.err = errors.Errorf("message truncated, %v bytes needed and %v left", n, len(d.data))
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) uint8() byte {
+	if b := d.read(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) boolean() bool {
+	return d.uint8() != 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.read(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.read(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	return int32(d.uint32())
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.read(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) float64() float64 {
+	return math.Float64frombits(d.uint64())
+}
+
+func (d *decoder) dateTime() time.Time {
+	ticks := int64(d.uint64())
+	if ticks <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(ticks/ticksPerSecond-unixEpochTickSecond, ticks%ticksPerSecond*100).UTC()
+}
+
+// length reads the length of a string or array, -1 being null. Every element takes at least a byte, which bounds the
+// length by the data left.
+func (d *decoder) length() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	if d.err == nil && int(n) > len(d.data) {
+		d.err = errors.Errorf("length %v exceeds the %v bytes left", n, len(d.data))
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) string() string {
+	return string(d.read(d.length()))
+}
+
+func (d *decoder) byteString() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	b := d.read(int(n))
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func (d *decoder) strings() []string {
+	values := make([]string, d.length())
+	for i := range values {
+		values[i] = d.string()
+	}
+	return values
+}
+
+func (d *decoder) statusCodes() []StatusCode {
+	codes := make([]StatusCode, d.length())
+	for i := range codes {
+		codes[i] = StatusCode(d.uint32())
+	}
+	return codes
+}
+
+// nodeIDWithFlags reads a node id, returning the flags of expanded node ids in the top bits of its encoding byte.
+func (d *decoder) nodeIDWithFlags() (NodeID, byte) {
+	encoding := d.uint8()
+	var id NodeID
+	switch encoding & 0x3F {
+	case 0x00:
+		id = NewNumericNodeID(0, uint32(d.uint8()))
+	case 0x01:
+		namespace := d.uint8()
+		id = NewNumericNodeID(uint16(namespace), uint32(d.uint16()))
+	case 0x02:
+		namespace := d.uint16()
+		id = NewNumericNodeID(namespace, d.uint32())
+	case 0x03:
+		namespace := d.uint16()
+		id = NewStringNodeID(namespace, d.string())
+	case 0x04:
+		namespace := d.uint16()
+		id = NodeID{Namespace: namespace, kind: nodeIDGUID, text: string(d.read(16))}
+	case 0x05:
+		namespace := d.uint16()
+		id = NodeID{Namespace: namespace, kind: nodeIDOpaque, text: string(d.byteString())}
+	default:
+		if d.err == nil {
+			d.err = errors.Errorf("unknown node id encoding 0x%02X", encoding)
+		}
+	}
+	return id, encoding & 0xC0
+}
+
+func (d *decoder) nodeID() NodeID {
+	id, _ := d.nodeIDWithFlags()
+	return id
+}
+
+// expandedNodeID reads an expanded node id, dropping its namespace uri and server index as only nodes of the server
+// connected to are used.
+func (d *decoder) expandedNodeID() NodeID {
+	id, flags := d.nodeIDWithFlags()
+	if flags&expandedNodeIDURI != 0 {
+		d.string()
+	}
+	if flags&expandedNodeIDServer != 0 {
+		d.uint32()
+	}
+	return id
+}
+
+func (d *decoder) qualifiedName() QualifiedName {
+	namespace := d.uint16()
+	return QualifiedName{Namespace: namespace, Name: d.string()}
+}
+
+// localizedText reads a localized text, returning its text.
+func (d *decoder) localizedText() string {
+	mask := d.uint8()
+	if mask&localizedTextLocale != 0 {
+		d.string()
+	}
+	if mask&localizedTextText != 0 {
+		return d.string()
+	}
+	return ""
+}
+
+// extensionObject reads an extension object, returning the id of its encoding and its binary body.
+func (d *decoder) extensionObject() (NodeID, []byte) {
+	typeID := d.nodeID()
+	switch encoding := d.uint8(); encoding {
+	case 0:
+		return typeID, nil
+	case extensionObjectBinary:
+		return typeID, d.byteString()
+	default:
+		d.byteString()
+		if d.err == nil {
+			d.err = errors.Errorf("extension object %v has unsupported encoding %v", typeID, encoding)
+		}
+		return typeID, nil
+	}
+}
+
+// diagnosticInfo skips a diagnostic info, which the client does not report.
+func (d *decoder) diagnosticInfo() {
+	mask := d.uint8()
+	// Symbolic id, namespace uri, locale and localized text are indexes into the string table
+	for bit := byte(0x01); bit <= 0x08; bit <<= 1 {
+		if mask&bit != 0 {
+			d.int32()
+		}
+	}
+	if mask&0x10 != 0 {
+		d.string()
+	}
+	if mask&0x20 != 0 {
+		d.uint32()
+	}
+	if mask&0x40 != 0 {
+		d.diagnosticInfo()
+	}
+}
+
+func (d *decoder) diagnosticInfos() {
+	for i, n := 0, d.length(); i < n; i++ {
+		d.diagnosticInfo()
+	}
+}
+
+// scalar reads a value of the given built-in type.
+func (d *decoder) scalar(valueType byte) interface{} {
+	switch valueType {
+	case typeBoolean:
+		return d.boolean()
+	case typeSByte:
+		return int8(d.uint8())
+	case typeByte:
+		return d.uint8()
+	case typeInt16:
+		return int16(d.uint16())
+	case typeUInt16:
+		return d.uint16()
+	case typeInt32:
+		return d.int32()
+	case typeUInt32:
+		return d.uint32()
+	case typeInt64:
+		return int64(d.uint64())
+	case typeUInt64:
+		return d.uint64()
+	case typeFloat:
+		return math.Float32frombits(d.uint32())
+	case typeDouble:
+		return d.float64()
+	case typeString:
+		return d.string()
+	case typeDateTime:
+		return d.dateTime()
+	case typeByteString:
+		return d.byteString()
+	default:
+		if d.err == nil {
+			d.err = errors.Errorf("values of built-in type %v are not supported", valueType)
+		}
+		return nil
+	}
+}
+
+func (d *decoder) variant() interface{} {
+	mask := d.uint8()
+	valueType := mask &^ (variantArray | variantDimensions)
+	if valueType == typeNull {
+		return nil
+	}
+	if mask&variantArray == 0 {
+		return d.scalar(valueType)
+	}
+	values := make([]interface{}, d.length())
+	for i := range values {
+		values[i] = d.scalar(valueType)
+	}
+	if mask&variantDimensions != 0 {
+		for i, n := 0, d.length(); i < n; i++ {
+			d.int32()
+		}
+	}
+	return values
+}
+
+func (d *decoder) dataValue() DataValue {
+	mask := d.uint8()
+	var dv DataValue
+	if mask&dataValueValue != 0 {
+		dv.Value = d.variant()
+	}
+	if mask&dataValueStatus != 0 {
+		dv.Status = StatusCode(d.uint32())
+	}
+	if mask&dataValueSourceTime != 0 {
+		d.dateTime()
+	}
+	if mask&dataValueSourcePicos != 0 {
+		d.uint16()
+	}
+	if mask&dataValueServerTime != 0 {
+		d.dateTime()
+	}
+	if mask&dataValueServerPicos != 0 {
+		d.uint16()
+	}
+	return dv
+}
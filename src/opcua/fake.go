@@ -0,0 +1,503 @@
+package opcua
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.viam.com/utils"
+)
+
+// fakeAnonymousPolicyID is the policy id of the anonymous user tokens the fake server accepts.
+const fakeAnonymousPolicyID = "anonymous_fake"
+
+// FakeServer stands in for the OPC UA server of a controller, for testing without one. Its address space holds the
+// Objects folder and the objects and variables added under it. It opens secure channels without security and
+// anonymous sessions, browses the address space and reads and writes the values of variables, a value written having
+// to be of the type of the value held.
+type FakeServer struct {
+	listener net.Listener
+
+	mu            sync.Mutex
+	nodes         map[NodeID]*fakeNode
+	onWrite       func(node NodeID, value interface{})
+	conns         map[net.Conn]struct{}
+	nextID        uint32
+	sessions      map[NodeID]bool
+	continuations map[string][]Reference
+	// maxReferences bounds the references of each browse result, whatever the client asks for, if set
+	maxReferences int
+
+	workers sync.WaitGroup
+}
+
+// fakeWrite is a value written to a variable by a client.
+type fakeWrite struct {
+	node  NodeID
+	value interface{}
+}
+
+type fakeNode struct {
+	class    NodeClass
+	name     QualifiedName
+	value    interface{}
+	children []NodeID
+}
+
+// NewFakeServer starts a fake server on a local port with an empty Objects folder.
+func NewFakeServer() (*FakeServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &FakeServer{
+		listener:      listener,
+		nodes:         map[NodeID]*fakeNode{ObjectsFolder: {class: NodeClassObject, name: QualifiedName{Name: "Objects"}}},
+		conns:         map[net.Conn]struct{}{},
+		sessions:      map[NodeID]bool{},
+		continuations: map[string][]Reference{},
+	}
+
+	s.workers.Add(1)
+	utils.PanicCapturingGo(func() {
+		defer s.workers.Done()
+		s.accept()
+	})
+	return s, nil
+}
+
+// Endpoint returns the endpoint url of the server.
+func (s *FakeServer) Endpoint() string {
+	return "opc.tcp://" + s.listener.Addr().String()
+}
+
+// Close stops the server and closes its connections.
+func (s *FakeServer) Close() error {
+	err := s.listener.Close()
+	s.Disconnect()
+	s.workers.Wait()
+	return err
+}
+
+// Disconnect closes the connections of the clients and forgets their sessions, as when the controller restarts.
+func (s *FakeServer) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		utils.UncheckedError(conn.Close())
+	}
+	s.sessions = map[NodeID]bool{}
+}
+
+// AddObject adds an object under the given parent, named in the namespace of its id.
+func (s *FakeServer) AddObject(parent, id NodeID, name string) {
+	s.add(parent, id, &fakeNode{class: NodeClassObject, name: QualifiedName{Namespace: id.Namespace, Name: name}})
+}
+
+// AddVariable adds a variable holding the given value under the given parent, named in the namespace of its id.
+func (s *FakeServer) AddVariable(parent, id NodeID, name string, value interface{}) {
+	browseName := QualifiedName{Namespace: id.Namespace, Name: name}
+	s.add(parent, id, &fakeNode{class: NodeClassVariable, name: browseName, value: value})
+}
+
+func (s *FakeServer) add(parent, id NodeID, node *fakeNode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes[id] = node
+	if parentNode, ok := s.nodes[parent]; ok {
+		parentNode.children = append(parentNode.children, id)
+	}
+}
+
+// Get returns the value of a variable and whether it exists.
+func (s *FakeServer) Get(id NodeID) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	node, ok := s.nodes[id]
+	if !ok || node.class != NodeClassVariable {
+		return nil, false
+	}
+	return node.value, true
+}
+
+// Set sets the value of a variable, which must exist.
+func (s *FakeServer) Set(id NodeID, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if node, ok := s.nodes[id]; ok && node.class == NodeClassVariable {
+		node.value = value
+	}
+}
+
+// OnWrite sets a function called, in its own goroutine, after each variable written by a client, to simulate the
+// program on the controller.
+func (s *FakeServer) OnWrite(onWrite func(node NodeID, value interface{})) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onWrite = onWrite
+}
+
+// SetMaxReferences bounds the references of each browse result, so that the rest have to be read with BrowseNext.
+func (s *FakeServer) SetMaxReferences(maxReferences int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxReferences = maxReferences
+}
+
+func (s *FakeServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.workers.Add(1)
+		utils.PanicCapturingGo(func() {
+			defer s.workers.Done()
+			s.serve(conn)
+		})
+	}
+}
+
+func (s *FakeServer) serve(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		utils.UncheckedError(conn.Close())
+	}()
+
+	if messageType, _, _, err := readChunk(conn); err != nil || messageType != messageHello {
+		return
+	}
+	ack := &encoder{}
+	ack.uint32(0)
+	ack.uint32(bufferSize)
+	ack.uint32(bufferSize)
+	ack.uint32(maxMessageSize)
+	ack.uint32(0)
+	if err := writeChunk(conn, messageAck, chunkFinal, ack.buf); err != nil {
+		return
+	}
+
+	var channelID, sequence uint32
+	for {
+		request, err := readSecureMessage(conn)
+		if err != nil || request.messageType == messageClose {
+			return
+		}
+		var response []byte
+		var writes []fakeWrite
+		var onWrite func(node NodeID, value interface{})
+		if request.messageType == messageOpen {
+			channelID = s.newID()
+			response = s.openSecureChannel(request.service, channelID)
+		} else {
+			response, writes, onWrite = s.handle(request.service)
+		}
+
+		sequence++
+		reply := secureMessage{
+			messageType: request.messageType,
+			channelID:   channelID,
+			tokenID:     channelID,
+			sequence:    sequence,
+			requestID:   request.requestID,
+			service:     response,
+		}
+		if err := writeSecureMessage(conn, reply); err != nil {
+			return
+		}
+		if onWrite == nil {
+			continue
+		}
+		for _, written := range writes {
+			written := written
+			s.workers.Add(1)
+			utils.PanicCapturingGo(func() {
+				defer s.workers.Done()
+				onWrite(written.node, written.value)
+			})
+		}
+	}
+}
+
+func (s *FakeServer) newID() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	return s.nextID
+}
+
+// openSecureChannel answers a request to open a secure channel, whose token has the id of the channel.
+func (s *FakeServer) openSecureChannel(service []byte, channelID uint32) []byte {
+	d := &decoder{data: service}
+	d.nodeID()
+	header := d.requestHeader()
+	e := &encoder{}
+	e.nodeID(NewNumericNodeID(0, idOpenSecureChannelResponse))
+	e.responseHeader(header.handle, StatusGood)
+	e.uint32(0)
+	e.uint32(channelID)
+	e.uint32(channelID)
+	e.dateTime(time.Now())
+	e.uint32(uint32(channelLifetime.Milliseconds()))
+	e.byteString(nil)
+	return e.buf
+}
+
+// handle answers a service request, returning the response and the variables written with the write hook to call.
+func (s *FakeServer) handle(service []byte) ([]byte, []fakeWrite, func(node NodeID, value interface{})) {
+	d := &decoder{data: service}
+	typeID := d.nodeID()
+	header := d.requestHeader()
+	fault := func(status StatusCode) []byte {
+		e := &encoder{}
+		e.nodeID(NewNumericNodeID(0, idServiceFault))
+		e.responseHeader(header.handle, status)
+		return e.buf
+	}
+	if d.err != nil {
+		return fault(StatusBadDecodingError), nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := &encoder{}
+	respond := func(responseType uint32) {
+		e.nodeID(NewNumericNodeID(0, responseType))
+		e.responseHeader(header.handle, StatusGood)
+	}
+	switch typeID {
+	case NewNumericNodeID(0, idCreateSessionRequest):
+		s.nextID++
+		sessionID := NewNumericNodeID(1, s.nextID)
+		authToken := NewStringNodeID(1, "session"+strconv.Itoa(int(s.nextID)))
+		s.sessions[authToken] = false
+		respond(idCreateSessionResponse)
+		e.nodeID(sessionID)
+		e.nodeID(authToken)
+		e.float64(float64(sessionTimeout.Milliseconds()))
+		e.byteString(nil)
+		e.byteString(nil)
+		// A secure endpoint, which the client cannot use, is offered first
+		e.int32(2)
+		e.endpoint(s.Endpoint(), endpoint{
+			securityMode:      3,
+			securityPolicy:    "http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256",
+			anonymousPolicyID: "anonymous_secure",
+		})
+		e.endpoint(s.Endpoint(), endpoint{
+			securityMode:      securityModeNone,
+			securityPolicy:    securityPolicyNone,
+			anonymousPolicyID: fakeAnonymousPolicyID,
+		})
+		e.int32(0)
+		e.string("")
+		e.byteString(nil)
+		e.uint32(maxMessageSize)
+		return e.buf, nil, nil
+	case NewNumericNodeID(0, idActivateSessionRequest):
+		if _, ok := s.sessions[header.authToken]; !ok {
+			return fault(StatusBadSessionIDInvalid), nil, nil
+		}
+		d.string()
+		d.byteString()
+		for i, n := 0, d.length(); i < n; i++ {
+			d.byteString()
+			d.byteString()
+		}
+		d.strings()
+		tokenType, body := d.extensionObject()
+		token := &decoder{data: body}
+		anonymous := tokenType == NewNumericNodeID(0, idAnonymousIdentityToken) && token.string() == fakeAnonymousPolicyID
+		if d.err != nil || !anonymous {
+			return fault(StatusBadIdentityTokenInvalid), nil, nil
+		}
+		s.sessions[header.authToken] = true
+		respond(idActivateSessionResponse)
+		e.byteString(nil)
+		e.int32(0)
+		e.int32(0)
+		return e.buf, nil, nil
+	}
+
+	if !s.sessions[header.authToken] {
+		return fault(StatusBadSessionIDInvalid), nil, nil
+	}
+	switch typeID {
+	case NewNumericNodeID(0, idCloseSessionRequest):
+		delete(s.sessions, header.authToken)
+		respond(idCloseSessionResponse)
+		return e.buf, nil, nil
+	case NewNumericNodeID(0, idReadRequest):
+		d.float64()
+		d.uint32()
+		var results []DataValue
+		for i, n := 0, d.length(); i < n; i++ {
+			id := d.nodeID()
+			attribute := d.uint32()
+			d.string()
+			d.qualifiedName()
+			results = append(results, s.read(id, attribute))
+		}
+		if d.err != nil {
+			return fault(StatusBadDecodingError), nil, nil
+		}
+		respond(idReadResponse)
+		e.int32(int32(len(results)))
+		for _, result := range results {
+			// The values held were checked when set, and unknown ones reported as status
+			utils.UncheckedError(e.dataValue(result))
+		}
+		e.int32(0)
+		return e.buf, nil, nil
+	case NewNumericNodeID(0, idWriteRequest):
+		var results []StatusCode
+		var writes []fakeWrite
+		for i, n := 0, d.length(); i < n; i++ {
+			id := d.nodeID()
+			attribute := d.uint32()
+			d.string()
+			value := d.dataValue()
+			if d.err != nil {
+				break
+			}
+			result := s.write(id, attribute, value.Value)
+			if result == StatusGood {
+				writes = append(writes, fakeWrite{node: id, value: value.Value})
+			}
+			results = append(results, result)
+		}
+		if d.err != nil {
+			return fault(StatusBadDecodingError), nil, nil
+		}
+		respond(idWriteResponse)
+		e.statusCodes(results)
+		e.int32(0)
+		return e.buf, writes, s.onWrite
+	case NewNumericNodeID(0, idBrowseRequest):
+		d.nodeID()
+		d.dateTime()
+		d.uint32()
+		maxReferences := int(d.uint32())
+		if s.maxReferences > 0 && (maxReferences == 0 || maxReferences > s.maxReferences) {
+			maxReferences = s.maxReferences
+		}
+		var nodes []NodeID
+		for i, n := 0, d.length(); i < n; i++ {
+			nodes = append(nodes, d.nodeID())
+			d.uint32()
+			d.nodeID()
+			d.boolean()
+			d.uint32()
+			d.uint32()
+		}
+		if d.err != nil {
+			return fault(StatusBadDecodingError), nil, nil
+		}
+		respond(idBrowseResponse)
+		e.int32(int32(len(nodes)))
+		for _, id := range nodes {
+			node, ok := s.nodes[id]
+			if !ok {
+				e.uint32(uint32(StatusBadNodeIDUnknown))
+				e.byteString(nil)
+				e.int32(0)
+				continue
+			}
+			var refs []Reference
+			for _, child := range node.children {
+				refs = append(refs, Reference{NodeID: child, BrowseName: s.nodes[child].name, NodeClass: s.nodes[child].class})
+			}
+			s.browseResult(e, refs, maxReferences)
+		}
+		e.int32(0)
+		return e.buf, nil, nil
+	case NewNumericNodeID(0, idBrowseNextRequest):
+		release := d.boolean()
+		var points []string
+		for i, n := 0, d.length(); i < n; i++ {
+			points = append(points, string(d.byteString()))
+		}
+		if d.err != nil {
+			return fault(StatusBadDecodingError), nil, nil
+		}
+		respond(idBrowseNextResponse)
+		e.int32(int32(len(points)))
+		for _, point := range points {
+			refs, ok := s.continuations[point]
+			delete(s.continuations, point)
+			switch {
+			case !ok:
+				e.uint32(uint32(StatusBadContinuationPointInvalid))
+				e.byteString(nil)
+				e.int32(0)
+			case release:
+				s.browseResult(e, nil, 0)
+			default:
+				s.browseResult(e, refs, s.maxReferences)
+			}
+		}
+		e.int32(0)
+		return e.buf, nil, nil
+	default:
+		return fault(StatusBadServiceUnsupported), nil, nil
+	}
+}
+
+// browseResult encodes up to maxReferences of the references, if set, keeping the rest for BrowseNext.
+func (s *FakeServer) browseResult(e *encoder, refs []Reference, maxReferences int) {
+	var continuationPoint []byte
+	if maxReferences > 0 && len(refs) > maxReferences {
+		s.nextID++
+		continuationPoint = []byte("continuation" + strconv.Itoa(int(s.nextID)))
+		s.continuations[string(continuationPoint)] = refs[maxReferences:]
+		refs = refs[:maxReferences]
+	}
+	e.uint32(uint32(StatusGood))
+	e.byteString(continuationPoint)
+	e.int32(int32(len(refs)))
+	for _, ref := range refs {
+		referenceType := uint32(idHasComponent)
+		if ref.NodeClass == NodeClassObject {
+			referenceType = idOrganizes
+		}
+		e.reference(ref, referenceType)
+	}
+}
+
+// read returns the value of an attribute, only the value of variables being readable.
+func (s *FakeServer) read(id NodeID, attribute uint32) DataValue {
+	node, ok := s.nodes[id]
+	switch {
+	case !ok:
+		return DataValue{Status: StatusBadNodeIDUnknown}
+	case attribute != attributeValue || node.class != NodeClassVariable:
+		return DataValue{Status: StatusBadAttributeIDInvalid}
+	default:
+		return DataValue{Value: node.value}
+	}
+}
+
+// write sets the value of a variable to a value of the same type.
+func (s *FakeServer) write(id NodeID, attribute uint32, value interface{}) StatusCode {
+	node, ok := s.nodes[id]
+	switch {
+	case !ok:
+		return StatusBadNodeIDUnknown
+	case attribute != attributeValue || node.class != NodeClassVariable:
+		return StatusBadNotWritable
+	case reflect.TypeOf(value) != reflect.TypeOf(node.value):
+		return StatusBadTypeMismatch
+	default:
+		node.value = value
+		return StatusGood
+	}
+}
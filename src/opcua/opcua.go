@@ -0,0 +1,384 @@
+// Package opcua implements enough of the OPC UA binary protocol (opc.tcp) to browse the address space of a KUKA
+// controller running KUKA.OPC UA and read and write its variables, along with a fake server for testing. Only secure
+// channels without security and anonymous sessions are supported.
+//
+// Every message starts with the message type (HEL, ACK, ERR, OPN, MSG or CLO), the chunk type (F for the final chunk
+// of a message) and the size of the whole message as a little endian uint32. HEL and ACK agree on the buffer sizes of
+// the connection. The messages of the secure channel then follow:
+//
+//	OPN: channel id, security policy, certificate, certificate thumbprint, sequence number, request id, service
+//	MSG: channel id, token id, sequence number, request id, service
+//
+// where each service is the node id of its binary encoding followed by its fields, the request and response header
+// first.
+package opcua
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultPort is the port OPC UA servers listen on.
+const DefaultPort = 4840
+
+// Message and chunk types
+const (
+	messageHello = "HEL"
+	messageAck   = "ACK"
+	messageError = "ERR"
+	messageOpen  = "OPN"
+	messageMsg   = "MSG"
+	messageClose = "CLO"
+
+	chunkFinal        byte = 'F'
+	chunkIntermediate byte = 'C'
+	chunkAbort        byte = 'A'
+)
+
+const (
+	headerSize = 8
+	// bufferSize is the largest chunk sent and accepted
+	bufferSize = 1 << 16
+	// maxMessageSize bounds the messages assembled from chunks
+	maxMessageSize = 1 << 24
+
+	securityPolicyNone = "http://opcfoundation.org/UA/SecurityPolicy#None"
+	securityModeNone   = 1
+	userTokenAnonymous = 0
+)
+
+// Ids of the binary encodings of the services and structures used
+const (
+	idAnonymousIdentityToken    = 321
+	idServiceFault              = 397
+	idOpenSecureChannelRequest  = 446
+	idOpenSecureChannelResponse = 449
+	idCloseSecureChannelRequest = 452
+	idCreateSessionRequest      = 461
+	idCreateSessionResponse     = 464
+	idActivateSessionRequest    = 467
+	idActivateSessionResponse   = 470
+	idCloseSessionRequest       = 473
+	idCloseSessionResponse      = 476
+	idBrowseRequest             = 527
+	idBrowseResponse            = 530
+	idBrowseNextRequest         = 533
+	idBrowseNextResponse        = 536
+	idReadRequest               = 631
+	idReadResponse              = 634
+	idWriteRequest              = 673
+	idWriteResponse             = 676
+)
+
+// Standard nodes, reference types and attributes
+const (
+	idHierarchicalReferences = 33
+	idOrganizes              = 35
+	idHasComponent           = 47
+	idObjectsFolder          = 85
+
+	attributeValue = 13
+
+	browseForward         = 0
+	browseResultAll       = 0x3F
+	timestampsNeither     = 3
+	applicationTypeClient = 1
+)
+
+// ObjectsFolder is the node the objects of the address space are organized under.
+var ObjectsFolder = NewNumericNodeID(0, idObjectsFolder)
+
+// NodeClass is the class of a node.
+type NodeClass uint32
+
+// Node classes
+const (
+	NodeClassObject   NodeClass = 1
+	NodeClassVariable NodeClass = 2
+)
+
+// Reference is a node referenced by a browsed node.
+type Reference struct {
+	NodeID     NodeID
+	BrowseName QualifiedName
+	NodeClass  NodeClass
+}
+
+// writeChunk writes a message as a single chunk.
+func writeChunk(w io.Writer, messageType string, chunk byte, body []byte) error {
+	if headerSize+len(body) > bufferSize {
+		return errors.Errorf("%v message of %v bytes exceeds the buffer size", messageType, headerSize+len(body))
+	}
+	data := append([]byte(messageType), chunk)
+	data = binary.LittleEndian.AppendUint32(data, uint32(headerSize+len(body)))
+	_, err := w.Write(append(data, body...))
+	return err
+}
+
+// readChunk reads a chunk, returning its message type, chunk type and body.
+func readChunk(r io.Reader) (string, byte, []byte, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", 0, nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[4:])
+	if size < headerSize || size > bufferSize {
+		return "", 0, nil, errors.Errorf("chunk size %v is out of range", size)
+	}
+	body := make([]byte, size-headerSize)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return "", 0, nil, err
+	}
+	return string(header[:3]), header[3], body, nil
+}
+
+// decodeError returns the error reported by an ERR message, or by an aborted chunk.
+func decodeError(body []byte) error {
+	d := &decoder{data: body}
+	status := StatusCode(d.uint32())
+	reason := d.string()
+	if d.err != nil {
+		return errors.Wrap(d.err, "malformed error message")
+	}
+	return errors.Wrapf(status, "server reported an error: %v", reason)
+}
+
+// secureMessage is a message of the secure channel, carrying a service.
+type secureMessage struct {
+	messageType string
+	channelID   uint32
+	tokenID     uint32
+	sequence    uint32
+	requestID   uint32
+	service     []byte
+}
+
+// writeSecureMessage writes a message of the secure channel as a single chunk.
+func writeSecureMessage(w io.Writer, msg secureMessage) error {
+	e := &encoder{}
+	e.uint32(msg.channelID)
+	if msg.messageType == messageOpen {
+		e.string(securityPolicyNone)
+		e.byteString(nil)
+		e.byteString(nil)
+	} else {
+		e.uint32(msg.tokenID)
+	}
+	e.uint32(msg.sequence)
+	e.uint32(msg.requestID)
+	e.buf = append(e.buf, msg.service...)
+	return writeChunk(w, msg.messageType, chunkFinal, e.buf)
+}
+
+// readSecureMessage reads a message of the secure channel, assembling its chunks.
+func readSecureMessage(r io.Reader) (secureMessage, error) {
+	var msg secureMessage
+	for {
+		messageType, chunk, body, err := readChunk(r)
+		if err != nil {
+			return secureMessage{}, err
+		}
+		switch messageType {
+		case messageError:
+			return secureMessage{}, decodeError(body)
+		case messageOpen, messageMsg, messageClose:
+		default:
+			return secureMessage{}, errors.Errorf("unexpected %v message", messageType)
+		}
+		if msg.messageType != "" && messageType != msg.messageType {
+			return secureMessage{}, errors.Errorf("%v chunk in a %v message", messageType, msg.messageType)
+		}
+
+		d := &decoder{data: body}
+		channelID := d.uint32()
+		var tokenID uint32
+		if messageType == messageOpen {
+			if policy := d.string(); d.err == nil && policy != securityPolicyNone {
+				return secureMessage{}, errors.Errorf("security policy %v is not supported", policy)
+			}
+			d.byteString()
+			d.byteString()
+		} else {
+			tokenID = d.uint32()
+		}
+		sequence := d.uint32()
+		requestID := d.uint32()
+		if d.err != nil {
+			return secureMessage{}, errors.Wrapf(d.err, "malformed %v message", messageType)
+		}
+
+		if chunk == chunkAbort {
+			return secureMessage{}, decodeError(d.data)
+		}
+		if msg.messageType != "" && requestID != msg.requestID {
+			return secureMessage{}, errors.Errorf("chunk of request %v in the message of request %v", requestID, msg.requestID)
+		}
+		if len(msg.service)+len(d.data) > maxMessageSize {
+			return secureMessage{}, errors.Errorf("%v message exceeds %v bytes", messageType, maxMessageSize)
+		}
+		msg.messageType, msg.channelID, msg.tokenID, msg.sequence, msg.requestID = messageType, channelID, tokenID, sequence,
+			requestID
+		msg.service = append(msg.service, d.data...)
+		switch chunk {
+		case chunkFinal:
+			return msg, nil
+		case chunkIntermediate:
+		default:
+			return secureMessage{}, errors.Errorf("unknown chunk type %q", chunk)
+		}
+	}
+}
+
+// requestHeader is the part of the header of a request the fake server uses.
+type requestHeader struct {
+	authToken NodeID
+	handle    uint32
+}
+
+func (e *encoder) requestHeader(authToken NodeID, handle uint32, timeout time.Duration) {
+	e.nodeID(authToken)
+	e.dateTime(time.Now())
+	e.uint32(handle)
+	e.uint32(0) // no diagnostics
+	e.string("")
+	e.uint32(uint32(timeout.Milliseconds()))
+	e.extensionObject(0, nil)
+}
+
+func (d *decoder) requestHeader() requestHeader {
+	header := requestHeader{authToken: d.nodeID()}
+	d.dateTime()
+	header.handle = d.uint32()
+	d.uint32()
+	d.string()
+	d.uint32()
+	d.extensionObject()
+	return header
+}
+
+func (e *encoder) responseHeader(handle uint32, result StatusCode) {
+	e.dateTime(time.Now())
+	e.uint32(handle)
+	e.uint32(uint32(result))
+	e.uint8(0) // no diagnostics
+	e.int32(-1)
+	e.extensionObject(0, nil)
+}
+
+// responseHeader reads the header of a response, returning its service result.
+func (d *decoder) responseHeader() StatusCode {
+	d.dateTime()
+	d.uint32()
+	result := StatusCode(d.uint32())
+	d.diagnosticInfo()
+	d.strings()
+	d.extensionObject()
+	return result
+}
+
+// endpoint is the part of an endpoint description the client uses.
+type endpoint struct {
+	securityMode   uint32
+	securityPolicy string
+	// anonymousPolicyID is the policy id of anonymous user tokens, empty if they are not accepted
+	anonymousPolicyID string
+}
+
+// endpoint encodes the description of an endpoint of the server accepting anonymous user tokens.
+func (e *encoder) endpoint(url string, ep endpoint) {
+	e.string(url)
+	// Server application description
+	e.string("urn:viam-kuka:fake")
+	e.string("")
+	e.localizedText("fake OPC UA server")
+	e.uint32(0) // server
+	e.string("")
+	e.string("")
+	e.strings([]string{url})
+	e.byteString(nil)
+	e.uint32(ep.securityMode)
+	e.string(ep.securityPolicy)
+	// User identity tokens
+	e.int32(1)
+	e.string(ep.anonymousPolicyID)
+	e.uint32(userTokenAnonymous)
+	e.string("")
+	e.string("")
+	e.string("")
+	e.string("http://opcfoundation.org/UA-Profile/Transport/uatcp-uasc-uabinary")
+	e.uint8(0)
+}
+
+func (d *decoder) endpoints() []endpoint {
+	endpoints := make([]endpoint, d.length())
+	for i := range endpoints {
+		d.string()
+		// Server application description
+		d.string()
+		d.string()
+		d.localizedText()
+		d.uint32()
+		d.string()
+		d.string()
+		d.strings()
+		d.byteString()
+		endpoints[i].securityMode = d.uint32()
+		endpoints[i].securityPolicy = d.string()
+		for j, n := 0, d.length(); j < n; j++ {
+			policyID := d.string()
+			if tokenType := d.uint32(); tokenType == userTokenAnonymous && endpoints[i].anonymousPolicyID == "" {
+				endpoints[i].anonymousPolicyID = policyID
+			}
+			d.string()
+			d.string()
+			d.string()
+		}
+		d.string()
+		d.uint8()
+	}
+	return endpoints
+}
+
+func (e *encoder) reference(ref Reference, referenceType uint32) {
+	e.nodeID(NewNumericNodeID(0, referenceType))
+	e.boolean(true)
+	e.nodeID(ref.NodeID)
+	e.qualifiedName(ref.BrowseName)
+	e.localizedText(ref.BrowseName.Name)
+	e.uint32(uint32(ref.NodeClass))
+	e.nodeID(NodeID{})
+}
+
+func (d *decoder) reference() Reference {
+	d.nodeID()
+	d.boolean()
+	ref := Reference{NodeID: d.expandedNodeID(), BrowseName: d.qualifiedName()}
+	d.localizedText()
+	ref.NodeClass = NodeClass(d.uint32())
+	d.expandedNodeID()
+	return ref
+}
+
+// browseResult reads the result of browsing a single node, returning its references and continuation point.
+func (d *decoder) browseResult() ([]Reference, []byte, error) {
+	if n := d.length(); n != 1 && d.err == nil {
+		return nil, nil, errors.Errorf("%v browse results for one node", n)
+	}
+	status := StatusCode(d.uint32())
+	continuationPoint := d.byteString()
+	refs := make([]Reference, d.length())
+	for i := range refs {
+		refs[i] = d.reference()
+	}
+	d.diagnosticInfos()
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	if status.IsBad() {
+		return nil, nil, status
+	}
+	return refs, continuationPoint, nil
+}
@@ -0,0 +1,222 @@
+package opcua
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"go.viam.com/test"
+)
+
+func TestEncoding(t *testing.T) {
+	t.Run("node ids", func(t *testing.T) {
+		for _, tt := range []struct {
+			id      NodeID
+			encoded []byte
+		}{
+			{ObjectsFolder, []byte{0x00, 85}},
+			{NewNumericNodeID(2, 1000), []byte{0x01, 2, 0xE8, 0x03}},
+			{NewNumericNodeID(300, 70000), []byte{0x02, 0x2C, 0x01, 0x70, 0x11, 0x01, 0x00}},
+			{NewStringNodeID(2, "Robot"), append([]byte{0x03, 2, 0, 5, 0, 0, 0}, "Robot"...)},
+		} {
+			e := &encoder{}
+			e.nodeID(tt.id)
+			test.That(t, e.buf, test.ShouldResemble, tt.encoded)
+
+			d := &decoder{data: e.buf}
+			test.That(t, d.nodeID(), test.ShouldResemble, tt.id)
+			test.That(t, d.err, test.ShouldBeNil)
+		}
+		test.That(t, NewStringNodeID(2, "Robot").String(), test.ShouldEqual, "ns=2;s=Robot")
+		test.That(t, ObjectsFolder.String(), test.ShouldEqual, "ns=0;i=85")
+
+		// The namespace uri and server index of expanded node ids are dropped
+		e := &encoder{}
+		e.uint8(0x01 | expandedNodeIDURI | expandedNodeIDServer)
+		e.uint8(2)
+		e.uint16(1000)
+		e.string("urn:kuka")
+		e.uint32(1)
+		d := &decoder{data: e.buf}
+		test.That(t, d.expandedNodeID(), test.ShouldResemble, NewNumericNodeID(2, 1000))
+		test.That(t, d.err, test.ShouldBeNil)
+		test.That(t, len(d.data), test.ShouldEqual, 0)
+	})
+
+	t.Run("variants", func(t *testing.T) {
+		for _, value := range []interface{}{
+			nil, true, int8(-3), uint8(3), int16(-300), uint16(300), int32(-70000), uint32(70000), int64(-1 << 40),
+			uint64(1 << 40), float32(0.5), 12.25, "{E6AXIS: A1 0.0}", []byte{1, 2},
+			time.Date(2026, time.October, 19, 12, 30, 0, 100, time.UTC),
+		} {
+			e := &encoder{}
+			test.That(t, e.variant(value), test.ShouldBeNil)
+			d := &decoder{data: e.buf}
+			test.That(t, d.variant(), test.ShouldResemble, value)
+			test.That(t, d.err, test.ShouldBeNil)
+		}
+
+		e := &encoder{}
+		test.That(t, e.variant(struct{}{}), test.ShouldNotBeNil)
+
+		// Arrays are decoded as []interface{}, their dimensions being dropped
+		e.uint8(typeDouble | variantArray | variantDimensions)
+		e.int32(2)
+		e.float64(1)
+		e.float64(2)
+		e.int32(1)
+		e.int32(2)
+		d := &decoder{data: e.buf}
+		test.That(t, d.variant(), test.ShouldResemble, []interface{}{1.0, 2.0})
+		test.That(t, d.err, test.ShouldBeNil)
+	})
+
+	t.Run("date time", func(t *testing.T) {
+		e := &encoder{}
+		e.dateTime(time.Unix(0, 0))
+		test.That(t, binary.LittleEndian.Uint64(e.buf), test.ShouldEqual, uint64(116444736000000000))
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		e := &encoder{}
+		e.string("Robot")
+		d := &decoder{data: e.buf[:6]}
+		test.That(t, d.string(), test.ShouldEqual, "")
+		test.That(t, d.err, test.ShouldNotBeNil)
+
+		// Lengths beyond the data left are refused without allocating
+		d = &decoder{data: []byte{0xFF, 0xFF, 0xFF, 0x7F}}
+		test.That(t, len(d.strings()), test.ShouldEqual, 0)
+		test.That(t, d.err, test.ShouldNotBeNil)
+	})
+
+	t.Run("messages", func(t *testing.T) {
+		var buf bytes.Buffer
+		msg := secureMessage{messageType: messageMsg, channelID: 1, tokenID: 2, sequence: 3, requestID: 4, service: []byte{5}}
+		test.That(t, writeSecureMessage(&buf, msg), test.ShouldBeNil)
+		test.That(t, buf.Bytes()[:8], test.ShouldResemble, []byte{'M', 'S', 'G', 'F', 25, 0, 0, 0})
+
+		// A message split into chunks is assembled
+		chunked := buf.Bytes()
+		chunked[3] = chunkIntermediate
+		test.That(t, writeSecureMessage(&buf, msg), test.ShouldBeNil)
+		read, err := readSecureMessage(&buf)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, read.service, test.ShouldResemble, []byte{5, 5})
+		test.That(t, read.requestID, test.ShouldEqual, 4)
+
+		e := &encoder{}
+		e.uint32(uint32(StatusBadDecodingError))
+		e.string("bad message")
+		test.That(t, writeChunk(&buf, messageError, chunkFinal, e.buf), test.ShouldBeNil)
+		_, err = readSecureMessage(&buf)
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "bad message")
+		test.That(t, err.Error(), test.ShouldContainSubstring, "BadDecodingError")
+	})
+}
+
+// newFakeController returns a fake server with the address space of a robot, its variables in a folder of their own.
+func newFakeController(t *testing.T) *FakeServer {
+	server, err := NewFakeServer()
+	test.That(t, err, test.ShouldBeNil)
+	t.Cleanup(func() { test.That(t, server.Close(), test.ShouldBeNil) })
+
+	robot := NewStringNodeID(2, "Robot")
+	server.AddObject(ObjectsFolder, robot, "Robot")
+	server.AddVariable(robot, NewStringNodeID(2, "$OV_PRO"), "$OV_PRO", int32(100))
+	server.AddVariable(robot, NewStringNodeID(2, "$AXIS_ACT"), "$AXIS_ACT", "{E6AXIS: A1 0.0}")
+	server.AddVariable(robot, NewNumericNodeID(2, 7), "viamStop", false)
+	return server
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeController(t)
+
+	writes := make(chan interface{}, 1)
+	server.OnWrite(func(node NodeID, value interface{}) { writes <- value })
+
+	client, err := Dial(ctx, server.Endpoint(), time.Second)
+	test.That(t, err, test.ShouldBeNil)
+	defer func() { test.That(t, client.Close(), test.ShouldBeNil) }()
+
+	t.Run("read and write", func(t *testing.T) {
+		value, err := client.Read(NewStringNodeID(2, "$OV_PRO"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, value, test.ShouldEqual, int32(100))
+
+		test.That(t, client.Write(NewStringNodeID(2, "$OV_PRO"), int32(50)), test.ShouldBeNil)
+		test.That(t, <-writes, test.ShouldEqual, int32(50))
+		value, _ = server.Get(NewStringNodeID(2, "$OV_PRO"))
+		test.That(t, value, test.ShouldEqual, int32(50))
+
+		err = client.Write(NewStringNodeID(2, "$OV_PRO"), "50")
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "BadTypeMismatch")
+
+		_, err = client.Read(NewStringNodeID(2, "$UNKNOWN"))
+		test.That(t, err, test.ShouldNotBeNil)
+		test.That(t, err.Error(), test.ShouldContainSubstring, "BadNodeIdUnknown")
+	})
+
+	t.Run("browse", func(t *testing.T) {
+		refs, err := client.Browse(ObjectsFolder)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, refs, test.ShouldResemble, []Reference{{
+			NodeID:     NewStringNodeID(2, "Robot"),
+			BrowseName: QualifiedName{Namespace: 2, Name: "Robot"},
+			NodeClass:  NodeClassObject,
+		}})
+
+		// References beyond those the server returns at once are read with BrowseNext
+		server.SetMaxReferences(1)
+		refs, err = client.Browse(NewStringNodeID(2, "Robot"))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, len(refs), test.ShouldEqual, 3)
+		test.That(t, refs[2].NodeID, test.ShouldResemble, NewNumericNodeID(2, 7))
+		test.That(t, refs[2].BrowseName.Name, test.ShouldEqual, "viamStop")
+		test.That(t, refs[2].NodeClass, test.ShouldEqual, NodeClassVariable)
+	})
+
+	t.Run("reconnect", func(t *testing.T) {
+		// A request on a connection closed by the server fails, and the next one reconnects
+		server.Disconnect()
+		_, err := client.Read(NewNumericNodeID(2, 7))
+		test.That(t, err, test.ShouldNotBeNil)
+		value, err := client.Read(NewNumericNodeID(2, 7))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, value, test.ShouldEqual, false)
+
+		// The connection is replaced ahead of the session expiring
+		client.mu.Lock()
+		client.renewAt = time.Now()
+		client.mu.Unlock()
+		value, err = client.Read(NewNumericNodeID(2, 7))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, value, test.ShouldEqual, false)
+	})
+}
+
+func TestDial(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := Dial(ctx, "http://127.0.0.1:4840", time.Second)
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// Nothing answers on the port of a closed server
+	server, err := NewFakeServer()
+	test.That(t, err, test.ShouldBeNil)
+	url := server.Endpoint()
+	test.That(t, server.Close(), test.ShouldBeNil)
+	_, err = Dial(ctx, url, time.Second)
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// Only anonymous sessions without security are opened
+	_, err = anonymousPolicyID(nil)
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = anonymousPolicyID([]endpoint{{securityMode: 3, securityPolicy: securityPolicyNone, anonymousPolicyID: "a"}})
+	test.That(t, err, test.ShouldNotBeNil)
+}
@@ -0,0 +1,151 @@
+// Package rsi implements the sensor side of the KUKA RobotSensorInterface (RSI) over UDP. Every interpolation cycle the
+// robot sends an XML telegram with its state, and the sensor answers with a correction, echoing the IPOC timestamp of
+// the telegram, before the next cycle. The corrections are relative, each one moving the robot on from where the
+// previous ones left it.
+package rsi
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CorrectionMode is what the corrections sent to the robot apply to.
+type CorrectionMode string
+
+const (
+	// JointCorrection corrects the axes, in degrees, through the AKorr element.
+	JointCorrection CorrectionMode = "joint"
+	// CartesianCorrection corrects the tool in the base frame, x,y,z in millimeters and a,b,c in degrees, through the
+	// RKorr element.
+	CartesianCorrection CorrectionMode = "cartesian"
+)
+
+// DefaultSensorType is the Type of the Sen element used by the ETHERNET object of the KUKA RSI examples.
+const DefaultSensorType = "ImFree"
+
+// State is the state of the robot reported in a telegram.
+type State struct {
+	// Pose is the actual cartesian position (RIst), x,y,z in millimeters and a,b,c in degrees
+	Pose [6]float64
+	// Joints are the actual axis positions (AIPos), in degrees
+	Joints [6]float64
+	// Delay is the number of late replies the robot has counted
+	Delay int
+	// IPOC is the timestamp of the telegram, in milliseconds, to be echoed in the reply
+	IPOC uint64
+}
+
+type cartesianElement struct {
+	X float64 `xml:"X,attr"`
+	Y float64 `xml:"Y,attr"`
+	Z float64 `xml:"Z,attr"`
+	A float64 `xml:"A,attr"`
+	B float64 `xml:"B,attr"`
+	C float64 `xml:"C,attr"`
+}
+
+func (e *cartesianElement) values() [6]float64 {
+	return [6]float64{e.X, e.Y, e.Z, e.A, e.B, e.C}
+}
+
+type axesElement struct {
+	A1 float64 `xml:"A1,attr"`
+	A2 float64 `xml:"A2,attr"`
+	A3 float64 `xml:"A3,attr"`
+	A4 float64 `xml:"A4,attr"`
+	A5 float64 `xml:"A5,attr"`
+	A6 float64 `xml:"A6,attr"`
+}
+
+func (e *axesElement) values() [6]float64 {
+	return [6]float64{e.A1, e.A2, e.A3, e.A4, e.A5, e.A6}
+}
+
+type robotTelegram struct {
+	XMLName xml.Name          `xml:"Rob"`
+	RIst    *cartesianElement `xml:"RIst"`
+	AIPos   *axesElement      `xml:"AIPos"`
+	Delay   struct {
+		D int `xml:"D,attr"`
+	} `xml:"Delay"`
+	IPOC *uint64 `xml:"IPOC"`
+}
+
+type sensorTelegram struct {
+	XMLName xml.Name          `xml:"Sen"`
+	Type    string            `xml:"Type,attr"`
+	RKorr   *cartesianElement `xml:"RKorr"`
+	AKorr   *axesElement      `xml:"AKorr"`
+	IPOC    *uint64           `xml:"IPOC"`
+}
+
+// ParseRobotTelegram parses a telegram sent by the robot, which must report its cartesian and axis positions.
+func ParseRobotTelegram(data []byte) (State, error) {
+	var telegram robotTelegram
+	if err := xml.Unmarshal(data, &telegram); err != nil {
+		return State{}, errors.Wrap(err, "failed to parse robot telegram")
+	}
+	if telegram.RIst == nil || telegram.AIPos == nil || telegram.IPOC == nil {
+		return State{}, errors.Errorf("robot telegram must have RIst, AIPos and IPOC elements: %q", data)
+	}
+	return State{
+		Pose:   telegram.RIst.values(),
+		Joints: telegram.AIPos.values(),
+		Delay:  telegram.Delay.D,
+		IPOC:   *telegram.IPOC,
+	}, nil
+}
+
+// ParseSensorTelegram parses a reply to the robot, returning the correction in the given mode and the IPOC echoed.
+func ParseSensorTelegram(data []byte, mode CorrectionMode) ([6]float64, uint64, error) {
+	var telegram sensorTelegram
+	if err := xml.Unmarshal(data, &telegram); err != nil {
+		return [6]float64{}, 0, errors.Wrap(err, "failed to parse sensor telegram")
+	}
+	if telegram.IPOC == nil {
+		return [6]float64{}, 0, errors.Errorf("sensor telegram must have an IPOC element: %q", data)
+	}
+
+	switch {
+	case mode == JointCorrection && telegram.AKorr != nil:
+		return telegram.AKorr.values(), *telegram.IPOC, nil
+	case mode == CartesianCorrection && telegram.RKorr != nil:
+		return telegram.RKorr.values(), *telegram.IPOC, nil
+	default:
+		return [6]float64{}, 0, errors.Errorf("sensor telegram has no %v correction: %q", mode, data)
+	}
+}
+
+// FormatSensorTelegram formats the reply to the robot giving the correction in the given mode, with the IPOC of the
+// telegram being answered.
+func FormatSensorTelegram(sensorType string, mode CorrectionMode, correction [6]float64, ipoc uint64) []byte {
+	var names [6]string
+	element := "AKorr"
+	if mode == CartesianCorrection {
+		element = "RKorr"
+		names = [6]string{"X", "Y", "Z", "A", "B", "C"}
+	} else {
+		names = [6]string{"A1", "A2", "A3", "A4", "A5", "A6"}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<Sen Type="%v"><%v`, sensorType, element)
+	for i, name := range names {
+		fmt.Fprintf(&b, ` %v="%.4f"`, name, correction[i])
+	}
+	fmt.Fprintf(&b, `/><IPOC>%v</IPOC></Sen>`, ipoc)
+	return []byte(b.String())
+}
+
+// FormatRobotTelegram formats a telegram as sent by the robot.
+func FormatRobotTelegram(state State) []byte {
+	p, j := state.Pose, state.Joints
+	return []byte(fmt.Sprintf(`<Rob Type="KUKA">`+
+		`<RIst X="%.4f" Y="%.4f" Z="%.4f" A="%.4f" B="%.4f" C="%.4f"/>`+
+		`<AIPos A1="%.4f" A2="%.4f" A3="%.4f" A4="%.4f" A5="%.4f" A6="%.4f"/>`+
+		`<Delay D="%v"/><IPOC>%v</IPOC></Rob>`,
+		p[0], p[1], p[2], p[3], p[4], p[5], j[0], j[1], j[2], j[3], j[4], j[5], state.Delay, state.IPOC))
+}
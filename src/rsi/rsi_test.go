@@ -0,0 +1,225 @@
+package rsi
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/test"
+)
+
+func TestTelegrams(t *testing.T) {
+	t.Run("robot", func(t *testing.T) {
+		data := []byte(`<Rob Type="KUKA"><RIst X="500.0" Y="0.0" Z="600.0" A="0.0" B="90.0" C="0.0"/>` +
+			`<RSol X="500.0" Y="0.0" Z="600.0" A="0.0" B="90.0" C="0.0"/>` +
+			`<AIPos A1="1.0" A2="-90.0" A3="90.0" A4="0.0" A5="45.0" A6="0.0"/>` +
+			`<Delay D="2"/><IPOC>4208163976</IPOC></Rob>`)
+		state, err := ParseRobotTelegram(data)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, state, test.ShouldResemble, State{
+			Pose:   [6]float64{500, 0, 600, 0, 90, 0},
+			Joints: [6]float64{1, -90, 90, 0, 45, 0},
+			Delay:  2,
+			IPOC:   4208163976,
+		})
+
+		parsed, err := ParseRobotTelegram(FormatRobotTelegram(state))
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, parsed, test.ShouldResemble, state)
+	})
+
+	t.Run("sensor", func(t *testing.T) {
+		correction := [6]float64{0.1, -0.2, 0, 0, 0, 0.05}
+		data := FormatSensorTelegram(DefaultSensorType, JointCorrection, correction, 42)
+		test.That(t, string(data), test.ShouldEqual, `<Sen Type="ImFree"><AKorr A1="0.1000" A2="-0.2000" A3="0.0000" `+
+			`A4="0.0000" A5="0.0000" A6="0.0500"/><IPOC>42</IPOC></Sen>`)
+
+		parsed, ipoc, err := ParseSensorTelegram(data, JointCorrection)
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, parsed, test.ShouldResemble, correction)
+		test.That(t, ipoc, test.ShouldEqual, 42)
+
+		_, _, err = ParseSensorTelegram(data, CartesianCorrection)
+		test.That(t, err, test.ShouldNotBeNil)
+
+		data = FormatSensorTelegram("Custom", CartesianCorrection, correction, 43)
+		test.That(t, string(data), test.ShouldStartWith, `<Sen Type="Custom"><RKorr X="0.1000"`)
+	})
+
+	errorTests := []struct {
+		description string
+		data        string
+	}{
+		{description: "not xml", data: "getcurrentjoints;"},
+		{description: "no axes", data: `<Rob Type="KUKA"><RIst X="0" Y="0" Z="0" A="0" B="0" C="0"/><IPOC>1</IPOC></Rob>`},
+		{description: "no ipoc", data: `<Rob Type="KUKA"><RIst X="0"/><AIPos A1="0"/></Rob>`},
+		{description: "bad value", data: `<Rob><RIst X="x"/><AIPos A1="0"/><IPOC>1</IPOC></Rob>`},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.description, func(t *testing.T) {
+			_, err := ParseRobotTelegram([]byte(tt.data))
+			test.That(t, err, test.ShouldNotBeNil)
+		})
+	}
+}
+
+// fakeCorrector sends a fixed correction, recording the error it was aborted with.
+type fakeCorrector struct {
+	correction [6]float64
+
+	mu      sync.Mutex
+	aborted error
+}
+
+func (c *fakeCorrector) Correct(state State) [6]float64 {
+	return c.correction
+}
+
+func (c *fakeCorrector) Abort(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.aborted = err
+}
+
+func (c *fakeCorrector) abortErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.aborted
+}
+
+func newTestSession(t *testing.T, cfg Config) (*Session, *Simulator) {
+	cfg.Address = "127.0.0.1:0"
+	if cfg.Mode == "" {
+		cfg.Mode = JointCorrection
+	}
+	cfg.Cycle = 4 * time.Millisecond
+	cfg.MaxMissedCycles = 10
+	cfg.MaxCorrection = 0.5
+
+	session, err := Listen(cfg, logging.NewTestLogger(t))
+	test.That(t, err, test.ShouldBeNil)
+	t.Cleanup(func() { test.That(t, session.Close(), test.ShouldBeNil) })
+
+	initial := State{Joints: [6]float64{0, -90, 90, 0, 45, 0}, Pose: [6]float64{500, 0, 600, 0, 90, 0}, IPOC: 1000}
+	sim, err := NewSimulator(session.Addr().String(), cfg.Mode, cfg.Cycle, initial)
+	test.That(t, err, test.ShouldBeNil)
+	t.Cleanup(func() { test.That(t, sim.Close(), test.ShouldBeNil) })
+	return session, sim
+}
+
+// waitFor waits for the condition to hold, failing the test if it does not.
+func waitFor(t *testing.T, description string, condition func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", description)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSession(t *testing.T) {
+	t.Run("holds and corrects", func(t *testing.T) {
+		session, sim := newTestSession(t, Config{})
+		waitFor(t, "telegrams", func() bool { return session.Stats().Telegrams > 5 })
+
+		state, updated, err := session.State()
+		test.That(t, err, test.ShouldBeNil)
+		test.That(t, time.Since(updated), test.ShouldBeLessThan, time.Second)
+		test.That(t, state.Joints, test.ShouldResemble, [6]float64{0, -90, 90, 0, 45, 0})
+
+		// Corrections are clamped to the max correction and applied every cycle
+		corrector := &fakeCorrector{correction: [6]float64{2, 0, 0, 0, 0, -0.1}}
+		session.SetCorrector(corrector)
+		waitFor(t, "correction", func() bool { return sim.State().Joints[0] >= 5 })
+		session.SetCorrector(nil)
+
+		// A reply in flight may still be applied
+		time.Sleep(20 * time.Millisecond)
+		joints := sim.State().Joints
+		time.Sleep(20 * time.Millisecond)
+		test.That(t, sim.State().Joints, test.ShouldResemble, joints)
+		test.That(t, joints[5], test.ShouldAlmostEqual, -joints[0]/5, 0.001)
+		test.That(t, corrector.abortErr(), test.ShouldBeNil)
+		test.That(t, sim.Late(), test.ShouldEqual, 0)
+	})
+
+	t.Run("cartesian", func(t *testing.T) {
+		session, sim := newTestSession(t, Config{Mode: CartesianCorrection, SensorType: "Custom"})
+		session.SetCorrector(&fakeCorrector{correction: [6]float64{0, 0, -0.5, 0, 0, 0}})
+		waitFor(t, "correction", func() bool { return sim.State().Pose[2] <= 595 })
+		test.That(t, sim.State().Joints, test.ShouldResemble, [6]float64{0, -90, 90, 0, 45, 0})
+	})
+
+	t.Run("watchdog on silence", func(t *testing.T) {
+		session, sim := newTestSession(t, Config{})
+		waitFor(t, "telegrams", func() bool { return session.Stats().Telegrams > 0 })
+
+		corrector := &fakeCorrector{correction: [6]float64{0.1, 0, 0, 0, 0, 0}}
+		session.SetCorrector(corrector)
+		sim.Pause(true)
+		waitFor(t, "watchdog", func() bool { return corrector.abortErr() != nil })
+		test.That(t, corrector.abortErr().Error(), test.ShouldContainSubstring, "no telegram from the robot")
+		_, _, err := session.State()
+		test.That(t, err, test.ShouldNotBeNil)
+
+		// Motion does not resume with the telegrams
+		stats := session.Stats()
+		test.That(t, stats.WatchdogTrips, test.ShouldEqual, 1)
+		sim.Pause(false)
+		waitFor(t, "telegrams", func() bool { return session.Stats().Telegrams > stats.Telegrams+5 })
+		joints := sim.State().Joints
+		time.Sleep(20 * time.Millisecond)
+		test.That(t, sim.State().Joints, test.ShouldResemble, joints)
+	})
+
+	t.Run("watchdog on missed cycles", func(t *testing.T) {
+		session, sim := newTestSession(t, Config{})
+		waitFor(t, "telegrams", func() bool { return session.Stats().Telegrams > 0 })
+
+		corrector := &fakeCorrector{}
+		session.SetCorrector(corrector)
+		sim.SkipCycles(3)
+		waitFor(t, "missed cycles", func() bool { return session.Stats().MissedCycles >= 3 })
+		test.That(t, corrector.abortErr(), test.ShouldBeNil)
+
+		sim.SkipCycles(20)
+		waitFor(t, "watchdog", func() bool { return corrector.abortErr() != nil })
+		test.That(t, corrector.abortErr().Error(), test.ShouldContainSubstring, "robot missed 20 cycles")
+		test.That(t, session.Stats().Connected, test.ShouldBeTrue)
+	})
+
+	t.Run("other robot", func(t *testing.T) {
+		session, sim := newTestSession(t, Config{RobotIP: "10.9.9.9"})
+		waitFor(t, "unanswered telegrams", func() bool { return sim.Late() > 3 })
+		test.That(t, session.Stats().Telegrams, test.ShouldEqual, 0)
+	})
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{Mode: JointCorrection, Cycle: 4 * time.Millisecond, MaxMissedCycles: 10, MaxCorrection: 0.1}
+	test.That(t, valid.Validate(), test.ShouldBeNil)
+
+	errorTests := []struct {
+		description string
+		modify      func(*Config)
+		errContains string
+	}{
+		{description: "mode", modify: func(c *Config) { c.Mode = "tool" }, errContains: "correction mode (tool)"},
+		{description: "cycle", modify: func(c *Config) { c.Cycle = 5 * time.Millisecond }, errContains: "cycle (5ms)"},
+		{description: "missed cycles", modify: func(c *Config) { c.MaxMissedCycles = 0 }, errContains: "max missed cycles"},
+		{description: "correction", modify: func(c *Config) { c.MaxCorrection = 0 }, errContains: "max correction"},
+		{description: "robot ip", modify: func(c *Config) { c.RobotIP = "kuka" }, errContains: "robot ip (kuka)"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.description, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			err := cfg.Validate()
+			test.That(t, err, test.ShouldNotBeNil)
+			test.That(t, errors.Cause(err).Error(), test.ShouldContainSubstring, tt.errContains)
+		})
+	}
+}
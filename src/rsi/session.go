@@ -0,0 +1,283 @@
+package rsi
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/utils"
+)
+
+// maxTelegramSize bounds the telegrams read from the robot
+const maxTelegramSize = 4096
+
+// Config is how the robot reaches the session and what the session sends back.
+type Config struct {
+	// Address is the local UDP address the robot sends its telegrams to, e.g. ":49152"
+	Address string
+	// RobotIP, if set, is the only address telegrams are answered from
+	RobotIP string
+	// SensorType is the Type of the replies, which must match the ETHERNET object of the RSI context
+	SensorType string
+	// Mode is what the corrections apply to
+	Mode CorrectionMode
+	// Cycle is the interpolation cycle of the RSI context, 4 or 12 milliseconds
+	Cycle time.Duration
+	// MaxMissedCycles is how many cycles may pass without a telegram before the watchdog trips
+	MaxMissedCycles int
+	// MaxCorrection bounds each value of a correction, in degrees or millimeters per cycle
+	MaxCorrection float64
+}
+
+// Validate ensures the config can be used for a session.
+func (cfg Config) Validate() error {
+	if cfg.Mode != JointCorrection && cfg.Mode != CartesianCorrection {
+		return errors.Errorf("correction mode (%v) must be %v or %v", cfg.Mode, JointCorrection, CartesianCorrection)
+	}
+	if cfg.Cycle != 4*time.Millisecond && cfg.Cycle != 12*time.Millisecond {
+		return errors.Errorf("cycle (%v) must be 4ms or 12ms", cfg.Cycle)
+	}
+	if cfg.MaxMissedCycles < 1 {
+		return errors.Errorf("max missed cycles (%v) must be at least 1", cfg.MaxMissedCycles)
+	}
+	if cfg.MaxCorrection <= 0 {
+		return errors.Errorf("max correction (%v) must be positive", cfg.MaxCorrection)
+	}
+	if cfg.RobotIP != "" && net.ParseIP(cfg.RobotIP) == nil {
+		return errors.Errorf("robot ip (%v) must be an IP address", cfg.RobotIP)
+	}
+	return nil
+}
+
+// Corrector gives the correction to send in each cycle.
+type Corrector interface {
+	// Correct returns the correction to send in reply to the state the robot reported.
+	Correct(state State) [6]float64
+	// Abort is called when the watchdog trips, after which the corrector is no longer used.
+	Abort(err error)
+}
+
+// Stats count the telegrams exchanged with the robot.
+type Stats struct {
+	// Connected is whether telegrams are arriving within the watchdog timeout
+	Connected bool
+	// Telegrams is the number of telegrams answered
+	Telegrams uint64
+	// MissedCycles is the number of cycles the robot did not send a telegram for, as counted from their IPOC
+	MissedCycles uint64
+	// WatchdogTrips is the number of times the watchdog tripped
+	WatchdogTrips uint64
+	// Delay is the number of late replies last reported by the robot
+	Delay int
+	// LastIPOC is the IPOC of the last telegram answered
+	LastIPOC uint64
+}
+
+// Session answers the telegrams of a robot running an RSI context, sending the corrections of its corrector and
+// holding the robot in place when it has none. The watchdog trips when no telegram arrives for MaxMissedCycles cycles,
+// removing the corrector so motion does not resume when telegrams do.
+type Session struct {
+	cfg     Config
+	robotIP net.IP
+	conn    *net.UDPConn
+	logger  logging.Logger
+
+	// mu is held while the corrector is called, so it is not used once replaced
+	mu        sync.Mutex
+	corrector Corrector
+	state     State
+	updated   time.Time
+	stats     Stats
+
+	closing chan struct{}
+	workers sync.WaitGroup
+}
+
+// Listen starts a session answering the robot at the configured address.
+func Listen(cfg Config, logger logging.Logger) (*Session, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.SensorType == "" {
+		cfg.SensorType = DefaultSensorType
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		cfg:     cfg,
+		robotIP: net.ParseIP(cfg.RobotIP),
+		conn:    conn,
+		logger:  logger,
+		closing: make(chan struct{}),
+	}
+	s.workers.Add(1)
+	utils.PanicCapturingGo(func() {
+		defer s.workers.Done()
+		s.serve()
+	})
+	return s, nil
+}
+
+// Addr returns the local address of the session.
+func (s *Session) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Mode returns what the corrections of the session apply to.
+func (s *Session) Mode() CorrectionMode {
+	return s.cfg.Mode
+}
+
+// Cycle returns the interpolation cycle of the RSI context.
+func (s *Session) Cycle() time.Duration {
+	return s.cfg.Cycle
+}
+
+// Close stops answering the robot, which stops the RSI context once its own timeout passes.
+func (s *Session) Close() error {
+	close(s.closing)
+	err := s.conn.Close()
+	s.workers.Wait()
+	return err
+}
+
+// SetCorrector sets the corrector giving the corrections from the next telegram on. Nil holds the robot in place.
+func (s *Session) SetCorrector(corrector Corrector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.corrector = corrector
+}
+
+// State returns the state last reported by the robot and when it arrived. An error is returned if no telegram is
+// arriving.
+func (s *Session) State() (State, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stats.Connected {
+		return State{}, time.Time{}, errors.Errorf("no telegrams arriving from the robot on %v", s.conn.LocalAddr())
+	}
+	return s.state, s.updated, nil
+}
+
+// Stats returns the counts of the telegrams exchanged with the robot.
+func (s *Session) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// watchdogTimeout is how long the robot may go without sending a telegram.
+func (s *Session) watchdogTimeout() time.Duration {
+	return time.Duration(s.cfg.MaxMissedCycles+1) * s.cfg.Cycle
+}
+
+// serve answers the telegrams of the robot until the session is closed.
+func (s *Session) serve() {
+	buf := make([]byte, maxTelegramSize)
+	timeout := s.watchdogTimeout()
+	for {
+		if err := s.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			s.logger.Warnf("error setting rsi read deadline: %v", err)
+		}
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.closing:
+				return
+			default:
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				s.trip(errors.Errorf("no telegram from the robot within %v", timeout))
+			} else {
+				s.logger.Warnf("error reading rsi telegram: %v", err)
+			}
+			continue
+		}
+		if s.robotIP != nil && !addr.IP.Equal(s.robotIP) {
+			s.logger.Debugf("ignoring rsi telegram from %v", addr)
+			continue
+		}
+
+		state, err := ParseRobotTelegram(buf[:n])
+		if err != nil {
+			s.logger.Warnf("error reading rsi telegram: %v", err)
+			continue
+		}
+
+		reply := FormatSensorTelegram(s.cfg.SensorType, s.cfg.Mode, s.correct(state), state.IPOC)
+		if _, err := s.conn.WriteToUDP(reply, addr); err != nil {
+			s.logger.Warnf("error replying to rsi telegram: %v", err)
+		}
+	}
+}
+
+// correct records the state reported by the robot and returns the correction to reply with. A gap in IPOC of more than
+// MaxMissedCycles trips the watchdog, holding the robot in place.
+func (s *Session) correct(state State) [6]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cycleMs := uint64(s.cfg.Cycle.Milliseconds())
+	var missed uint64
+	if s.stats.Connected && state.IPOC > s.stats.LastIPOC+cycleMs {
+		missed = (state.IPOC-s.stats.LastIPOC)/cycleMs - 1
+	}
+	wasConnected := s.stats.Connected
+
+	s.state = state
+	s.updated = time.Now()
+	s.stats.Connected = true
+	s.stats.Telegrams++
+	s.stats.MissedCycles += missed
+	s.stats.Delay = state.Delay
+	s.stats.LastIPOC = state.IPOC
+
+	if !wasConnected {
+		s.logger.Infof("rsi telegrams arriving from the robot, ipoc %v", state.IPOC)
+	}
+	if missed > uint64(s.cfg.MaxMissedCycles) {
+		s.tripLocked(errors.Errorf("robot missed %v cycles before ipoc %v", missed, state.IPOC))
+		s.stats.Connected = true
+	}
+
+	if s.corrector == nil {
+		return [6]float64{}
+	}
+	correction := s.corrector.Correct(state)
+	for i, value := range correction {
+		correction[i] = math.Max(-s.cfg.MaxCorrection, math.Min(s.cfg.MaxCorrection, value))
+	}
+	return correction
+}
+
+// trip trips the watchdog if telegrams were arriving.
+func (s *Session) trip(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats.Connected {
+		s.tripLocked(err)
+	}
+}
+
+// tripLocked marks the robot as no longer sending telegrams and aborts the corrector, if any.
+func (s *Session) tripLocked(err error) {
+	s.logger.Warnf("rsi watchdog tripped: %v", err)
+	s.stats.Connected = false
+	s.stats.WatchdogTrips++
+	if s.corrector != nil {
+		s.corrector.Abort(err)
+		s.corrector = nil
+	}
+}
@@ -0,0 +1,141 @@
+package rsi
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"go.viam.com/utils"
+)
+
+// Simulator stands in for a robot running an RSI context, for testing the sensor side without one. Every cycle it
+// sends its state and applies the correction replied, the joints in joint mode and the pose in cartesian mode, as it
+// has no kinematics. A reply that does not arrive within the cycle or does not echo the IPOC is counted as late.
+type Simulator struct {
+	conn  *net.UDPConn
+	mode  CorrectionMode
+	cycle time.Duration
+
+	mu     sync.Mutex
+	state  State
+	paused bool
+	late   int
+
+	closing chan struct{}
+	workers sync.WaitGroup
+}
+
+// NewSimulator starts a simulator sending telegrams to the sensor at the given address from the initial state.
+func NewSimulator(sensorAddress string, mode CorrectionMode, cycle time.Duration, initial State) (*Simulator, error) {
+	addr, err := net.ResolveUDPAddr("udp", sensorAddress)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	sim := &Simulator{conn: conn, mode: mode, cycle: cycle, state: initial, closing: make(chan struct{})}
+	sim.workers.Add(1)
+	utils.PanicCapturingGo(func() {
+		defer sim.workers.Done()
+		sim.run()
+	})
+	return sim, nil
+}
+
+// Close stops the simulator.
+func (sim *Simulator) Close() error {
+	close(sim.closing)
+	err := sim.conn.Close()
+	sim.workers.Wait()
+	return err
+}
+
+// State returns the current state of the simulated robot.
+func (sim *Simulator) State() State {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.state
+}
+
+// Late returns the number of replies that were late or did not echo the IPOC.
+func (sim *Simulator) Late() int {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.late
+}
+
+// Pause stops or resumes sending telegrams, the IPOC still advancing every cycle.
+func (sim *Simulator) Pause(paused bool) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.paused = paused
+}
+
+// SkipCycles advances the IPOC as if the given number of telegrams had been lost.
+func (sim *Simulator) SkipCycles(cycles int) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.state.IPOC += uint64(cycles) * uint64(sim.cycle.Milliseconds())
+}
+
+func (sim *Simulator) run() {
+	ticker := time.NewTicker(sim.cycle)
+	defer ticker.Stop()
+
+	buf := make([]byte, maxTelegramSize)
+	for {
+		select {
+		case <-sim.closing:
+			return
+		case <-ticker.C:
+		}
+
+		sim.mu.Lock()
+		sim.state.IPOC += uint64(sim.cycle.Milliseconds())
+		state, paused := sim.state, sim.paused
+		sim.mu.Unlock()
+		if paused {
+			continue
+		}
+
+		if _, err := sim.conn.Write(FormatRobotTelegram(state)); err != nil {
+			continue
+		}
+		if err := sim.conn.SetReadDeadline(time.Now().Add(sim.cycle)); err != nil {
+			continue
+		}
+		n, err := sim.conn.Read(buf)
+		if err != nil {
+			sim.countLate()
+			continue
+		}
+		correction, ipoc, err := ParseSensorTelegram(buf[:n], sim.mode)
+		if err != nil || ipoc != state.IPOC {
+			sim.countLate()
+			continue
+		}
+		sim.apply(correction)
+	}
+}
+
+func (sim *Simulator) countLate() {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.late++
+	sim.state.Delay = sim.late
+}
+
+func (sim *Simulator) apply(correction [6]float64) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	for i, value := range correction {
+		if sim.mode == JointCorrection {
+			sim.state.Joints[i] += value
+		} else {
+			sim.state.Pose[i] += value
+		}
+	}
+}